@@ -0,0 +1,98 @@
+// Example of producing to and consuming from an in-process mock cluster
+package main
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"os"
+	"time"
+)
+
+func main() {
+
+	mockCluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		fmt.Printf("Failed to create MockCluster: %s\n", err)
+		os.Exit(1)
+	}
+	defer mockCluster.Close()
+
+	broker := mockCluster.BootstrapServers()
+	topic := "mocktopic"
+
+	fmt.Printf("Created MockCluster with bootstrap.servers %s\n", broker)
+
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": broker})
+
+	if err != nil {
+		fmt.Printf("Failed to create producer: %s\n", err)
+		os.Exit(1)
+	}
+
+	deliveryChan := make(chan kafka.Event)
+
+	value := "Hello Go!"
+	err = p.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          []byte(value),
+	}, deliveryChan)
+	if err != nil {
+		fmt.Printf("Failed to produce message: %s\n", err)
+		os.Exit(1)
+	}
+
+	e := <-deliveryChan
+	m := e.(*kafka.Message)
+
+	if m.TopicPartition.Error != nil {
+		fmt.Printf("Delivery failed: %v\n", m.TopicPartition.Error)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Delivered message to topic %s [%d] at offset %v\n",
+		*m.TopicPartition.Topic, m.TopicPartition.Partition, m.TopicPartition.Offset)
+
+	close(deliveryChan)
+	p.Close()
+
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": broker,
+		"group.id":          "mockgroup",
+		"auto.offset.reset": "earliest"})
+
+	if err != nil {
+		fmt.Printf("Failed to create consumer: %s\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		fmt.Printf("Failed to subscribe: %s\n", err)
+		os.Exit(1)
+	}
+
+	msg, err := c.ReadMessage(10 * time.Second)
+	if err != nil {
+		fmt.Printf("Consumer error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Consumed message on %s: %s\n", msg.TopicPartition, string(msg.Value))
+}
@@ -0,0 +1,473 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ackerPartition holds the per-partition acknowledgement state.
+type ackerPartition struct {
+	// Tracked offsets, in the order they were consumed.
+	pending []Offset
+	// Tracked offsets and whether they have been acknowledged.
+	acked map[Offset]bool
+	// Offset to commit: one past the last offset of the contiguous
+	// acknowledged prefix, or OffsetInvalid if nothing is acknowledged yet.
+	commitOffset Offset
+	// Last offset committed by the Acker, or OffsetInvalid.
+	committedOffset Offset
+	// Offset of the first tracked message.
+	firstOffset Offset
+	// One past the offset of the last tracked message.
+	position Offset
+}
+
+// Acker tracks consumed messages that are processed, and acknowledged,
+// out of order and commits, for each partition, only the offset following
+// the contiguous prefix of acknowledged messages.
+//
+// Commits are held back at the first message that has not yet been
+// acknowledged, so that a crash never commits past a message that has yet to
+// be processed, providing at-least-once semantics for out-of-order
+// processing.
+//
+// Messages must be passed to Track() in the order they are consumed,
+// before they are handed off for processing, and to Ack() once
+// they have been processed. Ack() may be called from any go-routine.
+//
+// It is recommended to set `enable.auto.commit=false` on the Consumer
+// when using an Acker.
+// Commits are either explicit, with Commit(), or batched according to
+// the commit policy, see SetCommitPolicy().
+type Acker struct {
+	c          *Consumer
+	lock       sync.Mutex
+	partitions map[topicPartitionKey]*ackerPartition
+
+	policy AckerCommitPolicy
+	// Messages acknowledged since the last commit.
+	ackedCnt int
+	// Wakes up the committer to commit, non-blocking.
+	commitChan chan bool
+	// Terminates the committer() goroutine
+	termChan  chan bool
+	waitGroup sync.WaitGroup
+}
+
+// AckerCommitPolicy configures the Acker's batched commits: the Acker
+// commits once Messages messages have been acknowledged since the last
+// commit, or every Interval, whichever comes first.
+// The zero value disables batched commits.
+type AckerCommitPolicy struct {
+	// Messages is the number of acknowledged messages that trigger a
+	// commit, 0 disables.
+	Messages int
+	// Interval is the time between commits, 0 disables.
+	Interval time.Duration
+}
+
+// NewAcker creates a new Acker committing offsets for Consumer c.
+func NewAcker(c *Consumer) *Acker {
+	return &Acker{
+		c:          c,
+		partitions: make(map[topicPartitionKey]*ackerPartition),
+	}
+}
+
+// Track registers a consumed message as in-flight, holding back commits
+// for its partition at or past its offset until the message is acknowledged.
+func (a *Acker) Track(m *Message) error {
+	if m == nil || m.TopicPartition.Topic == nil || m.TopicPartition.Offset < 0 {
+		return newErrorFromString(ErrInvalidArg,
+			"Only consumed messages may be tracked")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := topicPartitionKey{*m.TopicPartition.Topic, m.TopicPartition.Partition}
+	ap, found := a.partitions[key]
+	if !found {
+		ap = &ackerPartition{
+			acked:           make(map[Offset]bool),
+			commitOffset:    OffsetInvalid,
+			committedOffset: OffsetInvalid,
+			firstOffset:     m.TopicPartition.Offset,
+		}
+		a.partitions[key] = ap
+	}
+
+	offset := m.TopicPartition.Offset
+	if _, found := ap.acked[offset]; found ||
+		(len(ap.pending) > 0 && offset < ap.pending[len(ap.pending)-1]) ||
+		(ap.commitOffset != OffsetInvalid && offset < ap.commitOffset) {
+		return newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Message %v tracked out of order", m.TopicPartition))
+	}
+
+	ap.pending = append(ap.pending, offset)
+	ap.acked[offset] = false
+	ap.position = offset + 1
+
+	return nil
+}
+
+// Ack acknowledges that a message previously passed to Track() has been
+// processed, possibly advancing the commit point of its partition.
+func (a *Acker) Ack(m *Message) error {
+	if m == nil || m.TopicPartition.Topic == nil {
+		return newErrorFromString(ErrInvalidArg, "Invalid message")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := topicPartitionKey{*m.TopicPartition.Topic, m.TopicPartition.Partition}
+	ap, found := a.partitions[key]
+	if !found {
+		return newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Message %v is not tracked", m.TopicPartition))
+	}
+
+	offset := m.TopicPartition.Offset
+	if acked, found := ap.acked[offset]; !found || acked {
+		return newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Message %v is not tracked or already acknowledged",
+				m.TopicPartition))
+	}
+
+	ap.acked[offset] = true
+
+	// Advance the commit point past the contiguous acknowledged prefix.
+	for len(ap.pending) > 0 && ap.acked[ap.pending[0]] {
+		delete(ap.acked, ap.pending[0])
+		ap.commitOffset = ap.pending[0] + 1
+		ap.pending = ap.pending[1:]
+	}
+
+	a.ackedCnt++
+	if a.policy.Messages > 0 && a.ackedCnt >= a.policy.Messages {
+		select {
+		case a.commitChan <- true:
+		default:
+			// A commit is already pending
+		}
+	}
+
+	return nil
+}
+
+// Commit commits, for each partition, the offset following the contiguous
+// prefix of acknowledged messages, if it has advanced since the last commit.
+//
+// Returns the committed offsets, or an error with the ErrNoOffset code
+// if there was nothing new to commit.
+func (a *Acker) Commit() ([]TopicPartition, error) {
+	return a.commit(nil)
+}
+
+// commit commits the acknowledged offsets, see Commit(), of the partitions
+// for which include returns true, or of all partitions if include is nil.
+func (a *Acker) commit(include func(key topicPartitionKey) bool) ([]TopicPartition, error) {
+	a.lock.Lock()
+	a.ackedCnt = 0
+	var offsets []TopicPartition
+	for key, ap := range a.partitions {
+		if include != nil && !include(key) {
+			continue
+		}
+		if ap.commitOffset == OffsetInvalid ||
+			ap.commitOffset == ap.committedOffset {
+			continue
+		}
+		topic := key.topic
+		offsets = append(offsets, TopicPartition{
+			Topic:     &topic,
+			Partition: key.partition,
+			Offset:    ap.commitOffset,
+		})
+	}
+	a.lock.Unlock()
+
+	if len(offsets) == 0 {
+		return nil, newErrorFromString(ErrNoOffset,
+			"No acknowledged offsets to commit")
+	}
+
+	committed, err := a.c.CommitOffsets(offsets)
+	if err != nil {
+		return committed, err
+	}
+
+	a.lock.Lock()
+	for _, tp := range committed {
+		if tp.Error != nil {
+			continue
+		}
+		ap, found := a.partitions[topicPartitionKey{*tp.Topic, tp.Partition}]
+		if found && tp.Offset > ap.committedOffset {
+			ap.committedOffset = tp.Offset
+		}
+	}
+	a.lock.Unlock()
+
+	return committed, nil
+}
+
+// InFlightUncommitted returns, for each partition with tracked messages,
+// the number of offsets that have been tracked, i.e., delivered for
+// processing, but not yet committed by the Acker: the position following
+// the last tracked message minus the last committed offset, or minus the
+// first tracked offset if nothing has been committed yet.
+//
+// This is the partition's reprocessing exposure: the messages that
+// would be consumed again after a crash. Compare it against the commit
+// frequency to tune the trade-off between throughput and reprocessing.
+func (a *Acker) InFlightUncommitted() map[TopicPartition]int64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	inFlight := make(map[TopicPartition]int64, len(a.partitions))
+	for key, ap := range a.partitions {
+		base := ap.committedOffset
+		if base == OffsetInvalid {
+			base = ap.firstOffset
+		}
+
+		n := int64(ap.position - base)
+		if n < 0 {
+			n = 0
+		}
+
+		topic := key.topic
+		inFlight[TopicPartition{Topic: &topic, Partition: key.partition}] = n
+	}
+
+	return inFlight
+}
+
+// ackerRevokePollInterval is how often Revoke() checks whether the
+// in-flight messages of the revoked partitions have been acknowledged.
+const ackerRevokePollInterval = 10 * time.Millisecond
+
+// Revoke hands off the revoked partitions to their next owner: it waits up
+// to timeout for all messages tracked on the partitions to be acknowledged,
+// commits the acknowledged offsets of the partitions and stops tracking them.
+// Subsequent Ack() calls for messages of the revoked partitions fail.
+//
+// Call Revoke from the rebalance callback, before the partitions are
+// unassigned, so that the new owner of the partitions resumes consumption
+// past the messages processed by this consumer, minimizing duplicate
+// processing across rebalances. See RebalanceCb().
+//
+// If messages are still in-flight when timeout expires the acknowledged
+// prefix is committed regardless and an error with the ErrTimedOut code
+// is returned. The remaining in-flight messages will be consumed again
+// by the new owner, in-flight processing of these messages should be
+// cancelled by the application.
+//
+// Returns the committed offsets, if any.
+func (a *Acker) Revoke(partitions []TopicPartition, timeout time.Duration) ([]TopicPartition, error) {
+	revoked := make(map[topicPartitionKey]bool, len(partitions))
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		revoked[topicPartitionKey{*tp.Topic, tp.Partition}] = true
+	}
+	include := func(key topicPartitionKey) bool {
+		return revoked[key]
+	}
+
+	deadline := time.Now().Add(timeout)
+	var inFlight []TopicPartition
+	for {
+		inFlight = a.pendingPartitions(include)
+		if len(inFlight) == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(ackerRevokePollInterval)
+	}
+
+	committed, err := a.commit(include)
+	if kerr, ok := err.(Error); ok && kerr.Code() == ErrNoOffset {
+		err = nil
+	}
+
+	a.lock.Lock()
+	for key := range revoked {
+		delete(a.partitions, key)
+	}
+	a.lock.Unlock()
+
+	if err == nil && len(inFlight) > 0 {
+		err = newErrorFromString(ErrTimedOut,
+			fmt.Sprintf("Timed out waiting for in-flight messages "+
+				"of revoked partitions %v", inFlight))
+	}
+
+	return committed, err
+}
+
+// pendingPartitions returns the partitions, for which include returns true,
+// with tracked messages not yet acknowledged, with the offset of their first
+// unacknowledged message.
+func (a *Acker) pendingPartitions(include func(key topicPartitionKey) bool) []TopicPartition {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var pending []TopicPartition
+	for key, ap := range a.partitions {
+		if !include(key) || len(ap.pending) == 0 {
+			continue
+		}
+		topic := key.topic
+		pending = append(pending, TopicPartition{
+			Topic:     &topic,
+			Partition: key.partition,
+			Offset:    ap.pending[0],
+		})
+	}
+
+	return pending
+}
+
+// RebalanceCb returns a rebalance callback, for Subscribe() or
+// SubscribeTopics(), that calls Revoke() with timeout for the partitions
+// of RevokedPartitions events before calling rebalanceCb, if not nil.
+// Unless rebalanceCb unassigns the partitions they are unassigned by the
+// Consumer once the callback returns.
+//
+// Revoke() errors are not returned since the partitions must be unassigned
+// regardless, they are passed to onRevokeError with the revoked partitions,
+// if not nil, else logged as an ACKERREVOKE error log.
+func (a *Acker) RebalanceCb(timeout time.Duration, rebalanceCb RebalanceCb, onRevokeError func(partitions []TopicPartition, err error)) RebalanceCb {
+	const logError = 3 // syslog LOG_ERR
+
+	return func(c *Consumer, ev Event) error {
+		if e, ok := ev.(RevokedPartitions); ok {
+			_, err := a.Revoke(e.Partitions, timeout)
+			if err != nil {
+				if onRevokeError != nil {
+					onRevokeError(e.Partitions, err)
+				} else {
+					c.handle.log(logError, "ACKERREVOKE", fmt.Sprintf(
+						"Failed to commit %d revoked partition(s): %v",
+						len(e.Partitions), err))
+				}
+			}
+		}
+
+		if rebalanceCb != nil {
+			return rebalanceCb(c, ev)
+		}
+		return nil
+	}
+}
+
+// SetCommitPolicy sets the policy for batched commits, replacing the
+// previous policy. Batched commits are performed by a background
+// goroutine, stop it with Close().
+//
+// Errors of batched commits are not returned, the offsets are committed
+// again by the next commit.
+// SetCommitPolicy and Close must not be called concurrently.
+func (a *Acker) SetCommitPolicy(policy AckerCommitPolicy) {
+	a.stopCommitter()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.policy = policy
+
+	if policy.Messages <= 0 && policy.Interval <= 0 {
+		return
+	}
+
+	commitChan := make(chan bool, 1)
+	termChan := make(chan bool)
+	a.commitChan = commitChan
+	a.termChan = termChan
+
+	a.waitGroup.Add(1)
+	go func() {
+		a.committer(policy, commitChan, termChan)
+		a.waitGroup.Done()
+	}()
+}
+
+// CommitPolicy returns the current batched commit policy.
+func (a *Acker) CommitPolicy() AckerCommitPolicy {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.policy
+}
+
+// Close stops batched commits and performs a final Commit() of the
+// acknowledged offsets, returning its error, if any, other than there
+// being nothing new to commit.
+// Close must be called before closing the Consumer.
+func (a *Acker) Close() error {
+	a.SetCommitPolicy(AckerCommitPolicy{})
+
+	_, err := a.Commit()
+	if kerr, ok := err.(Error); ok && kerr.Code() == ErrNoOffset {
+		return nil
+	}
+
+	return err
+}
+
+// stopCommitter stops the committer goroutine, if running.
+func (a *Acker) stopCommitter() {
+	if a.termChan == nil {
+		return
+	}
+
+	close(a.termChan)
+	a.waitGroup.Wait()
+
+	a.lock.Lock()
+	a.commitChan = nil
+	a.termChan = nil
+	a.lock.Unlock()
+}
+
+// committer commits according to policy until termChan is closed.
+func (a *Acker) committer(policy AckerCommitPolicy, commitChan chan bool, termChan chan bool) {
+	var tick <-chan time.Time
+	if policy.Interval > 0 {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-termChan:
+			return
+		case <-tick:
+		case <-commitChan:
+		}
+
+		a.Commit()
+	}
+}
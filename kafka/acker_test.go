@@ -0,0 +1,419 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAcker acknowledges messages out of order and verifies that only
+// the contiguous acknowledged prefix is committed.
+func TestAcker(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "ackertopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "ackergroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt, 30*time.Second)
+
+	a := NewAcker(c)
+	for _, m := range msgs {
+		err = a.Track(m)
+		if err != nil {
+			t.Fatalf("Track(%v): %v", m.TopicPartition, err)
+		}
+	}
+
+	expectCommitted := func(expected Offset) {
+		committed, err := c.Committed([]TopicPartition{
+			{Topic: &topic, Partition: 0}}, 5000)
+		if err != nil {
+			t.Fatalf("Committed: %v", err)
+		}
+		if committed[0].Offset != expected {
+			t.Errorf("Expected committed offset %v, got %v",
+				expected, committed[0])
+		}
+	}
+
+	expectInFlight := func(expected int64) {
+		inFlight := a.InFlightUncommitted()
+		if len(inFlight) != 1 {
+			t.Fatalf("Expected one partition in flight, got %v", inFlight)
+		}
+		for tp, n := range inFlight {
+			if *tp.Topic != topic || tp.Partition != 0 || n != expected {
+				t.Errorf("Expected %d in-flight uncommitted messages, got %v: %d",
+					expected, tp, n)
+			}
+		}
+	}
+
+	expectInFlight(int64(msgcnt))
+
+	_, err = a.Commit()
+	if err == nil || err.(Error).Code() != ErrNoOffset {
+		t.Errorf("Expected ErrNoOffset with nothing acknowledged, got %v", err)
+	}
+
+	// Leave a gap at offset 3.
+	for _, i := range []int{5, 1, 0, 4, 2} {
+		err = a.Ack(msgs[i])
+		if err != nil {
+			t.Fatalf("Ack(%v): %v", msgs[i].TopicPartition, err)
+		}
+	}
+
+	_, err = a.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	expectCommitted(3)
+	expectInFlight(int64(msgcnt - 3))
+
+	// Nothing new to commit until the gap is filled.
+	_, err = a.Commit()
+	if err == nil || err.(Error).Code() != ErrNoOffset {
+		t.Errorf("Expected ErrNoOffset with gap unfilled, got %v", err)
+	}
+
+	err = a.Ack(msgs[3])
+	if err != nil {
+		t.Fatalf("Ack(%v): %v", msgs[3].TopicPartition, err)
+	}
+
+	offsets, err := a.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if len(offsets) != 1 || offsets[0].Offset != 6 {
+		t.Errorf("Expected offset 6 to be committed, got %v", offsets)
+	}
+	expectCommitted(6)
+	expectInFlight(int64(msgcnt - 6))
+
+	// Acknowledging twice is an error.
+	err = a.Ack(msgs[3])
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for duplicate Ack, got %v", err)
+	}
+
+	// Tracking out of order is an error.
+	err = a.Track(msgs[0])
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for out of order Track, got %v", err)
+	}
+}
+
+// TestAckerCommitPolicy verifies batched commits by message count and
+// by interval, and the final commit on Close().
+func TestAckerCommitPolicy(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "ackerpolicytopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "ackerpolicygroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt, 30*time.Second)
+
+	a := NewAcker(c)
+	for _, m := range msgs {
+		err = a.Track(m)
+		if err != nil {
+			t.Fatalf("Track(%v): %v", m.TopicPartition, err)
+		}
+	}
+
+	waitCommitted := func(expected Offset) {
+		var committed []TopicPartition
+		tEnd := time.Now().Add(5 * time.Second)
+		for time.Now().Before(tEnd) {
+			committed, err = c.Committed([]TopicPartition{
+				{Topic: &topic, Partition: 0}}, 5000)
+			if err != nil {
+				t.Fatalf("Committed: %v", err)
+			}
+			if committed[0].Offset == expected {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Errorf("Expected committed offset %v, got %v", expected, committed)
+	}
+
+	ack := func(from, to int) {
+		for _, m := range msgs[from:to] {
+			err = a.Ack(m)
+			if err != nil {
+				t.Fatalf("Ack(%v): %v", m.TopicPartition, err)
+			}
+		}
+	}
+
+	// Commit every 3 messages.
+	policy := AckerCommitPolicy{Messages: 3, Interval: time.Hour}
+	a.SetCommitPolicy(policy)
+	if a.CommitPolicy() != policy {
+		t.Errorf("Expected commit policy %v, got %v", policy, a.CommitPolicy())
+	}
+
+	ack(0, 3)
+	waitCommitted(3)
+
+	// Commit every 100ms.
+	a.SetCommitPolicy(AckerCommitPolicy{Messages: 100, Interval: 100 * time.Millisecond})
+	ack(3, 5)
+	waitCommitted(5)
+
+	// No batched commits, but a final commit on Close().
+	a.SetCommitPolicy(AckerCommitPolicy{})
+	ack(5, 7)
+
+	err = a.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitCommitted(7)
+
+	// Nothing new to commit is not an error.
+	err = a.Close()
+	if err != nil {
+		t.Errorf("Expected nil error from Close with nothing to commit, got %v", err)
+	}
+}
+
+// TestAckerRevoke verifies that Revoke() waits for the in-flight messages
+// of the revoked partitions, commits them and stops tracking the partitions.
+func TestAckerRevoke(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "ackerrevoketopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 4
+	mockProduce(t, mc, topic, 0, msgcnt)
+	mockProduce(t, mc, topic, 1, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "ackerrevokegroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	partitions := []TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning}}
+	err = c.Assign(partitions)
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt*2, 30*time.Second)
+
+	a := NewAcker(c)
+	var late []*Message
+	for _, m := range msgs {
+		err = a.Track(m)
+		if err != nil {
+			t.Fatalf("Track(%v): %v", m.TopicPartition, err)
+		}
+		if m.TopicPartition.Partition == 0 {
+			late = append(late, m)
+		}
+	}
+
+	// Partition 0's messages are acknowledged while Revoke() waits,
+	// partition 1's last message is never acknowledged.
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		for _, m := range late {
+			a.Ack(m)
+		}
+	}()
+	for _, m := range msgs {
+		if m.TopicPartition.Partition == 1 &&
+			m.TopicPartition.Offset < Offset(msgcnt-1) {
+			a.Ack(m)
+		}
+	}
+
+	committed, err := a.Revoke(partitions[:1], 10*time.Second)
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if len(committed) != 1 || committed[0].Partition != 0 ||
+		committed[0].Offset != Offset(msgcnt) {
+		t.Errorf("Expected partition 0 committed at %d, got %v",
+			msgcnt, committed)
+	}
+
+	if err = a.Ack(late[0]); err == nil {
+		t.Errorf("Expected Ack() of a revoked partition to fail")
+	}
+
+	start := time.Now()
+	committed, err = a.Revoke(partitions[1:], time.Second)
+	if err == nil || err.(Error).Code() != ErrTimedOut {
+		t.Errorf("Expected Revoke() to time out, got %v", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("Expected Revoke() to wait for the timeout, "+
+			"returned after %v", time.Since(start))
+	}
+	if len(committed) != 1 || committed[0].Partition != 1 ||
+		committed[0].Offset != Offset(msgcnt-1) {
+		t.Errorf("Expected partition 1 committed at %d, got %v",
+			msgcnt-1, committed)
+	}
+}
+
+// TestAckerRebalanceCb verifies that the Acker's rebalance callback passes
+// Revoke() errors to onRevokeError, and only rebalance events to the
+// application's rebalance callback.
+func TestAckerRebalanceCb(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "ackerrebalancetopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 2)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "ackerrebalancegroup",
+		"enable.auto.commit": false,
+		"auto.offset.reset":  "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	a := NewAcker(c)
+
+	var events []Event
+	var revokeErr error
+	rebalanceCb := a.RebalanceCb(100*time.Millisecond,
+		func(c *Consumer, ev Event) error {
+			events = append(events, ev)
+			return nil
+		},
+		func(partitions []TopicPartition, err error) {
+			revokeErr = err
+		})
+
+	err = c.Subscribe(topic, rebalanceCb)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// The last message is never acknowledged.
+	msgs := mockConsume(t, c, 2, 30*time.Second)
+	for _, m := range msgs {
+		if err = a.Track(m); err != nil {
+			t.Fatalf("Track(%v): %v", m.TopicPartition, err)
+		}
+	}
+	a.Ack(msgs[0])
+
+	err = c.Unsubscribe()
+	if err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	for i := 0; i < 10 && len(events) < 2; i++ {
+		c.Poll(100)
+	}
+
+	if kerr, ok := revokeErr.(Error); !ok || kerr.Code() != ErrTimedOut {
+		t.Errorf("Expected onRevokeError with ErrTimedOut, got %v", revokeErr)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected an assign and a revoke event, got %v", events)
+	}
+	if _, ok := events[0].(AssignedPartitions); !ok {
+		t.Errorf("Expected AssignedPartitions, got %v", events[0])
+	}
+	if _, ok := events[1].(RevokedPartitions); !ok {
+		t.Errorf("Expected RevokedPartitions, got %v", events[1])
+	}
+}
@@ -42,6 +42,13 @@ ConfigResource_by_idx (const rd_kafka_ConfigResource_t **res, size_t cnt, size_t
     return res[idx];
 }
 
+static const rd_kafka_group_result_t *
+group_result_by_idx (const rd_kafka_group_result_t **groups, size_t cnt, size_t idx) {
+    if (idx >= cnt)
+      return NULL;
+    return groups[idx];
+}
+
 static const rd_kafka_ConfigEntry_t *
 ConfigEntry_by_idx (const rd_kafka_ConfigEntry_t **entries, size_t cnt, size_t idx) {
     if (idx >= cnt)
@@ -906,6 +913,94 @@ func (a *AdminClient) DescribeConfigs(ctx context.Context, resources []ConfigRes
 	return a.cConfigResourceToResult(cResults, cCnt)
 }
 
+// DeleteConsumerGroupOffsets deletes the committed offsets of the given
+// partitions for consumer group group, causing the group's consumers to
+// start from the position given by `auto.offset.reset` the next time the
+// partitions are assigned.
+//
+// Offsets can only be deleted for topics that the group is not actively
+// subscribed to, other partitions fail with ErrGroupSubscribedToTopic.
+//
+// Returns the per-partition results, with TopicPartition.Error set for
+// partitions whose offset could not be deleted, or an error if the
+// request as a whole failed, e.g., if the group does not exist.
+//
+// Requires broker version >= 2.4.0
+func (a *AdminClient) DeleteConsumerGroupOffsets(ctx context.Context, group string, partitions []TopicPartition, options ...DeleteConsumerGroupOffsetsAdminOption) (result []TopicPartition, err error) {
+	if len(partitions) == 0 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"Expected at least one partition")
+	}
+
+	cGroup := C.CString(group)
+	defer C.free(unsafe.Pointer(cGroup))
+
+	cParts := newCPartsFromTopicPartitions(partitions)
+	defer C.rd_kafka_topic_partition_list_destroy(cParts)
+
+	cDelOffsets := []*C.rd_kafka_DeleteConsumerGroupOffsets_t{
+		C.rd_kafka_DeleteConsumerGroupOffsets_new(cGroup, cParts),
+	}
+	if cDelOffsets[0] == nil {
+		return nil, newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Invalid arguments for group %s", group))
+	}
+	defer C.rd_kafka_DeleteConsumerGroupOffsets_destroy(cDelOffsets[0])
+
+	// Convert Go AdminOptions (if any) to C AdminOptions
+	genericOptions := make([]AdminOption, len(options))
+	for i := range options {
+		genericOptions[i] = options[i]
+	}
+	cOptions, err := adminOptionsSetup(a.handle, C.RD_KAFKA_ADMIN_OP_DELETECONSUMERGROUPOFFSETS, genericOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer C.rd_kafka_AdminOptions_destroy(cOptions)
+
+	// Create temporary queue for async operation
+	cQueue := C.rd_kafka_queue_new(a.handle.rk)
+	defer C.rd_kafka_queue_destroy(cQueue)
+
+	// Asynchronous call
+	C.rd_kafka_DeleteConsumerGroupOffsets(
+		a.handle.rk,
+		(**C.rd_kafka_DeleteConsumerGroupOffsets_t)(&cDelOffsets[0]),
+		C.size_t(len(cDelOffsets)),
+		cOptions,
+		cQueue)
+
+	// Wait for result, error or context timeout
+	rkev, err := a.waitResult(ctx, cQueue, C.RD_KAFKA_EVENT_DELETECONSUMERGROUPOFFSETS_RESULT)
+	if err != nil {
+		return nil, err
+	}
+	defer C.rd_kafka_event_destroy(rkev)
+
+	cRes := C.rd_kafka_event_DeleteConsumerGroupOffsets_result(rkev)
+
+	// Convert result from C to Go
+	var cCnt C.size_t
+	cGroupRes := C.rd_kafka_DeleteConsumerGroupOffsets_result_groups(cRes, &cCnt)
+	cGroupResult := C.group_result_by_idx(cGroupRes, cCnt, 0)
+	if cGroupResult == nil {
+		return nil, newErrorFromString(ErrFail, "No group result returned")
+	}
+
+	cErr := C.rd_kafka_group_result_error(cGroupResult)
+	if cErr != nil {
+		return nil, newErrorFromCString(C.rd_kafka_error_code(cErr),
+			C.rd_kafka_error_string(cErr))
+	}
+
+	cResParts := C.rd_kafka_group_result_partitions(cGroupResult)
+	if cResParts == nil {
+		return nil, nil
+	}
+
+	return newTopicPartitionsFromCparts(cResParts), nil
+}
+
 // GetMetadata queries broker for cluster and topic metadata.
 // If topic is non-nil only information about that topic is returned, else if
 // allTopics is false only information about locally used topics is returned,
@@ -950,6 +1045,12 @@ func (a *AdminClient) SetOAuthBearerTokenFailure(errstr string) error {
 	return a.handle.setOAuthBearerTokenFailure(errstr)
 }
 
+// CPtr returns the underlying librdkafka `rd_kafka_t` instance handle,
+// see Handle.CPtr() for the caveats.
+func (a *AdminClient) CPtr() unsafe.Pointer {
+	return a.handle.cPtr()
+}
+
 // Close an AdminClient instance.
 func (a *AdminClient) Close() {
 	if a.isDerived {
@@ -282,6 +282,25 @@ func testAdminAPIs(what string, a *AdminClient, t *testing.T) {
 		t.Fatalf("Expected DeadlineExceeded, not %v", ctx.Err())
 	}
 
+	ctx, cancel = context.WithTimeout(context.Background(), expDuration)
+	defer cancel()
+	dtopic := "mytopic"
+	dres, err := a.DeleteConsumerGroupOffsets(
+		ctx, "mygroup",
+		[]TopicPartition{{Topic: &dtopic, Partition: 0}},
+		SetAdminRequestTimeout(time.Minute))
+	if dres != nil || err == nil {
+		t.Fatalf("Expected DeleteConsumerGroupOffsets to fail, but got result: %v, err: %v", dres, err)
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded, not %v", ctx.Err())
+	}
+
+	_, err = a.DeleteConsumerGroupOffsets(ctx, "mygroup", nil)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Fatalf("Expected ErrInvalidArg for empty partition list, not %v", err)
+	}
+
 	ctx, cancel = context.WithTimeout(context.Background(), expDuration)
 	defer cancel()
 	clusterID, err := a.ClusterID(ctx)
@@ -113,6 +113,8 @@ func (ao AdminOptionRequestTimeout) supportsAlterConfigs() {
 }
 func (ao AdminOptionRequestTimeout) supportsDescribeConfigs() {
 }
+func (ao AdminOptionRequestTimeout) supportsDeleteConsumerGroupOffsets() {
+}
 
 func (ao AdminOptionRequestTimeout) apply(cOptions *C.rd_kafka_AdminOptions_t) error {
 	if !ao.isSet {
@@ -240,6 +242,14 @@ type DescribeConfigsAdminOption interface {
 	apply(cOptions *C.rd_kafka_AdminOptions_t) error
 }
 
+// DeleteConsumerGroupOffsetsAdminOption - see setters.
+//
+// See SetAdminRequestTimeout.
+type DeleteConsumerGroupOffsetsAdminOption interface {
+	supportsDeleteConsumerGroupOffsets()
+	apply(cOptions *C.rd_kafka_AdminOptions_t) error
+}
+
 // AdminOption is a generic type not to be used directly.
 //
 // See CreateTopicsAdminOption et.al.
@@ -0,0 +1,159 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+)
+
+// assignmentRegistryKey identifies a partition assigned to a consumer
+// group.
+type assignmentRegistryKey struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// assignmentRegistry tracks the partitions assigned to the Consumers in
+// this process that have `go.assignment.overlap.warn` enabled, to warn
+// when two Consumers in the same group are assigned the same partition
+// and thus fight over its committed offset.
+type assignmentRegistry struct {
+	lock   sync.Mutex
+	owners map[assignmentRegistryKey]map[*Consumer]bool
+}
+
+// assignments is the process-wide assignment registry.
+var assignments = assignmentRegistry{
+	owners: make(map[assignmentRegistryKey]map[*Consumer]bool),
+}
+
+// add registers partitions as assigned to c in group, returning the
+// warnings for partitions already assigned to other Consumers.
+func (r *assignmentRegistry) add(c *Consumer, group string, partitions []TopicPartition) (warnings []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, tp := range partitions {
+		key := assignmentRegistryKey{group, *tp.Topic, tp.Partition}
+		owners, found := r.owners[key]
+		if !found {
+			owners = make(map[*Consumer]bool)
+			r.owners[key] = owners
+		}
+
+		for other := range owners {
+			if other != c {
+				warnings = append(warnings, fmt.Sprintf(
+					"%s [%d] is assigned to both %s and %s in group %s: "+
+						"the consumers will overwrite each other's committed offsets",
+					key.topic, key.partition, other, c, group))
+			}
+		}
+
+		owners[c] = true
+	}
+
+	return warnings
+}
+
+// remove unregisters partitions as assigned to c in group.
+func (r *assignmentRegistry) remove(c *Consumer, group string, partitions []TopicPartition) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, tp := range partitions {
+		key := assignmentRegistryKey{group, *tp.Topic, tp.Partition}
+		r.removeKey(c, key)
+	}
+}
+
+// removeAll unregisters all partitions assigned to c.
+func (r *assignmentRegistry) removeAll(c *Consumer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for key := range r.owners {
+		r.removeKey(c, key)
+	}
+}
+
+// removeKey unregisters key as assigned to c.
+// Must be called with the lock held.
+func (r *assignmentRegistry) removeKey(c *Consumer, key assignmentRegistryKey) {
+	owners, found := r.owners[key]
+	if !found {
+		return
+	}
+
+	delete(owners, c)
+	if len(owners) == 0 {
+		delete(r.owners, key)
+	}
+}
+
+// registerAssign updates the registry after Assign(), replacing c's
+// previous assignment, and warns about overlapping partitions.
+func (c *Consumer) registerAssign(partitions []TopicPartition) {
+	if c.assignmentOverlapGroup == "" {
+		return
+	}
+
+	assignments.removeAll(c)
+	c.warnAssignmentOverlap(assignments.add(c, c.assignmentOverlapGroup, partitions))
+}
+
+// registerIncrementalAssign updates the registry after
+// IncrementalAssign() and warns about overlapping partitions.
+func (c *Consumer) registerIncrementalAssign(partitions []TopicPartition) {
+	if c.assignmentOverlapGroup == "" {
+		return
+	}
+
+	c.warnAssignmentOverlap(assignments.add(c, c.assignmentOverlapGroup, partitions))
+}
+
+// registerIncrementalUnassign updates the registry after
+// IncrementalUnassign().
+func (c *Consumer) registerIncrementalUnassign(partitions []TopicPartition) {
+	if c.assignmentOverlapGroup == "" {
+		return
+	}
+
+	assignments.remove(c, c.assignmentOverlapGroup, partitions)
+}
+
+// unregisterAssignment removes c's assignment from the registry, after
+// Unassign() or when c is closed.
+func (c *Consumer) unregisterAssignment() {
+	if c.assignmentOverlapGroup == "" {
+		return
+	}
+
+	assignments.removeAll(c)
+}
+
+// warnAssignmentOverlap logs the warnings as ASSIGNOVERLAP logs,
+// see handle.log().
+func (c *Consumer) warnAssignmentOverlap(warnings []string) {
+	const logWarning = 4 // syslog LOG_WARNING
+
+	for _, warning := range warnings {
+		c.handle.log(logWarning, "ASSIGNOVERLAP", warning)
+	}
+}
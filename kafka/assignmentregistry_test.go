@@ -0,0 +1,126 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAssignmentOverlapWarn verifies that an ASSIGNOVERLAP warning is
+// logged when two consumers in the same group, with
+// go.assignment.overlap.warn enabled, assign the same partition.
+func TestAssignmentOverlapWarn(t *testing.T) {
+	topic := "overlap"
+
+	newConsumer := func(group string) *Consumer {
+		c, err := NewConsumer(&ConfigMap{
+			"group.id":                   group,
+			"go.assignment.overlap.warn": true,
+			"go.logs.channel.enable":     true})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		return c
+	}
+
+	// expectWarnings checks that c logged exactly cnt overlap warnings.
+	expectWarnings := func(c *Consumer, cnt int) {
+		warnings := 0
+		for {
+			select {
+			case ev := <-c.Logs():
+				if ev.Tag != "ASSIGNOVERLAP" {
+					continue
+				}
+				if !strings.Contains(ev.Message, "overlap [1]") {
+					t.Errorf("Expected warning for overlap [1], got %s", ev.Message)
+				}
+				warnings++
+				continue
+			case <-time.After(100 * time.Millisecond):
+			}
+			break
+		}
+		if warnings != cnt {
+			t.Errorf("Expected %d overlap warnings from %s, got %d", cnt, c, warnings)
+		}
+	}
+
+	c1 := newConsumer("overlapgroup")
+	defer c1.Close()
+	c2 := newConsumer("overlapgroup")
+	defer c2.Close()
+	other := newConsumer("othergroup")
+	defer other.Close()
+
+	err := c1.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	expectWarnings(c1, 0)
+
+	// Another group may consume the same partitions.
+	err = other.Assign([]TopicPartition{{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	expectWarnings(other, 0)
+
+	err = c2.IncrementalAssign([]TopicPartition{
+		{Topic: &topic, Partition: 1},
+		{Topic: &topic, Partition: 2}})
+	if err != nil {
+		t.Fatalf("IncrementalAssign: %v", err)
+	}
+	expectWarnings(c2, 1)
+
+	// Once c2 no longer has the partition c1 may reassign it.
+	err = c2.IncrementalUnassign([]TopicPartition{{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("IncrementalUnassign: %v", err)
+	}
+	err = c1.Assign([]TopicPartition{{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	expectWarnings(c1, 0)
+
+	// Consumers without go.assignment.overlap.warn are not tracked.
+	c3, err := NewConsumer(&ConfigMap{"group.id": "overlapgroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c3.Close()
+	err = c3.Assign([]TopicPartition{{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	err = c1.Unassign()
+	if err != nil {
+		t.Fatalf("Unassign: %v", err)
+	}
+	err = c2.Assign([]TopicPartition{{Topic: &topic, Partition: 1}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	expectWarnings(c2, 0)
+}
@@ -0,0 +1,173 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// bridgeDrainInterval is how long the Bridge waits for the producer's
+// queue to drain before retrying a produce that failed with ErrQueueFull.
+const bridgeDrainInterval = 100 * time.Millisecond
+
+// BackpressureCb is called by a Bridge when it pauses (paused is true)
+// or resumes (paused is false) the consumer's partitions, see
+// Bridge.SetBackpressureCb().
+type BackpressureCb func(paused bool, partitions []TopicPartition)
+
+// Bridge runs a consume-transform-produce loop over a Consumer and a
+// Producer, applying backpressure from the producer to the consumer:
+// when the producer's queue is full (ErrQueueFull) the consumer's
+// assigned partitions are paused until the queue has drained enough for
+// the pending message to be produced, after which they are resumed.
+// This bounds the memory used by the loop to the producer's queue,
+// see `queue.buffering.max.messages` and `queue.buffering.max.kbytes`.
+//
+// Unlike Pipeline, Bridge provides at-least-once semantics at best and
+// does not commit offsets nor take ownership of the consumer or producer.
+// Delivery reports are emitted on the producer's Events() channel which
+// must be served by the application.
+//
+// Since the consumer is not polled while paused, the time it takes the
+// producer to drain its queue must not exceed `max.poll.interval.ms`.
+type Bridge struct {
+	c              *Consumer
+	p              *Producer
+	process        PipelineProcessor
+	backpressureCb BackpressureCb
+
+	termChan chan bool // Closed to stop Run()
+	stopOnce sync.Once
+}
+
+// NewBridge creates a Bridge consuming from c, which must have a
+// subscription or assignment, processing messages with process, and
+// producing the results with p.
+func NewBridge(c *Consumer, p *Producer, process PipelineProcessor) *Bridge {
+	return &Bridge{
+		c:        c,
+		p:        p,
+		process:  process,
+		termChan: make(chan bool),
+	}
+}
+
+// SetBackpressureCb sets a callback that is called with the affected
+// partitions whenever the Bridge pauses or resumes consumption.
+// Must be called before Run().
+func (b *Bridge) SetBackpressureCb(cb BackpressureCb) {
+	b.backpressureCb = cb
+}
+
+// Run consumes, processes and produces messages until Stop() is called,
+// in which case nil is returned.
+//
+// Errors returned by the PipelineProcessor, produce errors other than
+// ErrQueueFull, and fatal consumer errors are returned, other consumer
+// errors are ignored. The consumer is never left paused by Run().
+func (b *Bridge) Run() error {
+	for {
+		select {
+		case <-b.termChan:
+			return nil
+		default:
+		}
+
+		msg, err := b.c.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			if kerr, ok := err.(Error); ok && kerr.IsFatal() {
+				return err
+			}
+			continue
+		}
+
+		out, err := b.process(msg)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range out {
+			stopped, err := b.produce(m)
+			if err != nil || stopped {
+				return err
+			}
+		}
+	}
+}
+
+// produce produces msg, pausing the consumer while the producer's queue
+// is full.
+// Returns true if Stop() was called while paused, in which case msg
+// was not produced.
+func (b *Bridge) produce(msg *Message) (stopped bool, err error) {
+	err = b.p.Produce(msg, nil)
+	if !isQueueFull(err) {
+		return false, err
+	}
+
+	partitions, err := b.c.Assignment()
+	if err != nil {
+		return false, err
+	}
+
+	err = b.c.Pause(partitions)
+	if err != nil {
+		return false, err
+	}
+	if b.backpressureCb != nil {
+		b.backpressureCb(true, partitions)
+	}
+
+	defer func() {
+		rerr := b.c.Resume(partitions)
+		if err == nil {
+			err = rerr
+		}
+		if b.backpressureCb != nil {
+			b.backpressureCb(false, partitions)
+		}
+	}()
+
+	for {
+		select {
+		case <-b.termChan:
+			return true, nil
+		case <-time.After(bridgeDrainInterval):
+		}
+
+		err = b.p.Produce(msg, nil)
+		if !isQueueFull(err) {
+			return false, err
+		}
+	}
+}
+
+// isQueueFull returns true if err is ErrQueueFull.
+func isQueueFull(err error) bool {
+	kerr, ok := err.(Error)
+	return ok && kerr.Code() == ErrQueueFull
+}
+
+// Stop stops Run(). The messages of a consumed message that were not yet
+// produced when Run() returns are dropped; with `enable.auto.commit` the
+// consumed message's offset may nevertheless be committed.
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.termChan)
+	})
+}
@@ -0,0 +1,135 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBridgeBackpressure verifies that a Bridge with a slow producer
+// pauses the consumer while the producer's queue is full, resumes it once
+// drained, and that all messages are produced.
+func TestBridgeBackpressure(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	inTopic := "bridgeintopic"
+	outTopic := "bridgeouttopic"
+	msgcnt := 100
+	mockProduce(t, mc, inTopic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "bridgegroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &inTopic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// A small producer queue that is only sent every linger.ms makes for
+	// a slow producer.
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":            mc.BootstrapServers(),
+		"queue.buffering.max.messages": 10,
+		"batch.num.messages":           10,
+		"linger.ms":                    200})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	// Only accessed from Run().
+	paused := false
+	pauseCnt := 0
+	resumeCnt := 0
+	processed := 0
+
+	b := NewBridge(c, p, func(msg *Message) ([]*Message, error) {
+		if paused {
+			t.Errorf("Message %v consumed while paused", msg.TopicPartition)
+		}
+		processed++
+		return []*Message{{
+			TopicPartition: TopicPartition{Topic: &outTopic, Partition: 0},
+			Value:          msg.Value}}, nil
+	})
+	b.SetBackpressureCb(func(p bool, partitions []TopicPartition) {
+		if p == paused {
+			t.Errorf("Expected paused to toggle, got paused=%v twice", p)
+		}
+		if len(partitions) != 1 {
+			t.Errorf("Expected 1 affected partition, got %v", partitions)
+		}
+		paused = p
+		if paused {
+			pauseCnt++
+		} else {
+			resumeCnt++
+		}
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+
+	delivered := 0
+	timeout := time.After(30 * time.Second)
+	for delivered < msgcnt {
+		select {
+		case ev := <-p.Events():
+			m, ok := ev.(*Message)
+			if !ok {
+				continue
+			}
+			if m.TopicPartition.Error != nil {
+				t.Fatalf("Delivery failed: %v", m.TopicPartition)
+			}
+			delivered++
+		case err = <-runErr:
+			t.Fatalf("Run: %v", err)
+		case <-timeout:
+			t.Fatalf("Timed out after %d/%d delivered messages", delivered, msgcnt)
+		}
+	}
+
+	b.Stop()
+	if err = <-runErr; err != nil {
+		t.Fatalf("Expected Run to return nil after Stop, got %v", err)
+	}
+
+	if processed != msgcnt {
+		t.Errorf("Expected %d processed messages, got %d", msgcnt, processed)
+	}
+	if pauseCnt == 0 {
+		t.Errorf("Expected the consumer to be paused by the slow producer")
+	}
+	if resumeCnt != pauseCnt {
+		t.Errorf("Expected %d resumes, got %d", pauseCnt, resumeCnt)
+	}
+	t.Logf("Consumer was paused and resumed %d times", pauseCnt)
+}
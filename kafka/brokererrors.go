@@ -0,0 +1,106 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrokerError is the most recent error reported for a broker,
+// see Consumer.BrokerErrors().
+type BrokerError struct {
+	// Broker name as used by librdkafka, e.g., "ssl://host:9093".
+	Broker string
+	// Error is the error, e.g., ErrTransport for connection failures,
+	// ErrSsl for SSL handshake failures or ErrAuthentication for
+	// authentication failures.
+	Error Error
+	// Time the error was seen by the client.
+	Time time.Time
+}
+
+func (e BrokerError) String() string {
+	return fmt.Sprintf("%s: %v (at %s)", e.Broker, e.Error, e.Time.Format(time.RFC3339))
+}
+
+// brokerErrors tracks the most recent error per broker, by node id.
+type brokerErrors struct {
+	lock   sync.Mutex
+	errors map[int32]BrokerError
+}
+
+// parseBrokerErrorPrefix extracts the broker name and node id from an
+// error string of the form "<name>/<nodeid>: <reason>", as used by
+// librdkafka for broker-level errors.
+// Bootstrap brokers, "<name>/bootstrap", have the node id -1.
+func parseBrokerErrorPrefix(errstr string) (name string, nodeID int32, ok bool) {
+	end := strings.Index(errstr, ": ")
+	if end == -1 {
+		return "", 0, false
+	}
+
+	slash := strings.LastIndex(errstr[:end], "/")
+	if slash <= 0 {
+		return "", 0, false
+	}
+
+	name = errstr[:slash]
+	id := errstr[slash+1 : end]
+	if id == "bootstrap" {
+		return name, -1, true
+	}
+
+	n, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return name, int32(n), true
+}
+
+// add records err if it is a broker-level error.
+func (b *brokerErrors) add(err Error, now time.Time) {
+	name, nodeID, ok := parseBrokerErrorPrefix(err.str)
+	if !ok {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.errors == nil {
+		b.errors = make(map[int32]BrokerError)
+	}
+	b.errors[nodeID] = BrokerError{Broker: name, Error: err, Time: now}
+}
+
+// get returns a copy of the recorded errors.
+func (b *brokerErrors) get() map[int32]BrokerError {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	errors := make(map[int32]BrokerError, len(b.errors))
+	for nodeID, e := range b.errors {
+		errors[nodeID] = e
+	}
+
+	return errors
+}
@@ -0,0 +1,124 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseBrokerErrorPrefix verifies broker name and node id extraction
+// from librdkafka error strings.
+func TestParseBrokerErrorPrefix(t *testing.T) {
+	tests := []struct {
+		errstr string
+		name   string
+		nodeID int32
+		ok     bool
+	}{
+		{"127.0.0.1:9092/2: Connect to ipv4#127.0.0.1:9092 failed: Connection refused",
+			"127.0.0.1:9092", 2, true},
+		{"sasl_ssl://broker:9093/bootstrap: SSL handshake failed",
+			"sasl_ssl://broker:9093", -1, true},
+		{"1/3 brokers are down", "", 0, false},
+		{"Local: Timed out", "", 0, false},
+		{"broker/x: reason", "", 0, false},
+	}
+
+	for _, test := range tests {
+		name, nodeID, ok := parseBrokerErrorPrefix(test.errstr)
+		if name != test.name || nodeID != test.nodeID || ok != test.ok {
+			t.Errorf("%q: expected %q, %d, %v, got %q, %d, %v",
+				test.errstr, test.name, test.nodeID, test.ok, name, nodeID, ok)
+		}
+	}
+}
+
+// TestConsumerBrokerErrors takes down a mock broker and verifies that its
+// connection errors are reported by BrokerErrors().
+func TestConsumerBrokerErrors(t *testing.T) {
+	mc, err := NewMockCluster(3)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "brokererrorstopic"
+	err = mc.CreateTopic(topic, 3, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	for p := int32(0); p < 3; p++ {
+		err = mc.SetPartitionLeader(topic, p, p+1)
+		if err != nil {
+			t.Fatalf("SetPartitionLeader: %v", err)
+		}
+		mockProduce(t, mc, topic, p, 1)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "gotest",
+		"reconnect.backoff.ms":     100,
+		"reconnect.backoff.max.ms": 100})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 2, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// Fetch from all brokers.
+	mockConsume(t, c, 3, 30*time.Second)
+
+	err = mc.SetBrokerDown(2)
+	if err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	// Bring the broker back up for Close().
+	defer mc.SetBrokerUp(2)
+
+	var brokerErr BrokerError
+	var found bool
+	tEnd := time.Now().Add(10 * time.Second)
+	for !found && time.Now().Before(tEnd) {
+		c.Poll(100)
+		brokerErr, found = c.BrokerErrors()[2]
+	}
+
+	if !found {
+		t.Fatalf("Expected an error for broker 2, got %v", c.BrokerErrors())
+	}
+	t.Logf("Broker 2 error: %v", brokerErr)
+
+	if brokerErr.Error.Code() != ErrTransport {
+		t.Errorf("Expected ErrTransport for broker 2, got %v", brokerErr)
+	}
+
+	for nodeID := range c.BrokerErrors() {
+		if nodeID != 2 && nodeID != -1 {
+			t.Errorf("Unexpected error for broker %d: %v", nodeID, c.BrokerErrors())
+		}
+	}
+}
@@ -45,6 +45,36 @@ type ConfigValue interface{}
 // takes precedence.
 type ConfigMap map[string]ConfigValue
 
+// Consumer isolation levels, see the `isolation.level` configuration property.
+const (
+	// IsolationLevelReadUncommitted makes the consumer read all messages,
+	// including messages of open and aborted transactions.
+	IsolationLevelReadUncommitted = "read_uncommitted"
+	// IsolationLevelReadCommitted makes the consumer only read
+	// non-transactional messages and messages of committed transactions,
+	// this is the default.
+	IsolationLevelReadCommitted = "read_committed"
+)
+
+// SetIsolationLevel sets the consumer `isolation.level` configuration
+// property to level, which must be either IsolationLevelReadCommitted or
+// IsolationLevelReadUncommitted.
+//
+// With IsolationLevelReadCommitted the consumer does not read past
+// the Last Stable Offset (LSO), the offset of the first message of the
+// oldest open transaction, and filters out messages of aborted transactions.
+// The consumer's QueryWatermarkOffsets() then returns the LSO as the
+// high offset, and Lag() is computed against it.
+func (m ConfigMap) SetIsolationLevel(level string) error {
+	if level != IsolationLevelReadCommitted &&
+		level != IsolationLevelReadUncommitted {
+		return newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Invalid isolation.level \"%s\"", level))
+	}
+
+	return m.SetKey("isolation.level", level)
+}
+
 // SetKey sets configuration property key to value.
 //
 // For user convenience a key prefixed with {topic}. will be
@@ -263,6 +293,27 @@ func (m ConfigMap) extractLogConfig() (logsChanEnable bool, logsChan chan LogEve
 	return
 }
 
+// extractErrorConfig extracts generic go.error.* configuration properties,
+// go.error.stack.enable implies go.error.op.enable.
+func (m ConfigMap) extractErrorConfig() (opEnable bool, stackEnable bool, err error) {
+	v, err := m.extract("go.error.op.enable", false)
+	if err != nil {
+		return
+	}
+
+	opEnable = v.(bool)
+
+	v, err = m.extract("go.error.stack.enable", false)
+	if err != nil {
+		return
+	}
+
+	stackEnable = v.(bool)
+	opEnable = opEnable || stackEnable
+
+	return
+}
+
 func (m ConfigMap) clone() ConfigMap {
 	m2 := make(ConfigMap)
 	for k, v := range m {
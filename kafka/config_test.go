@@ -150,3 +150,41 @@ func TestConfigPluginPaths(t *testing.T) {
 		}
 	}
 }
+
+// TestConfigSetIsolationLevel verifies SetIsolationLevel() and that the
+// isolation level is in effect for the consumer.
+func TestConfigSetIsolationLevel(t *testing.T) {
+	config := &ConfigMap{"group.id": "gotest"}
+
+	c, err := NewConsumer(config)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	level := c.IsolationLevel()
+	c.Close()
+	if level != IsolationLevelReadCommitted {
+		t.Errorf("Expected default isolation level %s, got %s",
+			IsolationLevelReadCommitted, level)
+	}
+
+	err = config.SetIsolationLevel(IsolationLevelReadUncommitted)
+	if err != nil {
+		t.Fatalf("SetIsolationLevel: %v", err)
+	}
+
+	c, err = NewConsumer(config)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	level = c.IsolationLevel()
+	c.Close()
+	if level != IsolationLevelReadUncommitted {
+		t.Errorf("Expected isolation level %s, got %s",
+			IsolationLevelReadUncommitted, level)
+	}
+
+	err = config.SetIsolationLevel("read_everything")
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for invalid isolation level, got %v", err)
+	}
+}
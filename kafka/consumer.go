@@ -17,8 +17,12 @@ package kafka
  */
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -38,6 +42,11 @@ import "C"
 // The passed Event will be either AssignedPartitions or RevokedPartitions
 type RebalanceCb func(*Consumer, Event) error
 
+// OnBeforeLeaveCb is called by Consumer.Close() and
+// Consumer.LeaveGroupGracefully() before the consumer leaves its group,
+// see SetOnBeforeLeave().
+type OnBeforeLeaveCb func(*Consumer) error
+
 // Consumer implements a High-level Apache Kafka Consumer instance
 type Consumer struct {
 	events             chan Event
@@ -46,7 +55,58 @@ type Consumer struct {
 	readerTermChan     chan bool
 	rebalanceCb        RebalanceCb
 	appReassigned      bool
-	appRebalanceEnable bool // Config setting
+	appRebalanceEnable bool          // Config setting
+	idleTimeout        time.Duration // Config setting
+	lastMessageTime    time.Time     // Last message returned by ReadMessage()
+	throughput         *throughputMeter
+	valueReaderEnable  bool // Config setting
+	valueBuffer        valueBuffer
+	onBeforeLeave      OnBeforeLeaveCb
+	outOfRangeReset    Offset // Config setting, OffsetInvalid if disabled
+	brokerErrors       brokerErrors
+	interceptors       []ConsumerInterceptor
+	watermarkRetryLock sync.Mutex
+	watermarkRetry     RetryPolicy
+	// Config setting, the group.id if go.assignment.overlap.warn is
+	// enabled, else "".
+	assignmentOverlapGroup string
+	readyChan              chan bool // Closed on the first non-empty assignment
+	readyOnce              sync.Once
+	maxMessageAge          time.Duration // Config setting, 0 if disabled
+	// Consecutive stale messages skipped per partition,
+	// only accessed from the poll path.
+	staleCounts map[topicPartitionKey]int
+	// Config setting, the queued.min.messages threshold if
+	// go.fetch.queue.full.event.enable is enabled, else 0.
+	fetchQueueLimit int
+	fetchQueueFull  bool // Only accessed from the poll path
+	// Partitions owned according to the rebalance log, nil if
+	// go.rebalance.log.enable is disabled. Only accessed from the poll path.
+	rebalanceLogOwned map[topicPartitionKey]bool
+	// External offset store, see SetOffsetStore().
+	offsetStore            OffsetStore
+	offsetStoreKafkaCommit bool
+	// Poll watchdog, nil if go.max.poll.interval.warn.pct is disabled.
+	pollWatchdog *pollWatchdog
+	// Unknown topic errors emitted as TopicNotAvailable, nil if
+	// go.unknown.topic.errors.suppress is disabled.
+	// Only accessed from the poll path.
+	unknownTopicErrors map[string]bool
+	// Config setting, emit partition-scoped errors as PartitionError.
+	partitionErrorEvents bool
+	// Config setting, emit fencing of the static membership as
+	// InstanceFenced.
+	instanceFencedEvents bool
+	// Start positions of the assigned partitions, nil if
+	// go.start.positions.enable is disabled.
+	startPositions *startPositions
+	// Application configuration, kept for Reset().
+	conf ConfigMap
+	// Pauses the consumerReader() when closed, nil while paused,
+	// see PauseReader().
+	readerLock      sync.Mutex
+	readerPauseChan chan bool
+	readerDone      chan bool // Closed when consumerReader() returns
 }
 
 // Strings returns a human readable name for a Consumer instance
@@ -62,12 +122,64 @@ func (c *Consumer) gethandle() *handle {
 // Subscribe to a single topic
 // This replaces the current subscription
 func (c *Consumer) Subscribe(topic string, rebalanceCb RebalanceCb) error {
-	return c.SubscribeTopics([]string{topic}, rebalanceCb)
+	return c.handle.withOp("Consumer.Subscribe",
+		c.subscribeTopics([]string{topic}, rebalanceCb, false))
 }
 
 // SubscribeTopics subscribes to the provided list of topics.
 // This replaces the current subscription.
+//
+// If the set of topics is identical to the current subscription
+// (the order of topics is ignored) the subscription is left untouched,
+// avoiding an unnecessary rebalance, and only rebalanceCb is updated.
+// Use SubscribeTopicsForce() to resubscribe regardless.
 func (c *Consumer) SubscribeTopics(topics []string, rebalanceCb RebalanceCb) (err error) {
+	return c.handle.withOp("Consumer.SubscribeTopics",
+		c.subscribeTopics(topics, rebalanceCb, false))
+}
+
+// SubscribeTopicsForce subscribes to the provided list of topics, like
+// SubscribeTopics(), but always replaces the current subscription,
+// triggering a rebalance, even if the set of topics is unchanged.
+func (c *Consumer) SubscribeTopicsForce(topics []string, rebalanceCb RebalanceCb) (err error) {
+	return c.handle.withOp("Consumer.SubscribeTopicsForce",
+		c.subscribeTopics(topics, rebalanceCb, true))
+}
+
+// sameTopics returns true if a and b contain the same set of topics,
+// disregarding order.
+func sameTopics(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sa := append([]string(nil), a...)
+	sb := append([]string(nil), b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Consumer) subscribeTopics(topics []string, rebalanceCb RebalanceCb, force bool) (err error) {
+	if c.isClosed() {
+		return newClosedError()
+	}
+
+	if !force && len(topics) > 0 {
+		current, err := c.Subscription()
+		if err == nil && sameTopics(topics, current) {
+			c.rebalanceCb = rebalanceCb
+			return nil
+		}
+	}
+
 	ctopics := C.rd_kafka_topic_partition_list_new(C.int(len(topics)))
 	defer C.rd_kafka_topic_partition_list_destroy(ctopics)
 
@@ -87,6 +199,113 @@ func (c *Consumer) SubscribeTopics(topics []string, rebalanceCb RebalanceCb) (er
 	return nil
 }
 
+// SubscribeTopicsFrom subscribes to the provided list of topics, like
+// SubscribeTopics(), but starts consuming partitions that have no
+// committed offset for the consumer group from reset, which is either
+// "earliest" or "latest", rather than from the `auto.offset.reset`
+// configuration property.
+// This replaces the current subscription.
+//
+// The override is applied when partitions are assigned, by looking up the
+// committed offsets of the assigned partitions and explicitly assigning
+// partitions without a committed offset from the beginning or end.
+// If the committed offsets can't be retrieved the partitions are
+// assigned as-is and `auto.offset.reset` applies.
+// Offset resets on OffsetOutOfRange errors still use `auto.offset.reset`,
+// or `go.offset.out.of.range.reset` if set.
+//
+// The committed offsets are looked up synchronously from the rebalance
+// callback, i.e., from the application's Poll() or ReadMessage() call,
+// which blocks for the round-trip to the group coordinator, and for up to
+// timeoutMs if it is unavailable. This delays the rebalance and counts
+// towards `max.poll.interval.ms`, so timeoutMs should be well below it.
+// Use `go.offset.out.of.range.reset` instead, which resets partitions
+// without a committed offset from the poll path without blocking, if the
+// same reset applies to all subscriptions and to out of range offsets.
+//
+// rebalanceCb, if not nil, is called with the AssignedPartitions event
+// reflecting the overridden offsets, and may call Assign() or
+// IncrementalAssign() itself.
+func (c *Consumer) SubscribeTopicsFrom(topics []string, reset string, timeoutMs int, rebalanceCb RebalanceCb) (err error) {
+	var resetOffset Offset
+
+	switch reset {
+	case "earliest":
+		resetOffset = OffsetBeginning
+	case "latest":
+		resetOffset = OffsetEnd
+	default:
+		return c.handle.withOp("Consumer.SubscribeTopicsFrom", newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Invalid reset \"%s\": expected \"earliest\" or \"latest\"", reset)))
+	}
+
+	cb := func(c *Consumer, ev Event) error {
+		e, ok := ev.(AssignedPartitions)
+		if !ok {
+			if rebalanceCb != nil {
+				return rebalanceCb(c, ev)
+			}
+			return nil
+		}
+
+		committed, err := c.Committed(e.Partitions, timeoutMs)
+		if err == nil {
+			for i := range e.Partitions {
+				if e.Partitions[i].Offset == OffsetInvalid &&
+					i < len(committed) &&
+					committed[i].Offset == OffsetInvalid {
+					e.Partitions[i].Offset = resetOffset
+				}
+			}
+		}
+
+		if rebalanceCb != nil {
+			c.appReassigned = false
+			err = rebalanceCb(c, e)
+			if c.appReassigned {
+				return err
+			}
+		}
+
+		if c.GetRebalanceProtocol() == "COOPERATIVE" {
+			return c.IncrementalAssign(e.Partitions)
+		}
+		return c.Assign(e.Partitions)
+	}
+
+	return c.handle.withOp("Consumer.SubscribeTopicsFrom",
+		c.subscribeTopics(topics, cb, false))
+}
+
+// FilterPartitions returns the partitions in assigned for which keep
+// returns true, for statically sharding the partitions of subscribed
+// topics, e.g., keeping only the partitions a consumer instance owns.
+//
+// Use it in the rebalance callback passed to Subscribe() or
+// SubscribeTopics() to assign a subset of the partitions assigned by
+// the group:
+//   func(c *kafka.Consumer, ev kafka.Event) error {
+//           if e, ok := ev.(kafka.AssignedPartitions); ok {
+//                   return c.Assign(kafka.FilterPartitions(e.Partitions, keep))
+//           }
+//           return nil
+//   }
+// With the cooperative rebalance protocol use IncrementalAssign() instead,
+// see GetRebalanceProtocol().
+//
+// The group still considers the filtered out partitions assigned to this
+// consumer and will not assign them to other members, so each shard is
+// typically consumed by the members of its own consumer group.
+func FilterPartitions(assigned []TopicPartition, keep func(TopicPartition) bool) []TopicPartition {
+	kept := make([]TopicPartition, 0, len(assigned))
+	for _, tp := range assigned {
+		if keep(tp) {
+			kept = append(kept, tp)
+		}
+	}
+	return kept
+}
+
 // Unsubscribe from the current subscription, if any.
 func (c *Consumer) Unsubscribe() (err error) {
 	C.rd_kafka_unsubscribe(c.handle.rk)
@@ -103,6 +322,10 @@ func (c *Consumer) Unsubscribe() (err error) {
 //
 // This replaces the current assignment.
 func (c *Consumer) Assign(partitions []TopicPartition) (err error) {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.Assign", newClosedError())
+	}
+
 	c.appReassigned = true
 
 	cparts := newCPartsFromTopicPartitions(partitions)
@@ -110,21 +333,101 @@ func (c *Consumer) Assign(partitions []TopicPartition) (err error) {
 
 	e := C.rd_kafka_assign(c.handle.rk, cparts)
 	if e != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return newError(e)
+		return c.handle.withOp("Consumer.Assign", newError(e))
 	}
 
+	c.registerAssign(partitions)
+	c.trackStartPositions(partitions, true)
+	c.setReady(partitions)
+
 	return nil
 }
 
+// AssignAndWait assigns partitions, see Assign(), and then blocks until
+// each partition has been fetched from its leader at least once, i.e., its
+// high watermark offset is cached, see GetWatermarkOffsets(),
+// which makes startup sequencing predictable.
+//
+// The function will block for at most timeoutMs milliseconds, after which
+// ErrTimedOut is returned with the partitions that are not yet ready.
+// The partitions are still assigned on timeout.
+func (c *Consumer) AssignAndWait(partitions []TopicPartition, timeoutMs int) error {
+	return c.handle.withOp("Consumer.AssignAndWait", c.assignAndWait(partitions, timeoutMs))
+}
+
+// assignAndWait implements AssignAndWait().
+func (c *Consumer) assignAndWait(partitions []TopicPartition, timeoutMs int) error {
+	err := c.Assign(partitions)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	pending := partitions
+
+	for {
+		var notReady []TopicPartition
+		for _, tp := range pending {
+			_, high, err := c.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+			if err != nil || high == int64(OffsetInvalid) {
+				notReady = append(notReady, tp)
+			}
+		}
+
+		if len(notReady) == 0 {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return newErrorFromString(ErrTimedOut,
+				fmt.Sprintf("Timed out waiting for partitions to be ready: %v",
+					notReady))
+		}
+
+		pending = notReady
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ready returns a channel that is closed once the consumer has been
+// assigned partitions for the first time, by Assign() or
+// IncrementalAssign(), including the assignments from the consumer
+// group's rebalances, i.e., once the consumer is actually consuming.
+// The channel remains closed for the lifetime of the consumer, even if
+// the partitions are later revoked.
+//
+// This is typically used for readiness health checks.
+func (c *Consumer) Ready() <-chan bool {
+	return c.readyChan
+}
+
+// setReady closes the Ready() channel if partitions is non-empty.
+func (c *Consumer) setReady(partitions []TopicPartition) {
+	if len(partitions) == 0 {
+		return
+	}
+
+	c.readyOnce.Do(func() {
+		close(c.readyChan)
+	})
+}
+
 // Unassign the current set of partitions to consume.
 func (c *Consumer) Unassign() (err error) {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.Unassign", newClosedError())
+	}
+
 	c.appReassigned = true
 
 	e := C.rd_kafka_assign(c.handle.rk, nil)
 	if e != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return newError(e)
+		return c.handle.withOp("Consumer.Unassign", newError(e))
 	}
 
+	c.unregisterAssignment()
+	c.untrackStartPositions(nil)
+
 	return nil
 }
 
@@ -139,6 +442,10 @@ func (c *Consumer) Unassign() (err error) {
 //
 // The new partitions must not be part of the current assignment.
 func (c *Consumer) IncrementalAssign(partitions []TopicPartition) (err error) {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.IncrementalAssign", newClosedError())
+	}
+
 	c.appReassigned = true
 
 	cparts := newCPartsFromTopicPartitions(partitions)
@@ -146,9 +453,14 @@ func (c *Consumer) IncrementalAssign(partitions []TopicPartition) (err error) {
 
 	cError := C.rd_kafka_incremental_assign(c.handle.rk, cparts)
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return c.handle.withOp("Consumer.IncrementalAssign",
+			newErrorFromCErrorDestroy(cError))
 	}
 
+	c.registerIncrementalAssign(partitions)
+	c.trackStartPositions(partitions, false)
+	c.setReady(partitions)
+
 	return nil
 }
 
@@ -166,9 +478,13 @@ func (c *Consumer) IncrementalUnassign(partitions []TopicPartition) (err error)
 
 	cError := C.rd_kafka_incremental_unassign(c.handle.rk, cparts)
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return c.handle.withOp("Consumer.IncrementalUnassign",
+			newErrorFromCErrorDestroy(cError))
 	}
 
+	c.registerIncrementalUnassign(partitions)
+	c.untrackStartPositions(partitions)
+
 	return nil
 }
 
@@ -200,6 +516,28 @@ func (c *Consumer) AssignmentLost() bool {
 // This is a blocking call, caller will need to wrap in go-routine to
 // get async or throw-away behaviour.
 func (c *Consumer) commit(offsets []TopicPartition) (committedOffsets []TopicPartition, err error) {
+	return c.commitTimeout(offsets, -1)
+}
+
+// commitTimeout commits offsets, or the stored offsets if offsets is nil,
+// waiting at most timeoutMs for the commit to complete, -1 to wait
+// indefinitely.
+func (c *Consumer) commitTimeout(offsets []TopicPartition, timeoutMs int) (committedOffsets []TopicPartition, err error) {
+	if len(c.interceptors) > 0 {
+		defer func() {
+			c.interceptCommit(committedOffsets, err)
+		}()
+	}
+
+	if c.offsetStore != nil {
+		committedOffsets, err = c.commitToOffsetStore(offsets)
+		if err != nil || !c.offsetStoreKafkaCommit {
+			return committedOffsets, err
+		}
+		// Commit the same offsets to Kafka.
+		offsets = committedOffsets
+	}
+
 	var rkqu *C.rd_kafka_queue_t
 
 	rkqu = C.rd_kafka_queue_new(c.handle.rk)
@@ -211,13 +549,18 @@ func (c *Consumer) commit(offsets []TopicPartition) (committedOffsets []TopicPar
 		defer C.rd_kafka_topic_partition_list_destroy(coffsets)
 	}
 
+	start := time.Now()
 	cErr := C.rd_kafka_commit_queue(c.handle.rk, coffsets, rkqu, nil, nil)
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newError(cErr)
+		return nil, setGroupRetriable(newError(cErr))
 	}
 
-	rkev := C.rd_kafka_queue_poll(rkqu, C.int(-1))
+	rkev := C.rd_kafka_queue_poll(rkqu, C.int(timeoutMs))
 	if rkev == nil {
+		if timeoutMs >= 0 {
+			return nil, newErrorFromString(ErrTimedOut,
+				fmt.Sprintf("Commit did not complete within %dms", timeoutMs))
+		}
 		// shouldn't happen
 		return nil, newError(C.RD_KAFKA_RESP_ERR__DESTROY)
 	}
@@ -230,8 +573,10 @@ func (c *Consumer) commit(offsets []TopicPartition) (committedOffsets []TopicPar
 
 	cErr = C.rd_kafka_event_error(rkev)
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev))
+		return nil, setGroupRetriable(
+			newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev)))
 	}
+	c.handle.latencies.record(OperationCommit, start)
 
 	cRetoffsets := C.rd_kafka_event_topic_partition_list(rkev)
 	if cRetoffsets == nil {
@@ -243,11 +588,90 @@ func (c *Consumer) commit(offsets []TopicPartition) (committedOffsets []TopicPar
 	return committedOffsets, nil
 }
 
+// setGroupRetriable sets the retriable flag of err if it is a transient
+// group coordinator error, such as ErrCoordinatorLoadInProgress while the
+// coordinator is loading the group's offsets after a broker restart,
+// that librdkafka gave up retrying.
+func setGroupRetriable(err Error) Error {
+	switch err.Code() {
+	case ErrCoordinatorLoadInProgress, ErrCoordinatorNotAvailable,
+		ErrNotCoordinator, ErrRequestTimedOut, ErrTimedOut:
+		err.retriable = true
+	}
+	return err
+}
+
+// DefaultCommitRetryPolicy is a retry policy for CommitWithRetry() that
+// rides over a group coordinator loading or moving for several seconds.
+var DefaultCommitRetryPolicy = RetryPolicy{
+	MaxRetries:     10,
+	Backoff:        200 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	AttemptTimeout: 10 * time.Second,
+}
+
+// CommitWithRetry commits offsets, or the offsets for the currently
+// assigned partitions if offsets is nil, like CommitOffsets() or
+// Commit(), retrying errors that have the retriable flag set, see
+// Error.IsRetriable(), according to policy, e.g.,
+// DefaultCommitRetryPolicy.
+//
+// ctx bounds the total time spent and cancels any pending retry.
+// policy.AttemptTimeout is not enforced for an ongoing commit, which is
+// bounded by librdkafka's request timeouts.
+// This is a blocking call.
+// Returns the committed offsets on success.
+func (c *Consumer) CommitWithRetry(ctx context.Context, offsets []TopicPartition, policy RetryPolicy) (committedOffsets []TopicPartition, err error) {
+	committedOffsets, err = c.commitWithRetry(ctx, offsets, policy)
+	return committedOffsets, c.handle.withOp("Consumer.CommitWithRetry", err)
+}
+
+// commitWithRetry implements CommitWithRetry().
+func (c *Consumer) commitWithRetry(ctx context.Context, offsets []TopicPartition, policy RetryPolicy) (committedOffsets []TopicPartition, err error) {
+	err = policy.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryWithPolicy(ctx, policy,
+		func(err error) bool {
+			kerr, ok := err.(Error)
+			return ok && kerr.IsRetriable()
+		},
+		func(time.Duration) error {
+			var err error
+			committedOffsets, err = c.commit(offsets)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return committedOffsets, nil
+}
+
 // Commit offsets for currently assigned partitions
 // This is a blocking call.
 // Returns the committed offsets on success.
 func (c *Consumer) Commit() ([]TopicPartition, error) {
-	return c.commit(nil)
+	offsets, err := c.commit(nil)
+	return offsets, c.handle.withOp("Consumer.Commit", err)
+}
+
+// CommitStored synchronously commits the offsets stored with StoreOffsets()
+// or, with `enable.auto.offset.store`, automatically on consumption,
+// i.e., the offsets the next auto-commit would commit,
+// waiting at most timeoutMs for the commit to complete.
+// Use it on shutdown, before Close(), to guarantee the stored offsets are
+// durably committed.
+//
+// Returns the committed offsets on success, an error with the ErrNoOffset
+// code if there are no stored offsets to commit, or ErrTimedOut if the
+// commit did not complete within timeoutMs, in which case it may still
+// complete in the background.
+func (c *Consumer) CommitStored(timeoutMs int) ([]TopicPartition, error) {
+	offsets, err := c.commitTimeout(nil, timeoutMs)
+	return offsets, c.handle.withOp("Consumer.CommitStored", err)
 }
 
 // CommitMessage commits offset based on the provided message.
@@ -255,18 +679,21 @@ func (c *Consumer) Commit() ([]TopicPartition, error) {
 // Returns the committed offsets on success.
 func (c *Consumer) CommitMessage(m *Message) ([]TopicPartition, error) {
 	if m.TopicPartition.Error != nil {
-		return nil, newErrorFromString(ErrInvalidArg, "Can't commit errored message")
+		return nil, c.handle.withOp("Consumer.CommitMessage",
+			newErrorFromString(ErrInvalidArg, "Can't commit errored message"))
 	}
 	offsets := []TopicPartition{m.TopicPartition}
 	offsets[0].Offset++
-	return c.commit(offsets)
+	offsets, err := c.commit(offsets)
+	return offsets, c.handle.withOp("Consumer.CommitMessage", err)
 }
 
 // CommitOffsets commits the provided list of offsets
 // This is a blocking call.
 // Returns the committed offsets on success.
 func (c *Consumer) CommitOffsets(offsets []TopicPartition) ([]TopicPartition, error) {
-	return c.commit(offsets)
+	offsets, err := c.commit(offsets)
+	return offsets, c.handle.withOp("Consumer.CommitOffsets", err)
 }
 
 // StoreOffsets stores the provided list of offsets that will be committed
@@ -286,7 +713,7 @@ func (c *Consumer) StoreOffsets(offsets []TopicPartition) (storedOffsets []Topic
 	storedOffsets = newTopicPartitionsFromCparts(coffsets)
 
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return storedOffsets, newError(cErr)
+		return storedOffsets, c.handle.withOp("Consumer.StoreOffsets", newError(cErr))
 	}
 
 	return storedOffsets, nil
@@ -300,13 +727,59 @@ func (c *Consumer) StoreOffsets(offsets []TopicPartition) (storedOffsets []Topic
 // If timeoutMs is 0 it will initiate the seek but return
 // immediately without any error reporting (e.g., async).
 //
+// The offset may be an absolute offset or one of the logical offsets
+// OffsetBeginning, OffsetEnd and OffsetStored. OffsetStored seeks to the
+// partition's committed offset, or according to `auto.offset.reset` if it
+// has none, as looked up within timeoutMs, which must thus not be 0.
+//
 // Seek() may only be used for partitions already being consumed
-// (through Assign() or implicitly through a self-rebalanced Subscribe()).
+// (through Assign() or implicitly through a self-rebalanced Subscribe()),
+// else ErrState is returned.
 // To set the starting offset it is preferred to use Assign() and provide
 // a starting offset for each partition.
 //
 // Returns an error on failure or nil otherwise.
 func (c *Consumer) Seek(partition TopicPartition, timeoutMs int) error {
+	return c.handle.withOp("Consumer.Seek", c.seek(partition, timeoutMs))
+}
+
+// seek implements Seek().
+func (c *Consumer) seek(partition TopicPartition, timeoutMs int) error {
+	if partition.Topic == nil {
+		return newErrorFromString(ErrInvalidArg, "Seek() requires a topic")
+	}
+
+	assignment, err := c.Assignment()
+	if err != nil {
+		return err
+	}
+	if !containsPartition(assignment, partition) {
+		return newErrorFromString(ErrState,
+			fmt.Sprintf("Can't seek %s [%d]: partition is not assigned",
+				*partition.Topic, partition.Partition))
+	}
+
+	if partition.Offset == OffsetStored {
+		if timeoutMs == 0 {
+			return newErrorFromString(ErrInvalidArg,
+				"Seek() to OffsetStored requires a timeout")
+		}
+
+		deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+		partition.Offset, err = c.storedSeekOffset(partition, timeoutMs)
+		if err != nil {
+			return err
+		}
+
+		if timeoutMs > 0 {
+			timeoutMs = int(time.Until(deadline) / time.Millisecond)
+			if timeoutMs <= 0 {
+				return newErrorFromString(ErrTimedOut,
+					fmt.Sprintf("Timed out seeking %v", partition))
+			}
+		}
+	}
+
 	rkt := c.handle.getRkt(*partition.Topic)
 	cErr := C.rd_kafka_seek(rkt,
 		C.int32_t(partition.Partition),
@@ -315,9 +788,77 @@ func (c *Consumer) Seek(partition TopicPartition, timeoutMs int) error {
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
 		return newError(cErr)
 	}
+	c.trackSeek(partition)
 	return nil
 }
 
+// storedSeekOffset returns the offset to seek partition to for
+// OffsetStored: its committed offset or, if it has none, the
+// `auto.offset.reset` offset.
+func (c *Consumer) storedSeekOffset(partition TopicPartition, timeoutMs int) (Offset, error) {
+	committed, err := c.Committed([]TopicPartition{partition}, timeoutMs)
+	if err != nil {
+		return OffsetInvalid, err
+	}
+	if committed[0].Error != nil {
+		return OffsetInvalid, committed[0].Error
+	}
+	if committed[0].Offset >= 0 {
+		return committed[0].Offset, nil
+	}
+
+	reset := c.autoOffsetReset()
+	if reset == OffsetInvalid {
+		return OffsetInvalid, newErrorFromString(ErrNoOffset,
+			fmt.Sprintf("Can't seek %s [%d] to OffsetStored: no committed offset and no auto.offset.reset",
+				*partition.Topic, partition.Partition))
+	}
+
+	return reset, nil
+}
+
+// autoOffsetReset returns the logical offset that `auto.offset.reset`
+// resets partitions to, or OffsetInvalid if it is set to error.
+func (c *Consumer) autoOffsetReset() Offset {
+	v, err := c.handle.getConfigValue("auto.offset.reset")
+	if err != nil {
+		return OffsetInvalid
+	}
+
+	switch v {
+	case "smallest", "earliest", "beginning":
+		return OffsetBeginning
+	case "largest", "latest", "end":
+		return OffsetEnd
+	}
+
+	return OffsetInvalid
+}
+
+// resetOutOfRange reassigns the partition of an offset reset error,
+// tp.Error, from the go.offset.out.of.range.reset offset, returning an OffsetReset event
+// on success or the original error on failure.
+func (c *Consumer) resetOutOfRange(tp TopicPartition) Event {
+	resetTp := tp
+	resetTp.Offset = c.outOfRangeReset
+	resetTp.Error = nil
+
+	// librdkafka has stopped fetching the partition, which a Seek()
+	// does not restart, so reassign it from the reset offset instead.
+	err := c.IncrementalUnassign([]TopicPartition{resetTp})
+	if err == nil {
+		err = c.IncrementalAssign([]TopicPartition{resetTp})
+	}
+	if err != nil {
+		return tp.Error.(Error)
+	}
+
+	return OffsetReset{
+		TopicPartition: tp,
+		ResetOffset:    c.outOfRangeReset,
+	}
+}
+
 // Poll the consumer for messages or events.
 //
 // Will block for at most timeoutMs milliseconds
@@ -327,10 +868,43 @@ func (c *Consumer) Seek(partition TopicPartition, timeoutMs int) error {
 //
 // Returns nil on timeout, else an Event
 func (c *Consumer) Poll(timeoutMs int) (event Event) {
+	if c.isClosed() {
+		return newClosedError()
+	}
+
+	if c.pollWatchdog != nil {
+		c.pollWatchdogEnter()
+		defer c.pollWatchdogExit()
+	}
+
 	ev, _ := c.handle.eventPoll(nil, timeoutMs, 1, nil)
 	return ev
 }
 
+// QueueLength returns the number of messages and events buffered in the
+// consumer queue, waiting to be read with Poll() or ReadMessage(), or to
+// be forwarded to the Events() channel.
+// A steadily growing length indicates that the application is not polling
+// fast enough to keep up with consumption.
+func (c *Consumer) QueueLength() int {
+	return c.handle.queueLength()
+}
+
+// FetchQueueLen returns the number of fetched messages buffered by
+// librdkafka in the consumer queue, waiting to be polled by the
+// application, e.g., for adapting the number of processing workers to
+// the backlog: a deep queue calls for more workers, a shallow queue for
+// fewer. Unlike consumer lag, the fetch queue depth reflects what has
+// already been fetched and is immediately available to the application.
+//
+// The count is that of QueueLength() and thus also includes the,
+// typically few, events queued along with the messages.
+// Messages of partitions consumed from split partition queues,
+// such as with PartitionRunner, are not included.
+func (c *Consumer) FetchQueueLen() int {
+	return c.QueueLength()
+}
+
 // Events returns the Events channel (if enabled)
 func (c *Consumer) Events() chan Event {
 	return c.events
@@ -355,11 +929,24 @@ func (c *Consumer) Logs() chan LogEvent {
 // Messages are returned as (msg, nil),
 // while general errors are returned as (nil, err),
 // and partition-specific errors are returned as (msg, err) where
-// msg.TopicPartition provides partition-specific information (such as topic, partition and offset).
+// msg.TopicPartition provides partition-specific information (such as topic, partition and offset),
+// including PartitionError events if `go.partition.error.event.enable` is set.
+//
+// InstanceFenced events, if `go.instance.fenced.event.enable` is set, are
+// returned as (nil, err) with their fatal ErrFencedInstanceID error.
 //
 // All other event types, such as PartitionEOF, AssignedPartitions, etc, are silently discarded.
 //
+// If `go.idle.timeout.ms` is configured and no message has been returned
+// for that long the call returns (nil, err) where
+// `err.(kafka.Error).Code() == kafka.ErrConsumerIdle`, allowing
+// applications to tell an idle source apart from a single poll timeout.
+// The idle timer is restarted when ErrConsumerIdle is returned.
+//
 func (c *Consumer) ReadMessage(timeout time.Duration) (*Message, error) {
+	if c.isClosed() {
+		return nil, c.handle.withOp("Consumer.ReadMessage", newClosedError())
+	}
 
 	var absTimeout time.Time
 	var timeoutMs int
@@ -372,16 +959,39 @@ func (c *Consumer) ReadMessage(timeout time.Duration) (*Message, error) {
 	}
 
 	for {
-		ev := c.Poll(timeoutMs)
+		pollTimeoutMs := timeoutMs
+
+		if c.idleTimeout > 0 {
+			idleMs := int(math.Max(0.0,
+				c.lastMessageTime.Add(c.idleTimeout).Sub(time.Now()).Seconds()*1000.0))
+			if idleMs == 0 {
+				c.lastMessageTime = time.Now()
+				return nil, newErrorFromString(ErrConsumerIdle,
+					fmt.Sprintf("No messages consumed in %v", c.idleTimeout))
+			}
+
+			if pollTimeoutMs < 0 || idleMs < pollTimeoutMs {
+				pollTimeoutMs = idleMs
+			}
+		}
+
+		ev := c.Poll(pollTimeoutMs)
 
 		switch e := ev.(type) {
 		case *Message:
 			if e.TopicPartition.Error != nil {
 				return e, e.TopicPartition.Error
 			}
+			c.lastMessageTime = time.Now()
 			return e, nil
 		case Error:
 			return nil, e
+		case PartitionError:
+			tp := e.TopicPartition
+			tp.Error = e.Error
+			return &Message{TopicPartition: tp}, e.Error
+		case InstanceFenced:
+			return nil, e.Error
 		default:
 			// Ignore other event types
 		}
@@ -399,9 +1009,113 @@ func (c *Consumer) ReadMessage(timeout time.Duration) (*Message, error) {
 
 }
 
+// SetOnBeforeLeave sets a callback that Close() calls before the consumer
+// leaves its group, while the consumer is still fully usable, or nil to
+// remove a previously set callback.
+//
+// This is the hook for integrating with an external coordinator to
+// stagger group departures, e.g., during a rolling restart, so that only
+// a bounded number of instances trigger a rebalance at once:
+// the callback would typically block until the coordinator grants a
+// departure slot.
+// If the callback returns an error the consumer is still closed and
+// Close() returns the error.
+//
+// LeaveGroupGracefully() calls the callback after pausing the assignment
+// and commits once it returns, so this is also where in-flight message
+// handlers should be waited for.
+//
+// The callback must not call Close(). It is not called by CloseNoCommit().
+func (c *Consumer) SetOnBeforeLeave(cb OnBeforeLeaveCb) {
+	c.onBeforeLeave = cb
+}
+
 // Close Consumer instance.
 // The object is no longer usable after this call.
+//
+// If an OnBeforeLeaveCb is set, see SetOnBeforeLeave(), it is called first
+// and its error, if any, is returned.
 func (c *Consumer) Close() (err error) {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.Close", newClosedError())
+	}
+
+	if c.onBeforeLeave != nil {
+		err = c.onBeforeLeave(c)
+	}
+
+	c.close()
+
+	return err
+}
+
+// LeaveGroupGracefully drains and commits the consumer before closing it,
+// to minimize duplicate processing when scaling down a group:
+//  1. the current assignment is paused so that no more messages are fetched,
+//  2. the OnBeforeLeaveCb, see SetOnBeforeLeave(), is called and is expected
+//     to wait for in-flight message handlers to finish and store their
+//     offsets, e.g., with StoreOffsets(),
+//  3. the stored offsets are committed synchronously,
+//  4. the consumer is closed as by Close(), handing off its partitions.
+//
+// The application must stop polling the consumer before calling
+// LeaveGroupGracefully.
+// If the callback or the commit fails the consumer is still closed and the
+// first error is returned.
+//
+// ctx bounds the wait for the callback: if ctx is done before the callback
+// returns, the consumer is closed without committing, and without calling
+// the callback again, and ctx.Err() is returned. The callback may then
+// still be running and must not use the consumer once ctx is done.
+// The object is no longer usable after this call.
+func (c *Consumer) LeaveGroupGracefully(ctx context.Context) (err error) {
+	return c.handle.withOp("Consumer.LeaveGroupGracefully", c.leaveGroupGracefully(ctx))
+}
+
+// leaveGroupGracefully implements LeaveGroupGracefully().
+func (c *Consumer) leaveGroupGracefully(ctx context.Context) (err error) {
+
+	assignment, err := c.Assignment()
+	if err != nil {
+		return err
+	}
+
+	if len(assignment) > 0 {
+		err = c.Pause(assignment)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.onBeforeLeave != nil {
+		cbErr := make(chan error, 1)
+		go func() {
+			cbErr <- c.onBeforeLeave(c)
+		}()
+
+		select {
+		case err = <-cbErr:
+		case <-ctx.Done():
+			c.close()
+			return ctx.Err()
+		}
+	}
+
+	if err == nil {
+		_, err = c.Commit()
+		if kerr, ok := err.(Error); ok && kerr.Code() == ErrNoOffset {
+			// Nothing was consumed since the last commit.
+			err = nil
+		}
+	}
+
+	c.close()
+
+	return err
+}
+
+// close leaves the group and destroys the consumer, see Close().
+func (c *Consumer) close() {
 
 	// Wait for consumerReader() or pollLogEvents to terminate (by closing readerTermChan)
 	close(c.readerTermChan)
@@ -417,16 +1131,24 @@ func (c *Consumer) Close() (err error) {
 	// But we can't have that since the application might need the final RevokePartitions
 	// before shutting down. So we trigger an Unsubscribe() first, wait for that to
 	// propagate (in the Poll loop below), and then close the consumer.
+	// Without a subscription there is no final rebalance to wait for, only
+	// the events already queued are served.
+	pollTimeoutMs := 10 * 1000
+	if subscription, err := c.Subscription(); err == nil && len(subscription) == 0 {
+		pollTimeoutMs = 0
+	}
 	c.Unsubscribe()
 
 	// Poll for rebalance events
 	for {
-		c.Poll(10 * 1000)
+		c.Poll(pollTimeoutMs)
 		if int(C.rd_kafka_queue_length(c.handle.rkq)) == 0 {
 			break
 		}
 	}
 
+	c.releaseValueBuffer()
+
 	// Destroy our queue
 	C.rd_kafka_queue_destroy(c.handle.rkq)
 	c.handle.rkq = nil
@@ -434,80 +1156,419 @@ func (c *Consumer) Close() (err error) {
 	// Close the consumer
 	C.rd_kafka_consumer_close(c.handle.rk)
 
+	c.unregisterAssignment()
+
 	c.handle.cleanup()
 
 	C.rd_kafka_destroy(c.handle.rk)
+	c.handle.rk = nil
+}
+
+// CloseNoCommit closes the Consumer instance without leaving the
+// consumer group and without the final commit of the current assignment's
+// offsets that Close() performs when `enable.auto.commit` is true,
+// thus emulating a crashed consumer, e.g., for testing at-least-once
+// reprocessing.
+// The object is no longer usable after this call.
+//
+// Messages consumed since the last commit will be consumed again, i.e.,
+// duplicated, by the next consumer of their partitions.
+// Since the group is not left, the partitions are not reassigned to
+// other group members until this consumer's `session.timeout.ms` expires,
+// and no RevokedPartitions event is emitted.
+func (c *Consumer) CloseNoCommit() (err error) {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.CloseNoCommit", newClosedError())
+	}
+
+	// Wait for consumerReader() or pollLogEvents to terminate (by closing readerTermChan)
+	close(c.readerTermChan)
+	c.handle.waitGroup.Wait()
+	if c.eventsChanEnable {
+		close(c.events)
+	}
+
+	c.releaseValueBuffer()
+
+	// Destroy our queue
+	C.rd_kafka_queue_destroy(c.handle.rkq)
+	c.handle.rkq = nil
+
+	c.handle.cleanup()
+
+	// Skip rd_kafka_consumer_close(), which leaves the group and
+	// commits the final offsets.
+	C.rd_kafka_destroy_flags(c.handle.rk, C.RD_KAFKA_DESTROY_F_NO_CONSUMER_CLOSE)
+	c.handle.rk = nil
 
 	return nil
 }
 
-// NewConsumer creates a new high-level Consumer instance.
+// Reset closes and recreates the underlying librdkafka consumer instance
+// from the original configuration, so that the application may recover
+// in place from a fatal error, see Error.IsFatal(), rather than creating
+// a new Consumer.
 //
-// conf is a *ConfigMap with standard librdkafka configuration properties.
+// The current assignment is revoked and the group left as by Close(),
+// without calling the OnBeforeLeaveCb, and the new instance rejoins the
+// group with the same subscription and RebalanceCb.
+// Interceptors, the OffsetStore, the OnBeforeLeaveCb, the
+// MaxPollIntervalWarningCb and the watermark retry policy are preserved.
 //
-// Supported special configuration properties:
-//   go.application.rebalance.enable (bool, false) - Forward rebalancing responsibility to application via the Events() channel.
-//                                        If set to true the app must handle the AssignedPartitions and
-//                                        RevokedPartitions events and call Assign() and Unassign()
-//                                        respectively.
-//   go.events.channel.enable (bool, false) - [deprecated] Enable the Events() channel. Messages and events will be pushed on the Events() channel and the Poll() interface will be disabled.
-//   go.events.channel.size (int, 1000) - Events() channel size
-//   go.logs.channel.enable (bool, false) - Forward log to Logs() channel.
-//   go.logs.channel (chan kafka.LogEvent, nil) - Forward logs to application-provided channel instead of Logs(). Requires go.logs.channel.enable=true.
+// All other in-flight state is lost: messages and events not yet returned,
+// offsets stored but not committed, partitions assigned with Assign(),
+// paused partitions and the statistics collected by the Go client, such as
+// PartitionThroughput() and BrokerErrors().
+// With go.events.channel.enable the Events() channel is closed and replaced,
+// as is the Logs() channel unless provided by the application.
 //
-// WARNING: Due to the buffering nature of channels (and queues in general) the
-// use of the events channel risks receiving outdated events and
-// messages. Minimizing go.events.channel.size reduces the risk
-// and number of outdated events and messages but does not eliminate
-// the factor completely. With a channel size of 1 at most one
-// event or message may be outdated.
-func NewConsumer(conf *ConfigMap) (*Consumer, error) {
+// Reset replaces the Consumer object's state in place and is not safe for
+// concurrent use: no other goroutine may poll or otherwise use the
+// consumer while Reset is in progress. This includes the helpers holding
+// the consumer, such as an Acker, PartitionRunner, WatermarkMonitor or
+// DeserializingConsumer, which must be stopped before calling Reset and
+// recreated after. Channels obtained before Reset from Events(), Logs()
+// and Ready() belong to the closed instance, call these methods again to
+// get the new instance's channels.
+//
+// If the new instance can't be created Reset returns the error and the
+// consumer is left closed: Close(), Poll(), ReadMessage(), Subscribe(),
+// Assign() and Reset() return an ErrState error, and other methods must
+// not be called.
+func (c *Consumer) Reset() error {
+	if c.isClosed() {
+		return c.handle.withOp("Consumer.Reset", newClosedError())
+	}
+
+	subscription, err := c.Subscription()
+	if err != nil {
+		return err
+	}
+
+	var maxPollCb MaxPollIntervalWarningCb
+	if c.pollWatchdog != nil {
+		maxPollCb, _ = c.pollWatchdog.cb.Load().(MaxPollIntervalWarningCb)
+	}
+
+	c.close()
+
+	conf := c.conf
+	*c = Consumer{
+		rebalanceCb:            c.rebalanceCb,
+		onBeforeLeave:          c.onBeforeLeave,
+		interceptors:           c.interceptors,
+		watermarkRetry:         c.WatermarkRetryPolicy(),
+		offsetStore:            c.offsetStore,
+		offsetStoreKafkaCommit: c.offsetStoreKafkaCommit,
+	}
+
+	err = c.open(&conf)
+	if err != nil {
+		// open() fails before creating the librdkafka instance,
+		// leaving the consumer closed, see isClosed().
+		return err
+	}
+
+	if maxPollCb != nil {
+		c.SetOnMaxPollIntervalWarning(maxPollCb)
+	}
+
+	if len(subscription) > 0 {
+		return c.SubscribeTopicsForce(subscription, c.rebalanceCb)
+	}
+
+	return nil
+}
+
+// isClosed returns true if the consumer has no librdkafka instance, i.e.,
+// once closed, or after Reset() failed to create the new instance.
+func (c *Consumer) isClosed() bool {
+	return c.handle.rk == nil
+}
+
+// newClosedError returns the error returned by a closed consumer.
+func newClosedError() Error {
+	return newErrorFromString(ErrState, "Consumer is closed")
+}
+
+// BrokerErrors returns the most recent broker-level error, such as a
+// connection, SSL handshake or authentication failure, reported for each
+// broker, keyed by broker node id, to pinpoint misbehaving brokers.
+// Errors for bootstrap brokers, whose node id is not yet known, are keyed
+// by -1.
+//
+// The errors are collected from the error events seen by Poll(),
+// ReadMessage() or the Events() channel, which are still returned to the
+// application. An error is not cleared once the broker recovers, use
+// BrokerError.Time to tell old errors apart.
+func (c *Consumer) BrokerErrors() map[int32]BrokerError {
+	return c.brokerErrors.get()
+}
+
+// NewConsumer creates a new high-level Consumer instance.
+//
+// conf is a *ConfigMap with standard librdkafka configuration properties.
+//
+// Supported special configuration properties:
+//   go.application.rebalance.enable (bool, false) - Forward rebalancing responsibility to application via the Events() channel.
+//                                        If set to true the app must handle the AssignedPartitions and
+//                                        RevokedPartitions events and call Assign() and Unassign()
+//                                        respectively.
+//   go.events.channel.enable (bool, false) - [deprecated] Enable the Events() channel. Messages and events will be pushed on the Events() channel and the Poll() interface will be disabled.
+//   go.events.channel.size (int, 1000) - Events() channel size
+//   go.partition.throughput.window.ms (int, 10000) - Sliding window for PartitionThroughput(). 0 disables.
+//   go.idle.timeout.ms (int, 0) - Make ReadMessage() return ErrConsumerIdle when no message has been returned
+//                                 for this long, starting from when the consumer is created. 0 disables.
+//   go.value.reader.enable (bool, false) - Do not copy message values, read them with Message.ValueReader() from the
+//                                          librdkafka buffer instead, which is only valid until the next Poll().
+//                                          Not supported with go.events.channel.enable.
+//   go.offset.out.of.range.reset (string, "") - Reset partitions whose offset is out of range, or that have no committed
+//                                               offset, to "earliest" or "latest" from the Go client and emit an
+//                                               OffsetReset event for each reset. Sets `auto.offset.reset` to error.
+//   go.commit.on.revoke.only (bool, false) - Only commit the stored offsets when partitions are revoked and on Close(),
+//                                            rather than every `auto.commit.interval.ms`, to minimize commit traffic.
+//                                            Sets `enable.auto.commit` to true and `auto.commit.interval.ms` to 0.
+//                                            If the consumer crashes, or is closed with CloseNoCommit(), all messages
+//                                            consumed since the partitions were assigned are consumed again.
+//   go.max.message.age.ms (int, 0) - Skip messages whose timestamp is older than this, without returning them to the
+//                                    application. After a long run of stale messages the partition is sought to the
+//                                    first recent message, as looked up with OffsetsForTimes(). 0 disables.
+//   go.assignment.overlap.warn (bool, false) - Warn, with an ASSIGNOVERLAP log, when a partition is assigned to this
+//                                              consumer while also assigned to another consumer in this process,
+//                                              with this setting enabled, in the same group.
+//   go.rebalance.log.enable (bool, false) - Log each assign and revoke rebalance event as a REBALANCE log with the group,
+//                                           protocol and the added and revoked partitions.
+//   go.unknown.topic.errors.suppress (bool, false) - Emit a single TopicNotAvailable event, instead of repeated errors,
+//                                                    for an unknown topic, until partitions are assigned.
+//   go.fetch.queue.full.event.enable (bool, false) - Emit a FetchQueueFull event when the consumer queue reaches the
+//                                                    `queued.min.messages` threshold and fetching is paused.
+//   go.partition.error.event.enable (bool, false) - Emit partition-scoped consumer errors, such as fetch errors, as
+//                                                   PartitionError events carrying the partition, rather than as Error.
+//   go.instance.fenced.event.enable (bool, false) - Emit the fatal ErrFencedInstanceID error, raised when another consumer
+//                                                   with the same `group.instance.id` fences this one, as an
+//                                                   InstanceFenced event rather than as Error.
+//   go.start.positions.enable (bool, false) - Track whether the start position of each assigned partition came from
+//                                             its committed offset, `auto.offset.reset` or an explicit offset,
+//                                             see StartPositions().
+//   go.max.poll.interval.warn.pct (int, 0) - Warn, with a MAXPOLL log and the SetOnMaxPollIntervalWarning() callback,
+//                                            when Poll() or ReadMessage() has not been called for this percentage
+//                                            of `max.poll.interval.ms`, e.g., 80. Not supported with
+//                                            go.events.channel.enable. 0 disables.
+//   go.error.op.enable (bool, false) - Annotate the errors returned by the Consumer methods with the method, see Error.Op().
+//   go.error.stack.enable (bool, false) - Also capture the stack where errors are returned, see Error.Stack(), for
+//                                         debugging. Implies go.error.op.enable.
+//   go.logs.channel.enable (bool, false) - Forward log to Logs() channel.
+//   go.logs.channel (chan kafka.LogEvent, nil) - Forward logs to application-provided channel instead of Logs(). Requires go.logs.channel.enable=true.
+//
+// WARNING: Due to the buffering nature of channels (and queues in general) the
+// use of the events channel risks receiving outdated events and
+// messages. Minimizing go.events.channel.size reduces the risk
+// and number of outdated events and messages but does not eliminate
+// the factor completely. With a channel size of 1 at most one
+// event or message may be outdated.
+func NewConsumer(conf *ConfigMap) (*Consumer, error) {
 
 	err := versionCheck()
 	if err != nil {
 		return nil, err
 	}
 
+	c := &Consumer{
+		watermarkRetry: DefaultWatermarkRetryPolicy,
+	}
+
+	err = c.open(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// open applies conf to the consumer and creates the underlying
+// librdkafka instance, see NewConsumer() and Reset().
+func (c *Consumer) open(conf *ConfigMap) error {
+
 	// before we do anything with the configuration, create a copy such that
 	// the original is not mutated.
 	confCopy := conf.clone()
+	c.conf = conf.clone()
 
 	groupid, _ := confCopy.get("group.id", nil)
 	if groupid == nil {
 		// without a group.id the underlying cgrp subsystem in librdkafka wont get started
 		// and without it there is no way to consume assigned partitions.
 		// So for now require the group.id, this might change in the future.
-		return nil, newErrorFromString(ErrInvalidArg, "Required property group.id not set")
+		return newErrorFromString(ErrInvalidArg, "Required property group.id not set")
 	}
 
-	c := &Consumer{}
+	c.readyChan = make(chan bool)
 
 	v, err := confCopy.extract("go.application.rebalance.enable", false)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	c.appRebalanceEnable = v.(bool)
 
 	v, err = confCopy.extract("go.events.channel.enable", false)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	c.eventsChanEnable = v.(bool)
 
 	v, err = confCopy.extract("go.events.channel.size", 1000)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	eventsChanSize := v.(int)
 
+	v, err = confCopy.extract("go.idle.timeout.ms", 0)
+	if err != nil {
+		return err
+	}
+	c.idleTimeout = time.Duration(v.(int)) * time.Millisecond
+	c.lastMessageTime = time.Now()
+
+	v, err = confCopy.extract("go.partition.throughput.window.ms", 10000)
+	if err != nil {
+		return err
+	}
+	if v.(int) > 0 {
+		c.throughput = newThroughputMeter(time.Duration(v.(int)) * time.Millisecond)
+	}
+
+	v, err = confCopy.extract("go.value.reader.enable", false)
+	if err != nil {
+		return err
+	}
+	c.valueReaderEnable = v.(bool)
+	if c.valueReaderEnable && c.eventsChanEnable {
+		return newErrorFromString(ErrInvalidArg,
+			"go.value.reader.enable is not supported with go.events.channel.enable")
+	}
+
+	v, err = confCopy.extract("go.offset.out.of.range.reset", "")
+	if err != nil {
+		return err
+	}
+	switch v.(string) {
+	case "":
+		c.outOfRangeReset = OffsetInvalid
+	case "earliest":
+		c.outOfRangeReset = OffsetBeginning
+	case "latest":
+		c.outOfRangeReset = OffsetEnd
+	default:
+		return newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Invalid go.offset.out.of.range.reset \"%s\": expected \"earliest\" or \"latest\"", v))
+	}
+	if c.outOfRangeReset != OffsetInvalid {
+		// Have librdkafka stop the partition and emit an
+		// ErrAutoOffsetReset error instead of silently resetting it.
+		err = confCopy.SetKey("auto.offset.reset", "error")
+		if err != nil {
+			return err
+		}
+	}
+
+	v, err = confCopy.extract("go.commit.on.revoke.only", false)
+	if err != nil {
+		return err
+	}
+	if v.(bool) {
+		// With a zero interval librdkafka does not start the auto commit
+		// timer, but still commits the stored offsets of the partitions
+		// being revoked, and of the assignment on close.
+		err = confCopy.SetKey("enable.auto.commit", true)
+		if err != nil {
+			return err
+		}
+		err = confCopy.SetKey("auto.commit.interval.ms", 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	v, err = confCopy.extract("go.max.message.age.ms", 0)
+	if err != nil {
+		return err
+	}
+	c.maxMessageAge = time.Duration(v.(int)) * time.Millisecond
+	if c.maxMessageAge > 0 {
+		c.staleCounts = make(map[topicPartitionKey]int)
+	}
+
+	v, err = confCopy.extract("go.assignment.overlap.warn", false)
+	if err != nil {
+		return err
+	}
+	if v.(bool) {
+		c.assignmentOverlapGroup = fmt.Sprintf("%v", groupid)
+	}
+
+	v, err = confCopy.extract("go.rebalance.log.enable", false)
+	if err != nil {
+		return err
+	}
+	if v.(bool) {
+		c.rebalanceLogOwned = make(map[topicPartitionKey]bool)
+	}
+
+	v, err = confCopy.extract("go.unknown.topic.errors.suppress", false)
+	if err != nil {
+		return err
+	}
+	if v.(bool) {
+		c.unknownTopicErrors = make(map[string]bool)
+	}
+
+	v, err = confCopy.extract("go.max.poll.interval.warn.pct", 0)
+	if err != nil {
+		return err
+	}
+	pollWarnPct := v.(int)
+	if pollWarnPct < 0 || pollWarnPct > 100 {
+		return newErrorFromString(ErrInvalidArg,
+			"go.max.poll.interval.warn.pct must be between 0 and 100")
+	}
+
+	v, err = confCopy.extract("go.fetch.queue.full.event.enable", false)
+	if err != nil {
+		return err
+	}
+	fetchQueueFullEnable := v.(bool)
+
+	v, err = confCopy.extract("go.start.positions.enable", false)
+	if err != nil {
+		return err
+	}
+	startPositionsEnable := v.(bool)
+
+	v, err = confCopy.extract("go.partition.error.event.enable", false)
+	if err != nil {
+		return err
+	}
+	c.partitionErrorEvents = v.(bool)
+
+	v, err = confCopy.extract("go.instance.fenced.event.enable", false)
+	if err != nil {
+		return err
+	}
+	c.instanceFencedEvents = v.(bool)
+
 	logsChanEnable, logsChan, err := confCopy.extractLogConfig()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	c.handle.errorOp, c.handle.errorStack, err = confCopy.extractErrorConfig()
+	if err != nil {
+		return err
 	}
 
 	cConf, err := confCopy.convert()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	cErrstr := (*C.char)(C.malloc(C.size_t(256)))
 	defer C.free(unsafe.Pointer(cErrstr))
@@ -516,7 +1577,7 @@ func NewConsumer(conf *ConfigMap) (*Consumer, error) {
 
 	c.handle.rk = C.rd_kafka_new(C.RD_KAFKA_CONSUMER, cConf, cErrstr, 256)
 	if c.handle.rk == nil {
-		return nil, newErrorFromCString(C.RD_KAFKA_RESP_ERR__INVALID_ARG, cErrstr)
+		return newErrorFromCString(C.RD_KAFKA_RESP_ERR__INVALID_ARG, cErrstr)
 	}
 
 	C.rd_kafka_poll_set_consumer(c.handle.rk)
@@ -534,27 +1595,37 @@ func NewConsumer(conf *ConfigMap) (*Consumer, error) {
 		c.handle.setupLogQueue(logsChan, c.readerTermChan)
 	}
 
+	if fetchQueueFullEnable {
+		c.setupFetchQueueLimit()
+	}
+
+	if startPositionsEnable {
+		c.setupStartPositions()
+	}
+
+	if pollWarnPct > 0 && !c.eventsChanEnable {
+		c.setupPollWatchdog(pollWarnPct, c.readerTermChan)
+	}
+
 	if c.eventsChanEnable {
 		c.events = make(chan Event, eventsChanSize)
 		/* Start rdkafka consumer queue reader -> events writer goroutine */
-		c.handle.waitGroup.Add(1)
-		go func() {
-			consumerReader(c, c.readerTermChan)
-			c.handle.waitGroup.Done()
-		}()
+		c.startReader()
 	}
 
-	return c, nil
+	return nil
 }
 
 // consumerReader reads messages and events from the librdkafka consumer queue
 // and posts them on the consumer channel.
 // Runs until termChan closes
-func consumerReader(c *Consumer, termChan chan bool) {
+func consumerReader(c *Consumer, termChan chan bool, pauseChan chan bool) {
 	for {
 		select {
 		case _ = <-termChan:
 			return
+		case _ = <-pauseChan:
+			return
 		default:
 			_, term := c.handle.eventPoll(c.events, 100, 1000, termChan)
 			if term {
@@ -565,18 +1636,271 @@ func consumerReader(c *Consumer, termChan chan bool) {
 	}
 }
 
+// startReader starts the consumerReader() goroutine, which runs until
+// readerTermChan is closed or the reader is paused.
+func (c *Consumer) startReader() {
+	c.readerPauseChan = make(chan bool)
+	c.readerDone = make(chan bool)
+
+	c.handle.waitGroup.Add(1)
+	go func(pauseChan chan bool, done chan bool) {
+		consumerReader(c, c.readerTermChan, pauseChan)
+		close(done)
+		c.handle.waitGroup.Done()
+	}(c.readerPauseChan, c.readerDone)
+}
+
+// PauseReader stops the background goroutine that reads messages and
+// events from librdkafka onto the Events() channel, with
+// go.events.channel.enable, without closing the consumer, e.g., during a
+// configuration reload.
+// While paused nothing more is read: messages and events are left in
+// librdkafka's queue, subject to its prefetch limits, and are delivered
+// once ResumeReader() is called.
+//
+// PauseReader returns once the reader has stopped, after delivering the
+// events it had already read, up to a batch, so the application must keep
+// reading the Events() channel if it may be full.
+// Rebalances are not served while paused, the reader must be resumed
+// within `max.poll.interval.ms` to stay in the group.
+//
+// Returns ErrState if go.events.channel.enable is not set.
+// Pausing a paused reader is a no-op.
+func (c *Consumer) PauseReader() error {
+	return c.handle.withOp("Consumer.PauseReader", c.pauseReader())
+}
+
+// pauseReader implements PauseReader().
+func (c *Consumer) pauseReader() error {
+	if !c.eventsChanEnable {
+		return newErrorFromString(ErrState,
+			"PauseReader() requires go.events.channel.enable")
+	}
+
+	c.readerLock.Lock()
+	defer c.readerLock.Unlock()
+
+	if c.readerPauseChan == nil {
+		return nil
+	}
+
+	close(c.readerPauseChan)
+	<-c.readerDone
+	c.readerPauseChan = nil
+
+	return nil
+}
+
+// ResumeReader restarts the reader stopped by PauseReader(), which
+// resumes with the first message or event not yet delivered on the
+// Events() channel.
+//
+// Returns ErrState if go.events.channel.enable is not set.
+// Resuming a running reader is a no-op.
+// Must not be called after Close().
+func (c *Consumer) ResumeReader() error {
+	return c.handle.withOp("Consumer.ResumeReader", c.resumeReader())
+}
+
+// resumeReader implements ResumeReader().
+func (c *Consumer) resumeReader() error {
+	if !c.eventsChanEnable {
+		return newErrorFromString(ErrState,
+			"ResumeReader() requires go.events.channel.enable")
+	}
+
+	c.readerLock.Lock()
+	defer c.readerLock.Unlock()
+
+	if c.readerPauseChan != nil {
+		return nil
+	}
+
+	c.startReader()
+
+	return nil
+}
+
 // GetMetadata queries broker for cluster and topic metadata.
 // If topic is non-nil only information about that topic is returned, else if
 // allTopics is false only information about locally used topics is returned,
 // else information about all topics is returned.
 // GetMetadata is equivalent to listTopics, describeTopics and describeCluster in the Java API.
 func (c *Consumer) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*Metadata, error) {
-	return getMetadata(c, topic, allTopics, timeoutMs)
+	md, err := getMetadata(c, topic, allTopics, timeoutMs)
+	return md, c.handle.withOp("Consumer.GetMetadata", err)
+}
+
+// TopicPartitions returns all partitions of topic, as known by the
+// cluster metadata, as a TopicPartition list with each Offset set to offset,
+// sorted by partition. This is typically used to Assign() all partitions
+// of a topic.
+//
+// Returns the topic error, such as ErrUnknownTopicOrPart, if the topic
+// metadata could not be retrieved within timeoutMs.
+func (c *Consumer) TopicPartitions(topic string, offset Offset, timeoutMs int) ([]TopicPartition, error) {
+	partitions, err := c.topicPartitions(topic, offset, timeoutMs)
+	return partitions, c.handle.withOp("Consumer.TopicPartitions", err)
+}
+
+// topicPartitions implements TopicPartitions().
+func (c *Consumer) topicPartitions(topic string, offset Offset, timeoutMs int) ([]TopicPartition, error) {
+	md, err := c.GetMetadata(&topic, false, timeoutMs)
+	if err != nil {
+		return nil, err
+	}
+
+	tmd, found := md.Topics[topic]
+	if !found {
+		return nil, newErrorFromString(ErrUnknownTopicOrPart,
+			fmt.Sprintf("Topic %s not found in metadata", topic))
+	}
+	if tmd.Error.Code() != ErrNoError {
+		return nil, tmd.Error
+	}
+
+	partitions := make([]TopicPartition, 0, len(tmd.Partitions))
+	for _, pmd := range tmd.Partitions {
+		partitions = append(partitions, TopicPartition{
+			Topic:     &topic,
+			Partition: pmd.ID,
+			Offset:    offset,
+		})
+	}
+
+	sort.Sort(TopicPartitions(partitions))
+
+	return partitions, nil
 }
 
 // QueryWatermarkOffsets queries the broker for the low and high offsets for the given topic and partition.
+//
+// The low offset is the partition's log-start-offset, the earliest
+// offset that may be consumed, which advances as the partition is trimmed
+// by retention or DeleteRecords. For topics with tiered storage this
+// includes the segments in remote storage. Seeking below the low offset
+// triggers `auto.offset.reset`.
+//
+// For `read_committed` consumers (see IsolationLevel()) the high offset is
+// the Last Stable Offset (LSO) rather than the high watermark, which
+// differ while there are open transactions on the partition.
 func (c *Consumer) QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error) {
-	return queryWatermarkOffsets(c, topic, partition, timeoutMs)
+	low, high, err = queryWatermarkOffsets(c, topic, partition, timeoutMs)
+	return low, high, c.handle.withOp("Consumer.QueryWatermarkOffsets", err)
+}
+
+// IsolationLevel returns the effective `isolation.level` of the consumer,
+// either IsolationLevelReadCommitted (the default) or
+// IsolationLevelReadUncommitted.
+func (c *Consumer) IsolationLevel() string {
+	level, err := c.handle.getConfigValue("isolation.level")
+	if err != nil {
+		// Shouldn't happen, isolation.level is always set.
+		return ""
+	}
+	return level
+}
+
+// QueryWatermarkOffsetsCtx queries the broker for the low and high offsets
+// for the given topic and partition, like QueryWatermarkOffsets(), but
+// transparently retries on retriable errors, such as during a leader
+// election, according to the consumer's watermark retry policy
+// (see SetWatermarkRetryPolicy()).
+//
+// ctx bounds the total time spent, including retries, and cancels any
+// pending retry. Non-retriable errors are returned immediately, as is the
+// last error once the retries are exhausted.
+func (c *Consumer) QueryWatermarkOffsetsCtx(ctx context.Context, topic string, partition int32) (low, high int64, err error) {
+	low, high, err = queryWatermarkOffsetsCtx(ctx, c, topic, partition,
+		c.WatermarkRetryPolicy())
+	return low, high, c.handle.withOp("Consumer.QueryWatermarkOffsetsCtx", err)
+}
+
+// SetWatermarkRetryPolicy sets the retry policy used by
+// QueryWatermarkOffsetsCtx(), which defaults to
+// DefaultWatermarkRetryPolicy.
+func (c *Consumer) SetWatermarkRetryPolicy(policy RetryPolicy) error {
+	return c.handle.withOp("Consumer.SetWatermarkRetryPolicy", c.setWatermarkRetryPolicy(policy))
+}
+
+// setWatermarkRetryPolicy implements SetWatermarkRetryPolicy().
+func (c *Consumer) setWatermarkRetryPolicy(policy RetryPolicy) error {
+	err := policy.validate()
+	if err != nil {
+		return err
+	}
+
+	c.watermarkRetryLock.Lock()
+	c.watermarkRetry = policy
+	c.watermarkRetryLock.Unlock()
+
+	return nil
+}
+
+// WatermarkRetryPolicy returns the retry policy used by
+// QueryWatermarkOffsetsCtx().
+func (c *Consumer) WatermarkRetryPolicy() RetryPolicy {
+	c.watermarkRetryLock.Lock()
+	defer c.watermarkRetryLock.Unlock()
+	return c.watermarkRetry
+}
+
+// LastLatency returns the duration of the last successful op, i.e., the
+// round-trip time to the broker(s) of a commit, QueryWatermarkOffsets()
+// or GetMetadata() call, or 0 if op has not yet succeeded.
+//
+// Commits are measured for Commit(), CommitMessage(), CommitOffsets()
+// and CommitWithRetry(), not for the automatic commits of
+// `enable.auto.commit`.
+func (c *Consumer) LastLatency(op Operation) time.Duration {
+	return c.handle.latencies.get(op)
+}
+
+// AutoCommitEnabled returns true if the effective `enable.auto.commit`,
+// which defaults to true, is enabled, in which case offsets are committed
+// in the background regardless of whether the application has processed
+// the messages or not.
+func (c *Consumer) AutoCommitEnabled() bool {
+	enabled, err := c.handle.getConfigValue("enable.auto.commit")
+	if err != nil {
+		// Shouldn't happen, enable.auto.commit is always set.
+		return false
+	}
+	return enabled == "true"
+}
+
+// AutoCommitInterval returns the effective `auto.commit.interval.ms`,
+// the interval at which offsets are committed in the background when
+// AutoCommitEnabled() is true.
+func (c *Consumer) AutoCommitInterval() time.Duration {
+	interval, err := c.handle.getConfigValue("auto.commit.interval.ms")
+	if err != nil {
+		// Shouldn't happen, auto.commit.interval.ms is always set.
+		return 0
+	}
+	ms, _ := strconv.Atoi(interval)
+	return time.Duration(ms) * time.Millisecond
+}
+
+// GroupInstanceID returns the effective `group.instance.id`, or an empty
+// string if static group membership is not configured.
+//
+// With static membership the broker identifies the member by its
+// group.instance.id, rather than by the member id it assigns on join, so a
+// consumer that rejoins with the same id within `session.timeout.ms` gets
+// its previous assignment back without a rebalance. If a second consumer
+// joins with the same id, e.g., during a blue/green deployment, the broker
+// fences the previous member, which then fails with ErrFencedInstanceID,
+// thus the process that last joined holds the membership.
+//
+// The group.instance.id can't be changed at runtime, create a new consumer
+// to use a different id.
+func (c *Consumer) GroupInstanceID() string {
+	id, err := c.handle.getConfigValue("group.instance.id")
+	if err != nil {
+		return ""
+	}
+	return id
 }
 
 // GetWatermarkOffsets returns the cached low and high offsets for the given topic
@@ -584,7 +1908,8 @@ func (c *Consumer) QueryWatermarkOffsets(topic string, partition int32, timeoutM
 // The low offset is populated every statistics.interval.ms if that value is set.
 // OffsetInvalid will be returned if there is no cached offset for either value.
 func (c *Consumer) GetWatermarkOffsets(topic string, partition int32) (low, high int64, err error) {
-	return getWatermarkOffsets(c, topic, partition)
+	low, high, err = getWatermarkOffsets(c, topic, partition)
+	return low, high, c.handle.withOp("Consumer.GetWatermarkOffsets", err)
 }
 
 // OffsetsForTimes looks up offsets by timestamp for the given partitions.
@@ -603,7 +1928,73 @@ func (c *Consumer) GetWatermarkOffsets(topic string, partition int32) (low, high
 // Duplicate Topic+Partitions are not supported.
 // Per-partition errors may be returned in the `.Error` field.
 func (c *Consumer) OffsetsForTimes(times []TopicPartition, timeoutMs int) (offsets []TopicPartition, err error) {
-	return offsetsForTimes(c, times, timeoutMs)
+	offsets, err = offsetsForTimes(c, times, timeoutMs)
+	return offsets, c.handle.withOp("Consumer.OffsetsForTimes", err)
+}
+
+// SeekToTimestamp seeks each partition of the current assignment to the
+// earliest offset whose timestamp is greater than or equal to ts, as
+// looked up with OffsetsForTimes(), e.g., to replay the subscription from
+// a point in time. Partitions without such a message are sought to
+// OffsetEnd.
+//
+// The function will block for at most timeoutMs milliseconds, for looking
+// up the offsets and seeking the partitions altogether.
+//
+// Returns nil if there is no assignment, or the first lookup or seek
+// error, in which case the remaining partitions may not have been sought.
+func (c *Consumer) SeekToTimestamp(ts time.Time, timeoutMs int) error {
+	return c.handle.withOp("Consumer.SeekToTimestamp", c.seekToTimestamp(ts, timeoutMs))
+}
+
+// seekToTimestamp implements SeekToTimestamp().
+func (c *Consumer) seekToTimestamp(ts time.Time, timeoutMs int) error {
+	assignment, err := c.Assignment()
+	if err != nil {
+		return err
+	}
+	if len(assignment) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+
+	times := make([]TopicPartition, len(assignment))
+	for i, tp := range assignment {
+		times[i] = TopicPartition{
+			Topic:     tp.Topic,
+			Partition: tp.Partition,
+			Offset:    Offset(ts.UnixNano() / int64(time.Millisecond)),
+		}
+	}
+
+	offsets, err := c.OffsetsForTimes(times, timeoutMs)
+	if err != nil {
+		return err
+	}
+
+	for _, tp := range offsets {
+		if tp.Error != nil {
+			return tp.Error
+		}
+		if tp.Offset < 0 {
+			// No message at or after ts.
+			tp.Offset = OffsetEnd
+		}
+
+		remainingMs := int(time.Until(deadline) / time.Millisecond)
+		if remainingMs <= 0 {
+			return newErrorFromString(ErrTimedOut,
+				fmt.Sprintf("Timed out seeking %v", tp))
+		}
+
+		err = c.Seek(tp, remainingMs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Subscription returns the current subscription as set by Subscribe()
@@ -612,7 +2003,7 @@ func (c *Consumer) Subscription() (topics []string, err error) {
 
 	cErr := C.rd_kafka_subscription(c.handle.rk, &cTopics)
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newError(cErr)
+		return nil, c.handle.withOp("Consumer.Subscription", newError(cErr))
 	}
 	defer C.rd_kafka_topic_partition_list_destroy(cTopics)
 
@@ -633,7 +2024,7 @@ func (c *Consumer) Assignment() (partitions []TopicPartition, err error) {
 
 	cErr := C.rd_kafka_assignment(c.handle.rk, &cParts)
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newError(cErr)
+		return nil, c.handle.withOp("Consumer.Assignment", newError(cErr))
 	}
 	defer C.rd_kafka_topic_partition_list_destroy(cParts)
 
@@ -642,18 +2033,113 @@ func (c *Consumer) Assignment() (partitions []TopicPartition, err error) {
 	return partitions, nil
 }
 
+// AssignmentByTopic returns the partitions of the current assignment
+// grouped by topic, with each topic's partitions sorted.
+// An empty map is returned if there is no assignment, or it could not
+// be retrieved.
+func (c *Consumer) AssignmentByTopic() map[string][]int32 {
+	byTopic := make(map[string][]int32)
+
+	partitions, err := c.Assignment()
+	if err != nil {
+		return byTopic
+	}
+
+	for _, tp := range partitions {
+		byTopic[*tp.Topic] = append(byTopic[*tp.Topic], tp.Partition)
+	}
+
+	for _, ps := range byTopic {
+		sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	}
+
+	return byTopic
+}
+
 // Committed retrieves committed offsets for the given set of partitions
 func (c *Consumer) Committed(partitions []TopicPartition, timeoutMs int) (offsets []TopicPartition, err error) {
 	cparts := newCPartsFromTopicPartitions(partitions)
 	defer C.rd_kafka_topic_partition_list_destroy(cparts)
 	cerr := C.rd_kafka_committed(c.handle.rk, cparts, C.int(timeoutMs))
 	if cerr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newError(cerr)
+		return nil, c.handle.withOp("Consumer.Committed",
+			setGroupRetriable(newError(cerr)))
 	}
 
 	return newTopicPartitionsFromCparts(cparts), nil
 }
 
+// OffsetMismatch is a partition whose externally stored offset differs
+// from the offset committed for the consumer group, see
+// ReconcileOffsets().
+type OffsetMismatch struct {
+	// Topic and Partition of the mismatching partition, with Offset
+	// set to the committed offset, OffsetInvalid if none.
+	TopicPartition TopicPartition
+	// ExternalOffset is the externally stored offset, OffsetInvalid
+	// if none.
+	ExternalOffset Offset
+}
+
+func (m OffsetMismatch) String() string {
+	return fmt.Sprintf("OffsetMismatch (%v, external offset %v)",
+		m.TopicPartition, m.ExternalOffset)
+}
+
+// ReconcileOffsets retrieves the committed offsets for the consumer's
+// current assignment and compares them with the externally stored
+// offsets in external, returning the partitions whose offsets differ,
+// sorted by topic and partition.
+//
+// Assigned partitions missing from external are returned with an
+// ExternalOffset of OffsetInvalid, while partitions in external that are
+// not assigned are ignored.
+//
+// Applications storing offsets externally may call this on startup, or
+// from the rebalance callback, to detect that the group's offsets were
+// reset out-of-band and the external store is stale, or vice versa.
+func (c *Consumer) ReconcileOffsets(external []TopicPartition, timeoutMs int) (mismatches []OffsetMismatch, err error) {
+	mismatches, err = c.reconcileOffsets(external, timeoutMs)
+	return mismatches, c.handle.withOp("Consumer.ReconcileOffsets", err)
+}
+
+// reconcileOffsets implements ReconcileOffsets().
+func (c *Consumer) reconcileOffsets(external []TopicPartition, timeoutMs int) (mismatches []OffsetMismatch, err error) {
+	assignment, err := c.Assignment()
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := c.Committed(assignment, timeoutMs)
+	if err != nil {
+		return nil, err
+	}
+
+	externalOffsets := make(map[topicPartitionKey]Offset, len(external))
+	for _, tp := range external {
+		externalOffsets[topicPartitionKey{*tp.Topic, tp.Partition}] = tp.Offset
+	}
+
+	sort.Sort(TopicPartitions(committed))
+	for _, tp := range committed {
+		ext, found := externalOffsets[topicPartitionKey{*tp.Topic, tp.Partition}]
+		if !found {
+			ext = OffsetInvalid
+		}
+
+		if ext != tp.Offset {
+			mismatches = append(mismatches, OffsetMismatch{
+				TopicPartition: TopicPartition{
+					Topic:     tp.Topic,
+					Partition: tp.Partition,
+					Offset:    tp.Offset},
+				ExternalOffset: ext})
+		}
+	}
+
+	return mismatches, nil
+}
+
 // Position returns the current consume position for the given partitions.
 // Typical use is to call Assignment() to get the partition list
 // and then pass it to Position() to get the current consume position for
@@ -665,36 +2151,310 @@ func (c *Consumer) Position(partitions []TopicPartition) (offsets []TopicPartiti
 	defer C.rd_kafka_topic_partition_list_destroy(cparts)
 	cerr := C.rd_kafka_position(c.handle.rk, cparts)
 	if cerr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return nil, newError(cerr)
+		return nil, c.handle.withOp("Consumer.Position", newError(cerr))
 	}
 
 	return newTopicPartitionsFromCparts(cparts), nil
 }
 
+// ResolveOffsets returns a copy of partitions with logical offsets
+// resolved to the concrete offsets consumption would start from if the
+// partitions were assigned now, e.g., for logging or validating the start
+// position before calling Assign():
+//   OffsetStored - the committed offset, or the offset selected by
+//                  `auto.offset.reset` if there is no committed offset.
+//   OffsetBeginning - the low watermark.
+//   OffsetEnd - the high watermark.
+//   OffsetTail(n) - n messages before the high watermark, but no earlier
+//                   than the low watermark.
+// Concrete offsets are returned as is.
+//
+// timeoutMs applies to each underlying request: the committed offsets
+// lookup and each partition's watermark query.
+// Partitions that could not be resolved keep their logical offset and
+// have their Error set.
+//
+// The resolved offsets may be outdated by the time the partitions are
+// assigned, e.g., if messages are produced or offsets committed meanwhile.
+func (c *Consumer) ResolveOffsets(partitions []TopicPartition, timeoutMs int) (resolved []TopicPartition, err error) {
+	resolved, err = c.resolveOffsets(partitions, timeoutMs)
+	return resolved, c.handle.withOp("Consumer.ResolveOffsets", err)
+}
+
+// resolveOffsets implements ResolveOffsets().
+func (c *Consumer) resolveOffsets(partitions []TopicPartition, timeoutMs int) (resolved []TopicPartition, err error) {
+	resolved = make([]TopicPartition, len(partitions))
+	copy(resolved, partitions)
+
+	var stored []TopicPartition
+	var storedIdx []int
+	for i, tp := range resolved {
+		if tp.Topic == nil {
+			return nil, newErrorFromString(ErrInvalidArg,
+				"Partitions must have a topic")
+		}
+		if tp.Offset == OffsetStored {
+			stored = append(stored, tp)
+			storedIdx = append(storedIdx, i)
+		}
+	}
+
+	if len(stored) > 0 {
+		committed, err := c.Committed(stored, timeoutMs)
+		if err != nil {
+			return nil, err
+		}
+
+		reset, err := c.handle.getConfigValue("auto.offset.reset")
+		if err != nil {
+			return nil, err
+		}
+		// go.offset.out.of.range.reset overrides auto.offset.reset,
+		// which it sets to error.
+		switch c.outOfRangeReset {
+		case OffsetBeginning:
+			reset = "earliest"
+		case OffsetEnd:
+			reset = "latest"
+		}
+
+		for i, tp := range committed {
+			r := &resolved[storedIdx[i]]
+			switch {
+			case tp.Error != nil:
+				r.Error = tp.Error
+			case tp.Offset >= 0:
+				r.Offset = tp.Offset
+			case reset == "smallest" || reset == "earliest" || reset == "beginning":
+				r.Offset = OffsetBeginning
+			case reset == "largest" || reset == "latest" || reset == "end":
+				r.Offset = OffsetEnd
+			default:
+				r.Error = newErrorFromString(ErrAutoOffsetReset,
+					fmt.Sprintf("No committed offset and auto.offset.reset is %s", reset))
+			}
+		}
+	}
+
+	tailBase := int64(OffsetTail(0))
+
+	for i := range resolved {
+		r := &resolved[i]
+		if r.Error != nil || r.Offset >= 0 ||
+			(r.Offset != OffsetBeginning && r.Offset != OffsetEnd &&
+				int64(r.Offset) > tailBase) {
+			continue
+		}
+
+		low, high, err := c.QueryWatermarkOffsets(*r.Topic, r.Partition, timeoutMs)
+		if err != nil {
+			r.Error = err
+			continue
+		}
+
+		switch r.Offset {
+		case OffsetBeginning:
+			r.Offset = Offset(low)
+		case OffsetEnd:
+			r.Offset = Offset(high)
+		default:
+			offset := high - (tailBase - int64(r.Offset))
+			if offset < low {
+				offset = low
+			}
+			r.Offset = Offset(offset)
+		}
+	}
+
+	return resolved, nil
+}
+
+// PartitionThroughput returns the consumption rate, in messages per second,
+// of each partition that messages have been consumed from within the
+// sliding window configured with `go.partition.throughput.window.ms`
+// (10 seconds by default).
+//
+// Messages are counted when they are read from the consumer, with Poll(),
+// ReadMessage() or the Events() channel, which is typically a good
+// indication of the relative load of partitions, e.g., to detect hot
+// partitions.
+//
+// Returns nil if `go.partition.throughput.window.ms` is set to 0.
+func (c *Consumer) PartitionThroughput() map[TopicPartition]float64 {
+	if c.throughput == nil {
+		return nil
+	}
+	return c.throughput.rates(time.Now())
+}
+
+// LagUnknown is the lag returned by Lag() for partitions whose lag
+// can't be determined, e.g., because the consumer does not yet have a
+// position for the partition.
+const LagUnknown = int64(-1)
+
+// PartitionLag is the consumer lag of a partition, as returned by Lag()
+// and AssignmentLag().
+type PartitionLag struct {
+	// TopicPartition.Offset is the consumer's current position, or
+	// OffsetInvalid if there is none.
+	TopicPartition TopicPartition
+	// Lag is the number of messages between the position and the end of
+	// the partition, or LagUnknown.
+	Lag int64
+}
+
+func (l PartitionLag) String() string {
+	return fmt.Sprintf("PartitionLag (%v, lag %d)", l.TopicPartition, l.Lag)
+}
+
+// Lag returns the consumer lag, the number of messages between the
+// consumer's current position and the end of the partition, for each of
+// the given partitions.
+//
+// The end of the partition is queried from the partition leader and
+// honours the consumer's `isolation.level`: for `read_committed` consumers
+// (the default) it is the Last Stable Offset (LSO), the offset of the
+// first message of any still open transaction, while for `read_uncommitted`
+// consumers it is the high watermark.
+// Lag is thus not over-reported on topics with open transactions.
+//
+// The returned lags are in the order of the given partitions.
+// Partitions without a position, or whose end offset could not be queried
+// within timeoutMs, have a lag of LagUnknown.
+func (c *Consumer) Lag(partitions []TopicPartition, timeoutMs int) (lags []PartitionLag, err error) {
+	lags, err = c.lag(partitions, false, timeoutMs)
+	return lags, c.handle.withOp("Consumer.Lag", err)
+}
+
+// AssignmentLag returns the consumer lag, see Lag(), for each partition of
+// the current assignment.
+//
+// The end of each partition is taken from the cached high watermark,
+// see GetWatermarkOffsets(), which is updated on every fetch response,
+// and only queried from the partition leader if not yet cached.
+//
+// Partitions without a position yet, e.g., before the first fetch, have a
+// lag of LagUnknown rather than 0, so as not to be reported as caught up.
+// The returned lags are in the order of Assignment().
+func (c *Consumer) AssignmentLag(timeoutMs int) (lags []PartitionLag, err error) {
+	assignment, err := c.Assignment()
+	if err != nil {
+		return nil, c.handle.withOp("Consumer.AssignmentLag", err)
+	}
+
+	lags, err = c.lag(assignment, true, timeoutMs)
+	return lags, c.handle.withOp("Consumer.AssignmentLag", err)
+}
+
+// lag implements Lag() and AssignmentLag(), using the cached high
+// watermarks if useCached is true.
+func (c *Consumer) lag(partitions []TopicPartition, useCached bool, timeoutMs int) (lags []PartitionLag, err error) {
+	positions, err := c.Position(partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	lags = make([]PartitionLag, len(positions))
+	for i, pos := range positions {
+		lags[i] = PartitionLag{
+			TopicPartition: TopicPartition{Topic: pos.Topic, Partition: pos.Partition, Offset: pos.Offset},
+			Lag:            LagUnknown,
+		}
+
+		if pos.Offset < 0 {
+			continue
+		}
+
+		high := int64(OffsetInvalid)
+		if useCached {
+			_, high, _ = c.GetWatermarkOffsets(*pos.Topic, pos.Partition)
+		}
+		if high == int64(OffsetInvalid) {
+			_, high, err = c.QueryWatermarkOffsets(*pos.Topic, pos.Partition, timeoutMs)
+			if err != nil {
+				continue
+			}
+		}
+
+		lag := high - int64(pos.Offset)
+		if lag < 0 {
+			lag = 0
+		}
+		lags[i].Lag = lag
+	}
+
+	return lags, nil
+}
+
 // Pause consumption for the provided list of partitions
 //
 // Note that messages already enqueued on the consumer's Event channel
 // (if `go.events.channel.enable` has been set) will NOT be purged by
 // this call, set `go.events.channel.size` accordingly.
+//
+// Paused partitions remain assigned, i.e., they are still returned by
+// Assignment() and AssignmentByTopic(), until they are unassigned or
+// revoked.
+//
+// The TopicPartition.Error of each of the provided partitions is set to
+// the partition's error, if any, e.g., ErrUnknownPartition for a partition
+// that is not known to the consumer, while the other partitions are
+// paused. If any partition failed an error is returned.
 func (c *Consumer) Pause(partitions []TopicPartition) (err error) {
 	cparts := newCPartsFromTopicPartitions(partitions)
 	defer C.rd_kafka_topic_partition_list_destroy(cparts)
 	cerr := C.rd_kafka_pause_partitions(c.handle.rk, cparts)
 	if cerr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return newError(cerr)
+		return c.handle.withOp("Consumer.Pause", newError(cerr))
 	}
-	return nil
+	return c.handle.withOp("Consumer.Pause",
+		setPartitionErrors("pause", partitions, cparts))
 }
 
 // Resume consumption for the provided list of partitions
+//
+// The TopicPartition.Error of each of the provided partitions is set
+// as by Pause().
 func (c *Consumer) Resume(partitions []TopicPartition) (err error) {
 	cparts := newCPartsFromTopicPartitions(partitions)
 	defer C.rd_kafka_topic_partition_list_destroy(cparts)
 	cerr := C.rd_kafka_resume_partitions(c.handle.rk, cparts)
 	if cerr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return newError(cerr)
+		return c.handle.withOp("Consumer.Resume", newError(cerr))
 	}
-	return nil
+	return c.handle.withOp("Consumer.Resume",
+		setPartitionErrors("resume", partitions, cparts))
+}
+
+// setPartitionErrors sets the Error of each of partitions to the error of
+// the corresponding entry in cparts, which was created from partitions.
+// Returns an error with the first partition's error code if operation
+// failed for any partition, else nil.
+func setPartitionErrors(operation string, partitions []TopicPartition, cparts *C.rd_kafka_topic_partition_list_t) error {
+	var first error
+	failed := 0
+
+	for i := range partitions {
+		crktpar := C._c_rdkafka_topic_partition_list_entry(cparts, C.int(i))
+		if crktpar.err == C.RD_KAFKA_RESP_ERR_NO_ERROR {
+			partitions[i].Error = nil
+			continue
+		}
+
+		partitions[i].Error = newError(crktpar.err)
+		if first == nil {
+			first = partitions[i].Error
+		}
+		failed++
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return newErrorFromString(first.(Error).Code(),
+		fmt.Sprintf("Failed to %s %d of %d partition(s), see TopicPartition.Error: %v",
+			operation, failed, len(partitions), first))
 }
 
 // SetOAuthBearerToken sets the the data to be transmitted
@@ -708,7 +2468,8 @@ func (c *Consumer) Resume(partitions []TopicPartition) (err error) {
 // 3) SASL/OAUTHBEARER is supported but is not configured as the client's
 // authentication mechanism.
 func (c *Consumer) SetOAuthBearerToken(oauthBearerToken OAuthBearerToken) error {
-	return c.handle.setOAuthBearerToken(oauthBearerToken)
+	return c.handle.withOp("Consumer.SetOAuthBearerToken",
+		c.handle.setOAuthBearerToken(oauthBearerToken))
 }
 
 // SetOAuthBearerTokenFailure sets the error message describing why token
@@ -719,7 +2480,14 @@ func (c *Consumer) SetOAuthBearerToken(oauthBearerToken OAuthBearerToken) error
 // 2) SASL/OAUTHBEARER is supported but is not configured as the client's
 // authentication mechanism.
 func (c *Consumer) SetOAuthBearerTokenFailure(errstr string) error {
-	return c.handle.setOAuthBearerTokenFailure(errstr)
+	return c.handle.withOp("Consumer.SetOAuthBearerTokenFailure",
+		c.handle.setOAuthBearerTokenFailure(errstr))
+}
+
+// CPtr returns the underlying librdkafka `rd_kafka_t` instance handle,
+// see Handle.CPtr() for the caveats.
+func (c *Consumer) CPtr() unsafe.Pointer {
+	return c.handle.cPtr()
 }
 
 // ConsumerGroupMetadata reflects the current consumer group member metadata.
@@ -764,6 +2532,12 @@ func deserializeConsumerGroupMetadata(serialized []byte) (*C.rd_kafka_consumer_g
 // This object should be passed to the transactional producer's
 // SendOffsetsToTransaction() API.
 func (c *Consumer) GetConsumerGroupMetadata() (*ConsumerGroupMetadata, error) {
+	cgmd, err := c.getConsumerGroupMetadata()
+	return cgmd, c.handle.withOp("Consumer.GetConsumerGroupMetadata", err)
+}
+
+// getConsumerGroupMetadata implements GetConsumerGroupMetadata().
+func (c *Consumer) getConsumerGroupMetadata() (*ConsumerGroupMetadata, error) {
 	cgmd := C.rd_kafka_consumer_group_metadata(c.handle.rk)
 	if cgmd == nil {
 		return nil, NewError(ErrState, "Consumer group metadata not available", false)
@@ -839,6 +2613,22 @@ func (c *Consumer) handleRebalanceEvent(channel chan Event, rkev *C.rd_kafka_eve
 
 	var ev Event
 
+	if c.unknownTopicErrors != nil &&
+		C.rd_kafka_event_error(rkev) == C.RD_KAFKA_RESP_ERR__ASSIGN_PARTITIONS {
+		c.resetUnknownTopics()
+	}
+
+	if c.offsetStore != nil &&
+		C.rd_kafka_event_error(rkev) == C.RD_KAFKA_RESP_ERR__ASSIGN_PARTITIONS {
+		c.applyOffsetStore(C.rd_kafka_event_topic_partition_list(rkev))
+	}
+
+	if c.rebalanceLogOwned != nil {
+		c.logRebalance(
+			C.rd_kafka_event_error(rkev) == C.RD_KAFKA_RESP_ERR__ASSIGN_PARTITIONS,
+			newTopicPartitionsFromCparts(C.rd_kafka_event_topic_partition_list(rkev)))
+	}
+
 	if c.rebalanceCb != nil || c.appRebalanceEnable {
 		// Application has a rebalance callback or has enabled
 		// rebalances on the events channel, create the appropriate Event.
@@ -897,6 +2687,24 @@ func (c *Consumer) handleRebalanceEvent(channel chan Event, rkev *C.rd_kafka_eve
 		}
 	}
 
+	if cError == nil && cErr == 0 &&
+		C.rd_kafka_event_error(rkev) == C.RD_KAFKA_RESP_ERR__ASSIGN_PARTITIONS {
+		c.setReady(newTopicPartitionsFromCparts(
+			C.rd_kafka_event_topic_partition_list(rkev)))
+	}
+
+	if cError == nil && cErr == 0 && c.startPositions != nil {
+		if C.rd_kafka_event_error(rkev) == C.RD_KAFKA_RESP_ERR__ASSIGN_PARTITIONS {
+			c.trackStartPositions(newTopicPartitionsFromCparts(
+				C.rd_kafka_event_topic_partition_list(rkev)), !isCooperative)
+		} else if isCooperative {
+			c.untrackStartPositions(newTopicPartitionsFromCparts(
+				C.rd_kafka_event_topic_partition_list(rkev)))
+		} else {
+			c.untrackStartPositions(nil)
+		}
+	}
+
 	// If the *assign() call returned error, forward it to the
 	// the consumer's Events() channel for visibility.
 	if cError != nil {
@@ -17,11 +17,13 @@
 package kafka
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -419,3 +421,2237 @@ func TestConsumerLog(t *testing.T) {
 		}
 	}
 }
+
+// TestConsumerIdleTimeout verifies that ReadMessage() returns
+// ErrConsumerIdle once no messages have been consumed for
+// go.idle.timeout.ms, while plain poll timeouts are still ErrTimedOut.
+func TestConsumerIdleTimeout(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "idletopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 5
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "gotest",
+		"go.idle.timeout.ms": 2000})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	for i := 0; i < msgcnt; i++ {
+		_, err = c.ReadMessage(-1)
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+	}
+
+	// Shorter than the idle timeout: regular timeout.
+	_, err = c.ReadMessage(100 * time.Millisecond)
+	if err == nil || err.(Error).Code() != ErrTimedOut {
+		t.Fatalf("Expected ErrTimedOut, got %v", err)
+	}
+
+	// Indefinite wait: returns when the consumer goes idle.
+	start := time.Now()
+	_, err = c.ReadMessage(-1)
+	if err == nil || err.(Error).Code() != ErrConsumerIdle {
+		t.Fatalf("Expected ErrConsumerIdle, got %v", err)
+	}
+	t.Logf("ReadMessage() returned %v after %v", err, time.Since(start))
+
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("ErrConsumerIdle returned after %v, expected ~2s",
+			time.Since(start))
+	}
+
+	if ErrConsumerIdle.String() != "Local: Consumer idle" {
+		t.Errorf("Unexpected ErrConsumerIdle string: %s", ErrConsumerIdle)
+	}
+
+	// A new message resets the idle timer.
+	mockProduce(t, mc, topic, 0, 1)
+	_, err = c.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected message after producing, got %v", err)
+	}
+}
+
+// TestConsumerLag verifies Lag() against a mock cluster.
+func TestConsumerLag(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "lagtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	tp0 := TopicPartition{Topic: &topic, Partition: 0}
+	tp1 := TopicPartition{Topic: &topic, Partition: 1}
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	mockConsume(t, c, 4, 30*time.Second)
+
+	lags, err := c.Lag([]TopicPartition{tp0, tp1}, 5000)
+	if err != nil {
+		t.Fatalf("Lag: %v", err)
+	}
+
+	if len(lags) != 2 {
+		t.Fatalf("Expected 2 lags, got %v", lags)
+	}
+
+	if lags[0].TopicPartition.Partition != 0 || lags[0].Lag != int64(msgcnt-4) ||
+		lags[0].TopicPartition.Offset != 4 {
+		t.Errorf("Expected lag %d at offset 4 for %v, got %v", msgcnt-4, tp0, lags[0])
+	}
+
+	// Partition 1 is not assigned and has no position.
+	if lags[1].TopicPartition.Partition != 1 || lags[1].Lag != LagUnknown ||
+		lags[1].TopicPartition.Offset != OffsetInvalid {
+		t.Errorf("Expected LagUnknown for %v, got %v", tp1, lags[1])
+	}
+}
+
+// TestConsumerAssignmentLag verifies AssignmentLag() against a mock
+// cluster.
+func TestConsumerAssignmentLag(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "assignmentlagtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	lags, err := c.AssignmentLag(5000)
+	if err != nil || len(lags) != 0 {
+		t.Fatalf("Expected no lags without an assignment, got %v, %v", lags, err)
+	}
+
+	assignmentLag := func() map[int32]int64 {
+		lags, err := c.AssignmentLag(5000)
+		if err != nil {
+			t.Fatalf("AssignmentLag: %v", err)
+		}
+		byPartition := make(map[int32]int64, len(lags))
+		for _, l := range lags {
+			if *l.TopicPartition.Topic != topic {
+				t.Errorf("Unexpected partition %v", l)
+			}
+			byPartition[l.TopicPartition.Partition] = l.Lag
+		}
+		return byPartition
+	}
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// No position before the first message is consumed.
+	byPartition := assignmentLag()
+	if len(byPartition) != 2 || byPartition[0] != LagUnknown {
+		t.Errorf("Expected LagUnknown for partition 0, got %v", byPartition)
+	}
+
+	mockConsume(t, c, 4, 30*time.Second)
+
+	byPartition = assignmentLag()
+	if byPartition[0] != int64(msgcnt-4) {
+		t.Errorf("Expected lag %d for partition 0, got %v", msgcnt-4, byPartition)
+	}
+	// Partition 1 is empty and has no position.
+	if byPartition[1] != LagUnknown {
+		t.Errorf("Expected LagUnknown for partition 1, got %v", byPartition)
+	}
+}
+
+// TestConsumerTopicPartitions verifies TopicPartitions() against a
+// mock cluster.
+func TestConsumerTopicPartitions(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "tptopic"
+	err = mc.CreateTopic(topic, 5, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	partitions, err := c.TopicPartitions(topic, OffsetBeginning, 5000)
+	if err != nil {
+		t.Fatalf("TopicPartitions: %v", err)
+	}
+
+	if len(partitions) != 5 {
+		t.Fatalf("Expected 5 partitions, got %v", partitions)
+	}
+
+	for i, tp := range partitions {
+		if *tp.Topic != topic || tp.Partition != int32(i) ||
+			tp.Offset != OffsetBeginning {
+			t.Errorf("Unexpected partition #%d: %v", i, tp)
+		}
+	}
+}
+
+// TestConsumerAssignmentByTopic verifies that AssignmentByTopic() groups
+// the partitions assigned from a two-topic subscription by topic.
+func TestConsumerAssignmentByTopic(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topics := []string{"bytopic1", "bytopic2"}
+	err = mc.CreateTopic(topics[0], 3, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	err = mc.CreateTopic(topics[1], 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "bytopicgroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	if byTopic := c.AssignmentByTopic(); len(byTopic) != 0 {
+		t.Errorf("Expected no assignment before subscribing, got %v", byTopic)
+	}
+
+	err = c.SubscribeTopics(topics, nil)
+	if err != nil {
+		t.Fatalf("SubscribeTopics: %v", err)
+	}
+
+	expected := map[string][]int32{
+		topics[0]: {0, 1, 2},
+		topics[1]: {0, 1},
+	}
+
+	var byTopic map[string][]int32
+	for start := time.Now(); time.Since(start) < 30*time.Second; {
+		c.Poll(100)
+		byTopic = c.AssignmentByTopic()
+		if len(byTopic) == len(expected) {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(byTopic, expected) {
+		t.Errorf("Expected assignment %v, got %v", expected, byTopic)
+	}
+}
+
+// TestConsumerReady verifies that the Ready() channel is closed only once
+// the first rebalance has assigned partitions.
+func TestConsumerReady(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "readytopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "readygroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	isReady := func() bool {
+		select {
+		case <-c.Ready():
+			return true
+		default:
+			return false
+		}
+	}
+
+	// An empty assignment does not make the consumer ready.
+	err = c.Assign(nil)
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if isReady() {
+		t.Fatalf("Expected consumer not to be ready before subscribing")
+	}
+
+	assigned := false
+	err = c.Subscribe(topic, func(c *Consumer, ev Event) error {
+		switch e := ev.(type) {
+		case AssignedPartitions:
+			if isReady() {
+				t.Errorf("Expected consumer not to be ready before the assignment")
+			}
+			assigned = true
+			return c.Assign(e.Partitions)
+		case RevokedPartitions:
+			return c.Unassign()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for start := time.Now(); !assigned && time.Since(start) < 30*time.Second; {
+		c.Poll(100)
+	}
+
+	if !assigned {
+		t.Fatalf("Timed out waiting for the assignment")
+	}
+	if !isReady() {
+		t.Errorf("Expected consumer to be ready after the assignment")
+	}
+
+	// Without a rebalance callback the assignment is made by the
+	// consumer itself.
+	c2, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "readygroup2"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c2.Close()
+
+	err = c2.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for start := time.Now(); time.Since(start) < 30*time.Second; {
+		select {
+		case <-c2.Ready():
+			return
+		default:
+		}
+		c2.Poll(100)
+	}
+
+	t.Errorf("Expected consumer without a rebalance callback to be ready after the assignment")
+}
+
+// TestConsumerSubscribeTopicsFrom verifies that SubscribeTopicsFrom()
+// overrides auto.offset.reset for partitions without committed offsets,
+// but not for partitions with committed offsets.
+func TestConsumerSubscribeTopicsFrom(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "fromtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	newConsumer := func(group string) *Consumer {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers": mc.BootstrapServers(),
+			"group.id":          group,
+			"auto.offset.reset": "latest"})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		return c
+	}
+
+	// No committed offsets: start from the beginning despite
+	// auto.offset.reset=latest.
+	c := newConsumer("fromgroup1")
+	defer c.Close()
+
+	var assigned []TopicPartition
+	err = c.SubscribeTopicsFrom([]string{topic}, "earliest", 5000,
+		func(c *Consumer, ev Event) error {
+			if e, ok := ev.(AssignedPartitions); ok {
+				assigned = e.Partitions
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("SubscribeTopicsFrom: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt, 30*time.Second)
+	if msgs[0].TopicPartition.Offset != 0 {
+		t.Errorf("Expected to start at offset 0, got %v", msgs[0].TopicPartition)
+	}
+	if len(assigned) != 1 || assigned[0].Offset != OffsetBeginning {
+		t.Errorf("Expected rebalance callback with OffsetBeginning, got %v", assigned)
+	}
+
+	// Committed offset: start from the committed offset.
+	c0 := newConsumer("fromgroup2")
+	_, err = c0.CommitOffsets([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 5}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+	c0.Close()
+
+	c2 := newConsumer("fromgroup2")
+	defer c2.Close()
+
+	err = c2.SubscribeTopicsFrom([]string{topic}, "earliest", 5000, nil)
+	if err != nil {
+		t.Fatalf("SubscribeTopicsFrom: %v", err)
+	}
+
+	msgs = mockConsume(t, c2, 1, 30*time.Second)
+	if msgs[0].TopicPartition.Offset != 5 {
+		t.Errorf("Expected to start at committed offset 5, got %v",
+			msgs[0].TopicPartition)
+	}
+
+	err = c2.SubscribeTopicsFrom([]string{topic}, "beginning", 5000, nil)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for invalid reset, got %v", err)
+	}
+}
+
+// TestConsumerFilterPartitions keeps the even partitions of the assignment
+// and verifies that the odd partitions are not consumed.
+func TestConsumerFilterPartitions(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "filtertopic"
+	err = mc.CreateTopic(topic, 4, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	for p := int32(0); p < 4; p++ {
+		mockProduce(t, mc, topic, p, 5)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "filtergroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	even := func(tp TopicPartition) bool {
+		return tp.Partition%2 == 0
+	}
+
+	err = c.Subscribe(topic, func(c *Consumer, ev Event) error {
+		if e, ok := ev.(AssignedPartitions); ok {
+			return c.Assign(FilterPartitions(e.Partitions, even))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msgs := mockConsume(t, c, 10, 30*time.Second)
+	for _, m := range msgs {
+		if !even(m.TopicPartition) {
+			t.Errorf("Consumed message from filtered out partition: %v", m.TopicPartition)
+		}
+	}
+
+	// Nothing more to consume from the even partitions.
+	m, err := c.ReadMessage(2 * time.Second)
+	if err == nil {
+		t.Errorf("Expected no more messages, got %v", m.TopicPartition)
+	}
+
+	assignment, err := c.Assignment()
+	if err != nil {
+		t.Fatalf("Assignment: %v", err)
+	}
+	if len(assignment) != 2 || !even(assignment[0]) || !even(assignment[1]) {
+		t.Errorf("Expected the even partitions to be assigned, got %v", assignment)
+	}
+}
+
+// TestConsumerResolveOffsets verifies that logical offsets are resolved
+// to the watermarks and committed offsets.
+func TestConsumerResolveOffsets(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "resolvetopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	mockProduce(t, mc, topic, 0, 10)
+	mockProduce(t, mc, topic, 1, 4)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest",
+		"auto.offset.reset": "latest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	low, high, err := c.QueryWatermarkOffsets(topic, 0, 5000)
+	if err != nil {
+		t.Fatalf("QueryWatermarkOffsets: %v", err)
+	}
+
+	_, err = c.CommitOffsets([]TopicPartition{{Topic: &topic, Partition: 1, Offset: 2}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+
+	partitions := []TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 0, Offset: OffsetEnd},
+		{Topic: &topic, Partition: 0, Offset: OffsetTail(3)},
+		{Topic: &topic, Partition: 0, Offset: OffsetTail(100)},
+		{Topic: &topic, Partition: 0, Offset: OffsetStored},
+		{Topic: &topic, Partition: 0, Offset: 5},
+		{Topic: &topic, Partition: 1, Offset: OffsetStored},
+	}
+	expected := []Offset{
+		Offset(low),
+		Offset(high),
+		Offset(high - 3),
+		Offset(low),
+		Offset(high), // no committed offset: auto.offset.reset
+		5,
+		2, // committed
+	}
+
+	resolved, err := c.ResolveOffsets(partitions, 5000)
+	if err != nil {
+		t.Fatalf("ResolveOffsets: %v", err)
+	}
+
+	for i, tp := range resolved {
+		if tp.Error != nil || tp.Offset != expected[i] {
+			t.Errorf("%v: expected resolved offset %v, got %v",
+				partitions[i], expected[i], tp)
+		}
+	}
+
+	if partitions[0].Offset != OffsetBeginning {
+		t.Errorf("Expected input partitions to be unmodified, got %v", partitions)
+	}
+}
+
+// TestConsumerCloseWithoutSubscription verifies that Close() does not
+// wait for a final rebalance without a subscription, while a subscribed
+// consumer still gets its final RevokedPartitions.
+func TestConsumerCloseWithoutSubscription(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "closetopic"
+	mockProduce(t, mc, topic, 0, 1)
+
+	conf := ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest-close"}
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	mockConsume(t, c, 1, 30*time.Second)
+
+	start := time.Now()
+	c.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected Close() without a subscription not to wait for a rebalance, took %v", elapsed)
+	}
+
+	conf["group.id"] = "gotest-close-subscribed"
+	conf["auto.offset.reset"] = "earliest"
+	c, err = NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	revoked := false
+	err = c.Subscribe(topic, func(c *Consumer, ev Event) error {
+		if _, ok := ev.(RevokedPartitions); ok {
+			revoked = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	mockConsume(t, c, 1, 30*time.Second)
+
+	c.Close()
+	if !revoked {
+		t.Errorf("Expected Close() of a subscribed consumer to revoke its partitions")
+	}
+}
+
+// TestConsumerCloseNoCommit verifies that CloseNoCommit() skips the final
+// commit that Close() performs, causing messages to be reprocessed.
+func TestConsumerCloseNoCommit(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "closenocommittopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	tp := TopicPartition{Topic: &topic, Partition: 0, Offset: OffsetStored}
+
+	// Consumes all messages from the committed offset and closes,
+	// returning the offset of the first message.
+	consume := func(closeNoCommit bool) Offset {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":  mc.BootstrapServers(),
+			"group.id":           "gotest",
+			"auto.offset.reset":  "earliest",
+			"enable.auto.commit": true,
+			// Only commit on close
+			"auto.commit.interval.ms": 3600000})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+
+		err = c.Assign([]TopicPartition{tp})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+
+		msgs := mockConsume(t, c, msgcnt, 10*time.Second)
+
+		if closeNoCommit {
+			err = c.CloseNoCommit()
+		} else {
+			err = c.Close()
+		}
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		return msgs[0].TopicPartition.Offset
+	}
+
+	if first := consume(true); first != 0 {
+		t.Fatalf("Expected to start consuming at offset 0, not %v", first)
+	}
+
+	// Nothing was committed: the messages are reprocessed.
+	if first := consume(false); first != 0 {
+		t.Errorf("Expected messages to be reprocessed from offset 0, not %v", first)
+	}
+
+	// Close() committed the final offset.
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	committed, err := c.Committed([]TopicPartition{tp}, 5000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+
+	if committed[0].Offset != Offset(msgcnt) {
+		t.Errorf("Expected committed offset %d after Close(), got %v",
+			msgcnt, committed[0])
+	}
+}
+
+// TestConsumerOnBeforeLeave verifies that Close() calls the OnBeforeLeaveCb
+// while the consumer is still a member of the group.
+func TestConsumerOnBeforeLeave(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "beforeleavetopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	mockProduce(t, mc, topic, 0, 1)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	mockConsume(t, c, 1, 30*time.Second)
+
+	calls := 0
+	var assignment []TopicPartition
+	c.SetOnBeforeLeave(func(c *Consumer) error {
+		calls++
+		assignment, _ = c.Assignment()
+		return newErrorFromString(ErrFail, "no departure slot")
+	})
+
+	err = c.Close()
+	if err == nil || err.(Error).Code() != ErrFail {
+		t.Errorf("Expected Close() to return the callback's error, got %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected callback to be called once, got %d", calls)
+	}
+
+	if len(assignment) != 2 {
+		t.Errorf("Expected the callback to see the assignment, got %v", assignment)
+	}
+}
+
+// TestConsumerLeaveGroupGracefully verifies that LeaveGroupGracefully()
+// commits the offsets stored by the OnBeforeLeaveCb before closing.
+func TestConsumerLeaveGroupGracefully(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "leavegracefullytopic"
+	mockProduce(t, mc, topic, 0, 10)
+
+	conf := ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "gotest-leavegracefully",
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false}
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, 10, 30*time.Second)
+
+	// Hand the messages to a handler which only finishes once the
+	// callback waits for it.
+	handled := make(chan *Message, len(msgs))
+	go func() {
+		for _, m := range msgs[:5] {
+			time.Sleep(10 * time.Millisecond)
+			handled <- m
+		}
+		close(handled)
+	}()
+
+	c.SetOnBeforeLeave(func(c *Consumer) error {
+		for m := range handled {
+			tp := m.TopicPartition
+			tp.Offset++
+			_, err := c.StoreOffsets([]TopicPartition{tp})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	err = c.LeaveGroupGracefully(context.Background())
+	if err != nil {
+		t.Fatalf("LeaveGroupGracefully: %v", err)
+	}
+
+	c, err = NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	committed, err := c.Committed([]TopicPartition{{Topic: &topic, Partition: 0}}, 10000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+
+	if committed[0].Offset != msgs[4].TopicPartition.Offset+1 {
+		t.Errorf("Expected committed offset %v, got %v",
+			msgs[4].TopicPartition.Offset+1, committed[0])
+	}
+}
+
+// TestConsumerLeaveGroupGracefullyTimeout verifies that
+// LeaveGroupGracefully() closes the consumer without committing when the
+// OnBeforeLeaveCb outlives ctx, and that the callback is not called again.
+func TestConsumerLeaveGroupGracefullyTimeout(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "leavegracefullytimeouttopic"
+	mockProduce(t, mc, topic, 0, 10)
+
+	conf := ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "gotest-leavegracefullytimeout",
+		"enable.auto.commit": false}
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	mockConsume(t, c, 10, 30*time.Second)
+
+	var calls int32
+	release := make(chan bool)
+	c.SetOnBeforeLeave(func(c *Consumer) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = c.LeaveGroupGracefully(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The consumer is closed, without calling the callback again.
+	err = c.Close()
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrState {
+		t.Errorf("Expected Close() of the closed consumer to fail with ErrState, got %v", err)
+	}
+
+	close(release)
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected the callback to be called once, got %d", n)
+	}
+
+	c, err = NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	committed, err := c.Committed([]TopicPartition{{Topic: &topic, Partition: 0}}, 10000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+
+	if committed[0].Offset != OffsetInvalid {
+		t.Errorf("Expected no committed offset, got %v", committed[0])
+	}
+}
+
+// TestConsumerOffsetOutOfRangeReset verifies that go.offset.out.of.range.reset
+// resets a partition whose committed offset is out of range.
+func TestConsumerOffsetOutOfRangeReset(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "outofrangetopic"
+	mockProduce(t, mc, topic, 0, 10)
+
+	conf := ConfigMap{
+		"bootstrap.servers":            mc.BootstrapServers(),
+		"group.id":                     "gotest-outofrange",
+		"enable.auto.commit":           false,
+		"go.offset.out.of.range.reset": "earliest"}
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	// Emulate the records below the committed offset having been
+	// deleted by committing an offset past the end of the partition.
+	tp := TopicPartition{Topic: &topic, Partition: 0, Offset: 100}
+	_, err = c.CommitOffsets([]TopicPartition{tp})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+
+	tp.Offset = OffsetStored
+	err = c.Assign([]TopicPartition{tp})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	var reset *OffsetReset
+	msgcnt := 0
+	tEnd := time.Now().Add(30 * time.Second)
+	for msgcnt < 10 && time.Now().Before(tEnd) {
+		switch e := c.Poll(100).(type) {
+		case OffsetReset:
+			reset = &e
+		case *Message:
+			if e.TopicPartition.Error != nil {
+				t.Fatalf("Consumer error: %v", e.TopicPartition)
+			}
+			if reset == nil {
+				t.Fatalf("Message %v consumed before OffsetReset", e.TopicPartition)
+			}
+			msgcnt++
+		}
+	}
+
+	if reset == nil {
+		t.Fatalf("Expected OffsetReset event")
+	}
+	if reset.TopicPartition.Offset != 100 || reset.ResetOffset != OffsetBeginning ||
+		reset.TopicPartition.Error.(Error).Code() != ErrAutoOffsetReset {
+		t.Errorf("Unexpected OffsetReset event: %v", reset)
+	}
+	if msgcnt != 10 {
+		t.Errorf("Expected 10 messages after the reset, got %d", msgcnt)
+	}
+
+	conf["go.offset.out.of.range.reset"] = "middle"
+	_, err = NewConsumer(&conf)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for invalid reset, got %v", err)
+	}
+}
+
+// TestConsumerAutoCommitEnabled verifies that AutoCommitEnabled() reflects
+// the default and explicitly configured enable.auto.commit.
+func TestConsumerAutoCommitEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		config           ConfigMap
+		expected         bool
+		expectedInterval time.Duration
+	}{
+		{ConfigMap{"group.id": "gotest"}, true, 5 * time.Second},
+		{ConfigMap{"group.id": "gotest", "enable.auto.commit": true,
+			"auto.commit.interval.ms": 1500}, true, 1500 * time.Millisecond},
+		{ConfigMap{"group.id": "gotest", "enable.auto.commit": false}, false, 5 * time.Second},
+	} {
+		c, err := NewConsumer(&tc.config)
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		enabled := c.AutoCommitEnabled()
+		interval := c.AutoCommitInterval()
+		c.Close()
+		if enabled != tc.expected {
+			t.Errorf("Expected AutoCommitEnabled() %v for %v, got %v",
+				tc.expected, tc.config, enabled)
+		}
+		if interval != tc.expectedInterval {
+			t.Errorf("Expected AutoCommitInterval() %v for %v, got %v",
+				tc.expectedInterval, tc.config, interval)
+		}
+	}
+}
+
+// TestConsumerGroupInstanceID verifies that GroupInstanceID() reflects the
+// configured group.instance.id.
+func TestConsumerGroupInstanceID(t *testing.T) {
+	for _, tc := range []struct {
+		config   ConfigMap
+		expected string
+	}{
+		{ConfigMap{"group.id": "gotest"}, ""},
+		{ConfigMap{"group.id": "gotest", "group.instance.id": "instance-1"}, "instance-1"},
+	} {
+		c, err := NewConsumer(&tc.config)
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		id := c.GroupInstanceID()
+		c.Close()
+		if id != tc.expected {
+			t.Errorf("Expected GroupInstanceID() %q for %v, got %q",
+				tc.expected, tc.config, id)
+		}
+	}
+}
+
+// TestConsumerQueryWatermarkOffsetsCtx verifies that
+// QueryWatermarkOffsetsCtx() retries retriable errors according to the
+// retry policy, and returns non-retriable errors and cancellations.
+func TestConsumerQueryWatermarkOffsetsCtx(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "watermarkretry"
+	mockProduce(t, mc, topic, 0, 10)
+
+	// Each case uses its own consumer, so that no state, such as a
+	// pending retry or cached metadata, carries over from an earlier case.
+	newConsumer := func(policy *RetryPolicy) *Consumer {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers": mc.BootstrapServers(),
+			"group.id":          "gotest"})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		if policy != nil {
+			err = c.SetWatermarkRetryPolicy(*policy)
+			if err != nil {
+				t.Fatalf("SetWatermarkRetryPolicy: %v", err)
+			}
+		}
+		return c
+	}
+
+	// librdkafka may retry a failed ListOffsets request internally, so
+	// the number of requests per query is not known: fail all of them
+	// by injecting more errors than any query sends, and clear any left
+	// over errors before the next case.
+	failRequests := func(code ErrorCode) {
+		mc.ClearRoundtripErrors(mockAPIKeyListOffsets)
+		for i := 0; i < 100; i++ {
+			mc.SetRoundtripError(mockAPIKeyListOffsets, code)
+		}
+	}
+	defer mc.ClearRoundtripErrors(mockAPIKeyListOffsets)
+
+	ctx := context.Background()
+
+	c := newConsumer(nil)
+	if c.WatermarkRetryPolicy() != DefaultWatermarkRetryPolicy {
+		t.Errorf("Expected default retry policy %v, got %v",
+			DefaultWatermarkRetryPolicy, c.WatermarkRetryPolicy())
+	}
+
+	err = c.SetWatermarkRetryPolicy(RetryPolicy{MaxRetries: -1})
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for invalid retry policy, got %v", err)
+	}
+	c.Close()
+
+	// Retriable errors are retried, until the broker recovers.
+	c = newConsumer(&RetryPolicy{
+		MaxRetries:     100,
+		Backoff:        50 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		AttemptTimeout: 5 * time.Second})
+	failRequests(ErrNotLeaderForPartition)
+	recovered := time.AfterFunc(500*time.Millisecond, func() {
+		mc.ClearRoundtripErrors(mockAPIKeyListOffsets)
+	})
+	low, high, err := c.QueryWatermarkOffsetsCtx(ctx, topic, 0)
+	recovered.Stop()
+	if err != nil {
+		t.Errorf("Expected retries to ride over the errors, got %v", err)
+	} else if low != 0 || high != 10 {
+		t.Errorf("Expected watermarks 0..10, got %d..%d", low, high)
+	}
+	c.Close()
+
+	// Until the retries are exhausted.
+	c = newConsumer(&RetryPolicy{
+		MaxRetries:     1,
+		Backoff:        10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		AttemptTimeout: 5 * time.Second})
+	failRequests(ErrNotLeaderForPartition)
+	_, _, err = c.QueryWatermarkOffsetsCtx(ctx, topic, 0)
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrNotLeaderForPartition {
+		t.Errorf("Expected ErrNotLeaderForPartition once retries are exhausted, got %v", err)
+	}
+	c.Close()
+
+	// Non-retriable errors are not retried: a retry would not complete
+	// before the context times out.
+	c = newConsumer(&RetryPolicy{
+		MaxRetries:     1,
+		Backoff:        time.Minute,
+		MaxBackoff:     time.Minute,
+		AttemptTimeout: 5 * time.Second})
+	failRequests(ErrTopicAuthorizationFailed)
+	cctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, _, err = c.QueryWatermarkOffsetsCtx(cctx, topic, 0)
+	cancel()
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrTopicAuthorizationFailed {
+		t.Errorf("Expected ErrTopicAuthorizationFailed, got %v", err)
+	}
+
+	// Cancelling the context aborts the pending retry.
+	failRequests(ErrNotLeaderForPartition)
+	cctx, cancel = context.WithTimeout(ctx, 500*time.Millisecond)
+	_, _, err = c.QueryWatermarkOffsetsCtx(cctx, topic, 0)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	c.Close()
+}
+
+// TestConsumerReconcileOffsets verifies that ReconcileOffsets() returns
+// the assigned partitions whose committed and external offsets differ.
+func TestConsumerReconcileOffsets(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "reconcile"
+	for partition := int32(0); partition < 4; partition++ {
+		mockProduce(t, mc, topic, partition, 10)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "reconcilegroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1},
+		{Topic: &topic, Partition: 2}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	_, err = c.CommitOffsets([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 5},
+		{Topic: &topic, Partition: 1, Offset: 5}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+
+	// Partition 0 matches, partition 1 was reset out-of-band,
+	// partition 2 has no committed offset, partition 3 is not assigned.
+	external := []TopicPartition{
+		{Topic: &topic, Partition: 3, Offset: 7},
+		{Topic: &topic, Partition: 1, Offset: 8},
+		{Topic: &topic, Partition: 0, Offset: 5},
+		{Topic: &topic, Partition: 2, Offset: 3}}
+
+	mismatches, err := c.ReconcileOffsets(external, 5000)
+	if err != nil {
+		t.Fatalf("ReconcileOffsets: %v", err)
+	}
+
+	expected := []OffsetMismatch{
+		{TopicPartition{Topic: &topic, Partition: 1, Offset: 5}, 8},
+		{TopicPartition{Topic: &topic, Partition: 2, Offset: OffsetInvalid}, 3}}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Errorf("Expected mismatches %v, got %v", expected, mismatches)
+	}
+
+	// A partition missing from the external offsets is a mismatch.
+	mismatches, err = c.ReconcileOffsets([]TopicPartition{external[1], external[3]}, 5000)
+	if err != nil {
+		t.Fatalf("ReconcileOffsets: %v", err)
+	}
+
+	expected = []OffsetMismatch{
+		{TopicPartition{Topic: &topic, Partition: 0, Offset: 5}, OffsetInvalid},
+		{TopicPartition{Topic: &topic, Partition: 1, Offset: 5}, 8},
+		{TopicPartition{Topic: &topic, Partition: 2, Offset: OffsetInvalid}, 3}}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Errorf("Expected mismatches %v, got %v", expected, mismatches)
+	}
+}
+
+// TestConsumerCommitWithRetry verifies that commits failing with
+// ErrCoordinatorLoadInProgress are flagged as retriable and retried by
+// CommitWithRetry().
+func TestConsumerCommitWithRetry(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "commitretry"
+	mockProduce(t, mc, topic, 0, 10)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "commitretrygroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	// librdkafka retries the OffsetCommit request itself before giving
+	// up, this is enough errors for it to give up once.
+	failCommit := func() {
+		for i := 0; i < 3; i++ {
+			mc.SetRoundtripError(mockAPIKeyOffsetCommit, ErrCoordinatorLoadInProgress)
+		}
+	}
+
+	offsets := []TopicPartition{{Topic: &topic, Partition: 0, Offset: 5}}
+
+	failCommit()
+	_, err = c.CommitOffsets(offsets)
+	if err == nil || err.(Error).Code() != ErrCoordinatorLoadInProgress {
+		t.Fatalf("Expected ErrCoordinatorLoadInProgress, got %v", err)
+	}
+	if !err.(Error).IsRetriable() {
+		t.Errorf("Expected %v to be retriable", err)
+	}
+	mc.ClearRoundtripErrors(mockAPIKeyOffsetCommit)
+
+	failCommit()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	committed, err := c.CommitWithRetry(ctx, offsets, DefaultCommitRetryPolicy)
+	if err != nil {
+		t.Fatalf("CommitWithRetry: %v", err)
+	}
+	if len(committed) != 1 || committed[0].Offset != 5 || committed[0].Error != nil {
+		t.Errorf("Expected offset 5 to be committed, got %v", committed)
+	}
+
+	stored, err := c.Committed(offsets, 5000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+	if stored[0].Offset != 5 {
+		t.Errorf("Expected committed offset 5, got %v", stored[0])
+	}
+
+	// Non-retriable errors are returned immediately.
+	_, err = c.CommitWithRetry(ctx, nil, DefaultCommitRetryPolicy)
+	if err == nil || err.(Error).Code() != ErrNoOffset {
+		t.Errorf("Expected ErrNoOffset without an assignment, got %v", err)
+	}
+}
+
+// TestConsumerMaxMessageAge verifies that go.max.message.age.ms skips
+// a long run of stale messages and returns the recent ones.
+// The mock cluster does not support offset lookups by timestamp, so the
+// stale messages end up being skipped one by one rather than sought past.
+func TestConsumerMaxMessageAge(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "maxage"
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	// More stale messages than maxAgeSeekThreshold, followed by a few
+	// recent ones.
+	staleCnt := maxAgeSeekThreshold + 500
+	recentCnt := 5
+	drChan := make(chan Event, staleCnt+recentCnt)
+	for i := 0; i < staleCnt+recentCnt; i++ {
+		timestamp := time.Now().Add(-time.Hour)
+		if i >= staleCnt {
+			timestamp = time.Now()
+		}
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte(fmt.Sprintf("value%d", i)),
+			Timestamp:      timestamp}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	for i := 0; i < staleCnt+recentCnt; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":     mc.BootstrapServers(),
+		"group.id":              "maxagegroup",
+		"go.max.message.age.ms": 60000})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, recentCnt, 30*time.Second)
+	for i, m := range msgs {
+		if exp := fmt.Sprintf("value%d", staleCnt+i); string(m.Value) != exp {
+			t.Errorf("Expected recent message %s, got %s at %v", exp, m.Value, m.TopicPartition)
+		}
+	}
+
+	if m, err := c.ReadMessage(time.Second); err == nil {
+		t.Errorf("Expected no more messages, got %v", m.TopicPartition)
+	}
+}
+
+// TestConsumerLastLatency verifies that LastLatency() reports the
+// latency of the last commit, watermark query and metadata request.
+func TestConsumerLastLatency(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "latency"
+	mockProduce(t, mc, topic, 0, 1)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "latencygroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	ops := []Operation{OperationCommit, OperationQueryWatermarkOffsets, OperationGetMetadata}
+	for _, op := range ops {
+		if latency := c.LastLatency(op); latency != 0 {
+			t.Errorf("Expected no %s latency before the operation, got %v", op, latency)
+		}
+	}
+
+	_, err = c.CommitOffsets([]TopicPartition{{Topic: &topic, Partition: 0, Offset: 1}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+
+	_, _, err = c.QueryWatermarkOffsets(topic, 0, 5000)
+	if err != nil {
+		t.Fatalf("QueryWatermarkOffsets: %v", err)
+	}
+
+	_, err = c.GetMetadata(&topic, false, 5000)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+
+	for _, op := range ops {
+		if latency := c.LastLatency(op); latency <= 0 {
+			t.Errorf("Expected a positive %s latency, got %v", op, latency)
+		}
+	}
+}
+
+// TestConsumerQueueLength verifies that fetched messages are counted in
+// QueueLength() until they are polled.
+func TestConsumerQueueLength(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "queuelentopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 100
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	if c.QueueLength() != 0 {
+		t.Errorf("Expected empty queue, got %d", c.QueueLength())
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// Wait for the messages to be fetched, without polling.
+	deadline := time.Now().Add(10 * time.Second)
+	for c.QueueLength() < msgcnt && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	before := c.QueueLength()
+	if before < msgcnt {
+		t.Fatalf("Expected at least %d queued messages, got %d", msgcnt, before)
+	}
+	if fetchq := c.FetchQueueLen(); fetchq < msgcnt {
+		t.Errorf("Expected FetchQueueLen() of at least %d, got %d", msgcnt, fetchq)
+	}
+
+	mockConsume(t, c, msgcnt, 10*time.Second)
+
+	if after := c.QueueLength(); after >= before {
+		t.Errorf("Expected queue length to decrease from %d after polling, got %d",
+			before, after)
+	}
+}
+
+// TestConsumerSubscribeTopicsDuplicate verifies that subscribing twice to
+// the same set of topics only triggers a single rebalance.
+func TestConsumerSubscribeTopicsDuplicate(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topics := []string{"duptopic1", "duptopic2"}
+	for _, topic := range topics {
+		err = mc.CreateTopic(topic, 2, 1)
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "dupgroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	rebalances := 0
+	rebalanceCb := func(c *Consumer, ev Event) error {
+		switch e := ev.(type) {
+		case AssignedPartitions:
+			rebalances++
+			return c.Assign(e.Partitions)
+		case RevokedPartitions:
+			return c.Unassign()
+		}
+		return nil
+	}
+
+	err = c.SubscribeTopics(topics, rebalanceCb)
+	if err != nil {
+		t.Fatalf("SubscribeTopics: %v", err)
+	}
+
+	for start := time.Now(); rebalances == 0 && time.Since(start) < 30*time.Second; {
+		c.Poll(100)
+	}
+	if rebalances != 1 {
+		t.Fatalf("Expected 1 rebalance after the first subscribe, got %d", rebalances)
+	}
+
+	// Same topics in a different order: must not resubscribe.
+	err = c.SubscribeTopics([]string{topics[1], topics[0]}, rebalanceCb)
+	if err != nil {
+		t.Fatalf("SubscribeTopics: %v", err)
+	}
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		c.Poll(100)
+	}
+	if rebalances != 1 {
+		t.Errorf("Expected 1 rebalance after the duplicate subscribe, got %d", rebalances)
+	}
+}
+
+// TestConsumerFetchQueueFull verifies that a FetchQueueFull event is
+// emitted once the consumer queue fills up while the application is not
+// polling, and not again until the queue has been drained.
+func TestConsumerFetchQueueFull(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "fetchqueuetopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 100
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	limit := 10
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":                mc.BootstrapServers(),
+		"group.id":                         "fetchqueuegroup",
+		"queued.min.messages":              limit,
+		"go.fetch.queue.full.event.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// Slow poller: let the fetcher fill the queue.
+	for start := time.Now(); c.QueueLength() < limit &&
+		time.Since(start) < 30*time.Second; {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	ev := c.Poll(100)
+	full, ok := ev.(FetchQueueFull)
+	if !ok {
+		t.Fatalf("Expected FetchQueueFull event, got %v", ev)
+	}
+	if full.Limit != limit || full.QueueLength < limit {
+		t.Errorf("Unexpected %v", full)
+	}
+
+	events := 1
+	msgs := 0
+	for start := time.Now(); msgs < msgcnt && time.Since(start) < 30*time.Second; {
+		switch c.Poll(100).(type) {
+		case FetchQueueFull:
+			events++
+		case *Message:
+			msgs++
+		}
+	}
+
+	if msgs != msgcnt {
+		t.Errorf("Expected %d messages, got %d", msgcnt, msgs)
+	}
+	// The queue is refilled, and drained, while polling, but the event
+	// must not be emitted for every message.
+	if events >= msgs {
+		t.Errorf("Expected fewer FetchQueueFull events than messages, got %d", events)
+	}
+}
+
+// TestConsumerSeekToTimestampNoAssignment verifies that SeekToTimestamp()
+// is a no-op without an assignment.
+func TestConsumerSeekToTimestampNoAssignment(t *testing.T) {
+	c, err := NewConsumer(&ConfigMap{"group.id": "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.SeekToTimestamp(time.Now(), 100)
+	if err != nil {
+		t.Errorf("Expected nil without an assignment, got %v", err)
+	}
+}
+
+// TestConsumerCommitStored verifies that CommitStored() commits the
+// offsets stored with StoreOffsets().
+func TestConsumerCommitStored(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "commitstoredtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "commitstoredgroup",
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	partitions := []TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1}}
+	err = c.Assign(partitions)
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	_, err = c.CommitStored(5000)
+	if err == nil || err.(Error).Code() != ErrNoOffset {
+		t.Errorf("Expected ErrNoOffset without stored offsets, got %v", err)
+	}
+
+	_, err = c.StoreOffsets([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 3},
+		{Topic: &topic, Partition: 1, Offset: 7}})
+	if err != nil {
+		t.Fatalf("StoreOffsets: %v", err)
+	}
+
+	_, err = c.CommitStored(5000)
+	if err != nil {
+		t.Fatalf("CommitStored: %v", err)
+	}
+
+	committed, err := c.Committed(partitions, 5000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+	for i, expected := range []Offset{3, 7} {
+		if committed[i].Offset != expected {
+			t.Errorf("Expected committed offset %v, got %v",
+				expected, committed[i])
+		}
+	}
+}
+
+// TestConsumerAssignAndWait verifies that AssignAndWait() returns only once
+// the watermarks of all partitions are cached, and times out while the
+// broker is down.
+func TestConsumerAssignAndWait(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "assignandwaittopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 3)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "assignandwaitgroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	partitions := []TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning}}
+
+	_, high, _ := c.GetWatermarkOffsets(topic, 0)
+	if high != int64(OffsetInvalid) {
+		t.Fatalf("Expected no cached watermark before assignment, got %d", high)
+	}
+
+	err = c.AssignAndWait(partitions, 10000)
+	if err != nil {
+		t.Fatalf("AssignAndWait: %v", err)
+	}
+
+	for _, tp := range partitions {
+		_, high, err = c.GetWatermarkOffsets(topic, tp.Partition)
+		if err != nil || high == int64(OffsetInvalid) {
+			t.Errorf("Expected cached watermark for %v, got %d (%v)", tp, high, err)
+		}
+	}
+	if _, high, _ = c.GetWatermarkOffsets(topic, 0); high != 3 {
+		t.Errorf("Expected high watermark 3 for partition 0, got %d", high)
+	}
+
+	c2, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "assignandwaitgroup2"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c2.Close()
+
+	err = mc.SetBrokerDown(1)
+	if err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	defer mc.SetBrokerUp(1)
+
+	err = c2.AssignAndWait(partitions, 500)
+	if err == nil || err.(Error).Code() != ErrTimedOut {
+		t.Errorf("Expected ErrTimedOut while the broker is down, got %v", err)
+	}
+}
+
+// TestConsumerReset verifies that Reset() recovers a consumer from a fatal
+// error, resuming consumption with the same subscription and RebalanceCb.
+func TestConsumerReset(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "resettopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 5)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "resetgroup",
+		"auto.offset.reset":  "earliest",
+		"session.timeout.ms": 6000})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	assigns := 0
+	rebalanceCb := func(c *Consumer, ev Event) error {
+		if _, ok := ev.(AssignedPartitions); ok {
+			assigns++
+		}
+		return nil
+	}
+	err = c.Subscribe(topic, rebalanceCb)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	mockConsume(t, c, 5, 30*time.Second)
+
+	testFatalError(c, ErrFencedInstanceID, "reset test")
+	if err = getFatalError(c); err == nil {
+		t.Fatalf("Expected a fatal error")
+	}
+
+	err = c.Reset()
+	if err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if err = getFatalError(c); err != nil {
+		t.Errorf("Expected no fatal error after Reset, got %v", err)
+	}
+	subscription, err := c.Subscription()
+	if err != nil || len(subscription) != 1 || subscription[0] != topic {
+		t.Errorf("Expected subscription to %s to be preserved, got %v (%v)",
+			topic, subscription, err)
+	}
+
+	// Consumption resumes from the offsets committed by Reset().
+	mockProduce(t, mc, topic, 0, 5)
+	msgs := mockConsume(t, c, 5, 30*time.Second)
+	if msgs[0].TopicPartition.Offset != 5 {
+		t.Errorf("Expected consumption to resume at offset 5, got %v",
+			msgs[0].TopicPartition)
+	}
+
+	if assigns != 2 {
+		t.Errorf("Expected the RebalanceCb to see 2 assignments, got %d", assigns)
+	}
+}
+
+// TestConsumerResetFailure verifies that a consumer whose Reset() fails to
+// create the new instance is left closed, returning errors.
+func TestConsumerResetFailure(t *testing.T) {
+	c, err := NewConsumer(&ConfigMap{
+		"group.id": "resetfailuregroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	// Make the new instance fail to be created.
+	delete(c.conf, "group.id")
+
+	err = c.Reset()
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrInvalidArg {
+		t.Fatalf("Expected Reset to fail with ErrInvalidArg, got %v", err)
+	}
+
+	expectClosed := func(what string, err error) {
+		if kerr, ok := err.(Error); !ok || kerr.Code() != ErrState {
+			t.Errorf("Expected %s to fail with ErrState, got %v", what, err)
+		}
+	}
+
+	ev, _ := c.Poll(100).(Error)
+	expectClosed("Poll", ev)
+	_, err = c.ReadMessage(100 * time.Millisecond)
+	expectClosed("ReadMessage", err)
+	expectClosed("Subscribe", c.Subscribe("topic", nil))
+	expectClosed("Assign", c.Assign(nil))
+	expectClosed("Reset", c.Reset())
+	expectClosed("Close", c.Close())
+}
+
+// TestConsumerPauseReader verifies that messages are not read onto the
+// Events() channel while the reader is paused, and are all delivered once
+// it is resumed.
+func TestConsumerPauseReader(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "pausereadertopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 3)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "pausereadergroup",
+		"go.events.channel.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// Returns the messages received on the Events() channel within timeout.
+	readMessages := func(cnt int, timeout time.Duration) []*Message {
+		var msgs []*Message
+		tEnd := time.After(timeout)
+		for len(msgs) < cnt {
+			select {
+			case ev := <-c.Events():
+				if m, ok := ev.(*Message); ok {
+					msgs = append(msgs, m)
+				}
+			case <-tEnd:
+				return msgs
+			}
+		}
+		return msgs
+	}
+
+	if msgs := readMessages(3, 10*time.Second); len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(msgs))
+	}
+
+	err = c.PauseReader()
+	if err != nil {
+		t.Fatalf("PauseReader: %v", err)
+	}
+	// Pausing again is a no-op.
+	err = c.PauseReader()
+	if err != nil {
+		t.Fatalf("PauseReader: %v", err)
+	}
+
+	mockProduce(t, mc, topic, 0, 5)
+
+	if msgs := readMessages(1, 2*time.Second); len(msgs) != 0 {
+		t.Fatalf("Expected no messages while paused, got %v", msgs)
+	}
+
+	err = c.ResumeReader()
+	if err != nil {
+		t.Fatalf("ResumeReader: %v", err)
+	}
+
+	msgs := readMessages(5, 10*time.Second)
+	if len(msgs) != 5 {
+		t.Fatalf("Expected 5 messages after resuming, got %d", len(msgs))
+	}
+	for i, m := range msgs {
+		if m.TopicPartition.Offset != Offset(3+i) {
+			t.Errorf("Expected message %d at offset %d, got %v",
+				i, 3+i, m.TopicPartition)
+		}
+	}
+
+	c2, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "pausereadergroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c2.Close()
+
+	err = c2.PauseReader()
+	if err == nil || err.(Error).Code() != ErrState {
+		t.Errorf("Expected ErrState without go.events.channel.enable, got %v", err)
+	}
+}
+
+// TestConsumerPausePartitionErrors verifies that Pause() and Resume()
+// report per-partition errors in TopicPartition.Error.
+func TestConsumerPausePartitionErrors(t *testing.T) {
+	c, err := NewConsumer(&ConfigMap{
+		"group.id":           "pausepartitionerrorsgroup",
+		"socket.timeout.ms":  10,
+		"session.timeout.ms": 10})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	topic := "pausetopic"
+	unknownTopic := "pauseunknowntopic"
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	for _, op := range []struct {
+		name string
+		fn   func([]TopicPartition) error
+	}{{"Pause", c.Pause}, {"Resume", c.Resume}} {
+		partitions := []TopicPartition{
+			{Topic: &topic, Partition: 0},
+			{Topic: &unknownTopic, Partition: 0}}
+
+		err = op.fn(partitions)
+		if err == nil || err.(Error).Code() != ErrUnknownPartition {
+			t.Errorf("Expected %s to fail with ErrUnknownPartition, got %v",
+				op.name, err)
+		}
+		if partitions[0].Error != nil {
+			t.Errorf("Expected %s of %v to succeed, got %v",
+				op.name, partitions[0], partitions[0].Error)
+		}
+		if partitions[1].Error == nil ||
+			partitions[1].Error.(Error).Code() != ErrUnknownPartition {
+			t.Errorf("Expected %s of %v to fail with ErrUnknownPartition, got %v",
+				op.name, partitions[1], partitions[1].Error)
+		}
+
+		err = op.fn(partitions[:1])
+		if err != nil || partitions[0].Error != nil {
+			t.Errorf("Expected %s of the assigned partition to succeed, got %v",
+				op.name, err)
+		}
+
+		assignment, err := c.Assignment()
+		if err != nil || len(assignment) != 1 ||
+			*assignment[0].Topic != topic || assignment[0].Partition != 0 {
+			t.Errorf("Expected %s to leave %s [0] assigned, got %v (%v)",
+				op.name, topic, assignment, err)
+		}
+	}
+}
+
+// TestConsumerSeek verifies that Seek() supports the logical offsets and
+// returns ErrState for a partition that is not assigned.
+func TestConsumerSeek(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "seektopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 10)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "seekgroup",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.CommitOffsets([]TopicPartition{{Topic: &topic, Partition: 0, Offset: 3}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: 5}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if msgs := mockConsume(t, c, 1, 10*time.Second); msgs[0].TopicPartition.Offset != 5 {
+		t.Fatalf("Expected to start at offset 5, got %v", msgs[0].TopicPartition)
+	}
+
+	err = c.Seek(TopicPartition{Topic: &topic, Partition: 1, Offset: 0}, 5000)
+	if err == nil || err.(Error).Code() != ErrState {
+		t.Errorf("Expected ErrState seeking an unassigned partition, got %v", err)
+	}
+
+	err = c.Seek(TopicPartition{Topic: &topic, Partition: 0, Offset: OffsetStored}, 0)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg seeking to OffsetStored without a timeout, got %v", err)
+	}
+
+	for _, seek := range []struct {
+		offset   Offset
+		expected Offset
+	}{
+		{OffsetBeginning, 0},
+		{OffsetStored, 3},
+		{7, 7},
+		{OffsetEnd, 10},
+	} {
+		err = c.Seek(TopicPartition{Topic: &topic, Partition: 0, Offset: seek.offset}, 5000)
+		if err != nil {
+			t.Fatalf("Seek to %v: %v", seek.offset, err)
+		}
+		if seek.offset == OffsetEnd {
+			// Nothing to consume until a new message is produced,
+			// once the end offset has been looked up.
+			m, err := c.ReadMessage(time.Second)
+			if err == nil || err.(Error).Code() != ErrTimedOut {
+				t.Fatalf("Expected no message at the end, got %v (%v)", m, err)
+			}
+			mockProduce(t, mc, topic, 0, 1)
+		}
+
+		msgs := mockConsume(t, c, 1, 10*time.Second)
+		if msgs[0].TopicPartition.Offset != seek.expected {
+			t.Errorf("Expected Seek to %v to consume offset %v next, got %v",
+				seek.offset, seek.expected, msgs[0].TopicPartition)
+		}
+	}
+}
+
+// TestConsumerCommitOnRevokeOnly verifies that go.commit.on.revoke.only
+// commits the stored offsets on revoke and on Close(), but not in between.
+func TestConsumerCommitOnRevokeOnly(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "revokeonlytopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 5)
+
+	conf := ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "revokeonlygroup",
+		"auto.offset.reset":        "earliest",
+		"session.timeout.ms":       6000,
+		"go.commit.on.revoke.only": true}
+
+	committed := func(c *Consumer) Offset {
+		offsets, err := c.Committed([]TopicPartition{{Topic: &topic, Partition: 0}}, 5000)
+		if err != nil {
+			t.Fatalf("Committed: %v", err)
+		}
+		return offsets[0].Offset
+	}
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	if !c.AutoCommitEnabled() || c.AutoCommitInterval() != 0 {
+		t.Errorf("Expected auto commit to be enabled without an interval, got %v, %v",
+			c.AutoCommitEnabled(), c.AutoCommitInterval())
+	}
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	mockConsume(t, c, 5, 30*time.Second)
+
+	// Past the default auto.commit.interval.ms nothing is committed.
+	for i := 0; i < 60; i++ {
+		c.Poll(100)
+	}
+	if offset := committed(c); offset != OffsetInvalid {
+		t.Errorf("Expected no commit before revoke, got %v", offset)
+	}
+
+	err = c.Unsubscribe()
+	if err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		c.Poll(100)
+	}
+	if offset := committed(c); offset != 5 {
+		t.Errorf("Expected offset 5 to be committed on revoke, got %v", offset)
+	}
+
+	// Consume from the committed offset with a new consumer and close it.
+	mockProduce(t, mc, topic, 0, 5)
+	c.Close()
+
+	c, err = NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	msgs := mockConsume(t, c, 5, 30*time.Second)
+	if msgs[0].TopicPartition.Offset != 5 {
+		t.Errorf("Expected consumption to resume at offset 5, got %v",
+			msgs[0].TopicPartition)
+	}
+	c.Close()
+
+	c, err = NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+	if offset := committed(c); offset != 10 {
+		t.Errorf("Expected offset 10 to be committed on Close(), got %v", offset)
+	}
+}
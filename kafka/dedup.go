@@ -0,0 +1,145 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DedupKeyFunc extracts the deduplication key of a message.
+// ok is false for messages that should never be considered duplicates.
+type DedupKeyFunc func(m *Message) (key string, ok bool)
+
+// DedupByMessageKey is a DedupKeyFunc using the message key as the
+// deduplication key. Messages with a nil key are never considered
+// duplicates.
+func DedupByMessageKey(m *Message) (key string, ok bool) {
+	if m.Key == nil {
+		return "", false
+	}
+	return string(m.Key), true
+}
+
+// IdempotencyKeyHeader is the name of the message header holding the
+// idempotency key set by Producer.ProduceIdempotent().
+const IdempotencyKeyHeader = "idempotency-key"
+
+// DedupByIdempotencyKey is a DedupKeyFunc using the value of the
+// IdempotencyKeyHeader header, as set by Producer.ProduceIdempotent(),
+// as the deduplication key. Messages without the header are never
+// considered duplicates.
+func DedupByIdempotencyKey(m *Message) (key string, ok bool) {
+	for _, h := range m.Headers {
+		if h.Key == IdempotencyKeyHeader {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// dedupEntry is a key seen by a Deduplicator.
+type dedupEntry struct {
+	key      string
+	lastSeen time.Time
+}
+
+// Deduplicator suppresses re-delivered messages whose key has been seen
+// within a time window, backed by a bounded LRU of recently seen keys.
+//
+// Deduplication is BEST EFFORT and does NOT provide exactly-once semantics:
+// keys are only remembered in memory by this process, for at most
+// the configured window and number of keys, so duplicates that arrive
+// after the window, after the key has been evicted from the LRU, after
+// a restart, or at another consumer in the group (e.g., following a
+// rebalance) are not detected.
+// Use the transactional producer and `isolation.level=read_committed`
+// for exactly-once processing.
+//
+// A Deduplicator is safe for concurrent use.
+type Deduplicator struct {
+	keyFunc DedupKeyFunc
+	window  time.Duration
+	maxKeys int
+
+	lock    sync.Mutex
+	lru     *list.List // front is most recently seen
+	entries map[string]*list.Element
+
+	// now returns the current time, overridable for testing.
+	now func() time.Time
+}
+
+// NewDeduplicator creates a new Deduplicator extracting message keys with
+// keyFunc, considering a message a duplicate if its key was seen within
+// window, and remembering at most maxKeys keys.
+// A window of 0 remembers keys until they are evicted from the LRU.
+func NewDeduplicator(keyFunc DedupKeyFunc, window time.Duration, maxKeys int) (*Deduplicator, error) {
+	if keyFunc == nil || window < 0 || maxKeys < 1 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"Deduplicator requires a keyFunc, a non-negative window and maxKeys > 0")
+	}
+
+	return &Deduplicator{
+		keyFunc: keyFunc,
+		window:  window,
+		maxKeys: maxKeys,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}, nil
+}
+
+// IsDuplicate returns true if the key of m has been seen within the window,
+// and records the key as seen now.
+func (d *Deduplicator) IsDuplicate(m *Message) bool {
+	key, ok := d.keyFunc(m)
+	if !ok {
+		return false
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.now()
+
+	if elem, found := d.entries[key]; found {
+		entry := elem.Value.(*dedupEntry)
+		duplicate := d.window == 0 || now.Sub(entry.lastSeen) < d.window
+		entry.lastSeen = now
+		d.lru.MoveToFront(elem)
+		return duplicate
+	}
+
+	d.entries[key] = d.lru.PushFront(&dedupEntry{key: key, lastSeen: now})
+
+	for d.lru.Len() > d.maxKeys {
+		oldest := d.lru.Back()
+		delete(d.entries, oldest.Value.(*dedupEntry).key)
+		d.lru.Remove(oldest)
+	}
+
+	return false
+}
+
+// Len returns the number of keys currently remembered.
+func (d *Deduplicator) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lru.Len()
+}
@@ -0,0 +1,100 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDeduplicator verifies window and LRU bound handling of the
+// Deduplicator.
+func TestDeduplicator(t *testing.T) {
+	d, err := NewDeduplicator(DedupByMessageKey, time.Minute, 2)
+	if err != nil {
+		t.Fatalf("NewDeduplicator: %v", err)
+	}
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	msg := func(key string) *Message {
+		return &Message{Key: []byte(key)}
+	}
+
+	expect := func(key string, duplicate bool) {
+		if d.IsDuplicate(msg(key)) != duplicate {
+			t.Errorf("Expected IsDuplicate(%s) to be %v", key, duplicate)
+		}
+	}
+
+	expect("a", false)
+	expect("a", true)
+	expect("b", false)
+
+	// Messages without a key are never duplicates.
+	if d.IsDuplicate(&Message{}) || d.IsDuplicate(&Message{}) {
+		t.Errorf("Expected nil key to never be a duplicate")
+	}
+
+	// Evicts "a", the least recently seen key.
+	expect("b", true)
+	expect("c", false)
+	if d.Len() != 2 {
+		t.Errorf("Expected 2 keys remembered, got %d", d.Len())
+	}
+	expect("a", false)
+
+	// "c" was seen within the window, "a" was not.
+	now = now.Add(30 * time.Second)
+	expect("c", true)
+	now = now.Add(45 * time.Second)
+	expect("c", true)
+	expect("a", false)
+}
+
+// TestDeduplicatorNoWindow verifies that a zero window remembers keys
+// until they are evicted.
+func TestDeduplicatorNoWindow(t *testing.T) {
+	d, err := NewDeduplicator(DedupByMessageKey, 0, 10)
+	if err != nil {
+		t.Fatalf("NewDeduplicator: %v", err)
+	}
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	m := &Message{Key: []byte("key")}
+	if d.IsDuplicate(m) {
+		t.Errorf("Expected first message not to be a duplicate")
+	}
+
+	now = now.Add(24 * time.Hour)
+	if !d.IsDuplicate(m) {
+		t.Errorf("Expected repeated message to be a duplicate")
+	}
+
+	_, err = NewDeduplicator(nil, 0, 10)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for nil keyFunc, got %v", err)
+	}
+
+	_, err = NewDeduplicator(DedupByMessageKey, 0, 0)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for maxKeys 0, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Deserializer deserializes the key or value data of a message consumed
+// from topic, see NewDeserializingConsumer().
+type Deserializer func(topic string, data []byte) (interface{}, error)
+
+// DeserializeErrorAction is the action taken by a DeserializingConsumer
+// for a message that failed to deserialize.
+type DeserializeErrorAction int
+
+const (
+	// DeserializeErrorStop returns a DeserializationError, with the raw
+	// message, from ReadMessage()
+	DeserializeErrorStop DeserializeErrorAction = iota
+	// DeserializeErrorSkip skips the message
+	DeserializeErrorSkip
+	// DeserializeErrorDLQ produces the raw message to the dead letter
+	// queue, see SetDeadLetterQueue(), and skips it
+	DeserializeErrorDLQ
+)
+
+// DeserializeErrorHandler decides the action to take for raw, a message
+// with its key and value bytes intact, that failed to deserialize with err.
+type DeserializeErrorHandler func(raw *Message, err error) DeserializeErrorAction
+
+// DeserializationError is returned by DeserializingConsumer.ReadMessage()
+// for a message that failed to deserialize, when the action is
+// DeserializeErrorStop.
+type DeserializationError struct {
+	// Message is the raw message
+	Message *Message
+	// Err is the Deserializer's error
+	Err error
+}
+
+func (e *DeserializationError) Error() string {
+	return fmt.Sprintf("Failed to deserialize message at %v: %v",
+		e.Message.TopicPartition, e.Err)
+}
+
+// DeserializedMessage is a message returned by a DeserializingConsumer.
+type DeserializedMessage struct {
+	// Message is the raw message
+	Message *Message
+	// Key is the deserialized key, or the raw key without a key Deserializer
+	Key interface{}
+	// Value is the deserialized value, or the raw value without a
+	// value Deserializer
+	Value interface{}
+}
+
+// DeserializingConsumer reads messages from a Consumer and deserializes
+// their keys and values, handing messages that fail to deserialize, such
+// as poison payloads, to the OnDeserializeError() handler rather than
+// failing the consume loop or silently dropping them.
+type DeserializingConsumer struct {
+	c                 *Consumer
+	keyDeserializer   Deserializer
+	valueDeserializer Deserializer
+	onError           DeserializeErrorHandler
+
+	dlqProducer *Producer
+	dlqTopic    string
+}
+
+// NewDeserializingConsumer creates a DeserializingConsumer reading from c
+// and deserializing keys and values with keyDeserializer and
+// valueDeserializer, either of which may be nil to leave the key or value
+// as is.
+func NewDeserializingConsumer(c *Consumer, keyDeserializer, valueDeserializer Deserializer) *DeserializingConsumer {
+	return &DeserializingConsumer{
+		c:                 c,
+		keyDeserializer:   keyDeserializer,
+		valueDeserializer: valueDeserializer,
+	}
+}
+
+// OnDeserializeError sets the handler deciding the action to take for
+// messages that fail to deserialize.
+// Without a handler the action is DeserializeErrorStop.
+func (dc *DeserializingConsumer) OnDeserializeError(handler DeserializeErrorHandler) {
+	dc.onError = handler
+}
+
+// SetDeadLetterQueue sets the producer and topic raw messages are produced
+// to for DeserializeErrorDLQ, with the original topic, partition and
+// error described by the DeadLetterHeaderTopic, DeadLetterHeaderPartition
+// and DeadLetterHeaderError headers.
+// Delivery reports are emitted on p's Events() channel.
+func (dc *DeserializingConsumer) SetDeadLetterQueue(p *Producer, topic string) {
+	dc.dlqProducer = p
+	dc.dlqTopic = topic
+}
+
+// ReadMessage reads and deserializes the next message, see
+// Consumer.ReadMessage(), skipping messages that fail to deserialize
+// according to the OnDeserializeError() handler.
+//
+// Returns a DeserializationError for a message that failed to deserialize
+// with DeserializeErrorStop, the message has then been consumed, Seek()
+// to its offset to consume it again.
+// Errors producing to the dead letter queue are returned as is.
+func (dc *DeserializingConsumer) ReadMessage(timeout time.Duration) (*DeserializedMessage, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		remaining := timeout
+		if timeout >= 0 {
+			remaining = time.Until(deadline)
+			if remaining < 0 {
+				remaining = 0
+			}
+		}
+
+		msg, err := dc.c.ReadMessage(remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		dm, err := dc.deserialize(msg)
+		if err == nil {
+			return dm, nil
+		}
+
+		action := DeserializeErrorStop
+		if dc.onError != nil {
+			action = dc.onError(msg, err)
+		}
+
+		switch action {
+		case DeserializeErrorSkip:
+			continue
+		case DeserializeErrorDLQ:
+			err = dc.produceDeadLetter(msg, err)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			return nil, &DeserializationError{Message: msg, Err: err}
+		}
+	}
+}
+
+// deserialize deserializes the key and value of msg.
+func (dc *DeserializingConsumer) deserialize(msg *Message) (*DeserializedMessage, error) {
+	dm := &DeserializedMessage{Message: msg, Key: msg.Key, Value: msg.Value}
+	var err error
+
+	if dc.keyDeserializer != nil {
+		dm.Key, err = dc.keyDeserializer(*msg.TopicPartition.Topic, msg.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dc.valueDeserializer != nil {
+		dm.Value, err = dc.valueDeserializer(*msg.TopicPartition.Topic, msg.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dm, nil
+}
+
+// produceDeadLetter produces msg, which failed to deserialize with cause,
+// to the dead letter queue.
+func (dc *DeserializingConsumer) produceDeadLetter(msg *Message, cause error) error {
+	if dc.dlqProducer == nil {
+		return newErrorFromString(ErrState,
+			"DeserializeErrorDLQ requires SetDeadLetterQueue()")
+	}
+
+	headers := make([]Header, len(msg.Headers), len(msg.Headers)+3)
+	copy(headers, msg.Headers)
+	headers = append(headers,
+		Header{DeadLetterHeaderTopic, []byte(*msg.TopicPartition.Topic)},
+		Header{DeadLetterHeaderPartition,
+			[]byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		Header{DeadLetterHeaderError, []byte(cause.Error())})
+
+	return dc.dlqProducer.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &dc.dlqTopic, Partition: PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}, nil)
+}
@@ -0,0 +1,165 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestDeserializingConsumer verifies the Skip, DLQ and Stop actions for
+// messages that fail to deserialize.
+func TestDeserializingConsumer(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "deserializertopic"
+	dlqTopic := "deserializerdlq"
+	for _, tp := range []string{topic, dlqTopic} {
+		err = mc.CreateTopic(tp, 1, 1)
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	// "b" and "d" fail to deserialize.
+	values := []string{"1", "b", "3", "d", "5"}
+	deliveryChan := make(chan Event, len(values))
+	for _, v := range values {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte(v)}, deliveryChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	for range values {
+		if m := (<-deliveryChan).(*Message); m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition.Error)
+		}
+	}
+
+	atoi := func(topic string, data []byte) (interface{}, error) {
+		return strconv.Atoi(string(data))
+	}
+
+	// Reads until the consumer has no more messages, returning the
+	// deserialized values and the first read error.
+	readAll := func(action DeserializeErrorAction, group string) ([]interface{}, []string, error) {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers": mc.BootstrapServers(),
+			"group.id":          group,
+			"auto.offset.reset": "earliest"})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		defer c.Close()
+
+		err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0}})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+
+		var raw []string
+		dc := NewDeserializingConsumer(c, nil, atoi)
+		dc.SetDeadLetterQueue(p, dlqTopic)
+		dc.OnDeserializeError(func(m *Message, err error) DeserializeErrorAction {
+			raw = append(raw, string(m.Value))
+			return action
+		})
+
+		var results []interface{}
+		for {
+			dm, err := dc.ReadMessage(2 * time.Second)
+			if err != nil {
+				if err, ok := err.(Error); ok && err.Code() == ErrTimedOut {
+					return results, raw, nil
+				}
+				return results, raw, err
+			}
+			results = append(results, dm.Value)
+		}
+	}
+
+	results, raw, err := readAll(DeserializeErrorSkip, "skipgroup")
+	if err != nil {
+		t.Fatalf("Skip: unexpected error %v", err)
+	}
+	if fmt.Sprintf("%v %v", results, raw) != "[1 3 5] [b d]" {
+		t.Errorf("Skip: expected [1 3 5] [b d], got %v %v", results, raw)
+	}
+
+	results, _, err = readAll(DeserializeErrorStop, "stopgroup")
+	derr, ok := err.(*DeserializationError)
+	if !ok || string(derr.Message.Value) != "b" || derr.Message.TopicPartition.Offset != 1 {
+		t.Fatalf("Stop: expected DeserializationError for \"b\", got %v", err)
+	}
+	if fmt.Sprintf("%v", results) != "[1]" {
+		t.Errorf("Stop: expected [1], got %v", results)
+	}
+
+	results, _, err = readAll(DeserializeErrorDLQ, "dlqgroup")
+	if err != nil {
+		t.Fatalf("DLQ: unexpected error %v", err)
+	}
+	if fmt.Sprintf("%v", results) != "[1 3 5]" {
+		t.Errorf("DLQ: expected [1 3 5], got %v", results)
+	}
+	p.Flush(10 * 1000)
+
+	dlqc, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "dlqreadergroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer dlqc.Close()
+
+	err = dlqc.Assign([]TopicPartition{{Topic: &dlqTopic, Partition: 0}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	for i, m := range mockConsume(t, dlqc, 2, 10*time.Second) {
+		expected := []string{"b", "d"}[i]
+		if string(m.Value) != expected {
+			t.Errorf("DLQ message %d: expected raw value %s, got %s", i, expected, m.Value)
+		}
+		headers := make(map[string]string)
+		for _, h := range m.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+		if headers[DeadLetterHeaderTopic] != topic ||
+			headers[DeadLetterHeaderPartition] != "0" ||
+			headers[DeadLetterHeaderError] == "" {
+			t.Errorf("DLQ message %d: unexpected headers %v", i, m.Headers)
+		}
+	}
+}
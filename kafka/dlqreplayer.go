@@ -0,0 +1,248 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dlqReplaySeekTimeoutMs is the maximum time the DLQReplayer waits for
+// seeking a partition back to a message left in the dead letter queue.
+const dlqReplaySeekTimeoutMs = 10 * 1000
+
+// DeadLetterHeaderReplayAttempts is the number of times, as a decimal
+// string, a DLQReplayer's handler failed to process a message parked in
+// the parking lot topic. A DLQReplayer only makes the remaining attempts
+// for messages with this header, e.g., when replaying the parking lot.
+const DeadLetterHeaderReplayAttempts = "dlq.replay.attempts"
+
+// ReplayHandler reprocesses a dead letter message.
+type ReplayHandler func(msg *Message) error
+
+// DLQReplayer drains a dead letter queue topic by re-invoking a
+// ReplayHandler for each message at a controlled rate, e.g., after fixing
+// the bug the messages failed on.
+//
+// Messages the handler fails on are retried, at the same rate, up to the
+// maximum number of attempts, after which they are either parked in the
+// parking lot topic, see SetParkingLot(), or left in the dead letter queue,
+// in which case Run() returns the handler's error.
+//
+// The offset of each message is stored, see StoreOffsets(), once the
+// handler succeeded or the message was parked, the Consumer should
+// therefore be configured with `enable.auto.offset.store=false`, so that
+// messages left in the dead letter queue are not committed, and must not
+// be used with the Events() channel.
+type DLQReplayer struct {
+	c           *Consumer
+	handler     ReplayHandler
+	interval    time.Duration // Minimum interval between handler calls
+	maxAttempts int
+
+	parkingLotProducer *Producer
+	parkingLotTopic    string
+
+	termChan chan bool
+	stopOnce sync.Once
+}
+
+// NewDLQReplayer creates a DLQReplayer reprocessing the dead letter
+// messages consumed by c with handler, calling handler at most
+// ratePerSecond times per second and at most maxAttempts times for each
+// message.
+// The application subscribes or assigns c to the dead letter queue topic.
+//
+// Returns ErrInvalidArg if ratePerSecond or maxAttempts is not positive.
+func NewDLQReplayer(c *Consumer, handler ReplayHandler, ratePerSecond float64, maxAttempts int) (*DLQReplayer, error) {
+	if ratePerSecond <= 0 || maxAttempts < 1 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"ratePerSecond and maxAttempts must be positive")
+	}
+
+	return &DLQReplayer{
+		c:           c,
+		handler:     handler,
+		interval:    time.Duration(float64(time.Second) / ratePerSecond),
+		maxAttempts: maxAttempts,
+		termChan:    make(chan bool),
+	}, nil
+}
+
+// SetParkingLot parks messages the handler failed on maxAttempts times in
+// topic, produced with p, with the DeadLetterHeaderReplayAttempts and
+// DeadLetterHeaderError headers set, rather than leaving them in the dead
+// letter queue.
+// Must be called before Run().
+func (r *DLQReplayer) SetParkingLot(p *Producer, topic string) {
+	r.parkingLotProducer = p
+	r.parkingLotTopic = topic
+}
+
+// Run replays messages until Stop() is called, in which case nil is
+// returned.
+//
+// If the handler fails on a message maxAttempts times and there is no
+// parking lot, or the message can't be parked, the message's partition is
+// sought back to the message and the error is returned. The application
+// may then call Run() again to retry, or Stop() replaying.
+// Fatal consumer errors are returned as well, other consumer errors are
+// ignored.
+func (r *DLQReplayer) Run() error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.termChan:
+			return nil
+		default:
+		}
+
+		switch e := r.c.Poll(100).(type) {
+		case *Message:
+			err := r.replayMessage(e, ticker.C)
+			if err != nil {
+				return err
+			}
+		case Error:
+			if e.IsFatal() {
+				return e
+			}
+		}
+	}
+}
+
+// Stop stops Run(), once it has finished replaying the current message.
+func (r *DLQReplayer) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.termChan)
+	})
+}
+
+// replayMessage calls the handler for msg, retrying failures at the rate
+// of tick, and parks or leaves msg once the attempts are exhausted.
+func (r *DLQReplayer) replayMessage(msg *Message, tick <-chan time.Time) error {
+	attempts, err := replayAttempts(msg)
+	if err != nil {
+		return r.leave(msg, err)
+	}
+
+	for attempts < r.maxAttempts {
+		select {
+		case <-r.termChan:
+			// Not attempted, leave msg to be replayed again.
+			return r.leave(msg, nil)
+		case <-tick:
+		}
+
+		err = r.handler(msg)
+		attempts++
+		if err == nil {
+			return r.storeOffset(msg)
+		}
+	}
+
+	if err == nil {
+		err = newErrorFromString(ErrState, fmt.Sprintf(
+			"Message already attempted %d times", attempts))
+	}
+
+	if r.parkingLotProducer == nil {
+		return r.leave(msg, err)
+	}
+
+	parkErr := r.park(msg, attempts, err)
+	if parkErr != nil {
+		return r.leave(msg, parkErr)
+	}
+
+	return r.storeOffset(msg)
+}
+
+// replayAttempts returns the DeadLetterHeaderReplayAttempts of msg, or 0
+// if it has none.
+func replayAttempts(msg *Message) (int, error) {
+	for _, header := range msg.Headers {
+		if header.Key != DeadLetterHeaderReplayAttempts {
+			continue
+		}
+
+		attempts, err := strconv.Atoi(string(header.Value))
+		if err != nil || attempts < 0 {
+			return 0, newErrorFromString(ErrInvalidArg, fmt.Sprintf(
+				"Invalid %s header: %q",
+				DeadLetterHeaderReplayAttempts, header.Value))
+		}
+		return attempts, nil
+	}
+
+	return 0, nil
+}
+
+// park produces msg, which the handler failed on attempts times, last
+// with cause, to the parking lot topic and waits for its delivery.
+func (r *DLQReplayer) park(msg *Message, attempts int, cause error) error {
+	headers := make([]Header, 0, len(msg.Headers)+2)
+	for _, header := range msg.Headers {
+		if header.Key != DeadLetterHeaderReplayAttempts &&
+			header.Key != DeadLetterHeaderError {
+			headers = append(headers, header)
+		}
+	}
+	headers = append(headers,
+		Header{DeadLetterHeaderReplayAttempts, []byte(strconv.Itoa(attempts))},
+		Header{DeadLetterHeaderError, []byte(cause.Error())})
+
+	deliveryChan := make(chan Event, 1)
+	err := r.parkingLotProducer.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &r.parkingLotTopic, Partition: PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}, deliveryChan)
+	if err != nil {
+		return err
+	}
+
+	m := (<-deliveryChan).(*Message)
+	return m.TopicPartition.Error
+}
+
+// storeOffset stores the offset following msg.
+func (r *DLQReplayer) storeOffset(msg *Message) error {
+	_, err := r.c.StoreOffsets([]TopicPartition{{
+		Topic:     msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    msg.TopicPartition.Offset + 1,
+	}})
+	return err
+}
+
+// leave seeks the partition of msg back to msg, leaving it in the dead
+// letter queue to be replayed again.
+// Returns the seek error, if any, else cause.
+func (r *DLQReplayer) leave(msg *Message, cause error) error {
+	err := r.c.Seek(msg.TopicPartition, dlqReplaySeekTimeoutMs)
+	if err != nil {
+		return err
+	}
+
+	return cause
+}
@@ -0,0 +1,168 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestDLQReplayer tests replaying a dead letter queue with retries,
+// parking and leaving of failed messages.
+func TestDLQReplayer(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	dlqTopic := "dlqreplaytopic"
+	parkingLotTopic := "dlqreplayparkinglot"
+	mockProduce(t, mc, dlqTopic, 0, 5)
+
+	newConsumer := func(topic string) *Consumer {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":        mc.BootstrapServers(),
+			"group.id":                 "dlqreplaygroup",
+			"enable.auto.commit":       false,
+			"enable.auto.offset.store": false})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+
+		err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0,
+			Offset: OffsetBeginning}})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+		return c
+	}
+
+	// Expects offset to be stored for the DLQ partition.
+	expectStored := func(c *Consumer, offset Offset) {
+		committed, err := c.Commit()
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		if len(committed) != 1 || committed[0].Offset != offset {
+			t.Fatalf("Expected offset %v stored, got %v", offset, committed)
+		}
+	}
+
+	_, err = NewDLQReplayer(nil, nil, 0, 1)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Fatalf("Expected ErrInvalidArg for rate 0, got %v", err)
+	}
+
+	// With a parking lot: value1 always fails and is parked, value2
+	// fails once and succeeds on its retry.
+	p, err := NewProducer(&ConfigMap{"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	c := newConsumer(dlqTopic)
+	defer c.Close()
+
+	calls := make(map[string]int)
+	var replayer *DLQReplayer
+	replayer, err = NewDLQReplayer(c, func(msg *Message) error {
+		value := string(msg.Value)
+		calls[value]++
+		if value == "value1" || (value == "value2" && calls[value] == 1) {
+			return fmt.Errorf("Handler failed on %s", value)
+		}
+		if value == "value4" {
+			replayer.Stop()
+		}
+		return nil
+	}, 50, 3)
+	if err != nil {
+		t.Fatalf("NewDLQReplayer: %v", err)
+	}
+	replayer.SetParkingLot(p, parkingLotTopic)
+
+	start := time.Now()
+	err = replayer.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// 8 calls at 50/s are at least 7 intervals of 20ms apart.
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("Expected replaying to be rate limited, took %v", elapsed)
+	}
+	if calls["value0"] != 1 || calls["value1"] != 3 ||
+		calls["value2"] != 2 || calls["value3"] != 1 || calls["value4"] != 1 {
+		t.Errorf("Unexpected handler calls: %v", calls)
+	}
+	expectStored(c, 5)
+
+	pc := newConsumer(parkingLotTopic)
+	defer pc.Close()
+	parked := mockConsume(t, pc, 1, 10*time.Second)[0]
+	if string(parked.Value) != "value1" {
+		t.Fatalf("Expected value1 parked, got %v", parked)
+	}
+	attempts, err := replayAttempts(parked)
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected 3 attempts header, got %d (%v): %v",
+			attempts, err, parked.Headers)
+	}
+
+	// Replaying the parking lot only makes the remaining attempts.
+	parkedCalls := 0
+	parkedReplayer, err := NewDLQReplayer(pc, func(msg *Message) error {
+		parkedCalls++
+		return nil
+	}, 50, 3)
+	if err != nil {
+		t.Fatalf("NewDLQReplayer: %v", err)
+	}
+	err = parkedReplayer.replayMessage(parked, time.Tick(time.Millisecond))
+	if err == nil || parkedCalls != 0 {
+		t.Fatalf("Expected exhausted message to be left without calls, "+
+			"got %v after %d calls", err, parkedCalls)
+	}
+
+	// Without a parking lot: value1 is left in the DLQ.
+	lc := newConsumer(dlqTopic)
+	defer lc.Close()
+
+	replayer, err = NewDLQReplayer(lc, func(msg *Message) error {
+		if string(msg.Value) == "value1" {
+			return fmt.Errorf("Handler failed on value1")
+		}
+		return nil
+	}, 100, 2)
+	if err != nil {
+		t.Fatalf("NewDLQReplayer: %v", err)
+	}
+
+	err = replayer.Run()
+	if err == nil || err.Error() != "Handler failed on value1" {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	expectStored(lc, 1)
+
+	msg := mockConsume(t, lc, 1, 10*time.Second)[0]
+	if string(msg.Value) != "value1" {
+		t.Fatalf("Expected value1 to be consumed again, got %v", msg)
+	}
+}
@@ -28,9 +28,27 @@ import "C"
 
 import (
 	"fmt"
+	"runtime/debug"
 	"unsafe"
 )
 
+// Go client specific error codes.
+// These are raised by the Go client itself, rather than librdkafka,
+// and are allocated from a range that is not used by librdkafka.
+const (
+	// ErrConsumerIdle Local: Consumer idle
+	ErrConsumerIdle ErrorCode = -10000
+	// ErrRateLimited Local: Rate limited
+	ErrRateLimited ErrorCode = -10001
+)
+
+// goErrorCodeStrings provides the human readable representation of
+// the Go client specific error codes.
+var goErrorCodeStrings = map[ErrorCode]string{
+	ErrConsumerIdle: "Local: Consumer idle",
+	ErrRateLimited:  "Local: Rate limited",
+}
+
 // Error provides a Kafka-specific error container
 type Error struct {
 	code             ErrorCode
@@ -38,6 +56,9 @@ type Error struct {
 	fatal            bool
 	retriable        bool
 	txnRequiresAbort bool
+	op               string
+	stack            string
+	cause            error
 }
 
 func newError(code C.rd_kafka_resp_err_t) (err Error) {
@@ -96,7 +117,11 @@ func (e Error) String() string {
 	}
 
 	if e.IsFatal() {
-		return fmt.Sprintf("Fatal error: %s", errstr)
+		errstr = fmt.Sprintf("Fatal error: %s", errstr)
+	}
+
+	if len(e.op) > 0 {
+		return fmt.Sprintf("%s: %s", e.op, errstr)
 	}
 
 	return errstr
@@ -117,7 +142,10 @@ func (e Error) IsFatal() bool {
 
 // IsRetriable returns true if the operation that caused this error
 // may be retried.
-// This flag is currently only set by the Transactional producer API.
+// This flag is currently only set by the Transactional producer API,
+// by the consumer's commit and committed offsets APIs for transient
+// group coordinator errors, and by delivery reports for transient
+// errors that librdkafka gave up retrying, see Producer.Produce().
 func (e Error) IsRetriable() bool {
 	return e.retriable
 }
@@ -131,6 +159,51 @@ func (e Error) TxnRequiresAbort() bool {
 	return e.txnRequiresAbort
 }
 
+// Unwrap returns the application error that caused this error, such as
+// the error returned by an OffsetStore, or nil.
+func (e Error) Unwrap() error {
+	return e.cause
+}
+
+// Op returns the client method that returned the error, e.g.,
+// "Consumer.Commit", which is then also prefixed to the error string.
+// Only set if the `go.error.op.enable` configuration property is set,
+// else "".
+// For a method that fails in another client method it calls, the Op is
+// the method called by the application.
+func (e Error) Op() string {
+	return e.op
+}
+
+// Stack returns the goroutine stack captured where the error was returned,
+// as by runtime/debug.Stack(), to locate the failing call.
+// Only set if the `go.error.stack.enable` configuration property is set,
+// else "".
+func (e Error) Stack() string {
+	return e.stack
+}
+
+// withOp sets the Op, see Error.Op(), and with `go.error.stack.enable` the
+// Stack of err if err is an Error and `go.error.op.enable` is set,
+// else err is returned as is.
+func (h *handle) withOp(op string, err error) error {
+	if !h.errorOp || err == nil {
+		return err
+	}
+
+	kerr, ok := err.(Error)
+	if !ok {
+		return err
+	}
+
+	kerr.op = op
+	if h.errorStack && len(kerr.stack) == 0 {
+		kerr.stack = string(debug.Stack())
+	}
+
+	return kerr
+}
+
 // getFatalError returns an Error object if the client instance has raised a fatal error, else nil.
 func getFatalError(H Handle) error {
 	cErrstr := (*C.char)(C.malloc(C.size_t(512)))
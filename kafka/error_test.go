@@ -17,6 +17,7 @@
 package kafka
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -69,3 +70,136 @@ func TestFatalErrorClient(t *testing.T) {
 
 	p.Close()
 }
+
+// TestErrorCodeStrings verifies that error codes map to their librdkafka
+// names, and that the Go client specific codes have their own.
+func TestErrorCodeStrings(t *testing.T) {
+	for code, expected := range map[ErrorCode]string{
+		ErrNoError:                  "Success",
+		ErrTimedOut:                 "Local: Timed out",
+		ErrPartitionEOF:             "Broker: No more messages",
+		ErrUnknownTopicOrPart:       "Broker: Unknown topic or partition",
+		ErrGroupAuthorizationFailed: "Broker: Group authorization failed",
+		ErrFencedInstanceID:         "Broker: Static consumer fenced by other consumer with same group.instance.id",
+		ErrConsumerIdle:             "Local: Consumer idle",
+		ErrRateLimited:              "Local: Rate limited",
+	} {
+		if code.String() != expected {
+			t.Errorf("Expected %d to be \"%s\", got \"%s\"", int(code), expected, code)
+		}
+
+		err := NewError(code, "", false)
+		if err.Code() != code || err.Error() != expected {
+			t.Errorf("Expected Error with code \"%s\" to round-trip, got %v \"%s\"",
+				expected, err.Code(), err)
+		}
+	}
+}
+
+// TestConsumerErrorOp verifies that go.error.op.enable annotates returned
+// errors with the method that returned them, and go.error.stack.enable
+// also with the stack.
+func TestConsumerErrorOp(t *testing.T) {
+	for _, tc := range []struct {
+		conf      ConfigMap
+		wantOp    string
+		wantStack bool
+	}{
+		{ConfigMap{}, "", false},
+		{ConfigMap{"go.error.op.enable": true}, "Consumer.Commit", false},
+		{ConfigMap{"go.error.stack.enable": true}, "Consumer.Commit", true},
+	} {
+		conf := ConfigMap{"group.id": "gotest",
+			"socket.timeout.ms":  10,
+			"session.timeout.ms": 10}
+		for k, v := range tc.conf {
+			conf[k] = v
+		}
+
+		c, err := NewConsumer(&conf)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		// Nothing is assigned, so there is nothing to commit.
+		_, err = c.Commit()
+		c.Close()
+
+		kerr, ok := err.(Error)
+		if !ok {
+			t.Fatalf("%v: Expected Commit() to fail with an Error, got %v", tc.conf, err)
+		}
+
+		if kerr.Code() != ErrNoOffset {
+			t.Errorf("%v: Expected ErrNoOffset, got %v", tc.conf, kerr)
+		}
+
+		if kerr.Op() != tc.wantOp {
+			t.Errorf("%v: Expected Op() \"%s\", got \"%s\"", tc.conf, tc.wantOp, kerr.Op())
+		}
+
+		if tc.wantOp != "" && !strings.HasPrefix(kerr.Error(), tc.wantOp+": ") {
+			t.Errorf("%v: Expected \"%s\" to be prefixed by the op", tc.conf, kerr)
+		}
+
+		if (kerr.Stack() != "") != tc.wantStack {
+			t.Errorf("%v: Expected stack: %v, got \"%s\"", tc.conf, tc.wantStack, kerr.Stack())
+		}
+	}
+}
+
+// TestErrorOpReturnPaths verifies that the Op is set on the errors of
+// Seek() and of CommitTransaction()'s delivery failure check, which are
+// returned before calling into librdkafka.
+func TestErrorOpReturnPaths(t *testing.T) {
+	c, err := NewConsumer(&ConfigMap{"group.id": "gotest",
+		"socket.timeout.ms":  10,
+		"session.timeout.ms": 10,
+		"go.error.op.enable": true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+
+	topic := "gotest"
+	err = c.Seek(TopicPartition{Topic: &topic, Partition: 0}, 0)
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrState || kerr.Op() != "Consumer.Seek" {
+		t.Errorf("Expected Seek() of an unassigned partition to fail with ErrState "+
+			"and Op \"Consumer.Seek\", got %v", err)
+	}
+
+	_, err = c.CommitWithRetry(context.Background(), nil, RetryPolicy{MaxRetries: -1})
+	_, err2 := c.ReconcileOffsets(nil, 0)
+	for _, tc := range []struct {
+		op   string
+		err  error
+		code ErrorCode
+	}{
+		{"Consumer.CommitWithRetry", err, ErrInvalidArg},
+		{"Consumer.ResumeReader", c.ResumeReader(), ErrState},
+		{"Consumer.SubscribeTopicsFrom", c.SubscribeTopicsFrom([]string{topic}, "beginning", 0, nil), ErrInvalidArg},
+		{"Consumer.PauseReader", c.PauseReader(), ErrState},
+		{"Consumer.ReconcileOffsets", err2, ErrTimedOut},
+	} {
+		if kerr, ok := tc.err.(Error); !ok || kerr.Code() != tc.code || kerr.Op() != tc.op {
+			t.Errorf("Expected %s to fail with %v and its Op, got %v", tc.op, tc.code, tc.err)
+		}
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"transactional.id":   "gotest",
+		"go.error.op.enable": true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer p.Close()
+
+	p.txnFailures.add(&Message{TopicPartition: TopicPartition{
+		Topic: &topic, Partition: 0, Error: NewError(ErrMsgSizeTooLarge, "", false)}})
+	err = p.CommitTransaction(context.Background())
+	if kerr, ok := err.(Error); !ok || !kerr.TxnRequiresAbort() ||
+		kerr.Op() != "Producer.CommitTransaction" {
+		t.Errorf("Expected CommitTransaction() to fail with an abortable error "+
+			"and Op \"Producer.CommitTransaction\", got %v", err)
+	}
+}
@@ -19,6 +19,7 @@ package kafka
 import (
 	"fmt"
 	"os"
+	"time"
 	"unsafe"
 )
 
@@ -28,18 +29,9 @@ import (
 #include "glue_rdkafka.h"
 
 
-void chdrs_to_tmphdrs (glue_msg_t *gMsg) {
+void chdrs_list_to_tmphdrs (glue_msg_t *gMsg,
+                            const rd_kafka_headers_t *chdrs) {
     size_t i = 0;
-    const char *name;
-    const void *val;
-    size_t size;
-    rd_kafka_headers_t *chdrs;
-
-    if (rd_kafka_message_headers(gMsg->msg, &chdrs)) {
-        gMsg->tmphdrs = NULL;
-        gMsg->tmphdrsCnt = 0;
-        return;
-    }
 
     gMsg->tmphdrsCnt = rd_kafka_header_cnt(chdrs);
     gMsg->tmphdrs = malloc(sizeof(*gMsg->tmphdrs) * gMsg->tmphdrsCnt);
@@ -51,6 +43,18 @@ void chdrs_to_tmphdrs (glue_msg_t *gMsg) {
         i++;
 }
 
+void chdrs_to_tmphdrs (glue_msg_t *gMsg) {
+    rd_kafka_headers_t *chdrs;
+
+    if (rd_kafka_message_headers(gMsg->msg, &chdrs)) {
+        gMsg->tmphdrs = NULL;
+        gMsg->tmphdrsCnt = 0;
+        return;
+    }
+
+    chdrs_list_to_tmphdrs(gMsg, chdrs);
+}
+
 rd_kafka_event_t *_rk_queue_poll (rd_kafka_queue_t *rkq, int timeoutMs,
                                   rd_kafka_event_type_t *evtype,
                                   glue_msg_t *gMsg,
@@ -134,6 +138,23 @@ func (o OffsetsCommitted) String() string {
 	return fmt.Sprintf("OffsetsCommitted (%v, %v)", o.Error, o.Offsets)
 }
 
+// OffsetReset consumer event: the partition's offset was out of range, or
+// there was no committed offset, and the partition has been reset to
+// ResetOffset.
+// Needs to be explicitly enabled by setting the
+// `go.offset.out.of.range.reset` configuration property.
+type OffsetReset struct {
+	// TopicPartition.Offset is the offset that could not be fetched and
+	// TopicPartition.Error the ErrOffsetOutOfRange or ErrAutoOffsetReset
+	// error.
+	TopicPartition TopicPartition
+	ResetOffset    Offset
+}
+
+func (o OffsetReset) String() string {
+	return fmt.Sprintf("OffsetReset: %s reset to %s", o.TopicPartition, o.ResetOffset)
+}
+
 // OAuthBearerTokenRefresh indicates token refresh is required
 type OAuthBearerTokenRefresh struct {
 	// Config is the value of the sasl.oauthbearer.config property
@@ -160,11 +181,34 @@ func (h *handle) eventPoll(channel chan Event, timeoutMs int, maxEvents int, ter
 	if channel == nil {
 		maxEvents = 1
 	}
+
+	if h.c != nil && h.c.valueReaderEnable {
+		// Invalidate the value of the previously returned message.
+		h.c.releaseValueBuffer()
+	}
 out:
 	for evcnt := 0; evcnt < maxEvents; evcnt++ {
 		var evtype C.rd_kafka_event_type_t
 		var gMsg C.glue_msg_t
 		gMsg.want_hdrs = C.int8_t(bool2cint(h.msgFields.Headers))
+		if h.c != nil && h.c.fetchQueueLimit > 0 {
+			if ev := h.c.checkFetchQueue(); ev != nil {
+				retval = ev
+				timeoutMs = 0
+				if channel == nil {
+					break out
+				}
+				select {
+				case channel <- retval:
+				case <-termChan:
+					retval = nil
+					term = true
+					break out
+				}
+				continue
+			}
+		}
+
 		rkev := C._rk_queue_poll(h.rkq, C.int(timeoutMs), &evtype, &gMsg, prevRkev)
 		prevRkev = rkev
 		timeoutMs = 0
@@ -175,7 +219,28 @@ out:
 		case C.RD_KAFKA_EVENT_FETCH:
 			// Consumer fetch event, new message.
 			// Extracted into temporary gMsg for optimization
-			retval = h.newMessageFromGlueMsg(&gMsg)
+			msg := h.newMessageFromGlueMsg(&gMsg)
+			if h.c != nil && h.c.maxMessageAge > 0 &&
+				msg.TopicPartition.Error == nil && h.c.skipStale(msg) {
+				break
+			}
+			if h.c != nil && h.c.throughput != nil &&
+				msg.TopicPartition.Error == nil {
+				h.c.throughput.add(*msg.TopicPartition.Topic,
+					msg.TopicPartition.Partition, time.Now())
+			}
+			if h.c != nil && h.c.valueReaderEnable &&
+				msg.TopicPartition.Error == nil {
+				// Keep the event, and thus the value buffer,
+				// until the next poll.
+				h.c.retainValueBuffer(msg, gMsg.msg, rkev)
+				prevRkev = nil
+			}
+			if h.c != nil && len(h.c.interceptors) > 0 &&
+				msg.TopicPartition.Error == nil {
+				msg = h.c.interceptConsume(msg)
+			}
+			retval = msg
 
 		case C.RD_KAFKA_EVENT_REBALANCE:
 			// Consumer rebalance event
@@ -196,6 +261,30 @@ out:
 
 				retval = peof
 
+			} else if h.c != nil && h.c.outOfRangeReset != OffsetInvalid &&
+				(cErr == C.RD_KAFKA_RESP_ERR_OFFSET_OUT_OF_RANGE ||
+					cErr == C.RD_KAFKA_RESP_ERR__AUTO_OFFSET_RESET) {
+				err := newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev))
+				crktpar := C.rd_kafka_event_topic_partition(rkev)
+				if crktpar == nil {
+					retval = err
+					break
+				}
+
+				defer C.rd_kafka_topic_partition_destroy(crktpar)
+				var tp TopicPartition
+				setupTopicPartitionFromCrktpar(&tp, crktpar)
+				tp.Error = err
+
+				retval = h.c.resetOutOfRange(tp)
+
+			} else if h.c != nil && h.c.unknownTopicErrors != nil &&
+				isUnknownTopicError(ErrorCode(cErr)) {
+				// Only the first of repeated unknown topic errors
+				// is emitted, as a TopicNotAvailable event.
+				retval = h.c.suppressUnknownTopic(
+					newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev)))
+
 			} else if int(C.rd_kafka_event_error_is_fatal(rkev)) != 0 {
 				// A fatal error has been raised.
 				// Extract the actual error from the client
@@ -209,8 +298,26 @@ out:
 				fatalErr.fatal = true
 				retval = fatalErr
 
+				if h.c != nil && h.c.instanceFencedEvents {
+					if ev := h.c.newInstanceFenced(fatalErr); ev != nil {
+						retval = ev
+					}
+				}
+
 			} else {
-				retval = newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev))
+				err := newErrorFromCString(cErr, C.rd_kafka_event_error_string(rkev))
+				if h.c != nil {
+					h.c.brokerErrors.add(err, time.Now())
+				} else if h.p != nil {
+					h.p.brokerErrors.add(err, time.Now())
+				}
+				retval = err
+
+				if h.c != nil && h.c.partitionErrorEvents {
+					if ev := newPartitionError(rkev, err); ev != nil {
+						retval = ev
+					}
+				}
 			}
 
 		case C.RD_KAFKA_EVENT_STATS:
@@ -240,9 +347,24 @@ out:
 							ch = &cdr.deliveryChan
 						}
 						msg.Opaque = cdr.opaque
+						msg.batchIndex = cdr.batchIndex
 					}
 				}
 
+				if h.p != nil && len(h.p.interceptors) > 0 {
+					h.p.interceptAcknowledgement(msg)
+				}
+
+				if h.p != nil && h.p.deadLetterTopic != "" &&
+					msg.TopicPartition.Error != nil {
+					h.p.produceDeadLetter(msg)
+				}
+
+				if h.p != nil && h.p.transactional &&
+					msg.TopicPartition.Error != nil {
+					h.p.txnFailures.add(msg)
+				}
+
 				if ch == nil && h.fwdDr {
 					ch = &channel
 				}
@@ -275,6 +397,11 @@ out:
 				retval = OffsetsCommitted{nil, offsets}
 			}
 
+			if h.c != nil && len(h.c.interceptors) > 0 {
+				oc := retval.(OffsetsCommitted)
+				h.c.interceptCommit(oc.Offsets, oc.Error)
+			}
+
 		case C.RD_KAFKA_EVENT_OAUTHBEARER_TOKEN_REFRESH:
 			ev := OAuthBearerTokenRefresh{C.GoString(C.rd_kafka_event_config_string(rkev))}
 			retval = ev
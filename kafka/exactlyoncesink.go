@@ -0,0 +1,413 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sinkSeekTimeoutMs is the maximum time an ExactlyOnceSink blocks seeking
+// a partition back to its stored offset.
+const sinkSeekTimeoutMs = 10 * 1000
+
+// TransactionalOffsetStore is an OffsetStore that also stores processing
+// results, in transactions, such as a database, see NewExactlyOnceSink().
+// The ExactlyOnceSink fetches offsets with Fetch() and stores them in
+// transactions, it does not call Commit().
+type TransactionalOffsetStore interface {
+	OffsetStore
+	// Begin starts a store transaction.
+	Begin() (OffsetStoreTxn, error)
+}
+
+// OffsetStoreTxn is a TransactionalOffsetStore transaction, in which the
+// result of processing a message and the consumer's next offset for its
+// partition are stored atomically.
+type OffsetStoreTxn interface {
+	// StoreResult stores the handler's result for msg.
+	StoreResult(msg *Message, result interface{}) error
+	// StoreOffset stores the offset of partition tp.
+	StoreOffset(tp TopicPartition) error
+	// Commit commits the transaction.
+	Commit() error
+	// Abort rolls back the transaction.
+	Abort() error
+}
+
+// SinkHandler processes a message consumed by an ExactlyOnceSink,
+// returning the result to store for it.
+type SinkHandler func(msg *Message) (result interface{}, err error)
+
+// ExactlyOnceSink consumes messages into an external
+// TransactionalOffsetStore with exactly-once semantics: for each message
+// the SinkHandler's result and the message's offset are stored in the
+// same store transaction, and the consumer resumes from the stored offsets, rather than from the offsets
+// committed to Kafka, on assignment, so that each message's result is
+// stored exactly once, even across failures and restarts.
+//
+// The Consumer must be configured with `enable.auto.commit=false`, and
+// must not be used with the Events() channel. Partitions without a stored
+// offset are consumed according to `auto.offset.reset`.
+type ExactlyOnceSink struct {
+	c       *Consumer
+	store   TransactionalOffsetStore
+	handler SinkHandler
+
+	maxHandlerTime time.Duration
+	// Auto-pause state, only accessed from Run(), including the
+	// rebalance callback called from it.
+	paused          bool
+	pending         []*Message // Messages polled while paused
+	inFlight        *Message   // Message being handled while paused
+	inFlightRevoked bool       // inFlight's partition was revoked
+
+	errChan  chan error // First rebalance, or fatal, error
+	termChan chan bool  // Closed to stop Run()
+	stopOnce sync.Once
+}
+
+// NewExactlyOnceSink creates an ExactlyOnceSink consuming from c,
+// processing messages with handler, and storing results and offsets in
+// store.
+func NewExactlyOnceSink(c *Consumer, store TransactionalOffsetStore, handler SinkHandler) *ExactlyOnceSink {
+	return &ExactlyOnceSink{
+		c:        c,
+		store:    store,
+		handler:  handler,
+		errChan:  make(chan error, 1),
+		termChan: make(chan bool),
+	}
+}
+
+// SetMaxHandlerTime sets the time the handler may take processing a
+// message before the sink pauses all assigned partitions and keeps
+// polling the consumer, to stay in the group despite
+// `max.poll.interval.ms`, until the handler returns, after which the
+// partitions are resumed. 0, the default, disables auto-pausing.
+//
+// Messages already fetched when the partitions are paused are processed
+// once the handler returns, in order. If the partition of the message
+// being handled is revoked meanwhile, the handler's result is discarded,
+// rather than stored, since the partition's new owner processes the
+// message again.
+// Must be called before Run().
+func (s *ExactlyOnceSink) SetMaxHandlerTime(d time.Duration) {
+	s.maxHandlerTime = d
+}
+
+// Subscribe subscribes the consumer to topics with the ExactlyOnceSink's
+// rebalance callback, which assigns partitions at their stored offsets.
+// This replaces the current subscription.
+func (s *ExactlyOnceSink) Subscribe(topics []string) error {
+	return s.c.SubscribeTopics(topics, s.rebalance)
+}
+
+// Run consumes and processes messages until Stop() is called, in which
+// case nil is returned.
+//
+// If the handler fails, or the result and offset of a message can't be
+// stored, the store transaction is aborted, the message's partition is
+// sought back to its stored offset and the error is returned. The
+// application may then call Run() again to retry.
+// Errors loading the stored offsets on assignment, in which case the
+// partitions are not assigned, and fatal consumer errors are returned as
+// well, other consumer errors are ignored.
+func (s *ExactlyOnceSink) Run() error {
+	for {
+		select {
+		case <-s.termChan:
+			return nil
+		case err := <-s.errChan:
+			return err
+		default:
+		}
+
+		if len(s.pending) > 0 {
+			msg := s.pending[0]
+			s.pending = s.pending[1:]
+			err := s.processMessage(msg)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch e := s.c.Poll(100).(type) {
+		case *Message:
+			err := s.processMessage(e)
+			if err != nil {
+				return err
+			}
+		case Error:
+			if e.IsFatal() {
+				return e
+			}
+		}
+	}
+}
+
+// Stop stops Run(), once it has finished processing the current message.
+func (s *ExactlyOnceSink) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.termChan)
+	})
+}
+
+// processMessage processes msg and stores its result and offset.
+func (s *ExactlyOnceSink) processMessage(msg *Message) error {
+	result, revoked, err := s.handle(msg)
+	if revoked {
+		return nil
+	}
+	if err != nil {
+		return s.rewind(msg, err)
+	}
+
+	txn, err := s.store.Begin()
+	if err != nil {
+		return s.rewind(msg, err)
+	}
+
+	err = txn.StoreResult(msg, result)
+	if err == nil {
+		err = txn.StoreOffset(TopicPartition{
+			Topic:     msg.TopicPartition.Topic,
+			Partition: msg.TopicPartition.Partition,
+			Offset:    msg.TopicPartition.Offset + 1,
+		})
+	}
+	if err != nil {
+		txn.Abort()
+		return s.rewind(msg, err)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return s.rewind(msg, err)
+	}
+
+	return nil
+}
+
+// handle calls the handler for msg, pausing the assigned partitions and
+// polling the consumer if the handler takes longer than the
+// maxHandlerTime.
+// Returns the handler's result and error, and revoked true if the
+// partition of msg was revoked while paused.
+func (s *ExactlyOnceSink) handle(msg *Message) (result interface{}, revoked bool, err error) {
+	if s.maxHandlerTime <= 0 {
+		result, err = s.handler(msg)
+		return result, false, err
+	}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		result, err = s.handler(msg)
+	}()
+
+	timer := time.NewTimer(s.maxHandlerTime)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return result, false, err
+	case <-timer.C:
+	}
+
+	assignment, aerr := s.c.Assignment()
+	if aerr == nil {
+		aerr = s.c.Pause(assignment)
+	}
+	if aerr != nil {
+		// Can't pause, wait for the handler while blocked.
+		<-done
+		return result, false, err
+	}
+
+	s.paused = true
+	s.inFlight = msg
+	s.inFlightRevoked = false
+
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		default:
+			switch e := s.c.Poll(100).(type) {
+			case *Message:
+				s.pending = append(s.pending, e)
+			case Error:
+				if e.IsFatal() {
+					select {
+					case s.errChan <- e:
+					default:
+					}
+				}
+			}
+		}
+	}
+
+	s.paused = false
+	s.inFlight = nil
+
+	assignment, aerr = s.c.Assignment()
+	if aerr == nil {
+		aerr = s.c.Resume(assignment)
+	}
+	if aerr != nil && err == nil {
+		err = aerr
+	}
+
+	return result, s.inFlightRevoked, err
+}
+
+// dropPending drops the pending messages of partitions, or of all
+// partitions if partitions is nil, which are consumed again from their
+// new positions.
+func (s *ExactlyOnceSink) dropPending(partitions []TopicPartition) {
+	pending := s.pending[:0]
+	for _, msg := range s.pending {
+		if partitions != nil &&
+			!containsPartition(partitions, msg.TopicPartition) {
+			pending = append(pending, msg)
+		}
+	}
+	s.pending = pending
+}
+
+// containsPartition returns true if partitions contains the partition
+// of tp.
+func containsPartition(partitions []TopicPartition, tp TopicPartition) bool {
+	for _, p := range partitions {
+		if p.Partition == tp.Partition && *p.Topic == *tp.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// rewind seeks the partition of msg, which failed with cause, back to its
+// stored offset, which also covers a failed commit that did in fact
+// store the message, or to msg if there is no stored offset.
+// Returns the lookup or seek error, if any, else cause.
+func (s *ExactlyOnceSink) rewind(msg *Message, cause error) error {
+	tp := TopicPartition{
+		Topic:     msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+	}
+
+	stored, err := s.store.Fetch([]TopicPartition{tp})
+	if err != nil {
+		return err
+	}
+
+	tp.Offset = msg.TopicPartition.Offset
+	if len(stored) == 1 && stored[0].Offset >= 0 {
+		tp.Offset = stored[0].Offset
+	}
+
+	err = s.c.Seek(tp, sinkSeekTimeoutMs)
+	if err != nil {
+		return err
+	}
+
+	// The partition's pending messages are consumed again.
+	s.dropPending([]TopicPartition{tp})
+
+	return cause
+}
+
+// rebalance is the ExactlyOnceSink's rebalance callback.
+func (s *ExactlyOnceSink) rebalance(c *Consumer, ev Event) error {
+	cooperative := c.GetRebalanceProtocol() == "COOPERATIVE"
+
+	switch e := ev.(type) {
+	case AssignedPartitions:
+		partitions, err := s.storedOffsets(e.Partitions)
+		if err != nil {
+			select {
+			case s.errChan <- err:
+			default:
+			}
+			// Don't consume the partitions from the wrong
+			// offsets, an empty assignment still completes the
+			// rebalance.
+			partitions = nil
+		}
+
+		if cooperative {
+			err = c.IncrementalAssign(partitions)
+		} else {
+			err = c.Assign(partitions)
+		}
+		if err == nil && s.paused && len(partitions) > 0 {
+			// Resumed along with the rest of the assignment.
+			err = c.Pause(partitions)
+		}
+		return err
+
+	case RevokedPartitions:
+		revoked := e.Partitions
+		if !cooperative {
+			revoked = nil
+		}
+		s.dropPending(revoked)
+		if s.inFlight != nil && (revoked == nil ||
+			containsPartition(revoked, s.inFlight.TopicPartition)) {
+			s.inFlightRevoked = true
+		}
+
+		if cooperative {
+			return c.IncrementalUnassign(e.Partitions)
+		}
+		return c.Unassign()
+	}
+
+	return nil
+}
+
+// storedOffsets returns partitions with their stored offsets, or
+// OffsetInvalid, for the consumer to use `auto.offset.reset`, if there is
+// no stored offset.
+func (s *ExactlyOnceSink) storedOffsets(partitions []TopicPartition) ([]TopicPartition, error) {
+	stored, err := s.store.Fetch(partitions)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) != len(partitions) {
+		return nil, newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("OffsetStore returned %d offsets for %d partitions",
+				len(stored), len(partitions)))
+	}
+
+	assign := make([]TopicPartition, len(partitions))
+	for i, tp := range stored {
+		assign[i] = TopicPartition{
+			Topic:     partitions[i].Topic,
+			Partition: partitions[i].Partition,
+			Offset:    tp.Offset,
+		}
+		if tp.Offset < 0 {
+			assign[i].Offset = OffsetInvalid
+		}
+	}
+
+	return assign, nil
+}
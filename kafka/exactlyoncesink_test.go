@@ -0,0 +1,304 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memOffsetStore is an in-memory TransactionalOffsetStore for testing.
+type memOffsetStore struct {
+	lock    sync.Mutex
+	offsets map[topicPartitionKey]Offset
+	results []string
+	// Number of commits to fail after storing, as if the commit
+	// succeeded but the response was lost.
+	failCommitsAfterStore int
+}
+
+type memOffsetStoreTxn struct {
+	store   *memOffsetStore
+	offsets map[topicPartitionKey]Offset
+	results []string
+}
+
+func (s *memOffsetStore) Commit(offsets []TopicPartition) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, tp := range offsets {
+		s.offsets[topicPartitionKey{*tp.Topic, tp.Partition}] = tp.Offset
+	}
+	return nil
+}
+
+func (s *memOffsetStore) Fetch(partitions []TopicPartition) ([]TopicPartition, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stored := make([]TopicPartition, len(partitions))
+	for i, tp := range partitions {
+		stored[i] = tp
+		offset, found := s.offsets[topicPartitionKey{*tp.Topic, tp.Partition}]
+		if !found {
+			offset = OffsetInvalid
+		}
+		stored[i].Offset = offset
+	}
+	return stored, nil
+}
+
+func (s *memOffsetStore) Begin() (OffsetStoreTxn, error) {
+	return &memOffsetStoreTxn{store: s, offsets: make(map[topicPartitionKey]Offset)}, nil
+}
+
+func (txn *memOffsetStoreTxn) StoreResult(msg *Message, result interface{}) error {
+	txn.results = append(txn.results, result.(string))
+	return nil
+}
+
+func (txn *memOffsetStoreTxn) StoreOffset(tp TopicPartition) error {
+	txn.offsets[topicPartitionKey{*tp.Topic, tp.Partition}] = tp.Offset
+	return nil
+}
+
+func (txn *memOffsetStoreTxn) Commit() error {
+	s := txn.store
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.results = append(s.results, txn.results...)
+	for key, offset := range txn.offsets {
+		s.offsets[key] = offset
+	}
+
+	if s.failCommitsAfterStore > 0 {
+		s.failCommitsAfterStore--
+		return fmt.Errorf("Commit response lost")
+	}
+	return nil
+}
+
+func (txn *memOffsetStoreTxn) Abort() error {
+	return nil
+}
+
+// TestExactlyOnceSink verifies that each message's result is stored exactly
+// once across handler and store failures and a restart.
+func TestExactlyOnceSink(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "exactlyoncesinktopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	store := &memOffsetStore{offsets: make(map[topicPartitionKey]Offset)}
+
+	// Runs a sink, with a new consumer, until expectCnt results have
+	// been stored, retrying Run() on errors.
+	runSink := func(expectCnt int, failAt string) {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":  mc.BootstrapServers(),
+			"group.id":           "exactlyoncesinkgroup",
+			"enable.auto.commit": false,
+			"auto.offset.reset":  "earliest",
+			"session.timeout.ms": 6000})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		defer c.Close()
+
+		var sink *ExactlyOnceSink
+		failed := false
+		sink = NewExactlyOnceSink(c, store, func(msg *Message) (interface{}, error) {
+			value := string(msg.Value)
+			if value == failAt && !failed {
+				failed = true
+				return nil, fmt.Errorf("Handler failed on %s", value)
+			}
+
+			store.lock.Lock()
+			if len(store.results)+1 >= expectCnt {
+				sink.Stop()
+			}
+			store.lock.Unlock()
+
+			return fmt.Sprintf("%d:%s", msg.TopicPartition.Partition, value), nil
+		})
+
+		err = sink.Subscribe([]string{topic})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+
+		for retries := 0; ; retries++ {
+			err = sink.Run()
+			if err == nil {
+				break
+			}
+			if retries > 5 {
+				t.Fatalf("Run: %v", err)
+			}
+		}
+	}
+
+	produce := func(partition int32, values ...string) {
+		p, err := NewProducer(&ConfigMap{"bootstrap.servers": mc.BootstrapServers()})
+		if err != nil {
+			t.Fatalf("NewProducer: %v", err)
+		}
+		defer p.Close()
+
+		deliveryChan := make(chan Event, len(values))
+		for _, v := range values {
+			err = p.Produce(&Message{
+				TopicPartition: TopicPartition{Topic: &topic, Partition: partition},
+				Value:          []byte(v)}, deliveryChan)
+			if err != nil {
+				t.Fatalf("Produce: %v", err)
+			}
+		}
+		for range values {
+			if m := (<-deliveryChan).(*Message); m.TopicPartition.Error != nil {
+				t.Fatalf("Delivery failed: %v", m.TopicPartition.Error)
+			}
+		}
+	}
+
+	expectResults := func(expected ...string) {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		seen := make(map[string]int)
+		for _, r := range store.results {
+			seen[r]++
+		}
+		for _, r := range expected {
+			if seen[r] != 1 {
+				t.Errorf("Expected result %s to be stored once, got %d times (%v)",
+					r, seen[r], store.results)
+			}
+		}
+		if len(store.results) != len(expected) {
+			t.Errorf("Expected %d results, got %v", len(expected), store.results)
+		}
+	}
+
+	produce(0, "a", "b", "c")
+	produce(1, "d", "e")
+
+	// "b" fails in the handler, "d" is committed but reported as failed.
+	store.lock.Lock()
+	store.failCommitsAfterStore = 1
+	store.lock.Unlock()
+	runSink(5, "b")
+	expectResults("0:a", "0:b", "0:c", "1:d", "1:e")
+
+	// A new consumer resumes from the stored offsets.
+	produce(0, "f")
+	produce(1, "g")
+	runSink(7, "")
+	expectResults("0:a", "0:b", "0:c", "1:d", "1:e", "0:f", "1:g")
+}
+
+// TestExactlyOnceSinkMaxHandlerTime verifies that a handler exceeding
+// max.poll.interval.ms doesn't get the consumer kicked out of the group
+// with auto-pausing, and that messages fetched meanwhile are processed.
+func TestExactlyOnceSinkMaxHandlerTime(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "exactlyoncesinkpausetopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 3)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":    mc.BootstrapServers(),
+		"group.id":             "exactlyoncesinkpausegroup",
+		"enable.auto.commit":   false,
+		"auto.offset.reset":    "earliest",
+		"session.timeout.ms":   6000,
+		"max.poll.interval.ms": 6000})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	store := &memOffsetStore{offsets: make(map[topicPartitionKey]Offset)}
+
+	var sink *ExactlyOnceSink
+	calls := 0
+	sink = NewExactlyOnceSink(c, store, func(msg *Message) (interface{}, error) {
+		calls++
+		value := string(msg.Value)
+		switch value {
+		case "value0":
+			// Exceeds max.poll.interval.ms.
+			time.Sleep(8 * time.Second)
+		case "value2":
+			sink.Stop()
+		}
+		return value, nil
+	})
+	sink.SetMaxHandlerTime(500 * time.Millisecond)
+
+	revokes := 0
+	err = c.SubscribeTopics([]string{topic}, func(c *Consumer, ev Event) error {
+		if _, ok := ev.(RevokedPartitions); ok {
+			revokes++
+		}
+		return sink.rebalance(c, ev)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	err = sink.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if revokes != 0 {
+		t.Errorf("Expected no revocations, got %d", revokes)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 handler calls, got %d", calls)
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if len(store.results) != 3 {
+		t.Errorf("Expected 3 results, got %v", store.results)
+	}
+	if offset := store.offsets[topicPartitionKey{topic, 0}]; offset != 3 {
+		t.Errorf("Expected stored offset 3, got %v", offset)
+	}
+}
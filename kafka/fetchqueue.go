@@ -0,0 +1,80 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FetchQueueFull indicates that the consumer queue has reached the
+// `queued.min.messages` threshold, at which librdkafka stops fetching
+// until the application has consumed enough messages, typically because
+// the application is not polling fast enough to keep up.
+// Needs to be explicitly enabled by setting the
+// `go.fetch.queue.full.event.enable` configuration property.
+//
+// The event is emitted when the threshold is reached and again only once
+// the queue has been drained below half the threshold.
+// The `queued.max.messages.kbytes` threshold is not observable from the
+// application: use the per-partition `fetchq_size` of the Stats event to
+// monitor it.
+type FetchQueueFull struct {
+	// QueueLength is the number of messages and events in the consumer queue.
+	QueueLength int
+	// Limit is the `queued.min.messages` threshold.
+	Limit int
+}
+
+func (e FetchQueueFull) String() string {
+	return fmt.Sprintf("FetchQueueFull: %d messages queued (limit %d)",
+		e.QueueLength, e.Limit)
+}
+
+// setupFetchQueueLimit reads the `queued.min.messages` threshold for
+// `go.fetch.queue.full.event.enable` from the librdkafka configuration.
+func (c *Consumer) setupFetchQueueLimit() {
+	v, err := c.handle.getConfigValue("queued.min.messages")
+	if err != nil {
+		// Shouldn't happen, queued.min.messages is always set.
+		return
+	}
+
+	c.fetchQueueLimit, _ = strconv.Atoi(v)
+}
+
+// checkFetchQueue returns a FetchQueueFull event if the consumer queue
+// has reached the fetch queue threshold since the last event, else nil.
+//
+// Called from the poll path only.
+func (c *Consumer) checkFetchQueue() Event {
+	qlen := c.handle.queueLength()
+
+	if c.fetchQueueFull {
+		if qlen < c.fetchQueueLimit/2 {
+			c.fetchQueueFull = false
+		}
+		return nil
+	}
+
+	if qlen < c.fetchQueueLimit {
+		return nil
+	}
+
+	c.fetchQueueFull = true
+	return FetchQueueFull{QueueLength: qlen, Limit: c.fetchQueueLimit}
+}
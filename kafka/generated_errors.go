@@ -12,6 +12,9 @@ type ErrorCode int
 
 // String returns a human readable representation of an error code
 func (c ErrorCode) String() string {
+      if str, ok := goErrorCodeStrings[c]; ok {
+            return str
+      }
       return C.GoString(C.rd_kafka_err2str(C.rd_kafka_resp_err_t(c)))
 }
 
@@ -0,0 +1,203 @@
+/**
+ * Copyright 2016 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+/*
+#include <stdlib.h>
+#include "select_rdkafka.h"
+
+struct rd_kafka_group_info *_getGroupList_group_element(const struct rd_kafka_group_list *gl, int i) {
+  return &gl->groups[i];
+}
+
+struct rd_kafka_group_member_info *_getGroupList_member_element(struct rd_kafka_group_info *g, int i) {
+  return &g->members[i];
+}
+*/
+import "C"
+
+// GroupMember describes a member of a consumer group and the partitions
+// assigned to it by the group.
+type GroupMember struct {
+	// MemberID is the member id assigned by the group coordinator.
+	MemberID string
+	// ClientID is the member's client.id.
+	ClientID string
+	// Host is the member's host, as seen by the group coordinator.
+	Host string
+	// Assignment is the member's partition assignment, which is empty
+	// while the group is rebalancing.
+	Assignment []TopicPartition
+}
+
+// String returns a human readable representation of a GroupMember
+func (m GroupMember) String() string {
+	return fmt.Sprintf("%s (client.id %s, host %s): %v",
+		m.MemberID, m.ClientID, m.Host, m.Assignment)
+}
+
+// parseMemberAssignment parses a consumer protocol member assignment,
+// returning its partitions.
+//
+// The assignment is serialized as:
+//
+//	Version          int16
+//	Topics           array of:
+//	  Topic          string (int16 length prefixed)
+//	  Partitions     array of int32
+//	UserData         bytes
+//
+// where arrays are prefixed by their int32 element count.
+func parseMemberAssignment(b []byte) ([]TopicPartition, error) {
+	var partitions []TopicPartition
+
+	errTruncated := newErrorFromString(ErrBadMsg,
+		"Truncated consumer group member assignment")
+
+	readInt16 := func() (int16, bool) {
+		if len(b) < 2 {
+			return 0, false
+		}
+		v := int16(binary.BigEndian.Uint16(b))
+		b = b[2:]
+		return v, true
+	}
+	readInt32 := func() (int32, bool) {
+		if len(b) < 4 {
+			return 0, false
+		}
+		v := int32(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		return v, true
+	}
+
+	if len(b) == 0 {
+		// No assignment, e.g., while rebalancing.
+		return nil, nil
+	}
+
+	if _, ok := readInt16(); !ok {
+		return nil, errTruncated
+	}
+
+	topicCnt, ok := readInt32()
+	if !ok {
+		return nil, errTruncated
+	}
+
+	for i := int32(0); i < topicCnt; i++ {
+		topicLen, ok := readInt16()
+		if !ok || topicLen < 0 || len(b) < int(topicLen) {
+			return nil, errTruncated
+		}
+		topic := string(b[:topicLen])
+		b = b[topicLen:]
+
+		partitionCnt, ok := readInt32()
+		if !ok {
+			return nil, errTruncated
+		}
+
+		for j := int32(0); j < partitionCnt; j++ {
+			partition, ok := readInt32()
+			if !ok {
+				return nil, errTruncated
+			}
+			partitions = append(partitions, TopicPartition{
+				Topic:     &topic,
+				Partition: partition,
+				Offset:    OffsetInvalid,
+			})
+		}
+	}
+
+	return partitions, nil
+}
+
+// GroupMembers returns the current members of the consumer's group, as
+// described by the group coordinator, and the partitions assigned to each.
+//
+// This allows members to coordinate among themselves, e.g., by electing
+// the member with the lowest member id as leader for a shared resource.
+// The member list reflects the latest completed rebalance; the
+// assignments are empty while the group is rebalancing.
+// Compare GroupMember.MemberID with GetConsumerGroupMetadata() to
+// identify this consumer in the list.
+//
+// Requires `group.id` to be configured.
+func (c *Consumer) GroupMembers(timeoutMs int) ([]GroupMember, error) {
+	group, err := c.handle.getConfigValue("group.id")
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"group.id must be configured")
+	}
+
+	cGroup := C.CString(group)
+	defer C.free(unsafe.Pointer(cGroup))
+
+	var cGroupList *C.struct_rd_kafka_group_list
+	cErr := C.rd_kafka_list_groups(c.handle.rk, cGroup, &cGroupList,
+		C.int(timeoutMs))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return nil, newError(cErr)
+	}
+	defer C.rd_kafka_group_list_destroy(cGroupList)
+
+	var members []GroupMember
+	for i := 0; i < int(cGroupList.group_cnt); i++ {
+		g := C._getGroupList_group_element(cGroupList, C.int(i))
+		if C.GoString(g.group) != group {
+			continue
+		}
+		if g.err != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+			return nil, newError(g.err)
+		}
+
+		isConsumer := C.GoString(g.protocol_type) == "consumer"
+
+		for j := 0; j < int(g.member_cnt); j++ {
+			m := C._getGroupList_member_element(g, C.int(j))
+			member := GroupMember{
+				MemberID: C.GoString(m.member_id),
+				ClientID: C.GoString(m.client_id),
+				Host:     C.GoString(m.client_host),
+			}
+
+			if isConsumer && m.member_assignment_size > 0 {
+				member.Assignment, err = parseMemberAssignment(
+					C.GoBytes(m.member_assignment,
+						C.int(m.member_assignment_size)))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
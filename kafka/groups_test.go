@@ -0,0 +1,71 @@
+/**
+ * Copyright 2016 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"testing"
+)
+
+// TestParseMemberAssignment verifies parsing of a serialized consumer
+// protocol member assignment.
+func TestParseMemberAssignment(t *testing.T) {
+	b := []byte{
+		0, 1, // Version
+		0, 0, 0, 2, // Topic count
+		0, 2, 't', '1', // Topic
+		0, 0, 0, 2, // Partition count
+		0, 0, 0, 0,
+		0, 0, 0, 3,
+		0, 2, 't', '2', // Topic
+		0, 0, 0, 1, // Partition count
+		0, 0, 0, 7,
+		0, 0, 0, 0, // UserData
+	}
+
+	partitions, err := parseMemberAssignment(b)
+	if err != nil {
+		t.Fatalf("parseMemberAssignment: %v", err)
+	}
+
+	expected := []struct {
+		topic     string
+		partition int32
+	}{{"t1", 0}, {"t1", 3}, {"t2", 7}}
+	if len(partitions) != len(expected) {
+		t.Fatalf("Expected %d partitions, got %v", len(expected), partitions)
+	}
+	for i, tp := range partitions {
+		if *tp.Topic != expected[i].topic ||
+			tp.Partition != expected[i].partition {
+			t.Errorf("Expected %s [%d], got %v",
+				expected[i].topic, expected[i].partition, tp)
+		}
+	}
+
+	partitions, err = parseMemberAssignment(nil)
+	if err != nil || len(partitions) != 0 {
+		t.Errorf("Expected empty assignment, got %v, %v", partitions, err)
+	}
+
+	for _, n := range []int{1, 4, 9, 16} {
+		_, err = parseMemberAssignment(b[:n])
+		if err == nil || err.(Error).Code() != ErrBadMsg {
+			t.Errorf("Expected ErrBadMsg for assignment truncated at %d, got %v",
+				n, err)
+		}
+	}
+}
@@ -75,6 +75,19 @@ type Handle interface {
 	// authentication mechanism.
 	SetOAuthBearerTokenFailure(errstr string) error
 
+	// CPtr returns the underlying librdkafka `rd_kafka_t` instance handle
+	// as an unsafe.Pointer, for calling librdkafka functions that are
+	// not wrapped by this client through cgo.
+	//
+	// WARNING: This is an unsafe advanced escape hatch. The pointer
+	// is only valid until the client is closed, must not be destroyed
+	// and must not be used to change state that this client manages,
+	// such as the event queues, callbacks, assignment or transactions.
+	// The librdkafka API available depends on the librdkafka version
+	// this client is linked with and is not covered by this client's
+	// compatibility guarantees.
+	CPtr() unsafe.Pointer
+
 	// gethandle() returns the internal handle struct pointer
 	gethandle() *handle
 }
@@ -99,6 +112,9 @@ type handle struct {
 	// Cached instance name to avoid CGo call in String()
 	name string
 
+	// Last latency of the blocking broker operations
+	latencies operationLatencies
+
 	//
 	// cgo map
 	// Maps C callbacks based on cgoid back to its Go object
@@ -122,8 +138,17 @@ type handle struct {
 	//
 	c *Consumer
 
+	// Last rebalance reason logged by librdkafka, see
+	// Consumer.LastRebalanceReason().
+	rebalanceReasonLock sync.Mutex
+	rebalanceReason     string
+
 	// WaitGroup to wait for spawned go-routines to finish.
 	waitGroup sync.WaitGroup
+
+	// Config settings, annotate returned errors with their Op and Stack.
+	errorOp    bool
+	errorStack bool
 }
 
 func (h *handle) String() string {
@@ -140,6 +165,41 @@ func (h *handle) setup() {
 	}
 }
 
+// getConfigValue returns the effective value, including the default, of
+// the librdkafka configuration property name for this client instance.
+// Go client specific `go.*` properties are not available.
+func (h *handle) getConfigValue(name string) (string, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	cConf := C.rd_kafka_conf(h.rk)
+
+	var cSize C.size_t
+	if C.rd_kafka_conf_get(cConf, cName, nil, &cSize) != C.RD_KAFKA_CONF_OK {
+		return "", newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Unknown configuration property %s", name))
+	}
+
+	cValue := (*C.char)(C.malloc(cSize))
+	defer C.free(unsafe.Pointer(cValue))
+
+	if C.rd_kafka_conf_get(cConf, cName, cValue, &cSize) != C.RD_KAFKA_CONF_OK {
+		return "", newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Unknown configuration property %s", name))
+	}
+
+	return C.GoString(cValue), nil
+}
+
+// queueLength returns the number of events, including messages,
+// on the handle's event queue.
+func (h *handle) queueLength() int {
+	if h.rkq == nil {
+		return 0
+	}
+	return int(C.rd_kafka_queue_length(h.rkq))
+}
+
 func (h *handle) cleanup() {
 	if h.logs != nil {
 		C.rd_kafka_queue_destroy(h.logq)
@@ -206,6 +266,42 @@ func (h *handle) getRkt0(topic string, ctopic *C.char, doLock bool) (crkt *C.rd_
 	return crkt
 }
 
+// newRktWithConfig creates a C topic_t object for topic with the
+// default topic configuration overridden by conf, and adds it to the
+// local cache.
+// Returns ErrState if the topic is already in the cache, since the
+// configuration of a C topic_t object can't be changed once created.
+func (h *handle) newRktWithConfig(topic string, conf ConfigMap) error {
+	h.rktCacheLock.Lock()
+	defer h.rktCacheLock.Unlock()
+
+	if _, found := h.rktCache[topic]; found {
+		return newErrorFromString(ErrState,
+			fmt.Sprintf("Topic \"%s\" is already in use, its configuration can no longer be changed", topic))
+	}
+
+	cTopicConf := C.rd_kafka_default_topic_conf_dup(h.rk)
+	err := configConvertAnyconf(conf, (*rdkTopicConf)(cTopicConf))
+	if err != nil {
+		C.rd_kafka_topic_conf_destroy(cTopicConf)
+		return err
+	}
+
+	ctopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(ctopic))
+
+	// rd_kafka_topic_new() takes ownership of the topic conf.
+	crkt := C.rd_kafka_topic_new(h.rk, ctopic, cTopicConf)
+	if crkt == nil {
+		return newError(C.rd_kafka_last_error())
+	}
+
+	h.rktCache[topic] = crkt
+	h.rktNameCache[crkt] = topic
+
+	return nil
+}
+
 // getRkt finds or creates and returns a C topic_t object from the local cache.
 func (h *handle) getRkt(topic string) (crkt *C.rd_kafka_topic_t) {
 	return h.getRkt0(topic, nil, true)
@@ -243,6 +339,7 @@ type cgoif interface{}
 type cgoDr struct {
 	deliveryChan chan Event
 	opaque       interface{}
+	batchIndex   int
 }
 
 // cgoPut adds object cg to the handle's cgo map and returns a
@@ -317,6 +414,11 @@ func (h *handle) setOAuthBearerToken(oauthBearerToken OAuthBearerToken) error {
 	return newErrorFromCString(cErr, cErrstr)
 }
 
+// cPtr returns the rd_kafka_t instance handle, see Handle.CPtr()
+func (h *handle) cPtr() unsafe.Pointer {
+	return unsafe.Pointer(h.rk)
+}
+
 // setOauthBearerTokenFailure - see rd_kafka_oauthbearer_set_token_failure()
 func (h *handle) setOAuthBearerTokenFailure(errstr string) error {
 	cerrstr := C.CString(errstr)
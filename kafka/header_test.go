@@ -17,6 +17,8 @@
 package kafka
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 )
 
@@ -39,3 +41,69 @@ func TestHeader(t *testing.T) {
 	}
 
 }
+
+// testHeaders returns cnt headers with distinct keys and values
+func testHeaders(cnt int) []Header {
+	hdrs := make([]Header, cnt)
+	for i := range hdrs {
+		hdrs[i] = Header{fmt.Sprintf("header%d", i), []byte(fmt.Sprintf("value%d", i))}
+	}
+	return hdrs
+}
+
+// TestHeaderExtraction verifies that headers are extracted from C headers
+// intact, including nil and empty values.
+func TestHeaderExtraction(t *testing.T) {
+	hdrs := append(testHeaders(5),
+		Header{"nil", nil},
+		Header{"empty", []byte{}},
+		Header{"header0", []byte("duplicate key")})
+
+	chdrs := newCHeaders(hdrs)
+	defer destroyCHeaders(chdrs)
+
+	extracted := headersFromCHeaders(chdrs)
+	if len(extracted) != len(hdrs) {
+		t.Fatalf("Expected %d headers, got %v", len(hdrs), extracted)
+	}
+
+	for i, hdr := range extracted {
+		if hdr.Key != hdrs[i].Key || !bytes.Equal(hdr.Value, hdrs[i].Value) ||
+			(hdr.Value == nil) != (hdrs[i].Value == nil) {
+			t.Errorf("Header #%d: expected %v, got %v", i, hdrs[i], hdr)
+		}
+	}
+
+	// Appending to a value must not overwrite the next value.
+	_ = append(extracted[0].Value, 'x')
+	if string(extracted[1].Value) != "value1" {
+		t.Errorf("Header values overlap: %v", extracted)
+	}
+}
+
+// BenchmarkHeaderExtraction measures extraction of 50 headers per message
+// with a single cgo call, as done for consumed messages.
+func BenchmarkHeaderExtraction(b *testing.B) {
+	chdrs := newCHeaders(testHeaders(50))
+	defer destroyCHeaders(chdrs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		headersFromCHeaders(chdrs)
+	}
+}
+
+// BenchmarkHeaderExtractionPerHeader measures extraction of 50 headers per
+// message with one cgo call per header, for comparison with
+// BenchmarkHeaderExtraction.
+func BenchmarkHeaderExtractionPerHeader(b *testing.B) {
+	chdrs := newCHeaders(testHeaders(50))
+	defer destroyCHeaders(chdrs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		headersFromCHeadersPerHeader(chdrs)
+	}
+}
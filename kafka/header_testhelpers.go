@@ -0,0 +1,84 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"unsafe"
+)
+
+/*
+#include <stdlib.h>
+#include "select_rdkafka.h"
+#include "glue_rdkafka.h"
+
+// Defined in event.go
+void chdrs_list_to_tmphdrs (glue_msg_t *gMsg,
+                            const rd_kafka_headers_t *chdrs);
+*/
+import "C"
+
+// Header helpers for the header extraction tests and benchmarks,
+// which can't use cgo themselves.
+
+// newCHeaders creates a C header list from hdrs,
+// free it with destroyCHeaders().
+func newCHeaders(hdrs []Header) *C.rd_kafka_headers_t {
+	chdrs := C.rd_kafka_headers_new(C.size_t(len(hdrs)))
+	for _, hdr := range hdrs {
+		var cVal unsafe.Pointer
+		if hdr.Value != nil {
+			cVal = C.CBytes(hdr.Value)
+		}
+		cKey := C.CString(hdr.Key)
+		C.rd_kafka_header_add(chdrs, cKey, -1, cVal, C.ssize_t(len(hdr.Value)))
+		C.free(cVal)
+		C.free(unsafe.Pointer(cKey))
+	}
+	return chdrs
+}
+
+// destroyCHeaders frees a C header list created with newCHeaders().
+func destroyCHeaders(chdrs *C.rd_kafka_headers_t) {
+	C.rd_kafka_headers_destroy(chdrs)
+}
+
+// headersFromCHeaders extracts chdrs the way consumed message headers
+// are extracted, with a single cgo call for all headers.
+func headersFromCHeaders(chdrs *C.rd_kafka_headers_t) []Header {
+	var gMsg C.glue_msg_t
+	var msg Message
+	C.chdrs_list_to_tmphdrs(&gMsg, chdrs)
+	setupHeadersFromGlueMsg(&msg, &gMsg)
+	return msg.Headers
+}
+
+// headersFromCHeadersPerHeader extracts chdrs with one cgo call per
+// header, for comparison with headersFromCHeaders().
+func headersFromCHeadersPerHeader(chdrs *C.rd_kafka_headers_t) []Header {
+	hdrs := make([]Header, int(C.rd_kafka_header_cnt(chdrs)))
+	for n := range hdrs {
+		var cKey *C.char
+		var cVal unsafe.Pointer
+		var cSize C.size_t
+		C.rd_kafka_header_get_all(chdrs, C.size_t(n), &cKey, &cVal, &cSize)
+		hdrs[n].Key = C.GoString(cKey)
+		if cVal != nil {
+			hdrs[n].Value = C.GoBytes(cVal, C.int(cSize))
+		}
+	}
+	return hdrs
+}
@@ -0,0 +1,116 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// healthCheckDefaultTimeout bounds HealthCheck() for contexts without a
+// deadline.
+const healthCheckDefaultTimeout = 10 * time.Second
+
+// healthCheckErrorWindow is how far back, from the start of a failed
+// HealthCheck(), broker errors are considered to explain the failure.
+// librdkafka reports persisting connection errors on every reconnect.
+const healthCheckErrorWindow = 30 * time.Second
+
+// healthCheckErrorWait is the maximum time a failed HealthCheck() waits
+// for the broker error explaining the failure to be reported, since the
+// error events are served asynchronously by the producer.
+const healthCheckErrorWait = 200 * time.Millisecond
+
+// HealthCheck verifies that the producer can reach the cluster and is
+// authenticated, by performing a metadata request for the locally known
+// topics, e.g., for a readiness probe: a producer can enqueue messages
+// while it can't reach the cluster.
+//
+// Returns nil if the cluster responded, else an Error with the code:
+//
+//	ErrAuthentication - a broker failed authentication, also for SSL
+//	                    handshake and SASL authentication failures.
+//	ErrAllBrokersDown - no broker could be connected to, e.g., because of
+//	                    connection failures or unresolvable host names.
+//	ErrTimedOut - the cluster did not respond before the ctx deadline,
+//	              or ctx was cancelled.
+//
+// The metadata request is bounded by ctx's deadline, or by 10 seconds if
+// ctx has no deadline.
+func (p *Producer) HealthCheck(ctx context.Context) error {
+	start := time.Now()
+
+	checkTimeout := healthCheckDefaultTimeout
+	if remaining, ok := timeout(ctx); ok {
+		checkTimeout = remaining
+	}
+	if checkTimeout <= 0 || ctx.Err() != nil {
+		return newErrorFromString(ErrTimedOut, "Health check timed out")
+	}
+
+	_, err := p.GetMetadata(nil, false, int(checkTimeout/time.Millisecond))
+	if err == nil {
+		return nil
+	}
+
+	for waitUntil := time.Now().Add(healthCheckErrorWait); ; {
+		if classified, ok := p.classifyHealthCheckError(start); ok {
+			return classified
+		}
+		if ctx.Err() != nil || !time.Now().Before(waitUntil) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err.(Error).Code() == ErrTimedOut {
+		return newErrorFromString(ErrTimedOut, "Health check timed out")
+	}
+
+	return newErrorFromString(ErrAllBrokersDown,
+		fmt.Sprintf("Cluster unreachable: %v", err))
+}
+
+// classifyHealthCheckError returns the health check error explained by the
+// broker errors reported since healthCheckErrorWindow before start, with
+// authentication failures taking precedence over connection failures.
+func (p *Producer) classifyHealthCheckError(start time.Time) (error, bool) {
+	var unreachable *BrokerError
+
+	for _, be := range p.brokerErrors.get() {
+		if be.Time.Before(start.Add(-healthCheckErrorWindow)) {
+			continue
+		}
+
+		be := be
+		switch be.Error.Code() {
+		case ErrAuthentication, ErrSsl, ErrSaslAuthenticationFailed:
+			return newErrorFromString(ErrAuthentication,
+				fmt.Sprintf("Authentication failed: %v", be.Error)), true
+		case ErrTransport, ErrResolve, ErrAllBrokersDown:
+			unreachable = &be
+		}
+	}
+
+	if unreachable != nil {
+		return newErrorFromString(ErrAllBrokersDown,
+			fmt.Sprintf("Cluster unreachable: %v", unreachable.Error)), true
+	}
+
+	return nil, false
+}
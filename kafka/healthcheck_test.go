@@ -0,0 +1,90 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestProducerHealthCheck verifies the HealthCheck() error classification
+// against a mock cluster.
+func TestProducerHealthCheck(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	newProducer := func(conf ConfigMap) *Producer {
+		conf["bootstrap.servers"] = mc.BootstrapServers()
+		p, err := NewProducer(&conf)
+		if err != nil {
+			t.Fatalf("NewProducer: %v", err)
+		}
+		return p
+	}
+
+	healthCheck := func(p *Producer, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return p.HealthCheck(ctx)
+	}
+
+	expectCode := func(what string, err error, code ErrorCode) {
+		if err == nil || err.(Error).Code() != code {
+			t.Errorf("%s: expected %v, got %v", what, code, err)
+		}
+	}
+
+	p := newProducer(ConfigMap{})
+	defer p.Close()
+
+	err = healthCheck(p, 5*time.Second)
+	if err != nil {
+		t.Errorf("Expected healthy producer, got %v", err)
+	}
+
+	// Contexts without a deadline are bounded by the default timeout.
+	err = p.HealthCheck(context.Background())
+	if err != nil {
+		t.Errorf("Expected healthy producer without a deadline, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expectCode("Cancelled", p.HealthCheck(ctx), ErrTimedOut)
+
+	// The mock cluster does not support SASL.
+	saslP := newProducer(ConfigMap{
+		"security.protocol": "sasl_plaintext",
+		"sasl.mechanisms":   "PLAIN",
+		"sasl.username":     "user",
+		"sasl.password":     "password"})
+	defer saslP.Close()
+
+	expectCode("Authentication", healthCheck(saslP, 5*time.Second), ErrAuthentication)
+
+	err = mc.SetBrokerDown(1)
+	if err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	defer mc.SetBrokerUp(1)
+
+	expectCode("Unreachable", healthCheck(p, 2*time.Second), ErrAllBrokersDown)
+}
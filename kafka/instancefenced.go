@@ -0,0 +1,52 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+)
+
+// InstanceFenced is emitted when this consumer's static group membership,
+// see `group.instance.id`, has been fenced by another consumer with the
+// same `group.instance.id`, a fatal error: the consumer is no longer
+// usable and, since retrying would fence the other instance in turn, the
+// duplicate process should typically be terminated rather than restarted.
+// Needs to be explicitly enabled by setting the
+// `go.instance.fenced.event.enable` configuration property, else the
+// fence is emitted as a fatal Error with code ErrFencedInstanceID.
+type InstanceFenced struct {
+	// GroupInstanceID is the fenced `group.instance.id`.
+	GroupInstanceID string
+	// Error is the fatal ErrFencedInstanceID error.
+	Error Error
+}
+
+func (e InstanceFenced) String() string {
+	return fmt.Sprintf("InstanceFenced: %s: %v", e.GroupInstanceID, e.Error)
+}
+
+// newInstanceFenced returns an InstanceFenced event for the fatal error
+// err if it is ErrFencedInstanceID, else nil.
+func (c *Consumer) newInstanceFenced(err Error) Event {
+	if err.Code() != ErrFencedInstanceID {
+		return nil
+	}
+
+	instanceID, _ := c.handle.getConfigValue("group.instance.id")
+
+	return InstanceFenced{GroupInstanceID: instanceID, Error: err}
+}
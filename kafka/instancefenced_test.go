@@ -0,0 +1,94 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// heartbeatAPIKey is the Kafka protocol request type of Heartbeat.
+const heartbeatAPIKey = 12
+
+// TestConsumerInstanceFenced verifies that the fencing of a static member
+// is emitted as an InstanceFenced event.
+func TestConsumerInstanceFenced(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "instancefencedtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":               mc.BootstrapServers(),
+		"group.id":                        "instancefencedgroup",
+		"group.instance.id":               "instance-1",
+		"session.timeout.ms":              6000,
+		"heartbeat.interval.ms":           500,
+		"go.instance.fenced.event.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	assigned := false
+	err = c.Subscribe(topic, func(c *Consumer, ev Event) error {
+		_, assigned = ev.(AssignedPartitions)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	var fenced *InstanceFenced
+	tEnd := time.Now().Add(30 * time.Second)
+	for fenced == nil && time.Now().Before(tEnd) {
+		ev := c.Poll(100)
+		if assigned {
+			// The mock cluster does not detect duplicate instances,
+			// fence this one from the group coordinator's next
+			// Heartbeat response instead.
+			mc.SetRoundtripError(heartbeatAPIKey, ErrFencedInstanceID)
+			assigned = false
+		}
+
+		switch e := ev.(type) {
+		case InstanceFenced:
+			fenced = &e
+		case Error:
+			if e.IsFatal() {
+				t.Fatalf("Expected InstanceFenced, got fatal Error %v", e)
+			}
+		}
+	}
+
+	if fenced == nil {
+		t.Fatalf("Timed out waiting for InstanceFenced")
+	}
+	if fenced.GroupInstanceID != "instance-1" {
+		t.Errorf("Expected group.instance.id instance-1, got %s", fenced.GroupInstanceID)
+	}
+	if fenced.Error.Code() != ErrFencedInstanceID || !fenced.Error.IsFatal() {
+		t.Errorf("Expected a fatal ErrFencedInstanceID error, got %v", fenced.Error)
+	}
+}
@@ -1074,6 +1074,103 @@ outer:
 	c.Close()
 }
 
+// TestProducerConsumerTimestampTypes verifies that the consumed
+// TimestampType follows the topic's message.timestamp.type, and that the
+// produced timestamp is only kept for CreateTime topics.
+func TestProducerConsumerTimestampTypes(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	rand.Seed(time.Now().Unix())
+
+	a := createAdminClient(t)
+	defer a.Close()
+
+	conf := ConfigMap{"bootstrap.servers": testconf.Brokers}
+	conf.updateFromTestconf()
+
+	p, err := NewProducer(&conf)
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	/* Offset the timestamp to avoid comparison with system clock */
+	timestamp := time.Now().Add(87658 * time.Hour).Truncate(time.Millisecond)
+
+	for _, tsType := range []TimestampType{TimestampCreateTime, TimestampLogAppendTime} {
+		topic := fmt.Sprintf("%s-%s-%d", testconf.Topic, tsType, rand.Intn(100000))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		result, err := a.CreateTopics(ctx, []TopicSpecification{{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+			Config:            map[string]string{"message.timestamp.type": tsType.String()},
+		}})
+		if err != nil {
+			t.Fatalf("CreateTopics: %v", err)
+		}
+		if result[0].Error.Code() != ErrNoError {
+			t.Fatalf("Failed to create topic %s: %s", topic, result[0].Error)
+		}
+		defer a.DeleteTopics(context.Background(), []string{topic})
+
+		err = waitTopicInMetadata(a, topic, 10*1000)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		drChan := make(chan Event, 1)
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte(tsType.String()),
+			Timestamp:      timestamp},
+			drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+		dr := (<-drChan).(*Message)
+		if dr.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", dr.TopicPartition)
+		}
+
+		consumerConf := ConfigMap{"bootstrap.servers": testconf.Brokers,
+			"group.id": topic}
+		consumerConf.updateFromTestconf()
+
+		c, err := NewConsumer(&consumerConf)
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		defer c.Close()
+
+		err = c.Assign([]TopicPartition{dr.TopicPartition})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+
+		m, err := c.ReadMessage(10 * time.Second)
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		t.Logf("%s topic: consumed %v with timestamp %s %s",
+			tsType, m, m.TimestampType, m.Timestamp)
+
+		if m.TimestampType != tsType {
+			t.Errorf("%s topic: Expected TimestampType %s, got %s",
+				tsType, tsType, m.TimestampType)
+		}
+
+		if m.Timestamp.Equal(timestamp) != (tsType == TimestampCreateTime) {
+			t.Errorf("%s topic: Unexpected timestamp %v, produced %v",
+				tsType, m.Timestamp, timestamp)
+		}
+	}
+}
+
 // TestProducerConsumerHeaders produces messages with headers
 // and verifies them on consumption.
 // Requires librdkafka >=0.11.4 and Kafka >=0.11.0.0
@@ -1654,3 +1751,204 @@ func TestAdminClient_ControllerID(t *testing.T) {
 
 	t.Logf("ControllerID: %d\n", controllerID)
 }
+
+// Test AdminClient DeleteConsumerGroupOffsets.
+func TestAdminDeleteConsumerGroupOffsets(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"group.id": fmt.Sprintf("go.test.deloffsets.%d", rand.Intn(1000000))}
+	if err := config.updateFromTestconf(); err != nil {
+		t.Fatalf("Failed to update test configuration: %s\n", err)
+	}
+
+	c, err := NewConsumer(config)
+	if err != nil {
+		t.Fatalf("Failed to create Consumer: %s\n", err)
+	}
+	defer c.Close()
+
+	admin, err := NewAdminClientFromConsumer(c)
+	if err != nil {
+		t.Fatalf("Failed to create Admin client: %s\n", err)
+	}
+	defer admin.Close()
+
+	group, _ := config.Get("group.id", nil)
+	partitions := []TopicPartition{{Topic: &testconf.Topic, Partition: 0, Offset: 0}}
+
+	// The group is not subscribed, so its offsets can be deleted.
+	_, err = c.CommitOffsets(partitions)
+	if err != nil {
+		t.Fatalf("Failed to commit offsets: %s\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := admin.DeleteConsumerGroupOffsets(ctx, group.(string), partitions)
+	if err != nil {
+		t.Fatalf("Failed to delete offsets: %s\n", err)
+	}
+
+	if len(result) != 1 || result[0].Error != nil {
+		t.Fatalf("Expected one successfully deleted partition, got %v\n", result)
+	}
+
+	committed, err := c.Committed(partitions, 10000)
+	if err != nil {
+		t.Fatalf("Failed to get committed offsets: %s\n", err)
+	}
+
+	if committed[0].Offset != OffsetInvalid {
+		t.Errorf("Expected no committed offset after deletion, got %v\n", committed[0])
+	}
+}
+
+// TestConsumerGroupMembers verifies that each of two members of a group
+// sees both members in GroupMembers().
+func TestConsumerGroupMembers(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	consumers := make([]*Consumer, 2)
+	for i := range consumers {
+		config := &ConfigMap{
+			"bootstrap.servers":  testconf.Brokers,
+			"group.id":           testconf.GroupID + "-members",
+			"client.id":          fmt.Sprintf("member%d", i),
+			"session.timeout.ms": 6000,
+			"enable.auto.commit": false,
+		}
+		_ = config.updateFromTestconf()
+
+		c, err := NewConsumer(config)
+		if err != nil {
+			t.Fatalf("Unable to create consumer: %s", err)
+		}
+		defer func() { _ = c.Close() }()
+
+		err = c.Subscribe(testconf.Topic, nil)
+		if err != nil {
+			t.Fatalf("Subscribe: %s", err)
+		}
+		consumers[i] = c
+	}
+
+	// Poll both members until each sees both members in the group.
+	seen := make([]map[string]string, len(consumers))
+	for start := time.Now(); time.Since(start) < 60*time.Second; {
+		done := true
+		for i, c := range consumers {
+			c.Poll(100)
+
+			members, err := c.GroupMembers(5000)
+			if err != nil {
+				t.Logf("Consumer %d: GroupMembers: %s", i, err)
+				done = false
+				continue
+			}
+
+			seen[i] = make(map[string]string)
+			for _, m := range members {
+				seen[i][m.ClientID] = m.MemberID
+			}
+			if len(seen[i]) != 2 {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	for i := range consumers {
+		for j := range consumers {
+			clientID := fmt.Sprintf("member%d", j)
+			if seen[i][clientID] == "" {
+				t.Errorf("Consumer %d: expected %s in group members, got %v",
+					i, clientID, seen[i])
+			}
+		}
+	}
+}
+
+// TestConsumerSeekToTimestamp produces a timeline of messages and verifies
+// that consumption resumes from the first message at the sought timestamp.
+func TestConsumerSeekToTimestamp(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	conf := ConfigMap{"bootstrap.servers": testconf.Brokers}
+	conf.updateFromTestconf()
+
+	p, err := NewProducer(&conf)
+	if err != nil {
+		t.Fatalf("Unable to create producer: %s", err)
+	}
+	defer p.Close()
+
+	// One message per second, starting an hour from now so that the
+	// timeline follows any message already in the topic.
+	base := time.Now().Add(time.Hour).Truncate(time.Second)
+	runID := base.UnixNano()
+	msgcnt := 10
+	drChan := make(chan Event, msgcnt)
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &testconf.Topic, Partition: 0},
+			Value:          []byte(fmt.Sprintf("seek-%d-%d", runID, i)),
+			Timestamp:      base.Add(time.Duration(i) * time.Second)}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %s", err)
+		}
+	}
+	for i := 0; i < msgcnt; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+
+	conf = ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"group.id":           testconf.GroupID,
+		"enable.auto.commit": false}
+	conf.updateFromTestconf()
+
+	c, err := NewConsumer(&conf)
+	if err != nil {
+		t.Fatalf("Unable to create consumer: %s", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &testconf.Topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %s", err)
+	}
+
+	// Seek() requires the partition to be fetching.
+	_, err = c.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	seekIdx := 6
+	err = c.SeekToTimestamp(base.Add(time.Duration(seekIdx)*time.Second), 10000)
+	if err != nil {
+		t.Fatalf("SeekToTimestamp: %s", err)
+	}
+
+	m, err := c.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	expected := fmt.Sprintf("seek-%d-%d", runID, seekIdx)
+	if string(m.Value) != expected {
+		t.Errorf("Expected to resume from %s, got %s at %v",
+			expected, string(m.Value), m.TopicPartition)
+	}
+}
@@ -0,0 +1,108 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// ConsumerInterceptor intercepts the messages consumed and the offsets
+// committed by a Consumer, e.g., for tracing or metrics, without changes
+// to the application's poll loop. See Consumer.AddInterceptor().
+type ConsumerInterceptor interface {
+	// OnConsume is called with each consumed message before it is returned
+	// by Poll() or ReadMessage(), or sent on the Events() channel, and
+	// returns the message to pass on, which may be msg itself, modified,
+	// or a replacement. It must not return nil.
+	// Consumer errors are not passed to OnConsume.
+	OnConsume(msg *Message) *Message
+	// OnCommit is called with the result of each offset commit, both
+	// explicit commits, e.g., Commit(), and automatic commits.
+	OnCommit(offsets []TopicPartition, err error)
+}
+
+// AddInterceptor adds an interceptor to the consumer, called after the
+// previously added interceptors, so that each interceptor's OnConsume()
+// is passed the message returned by the previous interceptor.
+//
+// Interceptors are called from the goroutine polling the consumer, or
+// committing, and should be quick. Since a message returned by
+// OnConsume() replaces the consumed message, replacements of
+// go.value.reader.enable messages must retain the original's
+// ValueReader().
+//
+// AddInterceptor must not be called concurrently with polling the
+// consumer, i.e., interceptors should be added before consuming.
+func (c *Consumer) AddInterceptor(interceptor ConsumerInterceptor) {
+	c.interceptors = append(c.interceptors, interceptor)
+}
+
+// interceptConsume passes msg through the OnConsume() chain.
+func (c *Consumer) interceptConsume(msg *Message) *Message {
+	for _, interceptor := range c.interceptors {
+		msg = interceptor.OnConsume(msg)
+	}
+	return msg
+}
+
+// interceptCommit passes the commit result to all OnCommit() hooks.
+func (c *Consumer) interceptCommit(offsets []TopicPartition, err error) {
+	for _, interceptor := range c.interceptors {
+		interceptor.OnCommit(offsets, err)
+	}
+}
+
+// ProducerInterceptor intercepts the messages produced and their delivery
+// reports by a Producer, e.g., to inject tracing headers, without changes
+// to the application's produce calls. See Producer.AddInterceptor().
+type ProducerInterceptor interface {
+	// OnSend is called with each message passed to Produce(), or to the
+	// ProduceChannel(), before it is handed to librdkafka, and returns the
+	// message to produce, which may be msg itself, e.g., with added
+	// Headers, or a replacement. It must not return nil.
+	OnSend(msg *Message) *Message
+	// OnAcknowledgement is called with the delivery report of each
+	// produced message, and its delivery error, if any, before the
+	// report is emitted.
+	OnAcknowledgement(msg *Message, err error)
+}
+
+// AddInterceptor adds an interceptor to the producer, called after the
+// previously added interceptors, so that each interceptor's OnSend()
+// is passed the message returned by the previous interceptor.
+//
+// OnSend() is called from the producing goroutine and
+// OnAcknowledgement() from the producer's delivery report goroutine,
+// both should be quick.
+//
+// AddInterceptor must not be called concurrently with producing,
+// i.e., interceptors should be added before producing.
+func (p *Producer) AddInterceptor(interceptor ProducerInterceptor) {
+	p.interceptors = append(p.interceptors, interceptor)
+}
+
+// interceptSend passes msg through the OnSend() chain.
+func (p *Producer) interceptSend(msg *Message) *Message {
+	for _, interceptor := range p.interceptors {
+		msg = interceptor.OnSend(msg)
+	}
+	return msg
+}
+
+// interceptAcknowledgement passes the delivery report msg to all
+// OnAcknowledgement() hooks.
+func (p *Producer) interceptAcknowledgement(msg *Message) {
+	for _, interceptor := range p.interceptors {
+		interceptor.OnAcknowledgement(msg, msg.TopicPartition.Error)
+	}
+}
@@ -0,0 +1,223 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type spanIDKey struct{}
+
+// tracingInterceptor injects the span id from the "span-id" header into
+// a context carried by the message's Opaque, as a tracing integration would.
+type tracingInterceptor struct {
+	consumed  []string
+	committed [][]TopicPartition
+}
+
+func (ti *tracingInterceptor) OnConsume(msg *Message) *Message {
+	for _, hdr := range msg.Headers {
+		if hdr.Key == "span-id" {
+			msg.Opaque = context.WithValue(context.Background(), spanIDKey{}, string(hdr.Value))
+		}
+	}
+	ti.consumed = append(ti.consumed, "tracing")
+	return msg
+}
+
+func (ti *tracingInterceptor) OnCommit(offsets []TopicPartition, err error) {
+	if err == nil {
+		ti.committed = append(ti.committed, offsets)
+	}
+}
+
+// countingInterceptor verifies that it sees the previous interceptor's
+// changes.
+type countingInterceptor struct {
+	tracing *tracingInterceptor
+	count   int
+}
+
+func (ci *countingInterceptor) OnConsume(msg *Message) *Message {
+	if _, ok := msg.Opaque.(context.Context); ok {
+		ci.count++
+	}
+	ci.tracing.consumed = append(ci.tracing.consumed, "counting")
+	return msg
+}
+
+func (ci *countingInterceptor) OnCommit(offsets []TopicPartition, err error) {}
+
+// TestConsumerInterceptors verifies that chained interceptors are called
+// in order on the consume and commit paths.
+func TestConsumerInterceptors(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "interceptortopic"
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("value"),
+		Headers:        []Header{{"span-id", []byte("span1")}}}, nil)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+	p.Flush(10000)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "gotest-interceptors",
+		"enable.auto.commit": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	tracing := &tracingInterceptor{}
+	counting := &countingInterceptor{tracing: tracing}
+	c.AddInterceptor(tracing)
+	c.AddInterceptor(counting)
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	m, err := c.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	ctx, ok := m.Opaque.(context.Context)
+	if !ok || ctx.Value(spanIDKey{}) != "span1" {
+		t.Errorf("Expected span id in message context, got %v", m.Opaque)
+	}
+
+	if len(tracing.consumed) != 2 || tracing.consumed[0] != "tracing" ||
+		tracing.consumed[1] != "counting" || counting.count != 1 {
+		t.Errorf("Expected interceptors to be called in order, got %v, count %d",
+			tracing.consumed, counting.count)
+	}
+
+	_, err = c.CommitMessage(m)
+	if err != nil {
+		t.Fatalf("CommitMessage: %v", err)
+	}
+
+	if len(tracing.committed) != 1 || len(tracing.committed[0]) != 1 ||
+		tracing.committed[0][0].Offset != m.TopicPartition.Offset+1 {
+		t.Errorf("Expected OnCommit with offset %d, got %v",
+			m.TopicPartition.Offset+1, tracing.committed)
+	}
+}
+
+// tracePropagator adds a trace header to each produced message and
+// records the acknowledgements.
+type tracePropagator struct {
+	name string
+	acks []error
+}
+
+func (tp *tracePropagator) OnSend(msg *Message) *Message {
+	msg.Headers = append(msg.Headers, Header{"trace", []byte(tp.name)})
+	return msg
+}
+
+func (tp *tracePropagator) OnAcknowledgement(msg *Message, err error) {
+	tp.acks = append(tp.acks, err)
+}
+
+// TestProducerInterceptors verifies that headers added by chained
+// interceptors are produced, and that acknowledgements are intercepted.
+func TestProducerInterceptors(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "producerinterceptortopic"
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	first := &tracePropagator{name: "first"}
+	second := &tracePropagator{name: "second"}
+	p.AddInterceptor(first)
+	p.AddInterceptor(second)
+
+	drChan := make(chan Event, 1)
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("value")}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	dr := (<-drChan).(*Message)
+	if dr.TopicPartition.Error != nil {
+		t.Fatalf("Delivery failed: %v", dr.TopicPartition)
+	}
+
+	for _, tp := range []*tracePropagator{first, second} {
+		if len(tp.acks) != 1 || tp.acks[0] != nil {
+			t.Errorf("Expected %s interceptor to see one successful ack, got %v",
+				tp.name, tp.acks)
+		}
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	m, err := c.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	expHeaders := []Header{{"trace", []byte("first")}, {"trace", []byte("second")}}
+	if !reflect.DeepEqual(m.Headers, expHeaders) {
+		t.Errorf("Expected headers %v, got %v", expHeaders, m.Headers)
+	}
+}
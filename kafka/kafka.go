@@ -255,6 +255,7 @@ import (
 	"fmt"
 	// Make sure librdkafka_vendor/ sub-directory is included in vendor pulls.
 	_ "github.com/confluentinc/confluent-kafka-go/kafka/librdkafka_vendor"
+	"strings"
 	"unsafe"
 )
 
@@ -295,6 +296,13 @@ func (p TopicPartition) String() string {
 		topic, p.Partition, p.Offset)
 }
 
+// topicPartitionKey identifies a topic partition in maps, where
+// TopicPartition, with its Topic pointer, can't be used as the key.
+type topicPartitionKey struct {
+	topic     string
+	partition int32
+}
+
 // TopicPartitions is a slice of TopicPartitions that also implements
 // the sort interface
 type TopicPartitions []TopicPartition
@@ -373,3 +381,30 @@ func LibraryVersion() (int, string) {
 	verstr := C.GoString(C.rd_kafka_version_str())
 	return ver, verstr
 }
+
+// LibraryFeatures returns the optional features the underlying librdkafka
+// library was built with, i.e., its `builtin.features`, such as the
+// supported compression codecs ("gzip", "snappy", "lz4", "zstd") and
+// security mechanisms ("ssl", "sasl_gssapi", ...).
+func LibraryFeatures() []string {
+	cConf := C.rd_kafka_conf_new()
+	defer C.rd_kafka_conf_destroy(cConf)
+
+	cName := C.CString("builtin.features")
+	defer C.free(unsafe.Pointer(cName))
+
+	var cSize C.size_t
+	if C.rd_kafka_conf_get(cConf, cName, nil, &cSize) != C.RD_KAFKA_CONF_OK ||
+		cSize <= 1 {
+		return nil
+	}
+
+	cValue := (*C.char)(C.malloc(cSize))
+	defer C.free(unsafe.Pointer(cValue))
+
+	if C.rd_kafka_conf_get(cConf, cName, cValue, &cSize) != C.RD_KAFKA_CONF_OK {
+		return nil
+	}
+
+	return strings.Split(C.GoString(cValue), ",")
+}
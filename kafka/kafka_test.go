@@ -30,6 +30,19 @@ func TestLibraryVersion(t *testing.T) {
 	}
 }
 
+// TestLibraryFeatures verifies that the builtin features are returned
+func TestLibraryFeatures(t *testing.T) {
+	features := LibraryFeatures()
+	t.Logf("Library features: %v\n", features)
+
+	for _, f := range features {
+		if f == "gzip" {
+			return
+		}
+	}
+	t.Errorf("Expected gzip in library features, got %v\n", features)
+}
+
 //Test Offset APIs
 func TestOffsetAPIs(t *testing.T) {
 	offsets := []Offset{OffsetBeginning, OffsetEnd, OffsetInvalid, OffsetStored, 1001}
@@ -136,3 +149,28 @@ func TestOffsetAPIs(t *testing.T) {
 	t.Logf("offset tail %v\n", tail)
 
 }
+
+// TestHandleCPtr verifies that CPtr() returns the same rd_kafka_t
+// instance handle for a client and an AdminClient derived from it.
+func TestHandleCPtr(t *testing.T) {
+	p, err := NewProducer(&ConfigMap{"socket.timeout.ms": 10})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	a, err := NewAdminClientFromProducer(p)
+	if err != nil {
+		t.Fatalf("NewAdminClientFromProducer: %v", err)
+	}
+	defer a.Close()
+
+	var h Handle = p
+	if h.CPtr() == nil {
+		t.Fatalf("Expected a non-nil rd_kafka_t pointer")
+	}
+	if a.CPtr() != p.CPtr() {
+		t.Errorf("Expected the AdminClient to share the producer's rd_kafka_t %p, got %p",
+			p.CPtr(), a.CPtr())
+	}
+}
@@ -0,0 +1,96 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SerializeKey serializes a message key of a common type to bytes, the
+// way the Java client's default serializers do, so that keys produced by
+// Go and Java applications are identical and, with the same partitioner,
+// co-partitioned:
+//
+//	nil                        nil (no key)
+//	[]byte                     as is
+//	string                     UTF-8 (StringSerializer)
+//	int16, uint16              2 bytes big endian (ShortSerializer)
+//	int32, uint32              4 bytes big endian (IntegerSerializer)
+//	int, int64, uint, uint64   8 bytes big endian (LongSerializer)
+//	float32                    4 bytes IEEE 754 big endian (FloatSerializer)
+//	float64                    8 bytes IEEE 754 big endian (DoubleSerializer)
+//	encoding.BinaryMarshaler   MarshalBinary()
+//
+// Note that Go's int is serialized as a Java Long: convert keys to int32
+// to match Java Integer keys.
+// Other types fail with ErrInvalidArg.
+func SerializeKey(key interface{}) ([]byte, error) {
+	switch k := key.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return k, nil
+	case string:
+		return []byte(k), nil
+	case int16:
+		return serializeUint16(uint16(k)), nil
+	case uint16:
+		return serializeUint16(k), nil
+	case int32:
+		return serializeUint32(uint32(k)), nil
+	case uint32:
+		return serializeUint32(k), nil
+	case int:
+		return serializeUint64(uint64(k)), nil
+	case int64:
+		return serializeUint64(uint64(k)), nil
+	case uint:
+		return serializeUint64(uint64(k)), nil
+	case uint64:
+		return serializeUint64(k), nil
+	case float32:
+		return serializeUint32(math.Float32bits(k)), nil
+	case float64:
+		return serializeUint64(math.Float64bits(k)), nil
+	case encoding.BinaryMarshaler:
+		return k.MarshalBinary()
+	default:
+		return nil, newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Unsupported key type %T", key))
+	}
+}
+
+func serializeUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func serializeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func serializeUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
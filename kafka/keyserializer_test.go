@@ -0,0 +1,99 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestSerializeKey verifies the key serialization against the encodings of
+// the Java client's default serializers.
+func TestSerializeKey(t *testing.T) {
+	ts := time.Unix(1600000000, 0).UTC()
+	tsBytes, _ := ts.MarshalBinary()
+
+	for _, tc := range []struct {
+		key      interface{}
+		expected []byte
+	}{
+		{nil, nil},
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"key-ä", []byte("key-ä")},
+		{int16(-2), []byte{0xff, 0xfe}},
+		{int32(42), []byte{0, 0, 0, 42}},
+		{int32(-1), []byte{0xff, 0xff, 0xff, 0xff}},
+		{uint32(0x01020304), []byte{1, 2, 3, 4}},
+		{42, []byte{0, 0, 0, 0, 0, 0, 0, 42}},
+		{int64(-42), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xd6}},
+		{float32(1.5), []byte{0x3f, 0xc0, 0, 0}},
+		{float64(1.5), []byte{0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{ts, tsBytes},
+	} {
+		b, err := SerializeKey(tc.key)
+		if err != nil {
+			t.Errorf("SerializeKey(%v): %v", tc.key, err)
+			continue
+		}
+		if !bytes.Equal(b, tc.expected) {
+			t.Errorf("SerializeKey(%T %v): expected %v, got %v",
+				tc.key, tc.key, tc.expected, b)
+		}
+	}
+
+	_, err := SerializeKey(struct{}{})
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for an unsupported key type, got %v", err)
+	}
+}
+
+// TestProducerProduceKeyed verifies that ProduceKeyed() produces messages
+// with the serialized key.
+func TestProducerProduceKeyed(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	drChan := make(chan Event, 1)
+	err = p.ProduceKeyed("keyedtopic", int32(42), []byte("value"), drChan)
+	if err != nil {
+		t.Fatalf("ProduceKeyed: %v", err)
+	}
+
+	m := (<-drChan).(*Message)
+	if m.TopicPartition.Error != nil {
+		t.Fatalf("Delivery failed: %v", m.TopicPartition)
+	}
+	if !bytes.Equal(m.Key, []byte{0, 0, 0, 42}) {
+		t.Errorf("Expected serialized key, got %v", m.Key)
+	}
+
+	err = p.ProduceKeyed("keyedtopic", struct{}{}, nil, drChan)
+	if err == nil {
+		t.Errorf("Expected ProduceKeyed() to fail for an unsupported key type")
+	}
+}
@@ -0,0 +1,63 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// Operation identifies a blocking broker operation whose latency is
+// measured, see Consumer.LastLatency() and Producer.LastLatency().
+type Operation string
+
+const (
+	// OperationCommit is a consumer offset commit, from sending the
+	// request until the commit result is received.
+	OperationCommit = Operation("Commit")
+	// OperationQueryWatermarkOffsets is a QueryWatermarkOffsets() call
+	OperationQueryWatermarkOffsets = Operation("QueryWatermarkOffsets")
+	// OperationGetMetadata is a GetMetadata() call
+	OperationGetMetadata = Operation("GetMetadata")
+)
+
+// operationLatencies holds the last measured latency of each Operation.
+type operationLatencies struct {
+	lock sync.Mutex
+	last map[Operation]time.Duration
+}
+
+// record sets the latency of op to the time elapsed since start.
+func (ol *operationLatencies) record(op Operation, start time.Time) {
+	latency := time.Since(start)
+
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	if ol.last == nil {
+		ol.last = make(map[Operation]time.Duration)
+	}
+	ol.last[op] = latency
+}
+
+// get returns the last latency of op, or 0 if not yet measured.
+func (ol *operationLatencies) get(op Operation) time.Duration {
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	return ol.last[op]
+}
@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
@@ -67,6 +68,10 @@ func (h *handle) pollLogEvents(toChannel chan LogEvent, timeoutMs int, doneChan
 			logEvent := h.newLogEvent(cEvent)
 			C.rd_kafka_event_destroy(cEvent)
 
+			if h.c != nil {
+				h.setRebalanceReason(logEvent)
+			}
+
 			select {
 			case <-doneChan:
 				return
@@ -78,6 +83,31 @@ func (h *handle) pollLogEvents(toChannel chan LogEvent, timeoutMs int, doneChan
 	}
 }
 
+// log emits a log generated by the Go client as a LogEvent on the Logs()
+// channel if `go.logs.channel.enable` is set, without blocking, else
+// to stderr in librdkafka's default log format.
+func (h *handle) log(level int, tag string, message string) {
+	now := time.Now()
+
+	if h.logs == nil {
+		fmt.Fprintf(os.Stderr, "%%%d|%d.%03d|%s|%s| %s\n",
+			level, now.Unix(), now.Nanosecond()/int(time.Millisecond),
+			tag, h.name, message)
+		return
+	}
+
+	select {
+	case h.logs <- LogEvent{
+		Name:      h.name,
+		Tag:       tag,
+		Message:   message,
+		Level:     level,
+		Timestamp: now,
+	}:
+	default:
+	}
+}
+
 func (logEvent LogEvent) String() string {
 	return fmt.Sprintf(
 		"[%v][%s][%s][%d]%s",
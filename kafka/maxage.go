@@ -0,0 +1,78 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"time"
+)
+
+// maxAgeSeekThreshold is the number of consecutive stale messages skipped
+// for a partition, with `go.max.message.age.ms`, after which the stale
+// range is considered large and the partition is sought past it, rather
+// than fetching and skipping the remaining stale messages.
+const maxAgeSeekThreshold = 1000
+
+// maxAgeSeekTimeoutMs is the maximum time the offset lookup for seeking
+// past a stale range may block the poll.
+const maxAgeSeekTimeoutMs = 5000
+
+// skipStale returns true if msg is older than `go.max.message.age.ms`
+// and must be skipped, messages without a timestamp are never skipped. Once maxAgeSeekThreshold consecutive messages
+// have been skipped for a partition it is sought to the first message
+// that is recent enough, as looked up with OffsetsForTimes().
+//
+// Called from the poll path only.
+func (c *Consumer) skipStale(msg *Message) bool {
+	if msg.TimestampType == TimestampNotAvailable {
+		return false
+	}
+
+	key := topicPartitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+	oldest := time.Now().Add(-c.maxMessageAge)
+
+	if !msg.Timestamp.Before(oldest) {
+		delete(c.staleCounts, key)
+		return false
+	}
+
+	c.staleCounts[key]++
+	if c.staleCounts[key] < maxAgeSeekThreshold {
+		return true
+	}
+	delete(c.staleCounts, key)
+
+	times := []TopicPartition{{
+		Topic:     msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    Offset(oldest.UnixNano() / int64(time.Millisecond)),
+	}}
+	offsets, err := c.OffsetsForTimes(times, maxAgeSeekTimeoutMs)
+	if err != nil || offsets[0].Error != nil {
+		// Keep skipping message by message.
+		return true
+	}
+
+	// OffsetEnd if there are no recent messages in the partition.
+	seekTo := offsets[0]
+	if seekTo.Offset >= 0 && seekTo.Offset <= msg.TopicPartition.Offset {
+		return true
+	}
+
+	c.Seek(seekTo, 0)
+
+	return true
+}
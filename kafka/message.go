@@ -43,8 +43,13 @@ void setup_rkmessage (rd_kafka_message_t *rkmessage,
 */
 import "C"
 
-// TimestampType is a the Message timestamp type or source
+// TimestampType is the Message timestamp type or source.
 //
+// The type of a consumed message's timestamp is decided by the topic's
+// `message.timestamp.type` config (or the broker's
+// `log.message.timestamp.type` default): with CreateTime the broker keeps
+// the timestamp set by the producer, with LogAppendTime it overwrites it
+// with the time the message was appended to the log.
 type TimestampType int
 
 const (
@@ -56,6 +61,7 @@ const (
 	TimestampLogAppendTime = TimestampType(C.RD_KAFKA_TIMESTAMP_LOG_APPEND_TIME)
 )
 
+// String returns "CreateTime", "LogAppendTime" or "NotAvailable".
 func (t TimestampType) String() string {
 	switch t {
 	case TimestampCreateTime:
@@ -70,6 +76,13 @@ func (t TimestampType) String() string {
 }
 
 // Message represents a Kafka message
+//
+// Timestamp is produced as the message's CreateTime, or the current time
+// if zero, while TimestampType is ignored by Produce(). Consumed messages
+// carry the CreateTime or LogAppendTime, as indicated by TimestampType.
+//
+// Delivery reports do not carry the number of times the message was
+// retried, which librdkafka 1.7.0 does not expose, see Producer.Produce().
 type Message struct {
 	TopicPartition TopicPartition
 	Value          []byte
@@ -78,6 +91,13 @@ type Message struct {
 	TimestampType  TimestampType
 	Opaque         interface{}
 	Headers        []Header
+
+	// valueReader is set instead of Value for messages consumed
+	// with go.value.reader.enable.
+	valueReader *valueBufferReader
+	// batchIndex is the index of a delivery report's message in the
+	// messages passed to ProduceBatchSync(), else 0.
+	batchIndex int
 }
 
 // String returns a human readable representation of a Message.
@@ -92,6 +112,39 @@ func (m *Message) String() string {
 	return fmt.Sprintf("%s[%d]@%s", topic, m.TopicPartition.Partition, m.TopicPartition.Offset)
 }
 
+// ToProduceCopy returns a new Message, suitable for passing to
+// Producer.Produce(), with the key, value, headers, timestamp and partition
+// of m, destined for destTopic.
+//
+// This is typically used to copy consumed messages to another topic or
+// cluster. The partition is preserved, set
+// TopicPartition.Partition to PartitionAny on the returned Message to have
+// the destination producer's partitioner reassign it.
+// The original timestamp is preserved as the produced message's
+// CreateTime, regardless of m's TimestampType.
+//
+// The returned Message shares the Key, Value and header values
+// with m, but not the Headers slice itself, nor the Opaque.
+func (m *Message) ToProduceCopy(destTopic string) *Message {
+	c := &Message{
+		TopicPartition: TopicPartition{
+			Topic:     &destTopic,
+			Partition: m.TopicPartition.Partition,
+			Offset:    OffsetInvalid,
+		},
+		Key:       m.Key,
+		Value:     m.Value,
+		Timestamp: m.Timestamp,
+	}
+
+	if m.Headers != nil {
+		c.Headers = make([]Header, len(m.Headers))
+		copy(c.Headers, m.Headers)
+	}
+
+	return c
+}
+
 func (h *handle) getRktFromMessage(msg *Message) (crkt *C.rd_kafka_topic_t) {
 	if msg.TopicPartition.Topic == nil {
 		return nil
@@ -102,14 +155,29 @@ func (h *handle) getRktFromMessage(msg *Message) (crkt *C.rd_kafka_topic_t) {
 
 // setupHeadersFromGlueMsg converts the C tmp headers in gMsg to
 // Go Headers in msg.
+// The header values are copied to a single Go buffer, rather than
+// allocated one by one, since messages may carry many small headers.
 // gMsg.tmphdrs will be freed.
 func setupHeadersFromGlueMsg(msg *Message, gMsg *C.glue_msg_t) {
-	msg.Headers = make([]Header, gMsg.tmphdrsCnt)
-	for n := range msg.Headers {
-		tmphdr := (*[1 << 30]C.tmphdr_t)(unsafe.Pointer(gMsg.tmphdrs))[n]
+	cnt := int(gMsg.tmphdrsCnt)
+	tmphdrs := (*[1 << 30]C.tmphdr_t)(unsafe.Pointer(gMsg.tmphdrs))[:cnt:cnt]
+
+	size := 0
+	for _, tmphdr := range tmphdrs {
+		if tmphdr.val != nil {
+			size += int(tmphdr.size)
+		}
+	}
+	buf := make([]byte, size)
+
+	msg.Headers = make([]Header, cnt)
+	for n, tmphdr := range tmphdrs {
 		msg.Headers[n].Key = C.GoString(tmphdr.key)
 		if tmphdr.val != nil {
-			msg.Headers[n].Value = C.GoBytes(unsafe.Pointer(tmphdr.val), C.int(tmphdr.size))
+			sz := int(tmphdr.size)
+			copy(buf, (*[1 << 30]byte)(unsafe.Pointer(tmphdr.val))[:sz:sz])
+			msg.Headers[n].Value = buf[:sz:sz]
+			buf = buf[sz:]
 		} else {
 			msg.Headers[n].Value = nil
 		}
@@ -142,7 +210,10 @@ func (h *handle) setupMessageFromC(msg *Message, cmsg *C.rd_kafka_message_t) {
 		msg.TopicPartition.Topic = &topic
 	}
 	msg.TopicPartition.Partition = int32(cmsg.partition)
-	if cmsg.payload != nil && h.msgFields.Value {
+	// With go.value.reader.enable the value is read through
+	// Message.ValueReader() instead, error strings are still copied.
+	if cmsg.payload != nil && h.msgFields.Value &&
+		(h.c == nil || !h.c.valueReaderEnable || cmsg.err != 0) {
 		msg.Value = C.GoBytes(unsafe.Pointer(cmsg.payload), C.int(cmsg.len))
 	}
 	if cmsg.key != nil && h.msgFields.Key {
@@ -159,8 +230,50 @@ func (h *handle) setupMessageFromC(msg *Message, cmsg *C.rd_kafka_message_t) {
 	}
 	msg.TopicPartition.Offset = Offset(cmsg.offset)
 	if cmsg.err != 0 {
-		msg.TopicPartition.Error = newError(cmsg.err)
+		if h.c != nil && cmsg.payload != nil {
+			// Consumer errors carry the error string as payload.
+			msg.TopicPartition.Error = newConsumerMessageError(
+				ErrorCode(cmsg.err),
+				C.GoStringN((*C.char)(cmsg.payload), C.int(cmsg.len)),
+				msg.TopicPartition)
+		} else if h.p != nil {
+			msg.TopicPartition.Error = setDeliveryRetriable(newError(cmsg.err))
+		} else {
+			msg.TopicPartition.Error = newError(cmsg.err)
+		}
+	}
+}
+
+// compressionCodecNames maps the message set compression codec attribute
+// values to their names, as used in `compression.codec` and LibraryFeatures().
+var compressionCodecNames = map[int]string{
+	1: "gzip",
+	2: "snappy",
+	3: "lz4",
+	4: "zstd",
+}
+
+// newConsumerMessageError creates the error of a consumed message for
+// partition tp from the librdkafka error code and string.
+// Decompression failures due to a compression codec that the linked
+// librdkafka was not built with are given a descriptive error string.
+func newConsumerMessageError(code ErrorCode, errstr string, tp TopicPartition) Error {
+	var codec int
+	if code == ErrNotImplemented {
+		if n, _ := fmt.Sscanf(errstr, "Decompression (codec 0x%x)", &codec); n == 1 {
+			name, found := compressionCodecNames[codec]
+			if !found {
+				name = fmt.Sprintf("0x%x", codec)
+			}
+			tp.Error = nil
+			return newErrorFromString(code,
+				fmt.Sprintf("Unsupported compression codec %s for message at %s: "+
+					"the linked librdkafka is built without %s support, see LibraryFeatures(): %s",
+					name, tp, name, errstr))
+		}
 	}
+
+	return newErrorFromString(code, errstr)
 }
 
 // newMessageFromC creates a new message object from a C rd_kafka_message_t
@@ -17,7 +17,10 @@
 package kafka
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 //Test TimestampType
@@ -31,3 +34,252 @@ func TestTimestampType(t *testing.T) {
 		}
 	}
 }
+
+// TestMessageToProduceCopy mirrors messages between two mock clusters
+// and verifies that all message fields survive the round-trip.
+func TestMessageToProduceCopy(t *testing.T) {
+	srcCluster, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer srcCluster.Close()
+
+	dstCluster, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer dstCluster.Close()
+
+	srcTopic := "srctopic"
+	dstTopic := "dsttopic"
+	srcCluster.CreateTopic(srcTopic, 4, 1)
+	dstCluster.CreateTopic(dstTopic, 4, 1)
+
+	msgcnt := 10
+	partition := int32(2)
+	timestamp := time.Unix(1600000000, 0)
+
+	expected := make([]*Message, msgcnt)
+	for i := 0; i < msgcnt; i++ {
+		expected[i] = &Message{
+			TopicPartition: TopicPartition{Topic: &srcTopic, Partition: partition},
+			Key:            []byte(fmt.Sprintf("key%d", i)),
+			Value:          []byte(fmt.Sprintf("value%d", i)),
+			Timestamp:      timestamp.Add(time.Duration(i) * time.Second),
+			Headers: []Header{
+				{Key: "hdr", Value: []byte(fmt.Sprintf("hdrval%d", i))},
+				{Key: "nullhdr", Value: nil},
+			},
+		}
+	}
+	// Null key and value must also round-trip.
+	expected[msgcnt-1].Key = nil
+	expected[msgcnt-1].Value = nil
+
+	produceAll := func(mc *MockCluster, msgs []*Message) {
+		p, err := NewProducer(&ConfigMap{
+			"bootstrap.servers": mc.BootstrapServers()})
+		if err != nil {
+			t.Fatalf("NewProducer: %v", err)
+		}
+		defer p.Close()
+
+		drChan := make(chan Event, len(msgs))
+		for _, m := range msgs {
+			err = p.Produce(m, drChan)
+			if err != nil {
+				t.Fatalf("Produce: %v", err)
+			}
+		}
+		for range msgs {
+			m := (<-drChan).(*Message)
+			if m.TopicPartition.Error != nil {
+				t.Fatalf("Delivery failed: %v", m.TopicPartition)
+			}
+		}
+	}
+
+	consumeAll := func(mc *MockCluster, topic string) []*Message {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers": mc.BootstrapServers(),
+			"group.id":          "mirrorgroup",
+			"auto.offset.reset": "earliest"})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		defer c.Close()
+
+		err = c.Assign([]TopicPartition{
+			{Topic: &topic, Partition: partition, Offset: OffsetBeginning}})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+
+		return mockConsume(t, c, msgcnt, 30*time.Second)
+	}
+
+	produceAll(srcCluster, expected)
+
+	copies := make([]*Message, 0, msgcnt)
+	for _, m := range consumeAll(srcCluster, srcTopic) {
+		copies = append(copies, m.ToProduceCopy(dstTopic))
+	}
+
+	produceAll(dstCluster, copies)
+
+	for i, m := range consumeAll(dstCluster, dstTopic) {
+		exp := expected[i]
+		if *m.TopicPartition.Topic != dstTopic ||
+			m.TopicPartition.Partition != partition {
+			t.Errorf("Message #%d: expected %s [%d], got %v",
+				i, dstTopic, partition, m.TopicPartition)
+		}
+		if !reflect.DeepEqual(m.Key, exp.Key) {
+			t.Errorf("Message #%d: expected key %v, got %v", i, exp.Key, m.Key)
+		}
+		if !reflect.DeepEqual(m.Value, exp.Value) {
+			t.Errorf("Message #%d: expected value %v, got %v", i, exp.Value, m.Value)
+		}
+		if !m.Timestamp.Equal(exp.Timestamp) {
+			t.Errorf("Message #%d: expected timestamp %v, got %v",
+				i, exp.Timestamp, m.Timestamp)
+		}
+		if !reflect.DeepEqual(m.Headers, exp.Headers) {
+			t.Errorf("Message #%d: expected headers %v, got %v",
+				i, exp.Headers, m.Headers)
+		}
+	}
+}
+
+// TestMessageToProduceCopyIndependence verifies that the copy
+// is independent of the original message.
+func TestMessageToProduceCopyIndependence(t *testing.T) {
+	topic := "src"
+	m := &Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 3, Offset: 100},
+		Value:          []byte("value"),
+		Headers:        []Header{{Key: "hdr", Value: []byte("val")}},
+		Opaque:         "opaque",
+	}
+
+	c := m.ToProduceCopy("dst")
+	c.TopicPartition.Partition = PartitionAny
+	c.Headers[0].Key = "changed"
+
+	if *m.TopicPartition.Topic != "src" || m.TopicPartition.Partition != 3 {
+		t.Errorf("Original message modified: %v", m.TopicPartition)
+	}
+	if m.Headers[0].Key != "hdr" {
+		t.Errorf("Original message headers modified: %v", m.Headers)
+	}
+	if *c.TopicPartition.Topic != "dst" {
+		t.Errorf("Expected topic dst, got %v", c.TopicPartition)
+	}
+	if c.TopicPartition.Offset != OffsetInvalid {
+		t.Errorf("Expected OffsetInvalid, got %v", c.TopicPartition.Offset)
+	}
+	if c.Opaque != nil {
+		t.Errorf("Expected nil Opaque, got %v", c.Opaque)
+	}
+}
+
+// TestConsumerMessageError verifies the error strings of consumed message
+// errors, in particular unsupported compression codecs.
+func TestConsumerMessageError(t *testing.T) {
+	topic := "topic"
+	tp := TopicPartition{Topic: &topic, Partition: 3, Offset: 1234}
+
+	err := newConsumerMessageError(ErrNotImplemented,
+		"Decompression (codec 0x4) of message at 1234 of 100 bytes failed: Local: Not implemented",
+		tp)
+	expected := "Unsupported compression codec zstd for message at topic[3]@1234: " +
+		"the linked librdkafka is built without zstd support, see LibraryFeatures(): " +
+		"Decompression (codec 0x4) of message at 1234 of 100 bytes failed: Local: Not implemented"
+	if err.Code() != ErrNotImplemented || err.Error() != expected {
+		t.Errorf("Expected %s error \"%s\", got %s \"%s\"",
+			ErrNotImplemented, expected, err.Code(), err)
+	}
+
+	errstr := "Fetch from broker 1 failed: Broker: Not leader for partition"
+	err = newConsumerMessageError(ErrNotLeaderForPartition, errstr, tp)
+	if err.Code() != ErrNotLeaderForPartition || err.Error() != errstr {
+		t.Errorf("Expected error \"%s\", got \"%s\"", errstr, err)
+	}
+}
+
+// TestMessageTimestampCreateTime verifies that the produced timestamp, or
+// the time of producing if none, is consumed as the CreateTime, which is
+// the mock cluster's topic default.
+func TestMessageTimestampCreateTime(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "timestamptopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	timestamp := time.Unix(1600000000, 0)
+	before := time.Now().Truncate(time.Millisecond)
+	drChan := make(chan Event, 2)
+	for _, ts := range []time.Time{timestamp, {}} {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("timestamp"),
+			Timestamp:      ts,
+			// Ignored by Produce()
+			TimestampType: TimestampLogAppendTime},
+			drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition.Error)
+		}
+	}
+	after := time.Now()
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "timestampgroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	msgs := mockConsume(t, c, 2, 30*time.Second)
+
+	for _, m := range msgs {
+		if m.TimestampType != TimestampCreateTime {
+			t.Errorf("%v: Expected TimestampType CreateTime, got %s", m, m.TimestampType)
+		}
+	}
+
+	if !msgs[0].Timestamp.Equal(timestamp) {
+		t.Errorf("%v: Expected the produced timestamp %v, got %v",
+			msgs[0], timestamp, msgs[0].Timestamp)
+	}
+
+	if msgs[1].Timestamp.Before(before) || msgs[1].Timestamp.After(after) {
+		t.Errorf("%v: Expected the time of producing, between %v and %v, got %v",
+			msgs[1], before, after, msgs[1].Timestamp)
+	}
+}
@@ -17,6 +17,8 @@
 package kafka
 
 import (
+	"context"
+	"time"
 	"unsafe"
 )
 
@@ -87,11 +89,13 @@ func getMetadata(H Handle, topic *string, allTopics bool, timeoutMs int) (*Metad
 	}
 
 	var cMd *C.struct_rd_kafka_metadata
+	start := time.Now()
 	cErr := C.rd_kafka_metadata(h.rk, bool2cint(allTopics),
 		rkt, &cMd, C.int(timeoutMs))
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
 		return nil, newError(cErr)
 	}
+	h.latencies.record(OperationGetMetadata, start)
 
 	m := Metadata{}
 	defer C.rd_kafka_metadata_destroy(cMd)
@@ -146,17 +150,66 @@ func queryWatermarkOffsets(H Handle, topic string, partition int32, timeoutMs in
 
 	var cLow, cHigh C.int64_t
 
+	start := time.Now()
 	e := C.rd_kafka_query_watermark_offsets(h.rk, ctopic, C.int32_t(partition),
 		&cLow, &cHigh, C.int(timeoutMs))
 	if e != C.RD_KAFKA_RESP_ERR_NO_ERROR {
 		return 0, 0, newError(e)
 	}
+	h.latencies.record(OperationQueryWatermarkOffsets, start)
 
 	low = int64(cLow)
 	high = int64(cHigh)
 	return low, high, nil
 }
 
+// DefaultWatermarkRetryPolicy is the retry policy used by
+// Consumer.QueryWatermarkOffsetsCtx() unless changed with
+// Consumer.SetWatermarkRetryPolicy(), riding over a leader election
+// of a couple of seconds.
+var DefaultWatermarkRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	Backoff:        100 * time.Millisecond,
+	MaxBackoff:     1 * time.Second,
+	AttemptTimeout: 5 * time.Second,
+}
+
+// isRetriableWatermarkError returns true if a watermark query that failed
+// with err may succeed if retried, e.g., during a leader election.
+func isRetriableWatermarkError(err error) bool {
+	kerr, ok := err.(Error)
+	if !ok {
+		return false
+	}
+
+	switch kerr.Code() {
+	case ErrTransport, ErrAllBrokersDown, ErrTimedOut,
+		ErrLeaderNotAvailable, ErrNotLeaderForPartition,
+		ErrRequestTimedOut, ErrBrokerNotAvailable, ErrNetworkException,
+		ErrFencedLeaderEpoch, ErrUnknownLeaderEpoch, ErrOffsetNotAvailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// queryWatermarkOffsetsCtx is queryWatermarkOffsets retrying on retriable
+// errors according to policy until ctx is done.
+func queryWatermarkOffsetsCtx(ctx context.Context, H Handle, topic string, partition int32, policy RetryPolicy) (low, high int64, err error) {
+	err = retryWithPolicy(ctx, policy, isRetriableWatermarkError,
+		func(attemptTimeout time.Duration) error {
+			var err error
+			low, high, err = queryWatermarkOffsets(H, topic, partition,
+				int(attemptTimeout/time.Millisecond))
+			return err
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return low, high, nil
+}
+
 // getWatermarkOffsets returns the clients cached low and high offsets for the given topic
 // and partition.
 func getWatermarkOffsets(H Handle, topic string, partition int32) (low, high int64, err error) {
@@ -0,0 +1,281 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"unsafe"
+)
+
+/*
+#include <stdlib.h>
+#include "select_rdkafka.h"
+
+#ifdef USE_VENDORED_LIBRDKAFKA
+// The bundled librdkafka static libraries include the mock cluster,
+// but its header (rdkafka_mock.h) is not bundled, so declare the
+// subset of the mock API used by this client here.
+typedef struct rd_kafka_mock_cluster_s rd_kafka_mock_cluster_t;
+
+rd_kafka_mock_cluster_t *rd_kafka_mock_cluster_new (rd_kafka_t *rk,
+                                                    int broker_cnt);
+void rd_kafka_mock_cluster_destroy (rd_kafka_mock_cluster_t *mcluster);
+const char *
+rd_kafka_mock_cluster_bootstraps (const rd_kafka_mock_cluster_t *mcluster);
+rd_kafka_resp_err_t
+rd_kafka_mock_topic_create (rd_kafka_mock_cluster_t *mcluster,
+                            const char *topic, int partition_cnt,
+                            int replication_factor);
+void rd_kafka_mock_push_request_errors_array (
+        rd_kafka_mock_cluster_t *mcluster, int16_t ApiKey, size_t cnt,
+        const rd_kafka_resp_err_t *errors);
+void rd_kafka_mock_clear_request_errors (rd_kafka_mock_cluster_t *mcluster,
+                                         int16_t ApiKey);
+rd_kafka_resp_err_t
+rd_kafka_mock_broker_set_down (rd_kafka_mock_cluster_t *mcluster,
+                               int32_t broker_id);
+rd_kafka_resp_err_t
+rd_kafka_mock_broker_set_up (rd_kafka_mock_cluster_t *mcluster,
+                             int32_t broker_id);
+rd_kafka_resp_err_t
+rd_kafka_mock_partition_set_leader (rd_kafka_mock_cluster_t *mcluster,
+                                    const char *topic, int32_t partition,
+                                    int32_t broker_id);
+rd_kafka_resp_err_t
+rd_kafka_mock_partition_set_follower (rd_kafka_mock_cluster_t *mcluster,
+                                      const char *topic, int32_t partition,
+                                      int32_t broker_id);
+rd_kafka_resp_err_t
+rd_kafka_mock_partition_set_follower_wmarks (rd_kafka_mock_cluster_t *mcluster,
+                                             const char *topic,
+                                             int32_t partition,
+                                             int64_t lo, int64_t hi);
+rd_kafka_resp_err_t
+rd_kafka_mock_coordinator_set (rd_kafka_mock_cluster_t *mcluster,
+                               const char *key_type, const char *key,
+                               int32_t broker_id);
+#else
+#include <librdkafka/rdkafka_mock.h>
+#endif
+*/
+import "C"
+
+// MockCluster represents a Kafka mock cluster instance which can be used
+// for testing.
+//
+// The mock cluster is an in-process, librdkafka-provided implementation
+// of a subset of the Kafka protocol. Real Producer, Consumer and AdminClient
+// instances may be pointed to the mock cluster by setting their
+// `bootstrap.servers` to the value returned by BootstrapServers().
+type MockCluster struct {
+	rk       *C.rd_kafka_t
+	mcluster *C.rd_kafka_mock_cluster_t
+}
+
+// NewMockCluster provides a mock Kafka cluster with a configurable
+// number of brokers that support a reasonable subset of Kafka protocol
+// operations, error injection, etc.
+//
+// The broker ids will start at 1 up to and including brokerCount.
+//
+// Mock clusters provide localhost listeners that can be used as the bootstrap
+// servers by multiple Kafka client instances.
+//
+// Currently supported functionality:
+//  * Producer
+//  * Idempotent Producer
+//  * Transactional Producer
+//  * Low-level consumer
+//  * High-level balanced consumer groups with offset commits
+//  * Topic Metadata and auto creation
+//
+// Warning: THIS IS AN EXPERIMENTAL API, SUBJECT TO CHANGE OR REMOVAL.
+func NewMockCluster(brokerCount int) (*MockCluster, error) {
+
+	err := versionCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	if brokerCount < 1 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"MockCluster requires at least one broker")
+	}
+
+	mc := &MockCluster{}
+
+	cErrstr := (*C.char)(C.malloc(C.size_t(256)))
+	defer C.free(unsafe.Pointer(cErrstr))
+
+	// The mock cluster needs a client instance to run its
+	// background thread on, this instance is not used for anything else.
+	cConf := C.rd_kafka_conf_new()
+
+	mc.rk = C.rd_kafka_new(C.RD_KAFKA_PRODUCER, cConf, cErrstr, 256)
+	if mc.rk == nil {
+		C.rd_kafka_conf_destroy(cConf)
+		return nil, newErrorFromCString(C.RD_KAFKA_RESP_ERR__INVALID_ARG, cErrstr)
+	}
+
+	mc.mcluster = C.rd_kafka_mock_cluster_new(mc.rk, C.int(brokerCount))
+	if mc.mcluster == nil {
+		C.rd_kafka_destroy(mc.rk)
+		return nil, newErrorFromString(ErrFail, "Failed to create mock cluster")
+	}
+
+	return mc, nil
+}
+
+// BootstrapServers returns the bootstrap.servers property for this MockCluster
+func (mc *MockCluster) BootstrapServers() string {
+	return C.GoString(C.rd_kafka_mock_cluster_bootstraps(mc.mcluster))
+}
+
+// CreateTopic creates a topic in the mock cluster with the given
+// number of partitions and replication factor.
+//
+// Topics are otherwise automatically created in the mock cluster
+// (with the default number of partitions) when first used.
+func (mc *MockCluster) CreateTopic(topic string, numPartitions int, replicationFactor int) error {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cErr := C.rd_kafka_mock_topic_create(mc.mcluster, cTopic,
+		C.int(numPartitions), C.int(replicationFactor))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+
+	return nil
+}
+
+// SetRoundtripError injects a broker-side error for the next request
+// of the given Kafka protocol request type (apiKey), e.g., 0 for Produce
+// or 1 for Fetch.
+// The request will fail with errorCode, as if returned by the broker.
+//
+// Each call pushes one error onto the per-apiKey error stack, call
+// SetRoundtripError() repeatedly to fail multiple requests.
+// Use ClearRoundtripErrors() to remove any errors that have not yet
+// been returned.
+func (mc *MockCluster) SetRoundtripError(apiKey int16, errorCode ErrorCode) {
+	cErrs := []C.rd_kafka_resp_err_t{C.rd_kafka_resp_err_t(errorCode)}
+	C.rd_kafka_mock_push_request_errors_array(mc.mcluster, C.int16_t(apiKey),
+		C.size_t(len(cErrs)), &cErrs[0])
+}
+
+// ClearRoundtripErrors removes any injected errors for the given
+// Kafka protocol request type (apiKey) that have not yet been returned.
+func (mc *MockCluster) ClearRoundtripErrors(apiKey int16) {
+	C.rd_kafka_mock_clear_request_errors(mc.mcluster, C.int16_t(apiKey))
+}
+
+// SetBrokerDown disconnects the broker and disallows any new connections.
+// This does NOT trigger leader change.
+func (mc *MockCluster) SetBrokerDown(brokerID int32) error {
+	cErr := C.rd_kafka_mock_broker_set_down(mc.mcluster, C.int32_t(brokerID))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// SetBrokerUp makes a broker previously set down with SetBrokerDown()
+// accept connections again.
+func (mc *MockCluster) SetBrokerUp(brokerID int32) error {
+	cErr := C.rd_kafka_mock_broker_set_up(mc.mcluster, C.int32_t(brokerID))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// SetPartitionLeader sets the partition leader broker for the given
+// topic partition, as if a leader election had taken place.
+// Clients will discover the new leader through their regular metadata
+// refreshes, or when their requests fail with NotLeaderForPartition.
+func (mc *MockCluster) SetPartitionLeader(topic string, partition int32, brokerID int32) error {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cErr := C.rd_kafka_mock_partition_set_leader(mc.mcluster, cTopic,
+		C.int32_t(partition), C.int32_t(brokerID))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// SetPartitionFollower sets the preferred replica (follower) broker
+// for the given topic partition, which consumers will be directed to
+// fetch from if they have `client.rack` configured.
+func (mc *MockCluster) SetPartitionFollower(topic string, partition int32, brokerID int32) error {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cErr := C.rd_kafka_mock_partition_set_follower(mc.mcluster, cTopic,
+		C.int32_t(partition), C.int32_t(brokerID))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// SetPartitionFollowerWmarks sets the low and high watermarks returned by
+// the follower broker (see SetPartitionFollower()) for the given
+// topic partition, allowing the follower to lag behind the leader.
+// A value of -1 for low or high leaves the respective watermark with
+// the leader's value.
+func (mc *MockCluster) SetPartitionFollowerWmarks(topic string, partition int32, low int64, high int64) error {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cErr := C.rd_kafka_mock_partition_set_follower_wmarks(mc.mcluster, cTopic,
+		C.int32_t(partition), C.int64_t(low), C.int64_t(high))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// SetCoordinator explicitly sets the coordinator broker for the given
+// coordinator type and key, where keyType is either "transaction",
+// in which case key is the transactional.id, or "group", in which case key
+// is the consumer group.id.
+//
+// Clients will discover the new coordinator when their requests to the
+// previous coordinator fail with NotCoordinator.
+func (mc *MockCluster) SetCoordinator(keyType string, key string, brokerID int32) error {
+	cKeyType := C.CString(keyType)
+	defer C.free(unsafe.Pointer(cKeyType))
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	cErr := C.rd_kafka_mock_coordinator_set(mc.mcluster, cKeyType, cKey,
+		C.int32_t(brokerID))
+	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
+		return newError(cErr)
+	}
+	return nil
+}
+
+// Close and destroy the MockCluster.
+// Any clients connected to the mock cluster should be closed prior
+// to calling Close().
+func (mc *MockCluster) Close() {
+	C.rd_kafka_mock_cluster_destroy(mc.mcluster)
+	C.rd_kafka_destroy(mc.rk)
+}
@@ -0,0 +1,426 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// mockProduce produces msgcnt messages to topic on the given mock cluster,
+// waiting for all of them to be delivered.
+func mockProduce(t *testing.T, mc *MockCluster, topic string, partition int32, msgcnt int) {
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	drChan := make(chan Event, msgcnt)
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: partition},
+			Key:            []byte(fmt.Sprintf("key%d", i)),
+			Value:          []byte(fmt.Sprintf("value%d", i))},
+			drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	for i := 0; i < msgcnt; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+}
+
+// mockConsume reads msgcnt messages from consumer c, failing the test
+// if they're not all received within timeout.
+func mockConsume(t *testing.T, c *Consumer, msgcnt int, timeout time.Duration) []*Message {
+	msgs := make([]*Message, 0, msgcnt)
+	tEnd := time.Now().Add(timeout)
+	for len(msgs) < msgcnt {
+		if time.Now().After(tEnd) {
+			t.Fatalf("Only consumed %d/%d messages within %v",
+				len(msgs), msgcnt, timeout)
+		}
+		m, err := c.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			if err.(Error).Code() == ErrTimedOut {
+				continue
+			}
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+
+	return msgs
+}
+
+// TestMockCluster produces and consumes messages against a mock cluster.
+func TestMockCluster(t *testing.T) {
+	mc, err := NewMockCluster(3)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	bootstrapServers := mc.BootstrapServers()
+	if bootstrapServers == "" {
+		t.Fatalf("Expected non-empty BootstrapServers()")
+	}
+	t.Logf("Mock cluster bootstrap.servers: %s", bootstrapServers)
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 4, 3)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 100
+	mockProduce(t, mc, topic, PartitionAny, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": bootstrapServers,
+		"group.id":          "mockgroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt, 30*time.Second)
+
+	seen := make(map[string]bool)
+	for _, m := range msgs {
+		if *m.TopicPartition.Topic != topic {
+			t.Errorf("Message from unexpected topic: %v", m.TopicPartition)
+		}
+		seen[string(m.Key)] = true
+	}
+
+	if len(seen) != msgcnt {
+		t.Errorf("Expected %d unique messages, got %d", msgcnt, len(seen))
+	}
+}
+
+// TestMockClusterInvalidBrokerCount verifies that a mock cluster needs brokers.
+func TestMockClusterInvalidBrokerCount(t *testing.T) {
+	_, err := NewMockCluster(0)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Fatalf("Expected ErrInvalidArg, got %v", err)
+	}
+}
+
+// Kafka protocol request types used by the mock cluster tests.
+const (
+	mockAPIKeyProduce      = 0
+	mockAPIKeyListOffsets  = 2
+	mockAPIKeyOffsetCommit = 8
+	mockAPIKeyEndTxn       = 26
+)
+
+// TestMockClusterProduceError injects retriable Produce errors and
+// verifies that the producer keeps retrying until the errors are cleared.
+func TestMockClusterProduceError(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"retry.backoff.ms":   100,
+		"message.timeout.ms": 60000})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	// Fail more Produce requests than can be retried in the time
+	// we're waiting below.
+	for i := 0; i < 100; i++ {
+		mc.SetRoundtripError(mockAPIKeyProduce, ErrNotEnoughReplicas)
+	}
+
+	drChan := make(chan Event, 1)
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("retried")}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	select {
+	case ev := <-drChan:
+		t.Fatalf("Expected no delivery report while errors are injected, got %v", ev)
+	case <-time.After(1 * time.Second):
+	}
+
+	mc.ClearRoundtripErrors(mockAPIKeyProduce)
+
+	select {
+	case ev := <-drChan:
+		m := ev.(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Expected successful delivery after clearing errors, got %v",
+				m.TopicPartition)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Timed out waiting for delivery report")
+	}
+}
+
+// TestMockClusterBrokerDown verifies that produced messages are delivered
+// once a downed broker comes back up.
+func TestMockClusterBrokerDown(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	err = mc.SetBrokerDown(1)
+	if err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"message.timeout.ms": 60000})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	drChan := make(chan Event, 1)
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("delayed")}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	select {
+	case ev := <-drChan:
+		t.Fatalf("Expected no delivery report while broker is down, got %v", ev)
+	case <-time.After(1 * time.Second):
+	}
+
+	err = mc.SetBrokerUp(1)
+	if err != nil {
+		t.Fatalf("SetBrokerUp: %v", err)
+	}
+
+	select {
+	case ev := <-drChan:
+		m := ev.(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Expected successful delivery with broker up, got %v",
+				m.TopicPartition)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Timed out waiting for delivery report")
+	}
+}
+
+// TestMockClusterLeaderChange moves the partition leader mid-consume and
+// verifies that consumption continues without gaps or duplicates.
+func TestMockClusterLeaderChange(t *testing.T) {
+	mc, err := NewMockCluster(3)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 1, 3)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	err = mc.SetPartitionLeader(topic, 0, 1)
+	if err != nil {
+		t.Fatalf("SetPartitionLeader: %v", err)
+	}
+
+	msgcnt := 50
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":                  mc.BootstrapServers(),
+		"group.id":                           "mockgroup",
+		"topic.metadata.refresh.interval.ms": 1000})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, c, msgcnt/2, 30*time.Second)
+
+	err = mc.SetPartitionLeader(topic, 0, 2)
+	if err != nil {
+		t.Fatalf("SetPartitionLeader: %v", err)
+	}
+
+	mockProduce(t, mc, topic, 0, msgcnt)
+
+	msgs = append(msgs, mockConsume(t, c, msgcnt*2-len(msgs), 30*time.Second)...)
+
+	for i, m := range msgs {
+		if m.TopicPartition.Offset != Offset(i) {
+			t.Fatalf("Message #%d: expected offset %d, got %v",
+				i, i, m.TopicPartition)
+		}
+	}
+}
+
+// TestMockClusterInvalidPartition verifies that the partition control
+// APIs propagate errors for unknown partitions.
+func TestMockClusterInvalidPartition(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	err = mc.SetPartitionLeader(topic, 5, 1)
+	if err == nil {
+		t.Errorf("Expected SetPartitionLeader to fail for unknown partition")
+	}
+
+	err = mc.SetPartitionFollowerWmarks(topic, 5, 0, 10)
+	if err == nil {
+		t.Errorf("Expected SetPartitionFollowerWmarks to fail for unknown partition")
+	}
+
+	err = mc.SetPartitionFollowerWmarks(topic, 0, 0, 10)
+	if err != nil {
+		t.Errorf("SetPartitionFollowerWmarks: %v", err)
+	}
+}
+
+// TestMockClusterTxnCoordinatorChange moves the transaction coordinator
+// mid-transaction, and fails EndTxn with coordinator errors, verifying
+// that the transaction still commits.
+func TestMockClusterTxnCoordinatorChange(t *testing.T) {
+	mc, err := NewMockCluster(3)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "mocktopic"
+	err = mc.CreateTopic(topic, 1, 3)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	transactionalID := "mocktxnid"
+	err = mc.SetCoordinator("transaction", transactionalID, 1)
+	if err != nil {
+		t.Fatalf("SetCoordinator: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"transactional.id":  transactionalID})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = p.InitTransactions(ctx)
+	if err != nil {
+		t.Fatalf("InitTransactions: %v", err)
+	}
+
+	err = p.BeginTransaction()
+	if err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+
+	msgcnt := 10
+	drChan := make(chan Event, msgcnt)
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte(fmt.Sprintf("value%d", i))}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	// Move the coordinator and fail the first EndTxn attempts
+	// with coordinator errors.
+	err = mc.SetCoordinator("transaction", transactionalID, 2)
+	if err != nil {
+		t.Fatalf("SetCoordinator: %v", err)
+	}
+	mc.SetRoundtripError(mockAPIKeyEndTxn, ErrNotCoordinator)
+	mc.SetRoundtripError(mockAPIKeyEndTxn, ErrCoordinatorNotAvailable)
+
+	err = p.CommitTransaction(ctx)
+	if err != nil {
+		t.Fatalf("CommitTransaction: %v", err)
+	}
+
+	for i := 0; i < msgcnt; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+}
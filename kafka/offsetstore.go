@@ -0,0 +1,137 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+/*
+#include <stdlib.h>
+#include "select_rdkafka.h"
+*/
+import "C"
+
+// OffsetStore is an external store of consumer offsets, such as the
+// application's database, see Consumer.SetOffsetStore().
+type OffsetStore interface {
+	// Commit stores offsets, the offsets of the next messages to consume.
+	Commit(offsets []TopicPartition) error
+	// Fetch returns the stored offsets of partitions, with the Offset of
+	// partitions without a stored offset set to OffsetInvalid.
+	Fetch(partitions []TopicPartition) ([]TopicPartition, error)
+}
+
+// SetOffsetStore sets an external OffsetStore, or nil to remove a
+// previously set store, that Commit(), CommitMessage(), CommitOffsets() and
+// CommitStored() commit offsets to, in addition to Kafka if
+// alsoCommitToKafka is true, else instead of Kafka.
+// Offset-less commits commit the consumer's current position, see
+// Position(), of each assigned partition.
+// A failed store commit is returned as an Error with code ErrFail whose
+// Unwrap() returns the store's error, unless the store returned an Error.
+//
+// Assigned partitions are started from the offsets fetched from the store,
+// which are set in the AssignedPartitions event passed to the rebalance
+// callback, if any. Partitions without a stored offset, or all partitions
+// if the store fails, in which case an OFFSETSTORE error log is emitted,
+// are started from the offsets committed to Kafka, if any, or according
+// to `auto.offset.reset`.
+//
+// The consumer should be configured with `enable.auto.commit=false`,
+// since librdkafka's automatic commits only commit to Kafka.
+// Must be called before subscribing.
+func (c *Consumer) SetOffsetStore(store OffsetStore, alsoCommitToKafka bool) {
+	c.offsetStore = store
+	c.offsetStoreKafkaCommit = alsoCommitToKafka
+}
+
+// commitToOffsetStore commits offsets, or the current positions if offsets
+// is nil, to the external OffsetStore, returning the committed offsets.
+func (c *Consumer) commitToOffsetStore(offsets []TopicPartition) ([]TopicPartition, error) {
+	if offsets == nil {
+		assignment, err := c.Assignment()
+		if err != nil {
+			return nil, err
+		}
+
+		positions, err := c.Position(assignment)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tp := range positions {
+			if tp.Offset >= 0 {
+				offsets = append(offsets, tp)
+			}
+		}
+
+		if len(offsets) == 0 {
+			return nil, newError(C.RD_KAFKA_RESP_ERR__NO_OFFSET)
+		}
+	}
+
+	err := c.offsetStore.Commit(offsets)
+	if err != nil {
+		if _, ok := err.(Error); ok {
+			return nil, err
+		}
+		kerr := newErrorFromString(ErrFail, "OffsetStore.Commit: "+err.Error())
+		kerr.cause = err
+		return nil, kerr
+	}
+
+	return offsets, nil
+}
+
+// applyOffsetStore sets the offsets of cparts, the partitions of an assign
+// rebalance event, to the offsets fetched from the external OffsetStore.
+//
+// Called from the poll path only.
+func (c *Consumer) applyOffsetStore(cparts *C.rd_kafka_topic_partition_list_t) {
+	const logError = 3 // syslog LOG_ERR
+
+	partitions := newTopicPartitionsFromCparts(cparts)
+	if len(partitions) == 0 {
+		return
+	}
+
+	fetched, err := c.offsetStore.Fetch(partitions)
+	if err != nil {
+		c.handle.log(logError, "OFFSETSTORE", fmt.Sprintf(
+			"Failed to fetch offsets of %d assigned partition(s) from the "+
+				"offset store, using the committed offsets: %v",
+			len(partitions), err))
+		return
+	}
+
+	for _, tp := range fetched {
+		if tp.Topic == nil || tp.Offset < 0 {
+			continue
+		}
+
+		ctopic := C.CString(*tp.Topic)
+		rktpar := C.rd_kafka_topic_partition_list_find(cparts, ctopic,
+			C.int32_t(tp.Partition))
+		C.free(unsafe.Pointer(ctopic))
+
+		if rktpar != nil {
+			rktpar.offset = C.int64_t(tp.Offset)
+		}
+	}
+}
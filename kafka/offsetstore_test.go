@@ -0,0 +1,199 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestConsumerOffsetStore tests that commits go to the external
+// OffsetStore and that assigned partitions start from its offsets.
+func TestConsumerOffsetStore(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "offsetstoretopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 10)
+
+	store := &memOffsetStore{offsets: make(map[topicPartitionKey]Offset)}
+	store.offsets[topicPartitionKey{topic, 0}] = 3
+
+	// Consumes msgcnt messages, expecting to start at expectOffset,
+	// and commits.
+	consume := func(alsoCommitToKafka bool, msgcnt int, expectOffset Offset) {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":  mc.BootstrapServers(),
+			"group.id":           "offsetstoregroup",
+			"enable.auto.commit": false,
+			"auto.offset.reset":  "earliest",
+			"session.timeout.ms": 6000})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		defer c.Close()
+
+		c.SetOffsetStore(store, alsoCommitToKafka)
+
+		err = c.Subscribe(topic, nil)
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+
+		msgs := mockConsume(t, c, msgcnt, 30*time.Second)
+		if msgs[0].TopicPartition.Offset != expectOffset {
+			t.Fatalf("Expected first message at offset %v, got %v",
+				expectOffset, msgs[0].TopicPartition)
+		}
+
+		committed, err := c.Commit()
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		next := expectOffset + Offset(msgcnt)
+		if len(committed) != 1 || committed[0].Offset != next {
+			t.Fatalf("Expected offset %v committed, got %v", next, committed)
+		}
+
+		store.lock.Lock()
+		stored := store.offsets[topicPartitionKey{topic, 0}]
+		store.lock.Unlock()
+		if stored != next {
+			t.Fatalf("Expected offset %v stored, got %v", next, stored)
+		}
+
+		kafkaCommitted, err := c.Committed(committed, 10*1000)
+		if err != nil {
+			t.Fatalf("Committed: %v", err)
+		}
+		if alsoCommitToKafka && kafkaCommitted[0].Offset != next {
+			t.Fatalf("Expected offset %v committed to Kafka, got %v",
+				next, kafkaCommitted[0])
+		} else if !alsoCommitToKafka && kafkaCommitted[0].Offset != OffsetInvalid {
+			t.Fatalf("Expected no offset committed to Kafka, got %v",
+				kafkaCommitted[0])
+		}
+	}
+
+	// Starts at the stored offset rather than at the beginning.
+	consume(false, 2, 3)
+	// Resumes from the stored offset, also committing to Kafka.
+	consume(true, 2, 5)
+
+	// The stored offset takes precedence over the committed offset.
+	store.lock.Lock()
+	store.offsets[topicPartitionKey{topic, 0}] = 1
+	store.lock.Unlock()
+	consume(true, 2, 1)
+}
+
+// failingOffsetStore is an OffsetStore whose commits fail with err.
+type failingOffsetStore struct {
+	memOffsetStore
+	err error
+}
+
+func (s *failingOffsetStore) Commit(offsets []TopicPartition) error {
+	return s.err
+}
+
+// TestConsumerOffsetStoreCommitError verifies that OffsetStore commit
+// errors are returned as an Error wrapping the store's error, also from
+// the APIs that commit on behalf of the application.
+func TestConsumerOffsetStoreCommitError(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "offsetstorefailtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 4)
+
+	storeErr := errors.New("offset store unavailable")
+	store := &failingOffsetStore{
+		memOffsetStore: memOffsetStore{offsets: make(map[topicPartitionKey]Offset)},
+		err:            storeErr}
+
+	expectStoreError := func(what string, err error) {
+		kerr, ok := err.(Error)
+		if !ok || kerr.Code() != ErrFail {
+			t.Errorf("%s: Expected an Error with code ErrFail, got %v", what, err)
+			return
+		}
+		if !errors.Is(err, storeErr) {
+			t.Errorf("%s: Expected %v to wrap the store's error", what, err)
+		}
+	}
+
+	newConsumer := func() *Consumer {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":  mc.BootstrapServers(),
+			"group.id":           "offsetstorefailgroup",
+			"enable.auto.commit": false,
+			"auto.offset.reset":  "earliest",
+			"session.timeout.ms": 6000})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		c.SetOffsetStore(store, false)
+
+		err = c.Subscribe(topic, nil)
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		return c
+	}
+
+	c := newConsumer()
+	msgs := mockConsume(t, c, 4, 30*time.Second)
+
+	a := NewAcker(c)
+	for _, m := range msgs {
+		if err = a.Track(m); err != nil {
+			t.Fatalf("Track(%v): %v", m.TopicPartition, err)
+		}
+		a.Ack(m)
+	}
+	assignment, err := c.Assignment()
+	if err != nil {
+		t.Fatalf("Assignment: %v", err)
+	}
+	_, err = a.Revoke(assignment, time.Second)
+	expectStoreError("Acker.Revoke", err)
+	c.Close()
+
+	c = newConsumer()
+	mockConsume(t, c, 4, 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = c.LeaveGroupGracefully(ctx)
+	expectStoreError("LeaveGroupGracefully", err)
+}
@@ -0,0 +1,106 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// This file must only contain C declarations in its preamble since it
+// exports Go functions to C, see Producer's set_go_partitioner().
+
+/*
+#include "select_rdkafka.h"
+*/
+import "C"
+
+// Partitioner returns the partition, in the range 0..partitionCount-1,
+// to produce a message with the given key to, see `go.partitioner`.
+// key is nil for messages without a key.
+// Returning a partition outside the range fails the message with
+// ErrUnknownPartition.
+type Partitioner func(key []byte, partitionCount int32) int32
+
+// partitioners maps the ids passed to librdkafka as the topic opaque to
+// the producers' Partitioners.
+var partitioners = struct {
+	lock sync.RWMutex
+	next uintptr
+	m    map[uintptr]Partitioner
+}{m: make(map[uintptr]Partitioner)}
+
+// partitionerFromConfig converts the `go.partitioner` value v to a
+// Partitioner, returning nil if v is nil.
+func partitionerFromConfig(v ConfigValue) (Partitioner, error) {
+	switch p := v.(type) {
+	case nil:
+		return nil, nil
+	case Partitioner:
+		return p, nil
+	case func([]byte, int32) int32:
+		return p, nil
+	default:
+		return nil, newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("Expected go.partitioner to be a kafka.Partitioner, not %T", v))
+	}
+}
+
+// registerPartitioner registers partitioner and returns its id.
+func registerPartitioner(partitioner Partitioner) uintptr {
+	partitioners.lock.Lock()
+	defer partitioners.lock.Unlock()
+
+	partitioners.next++
+	partitioners.m[partitioners.next] = partitioner
+
+	return partitioners.next
+}
+
+// unregisterPartitioner removes the partitioner registered with id.
+func unregisterPartitioner(id uintptr) {
+	partitioners.lock.Lock()
+	defer partitioners.lock.Unlock()
+
+	delete(partitioners.m, id)
+}
+
+// goPartitionerCb is the librdkafka partitioner_cb for producers with
+// a `go.partitioner`, called from the application's Produce() goroutine
+// or from librdkafka's internal threads.
+//
+//export goPartitionerCb
+func goPartitionerCb(rkt *C.rd_kafka_topic_t, key unsafe.Pointer, keylen C.size_t,
+	partitionCnt C.int32_t, rktOpaque unsafe.Pointer, msgOpaque unsafe.Pointer) C.int32_t {
+
+	partitioners.lock.RLock()
+	partitioner := partitioners.m[uintptr(rktOpaque)]
+	partitioners.lock.RUnlock()
+
+	if partitioner == nil {
+		// Producer is closing.
+		return C.RD_KAFKA_PARTITION_UA
+	}
+
+	var goKey []byte
+	if key != nil {
+		goKey = C.GoBytes(key, C.int(keylen))
+	}
+
+	return C.int32_t(partitioner(goKey, int32(partitionCnt)))
+}
@@ -0,0 +1,59 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+)
+
+/*
+#include "select_rdkafka.h"
+*/
+import "C"
+
+// PartitionError is a consumer error scoped to a single partition, such as
+// a fetch error of the partition while the other partitions proceed, for
+// the application to pause or seek just that partition.
+// Needs to be explicitly enabled by setting the
+// `go.partition.error.event.enable` configuration property, else such
+// errors are emitted as Error events, without their partition.
+type PartitionError struct {
+	// TopicPartition is the partition, with the Offset at which the
+	// error occurred, if known.
+	TopicPartition TopicPartition
+	// Error is the partition's error.
+	Error Error
+}
+
+func (e PartitionError) String() string {
+	return fmt.Sprintf("PartitionError: %v: %v", e.TopicPartition, e.Error)
+}
+
+// newPartitionError returns a PartitionError event for the error event
+// rkev, with error err, if it is scoped to a partition, else nil.
+func newPartitionError(rkev *C.rd_kafka_event_t, err Error) Event {
+	crktpar := C.rd_kafka_event_topic_partition(rkev)
+	if crktpar == nil {
+		return nil
+	}
+	defer C.rd_kafka_topic_partition_destroy(crktpar)
+
+	var tp TopicPartition
+	setupTopicPartitionFromCrktpar(&tp, crktpar)
+
+	return PartitionError{TopicPartition: tp, Error: err}
+}
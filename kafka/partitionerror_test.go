@@ -0,0 +1,103 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConsumerPartitionError verifies that a fetch error of a single
+// partition is emitted as a PartitionError while the other partitions
+// proceed.
+func TestConsumerPartitionError(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "partitionerrortopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 3)
+	mockProduce(t, mc, topic, 1, 3)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":               mc.BootstrapServers(),
+		"group.id":                        "partitionerrorgroup",
+		"auto.offset.reset":               "error",
+		"go.partition.error.event.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	// Partition 0's fetch fails with an out of range offset.
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 1000},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	var partitionErr *PartitionError
+	msgcnt := 0
+	tEnd := time.Now().Add(10 * time.Second)
+	for (partitionErr == nil || msgcnt < 3) && time.Now().Before(tEnd) {
+		switch e := c.Poll(100).(type) {
+		case *Message:
+			if e.TopicPartition.Partition != 1 {
+				t.Fatalf("Unexpected message from %v", e.TopicPartition)
+			}
+			msgcnt++
+		case PartitionError:
+			partitionErr = &e
+		case Error:
+			t.Fatalf("Expected a PartitionError, got Error %v", e)
+		}
+	}
+
+	if partitionErr == nil {
+		t.Fatalf("Timed out waiting for PartitionError")
+	}
+	if *partitionErr.TopicPartition.Topic != topic ||
+		partitionErr.TopicPartition.Partition != 0 {
+		t.Errorf("Expected PartitionError for %s [0], got %v",
+			topic, partitionErr)
+	}
+	if partitionErr.Error.Code() != ErrAutoOffsetReset {
+		t.Errorf("Expected ErrAutoOffsetReset, got %v", partitionErr.Error)
+	}
+	if msgcnt != 3 {
+		t.Errorf("Expected 3 messages from partition 1, got %d", msgcnt)
+	}
+
+	// ReadMessage() returns the partition with the error.
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: 2000}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	msg, err := c.ReadMessage(10 * time.Second)
+	if err == nil || msg == nil || msg.TopicPartition.Partition != 0 ||
+		msg.TopicPartition.Error != err {
+		t.Errorf("Expected partition 0 error from ReadMessage, got %v, %v",
+			msg, err)
+	}
+}
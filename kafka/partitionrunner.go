@@ -0,0 +1,495 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+/*
+#include <stdlib.h>
+#include "select_rdkafka.h"
+*/
+import "C"
+
+// partitionRunnerPollInterval is the maximum time a PartitionRunner
+// goroutine blocks waiting for a message, and thus how long it takes to
+// notice that it has been stopped.
+const partitionRunnerPollInterval = 100
+
+// DefaultPartitionRunnerCommitInterval is the default interval at which
+// each PartitionRunner goroutine commits the offset of its partition.
+const DefaultPartitionRunnerCommitInterval = 5 * time.Second
+
+// PollBackoff returns the time a PartitionRunner goroutine waits before
+// polling its partition again after emptyPolls (1 or more) consecutive
+// polls that returned no message, see PartitionRunner.SetPollBackoff().
+type PollBackoff func(emptyPolls int) time.Duration
+
+// NewExponentialPollBackoff returns a PollBackoff waiting initial after
+// the first empty poll, doubled for each subsequent empty poll up to max.
+func NewExponentialPollBackoff(initial time.Duration, max time.Duration) PollBackoff {
+	return func(emptyPolls int) time.Duration {
+		backoff := initial
+		for i := 1; i < emptyPolls && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			backoff = max
+		}
+		return backoff
+	}
+}
+
+// PartitionHandler processes a message consumed by a PartitionRunner.
+// A returned error stops the PartitionRunner.
+type PartitionHandler func(msg *Message) error
+
+// partitionWorker consumes the split queue of a single partition.
+type partitionWorker struct {
+	topic     string
+	partition int32
+	rkq       *C.rd_kafka_queue_t
+	termChan  chan bool // Closed to stop the worker
+	doneChan  chan bool // Closed when the worker has stopped
+
+	lock sync.Mutex
+	// One past the offset of the last processed message,
+	// or OffsetInvalid if none.
+	offset Offset
+	// Last offset committed by the worker, or OffsetInvalid.
+	committed Offset
+
+	// Paused by the prefetch limit, only accessed by Run(), including
+	// the rebalance callback called from it.
+	prefetchPaused bool
+}
+
+// queueLength returns the number of messages and events buffered in w's
+// partition queue.
+func (w *partitionWorker) queueLength() int {
+	return int(C.rd_kafka_queue_length(w.rkq))
+}
+
+// PartitionRunner consumes each assigned partition in a goroutine of its
+// own, preserving per-partition ordering while processing partitions in
+// parallel.
+//
+// The PartitionRunner takes over the consumer's rebalancing: on
+// assignment each partition's queue is split off from the consumer queue
+// and a goroutine is started to consume it, on revocation the goroutines
+// of the revoked partitions are stopped, after finishing the message
+// being processed, and the offsets of their processed messages committed
+// before the partitions are unassigned.
+// Offsets are also committed periodically by each goroutine for its
+// partition, see SetCommitInterval().
+//
+// The Consumer must be configured with `enable.auto.commit=false` and
+// `enable.auto.offset.store=false`, so that offsets are only committed
+// once messages have been processed, and must not be used with the
+// Events() channel.
+type PartitionRunner struct {
+	c              *Consumer
+	handler        PartitionHandler
+	commitInterval time.Duration
+	pollBackoff    PollBackoff
+	prefetchLimit  int
+
+	lock    sync.Mutex
+	workers map[topicPartitionKey]*partitionWorker
+
+	errChan  chan error // First handler error
+	termChan chan bool  // Closed to stop Run()
+	stopOnce sync.Once
+}
+
+// NewPartitionRunner creates a PartitionRunner consuming from c and
+// processing messages with handler.
+func NewPartitionRunner(c *Consumer, handler PartitionHandler) *PartitionRunner {
+	return &PartitionRunner{
+		c:              c,
+		handler:        handler,
+		commitInterval: DefaultPartitionRunnerCommitInterval,
+		workers:        make(map[topicPartitionKey]*partitionWorker),
+		errChan:        make(chan error, 1),
+		termChan:       make(chan bool),
+	}
+}
+
+// SetCommitInterval sets the interval at which each partition's goroutine
+// commits the offset of its processed messages, 0 disables periodic
+// commits, leaving only the commits on revocation and Run() exit.
+// Must be called before Run().
+func (r *PartitionRunner) SetCommitInterval(interval time.Duration) {
+	r.commitInterval = interval
+}
+
+// SetPollBackoff sets the backoff each partition's goroutine applies
+// between polls that return no message, resetting as soon as a message
+// arrives, which reduces the idle CPU usage on low-traffic partitions
+// without increasing the latency while messages are flowing, but does
+// delay the first message after an idle period by up to the backoff.
+// nil, the default, disables the backoff, for latency-sensitive
+// applications.
+// Must be called before Run().
+func (r *PartitionRunner) SetPollBackoff(backoff PollBackoff) {
+	r.pollBackoff = backoff
+}
+
+// SetPrefetchLimit bounds the number of messages buffered in memory for
+// each partition, independent of the global `queued.min.messages` and
+// `queued.max.messages.kbytes` fetch settings: a partition is paused once
+// limit messages are buffered for it and resumed once its goroutine has
+// drained it to half the limit. The limit is checked by Run() between
+// polls, at least every 100ms, and the messages fetched meanwhile, as
+// well as fetch responses in flight when a partition is paused, are still
+// buffered, so the limit may be exceeded by the messages fetched in that
+// time.
+// 0, the default, disables the limit.
+// Must be called before Run().
+func (r *PartitionRunner) SetPrefetchLimit(limit int) {
+	r.prefetchLimit = limit
+}
+
+// Subscribe subscribes the consumer to topics with the PartitionRunner's
+// rebalance callback.
+// This replaces the current subscription.
+func (r *PartitionRunner) Subscribe(topics []string) error {
+	return r.c.SubscribeTopics(topics, r.rebalance)
+}
+
+// Run polls the consumer, serving rebalances, until Stop() is called, in
+// which case nil is returned, or a handler returns an error, which is
+// returned. Fatal consumer errors are also returned, other consumer
+// errors are ignored.
+//
+// On return all partition goroutines have been stopped and the offsets of
+// their processed messages committed, the partitions remain assigned.
+func (r *PartitionRunner) Run() (err error) {
+	defer func() {
+		cerr := r.stopWorkers(nil)
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	for {
+		select {
+		case <-r.termChan:
+			return nil
+		case err = <-r.errChan:
+			return err
+		default:
+		}
+
+		ev := r.c.Poll(100)
+		if e, ok := ev.(Error); ok && e.IsFatal() {
+			return e
+		}
+		r.enforcePrefetchLimits()
+		// Messages are consumed from the partition queues, none
+		// are expected on the consumer queue.
+	}
+}
+
+// Stop stops Run().
+func (r *PartitionRunner) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.termChan)
+	})
+}
+
+// rebalance is the PartitionRunner's rebalance callback.
+func (r *PartitionRunner) rebalance(c *Consumer, ev Event) error {
+	cooperative := c.GetRebalanceProtocol() == "COOPERATIVE"
+
+	switch e := ev.(type) {
+	case AssignedPartitions:
+		// Split off the partition queues before the partitions are
+		// assigned, so that no messages end up on the consumer queue.
+		for _, tp := range e.Partitions {
+			err := r.startWorker(tp)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cooperative {
+			return c.IncrementalAssign(e.Partitions)
+		}
+		return c.Assign(e.Partitions)
+
+	case RevokedPartitions:
+		// Commit errors are ignored, the partitions must be
+		// unassigned regardless.
+		r.stopWorkers(e.Partitions)
+
+		if cooperative {
+			return c.IncrementalUnassign(e.Partitions)
+		}
+		return c.Unassign()
+	}
+
+	return nil
+}
+
+// startWorker starts a goroutine consuming partition tp from its split
+// partition queue.
+func (r *PartitionRunner) startWorker(tp TopicPartition) error {
+	key := topicPartitionKey{*tp.Topic, tp.Partition}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.workers[key]; found {
+		return nil
+	}
+
+	cTopic := C.CString(*tp.Topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	rkq := C.rd_kafka_queue_get_partition(r.c.handle.rk, cTopic,
+		C.int32_t(tp.Partition))
+	if rkq == nil {
+		return newErrorFromString(ErrUnknownPartition,
+			fmt.Sprintf("Unable to get partition queue for %v", tp))
+	}
+	// Stop forwarding the partition queue to the consumer queue.
+	C.rd_kafka_queue_forward(rkq, nil)
+
+	w := &partitionWorker{
+		topic:     *tp.Topic,
+		partition: tp.Partition,
+		rkq:       rkq,
+		termChan:  make(chan bool),
+		doneChan:  make(chan bool),
+		offset:    OffsetInvalid,
+		committed: OffsetInvalid,
+	}
+	r.workers[key] = w
+
+	go func() {
+		defer close(w.doneChan)
+		r.work(w)
+	}()
+
+	return nil
+}
+
+// work consumes and processes the messages of w's partition until
+// w.termChan is closed or the handler fails.
+func (r *PartitionRunner) work(w *partitionWorker) {
+	var tick <-chan time.Time
+	if r.commitInterval > 0 {
+		ticker := time.NewTicker(r.commitInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	emptyPolls := 0
+
+	for {
+		select {
+		case <-w.termChan:
+			return
+		case <-tick:
+			r.commit([]*partitionWorker{w})
+		default:
+		}
+
+		cmsg := C.rd_kafka_consume_queue(w.rkq, partitionRunnerPollInterval)
+		if cmsg == nil {
+			emptyPolls++
+			r.backoff(w, emptyPolls)
+			continue
+		}
+		msg := r.c.handle.newMessageFromC(cmsg)
+		C.rd_kafka_message_destroy(cmsg)
+
+		if msg.TopicPartition.Error != nil {
+			// Partition EOF and consumer errors are ignored.
+			continue
+		}
+		emptyPolls = 0
+
+		err := r.handler(msg)
+		if err != nil {
+			select {
+			case r.errChan <- err:
+			default:
+				// Run() is already stopping
+			}
+			return
+		}
+
+		w.lock.Lock()
+		w.offset = msg.TopicPartition.Offset + 1
+		w.lock.Unlock()
+	}
+}
+
+// enforcePrefetchLimits pauses the partitions whose queue has reached the
+// prefetch limit, and resumes those drained to half the limit.
+func (r *PartitionRunner) enforcePrefetchLimits() {
+	if r.prefetchLimit <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, w := range r.workers {
+		qlen := w.queueLength()
+		if !w.prefetchPaused && qlen >= r.prefetchLimit {
+			r.setPrefetchPaused(w, true)
+		} else if w.prefetchPaused && qlen <= r.prefetchLimit/2 {
+			r.setPrefetchPaused(w, false)
+		}
+	}
+}
+
+// setPrefetchPaused pauses or resumes w's partition for the prefetch
+// limit, if not already.
+func (r *PartitionRunner) setPrefetchPaused(w *partitionWorker, paused bool) {
+	if w.prefetchPaused == paused {
+		return
+	}
+
+	partitions := []TopicPartition{{Topic: &w.topic, Partition: w.partition}}
+	var err error
+	if paused {
+		err = r.c.Pause(partitions)
+	} else {
+		err = r.c.Resume(partitions)
+	}
+	if err != nil {
+		// Retried on the next check.
+		return
+	}
+
+	w.prefetchPaused = paused
+}
+
+// backoff waits for the PollBackoff after emptyPolls consecutive empty
+// polls of w's partition, or until w.termChan is closed.
+func (r *PartitionRunner) backoff(w *partitionWorker, emptyPolls int) {
+	if r.pollBackoff == nil {
+		return
+	}
+
+	backoff := r.pollBackoff(emptyPolls)
+	if backoff <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-w.termChan:
+	case <-timer.C:
+	}
+}
+
+// stopWorkers stops the goroutines of partitions, or of all partitions if
+// partitions is nil, waits for them to finish processing their current
+// message, commits their processed offsets and releases their partition
+// queues.
+func (r *PartitionRunner) stopWorkers(partitions []TopicPartition) error {
+	r.lock.Lock()
+	var stopped []*partitionWorker
+	if partitions == nil {
+		for key, w := range r.workers {
+			stopped = append(stopped, w)
+			delete(r.workers, key)
+		}
+	} else {
+		for _, tp := range partitions {
+			key := topicPartitionKey{*tp.Topic, tp.Partition}
+			if w, found := r.workers[key]; found {
+				stopped = append(stopped, w)
+				delete(r.workers, key)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	for _, w := range stopped {
+		close(w.termChan)
+	}
+	for _, w := range stopped {
+		<-w.doneChan
+	}
+
+	err := r.commit(stopped)
+
+	for _, w := range stopped {
+		// Don't leave the partition paused, e.g., when it is
+		// assigned again.
+		r.setPrefetchPaused(w, false)
+		C.rd_kafka_queue_destroy(w.rkq)
+	}
+
+	return err
+}
+
+// commit commits the processed offsets of workers that have advanced
+// since their last commit.
+func (r *PartitionRunner) commit(workers []*partitionWorker) error {
+	var offsets []TopicPartition
+	for _, w := range workers {
+		w.lock.Lock()
+		if w.offset != OffsetInvalid && w.offset != w.committed {
+			topic := w.topic
+			offsets = append(offsets, TopicPartition{
+				Topic:     &topic,
+				Partition: w.partition,
+				Offset:    w.offset,
+			})
+		}
+		w.lock.Unlock()
+	}
+
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	committed, err := r.c.CommitOffsets(offsets)
+	if err != nil {
+		return err
+	}
+
+	for _, tp := range committed {
+		if tp.Error != nil {
+			continue
+		}
+		for _, w := range workers {
+			if w.topic == *tp.Topic && w.partition == tp.Partition {
+				w.lock.Lock()
+				if tp.Offset > w.committed {
+					w.committed = tp.Offset
+				}
+				w.lock.Unlock()
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPartitionRunner verifies that a PartitionRunner processes each
+// partition in order, partitions in parallel, and commits the processed
+// offsets of each partition.
+func TestPartitionRunner(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "partitionrunnertopic"
+	partitionCnt := 4
+	err = mc.CreateTopic(topic, partitionCnt, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	msgcnt := 10
+	for partition := 0; partition < partitionCnt; partition++ {
+		mockProduce(t, mc, topic, int32(partition), msgcnt)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "partitionrunnergroup",
+		"auto.offset.reset":        "earliest",
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	var lock sync.Mutex
+	offsets := make(map[int32][]Offset)
+	active := 0
+	maxActive := 0
+	done := make(chan bool)
+	processed := 0
+
+	r := NewPartitionRunner(c, func(msg *Message) error {
+		lock.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		active--
+		p := msg.TopicPartition.Partition
+		offsets[p] = append(offsets[p], msg.TopicPartition.Offset)
+		processed++
+		if processed == msgcnt*partitionCnt {
+			close(done)
+		}
+		return nil
+	})
+
+	err = r.Subscribe([]string{topic})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Errorf("Timed out waiting for messages to be processed")
+	}
+
+	r.Stop()
+	err = <-runErr
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	lock.Lock()
+	for partition := int32(0); partition < int32(partitionCnt); partition++ {
+		if len(offsets[partition]) != msgcnt {
+			t.Errorf("Partition %d: expected %d messages, got %v",
+				partition, msgcnt, offsets[partition])
+			continue
+		}
+		for i, offset := range offsets[partition] {
+			if offset != Offset(i) {
+				t.Errorf("Partition %d: expected offset %d, got %v",
+					partition, i, offsets[partition])
+				break
+			}
+		}
+	}
+	if maxActive < 2 {
+		t.Errorf("Expected partitions to be processed in parallel, "+
+			"at most %d were", maxActive)
+	}
+	lock.Unlock()
+
+	var partitions []TopicPartition
+	for partition := int32(0); partition < int32(partitionCnt); partition++ {
+		partitions = append(partitions,
+			TopicPartition{Topic: &topic, Partition: partition})
+	}
+	committed, err := c.Committed(partitions, 5000)
+	if err != nil {
+		t.Fatalf("Committed: %v", err)
+	}
+	for _, tp := range committed {
+		if tp.Offset != Offset(msgcnt) {
+			t.Errorf("Expected committed offset %d, got %v", msgcnt, tp)
+		}
+	}
+}
+
+// TestPartitionRunnerPollBackoff verifies that the PollBackoff is applied
+// between empty polls and reset when messages arrive.
+func TestPartitionRunnerPollBackoff(t *testing.T) {
+	exponential := NewExponentialPollBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for emptyPolls, expected := range map[int]time.Duration{
+		1:   10 * time.Millisecond,
+		2:   20 * time.Millisecond,
+		3:   40 * time.Millisecond,
+		4:   50 * time.Millisecond,
+		100: 50 * time.Millisecond} {
+		if backoff := exponential(emptyPolls); backoff != expected {
+			t.Errorf("Expected backoff %v after %d empty polls, got %v",
+				expected, emptyPolls, backoff)
+		}
+	}
+
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "partitionrunnerbackofftopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "partitionrunnerbackoffgroup",
+		"auto.offset.reset":        "earliest",
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	var lock sync.Mutex
+	var emptyPollsSeen []int
+	processed := make(chan bool, 10)
+
+	r := NewPartitionRunner(c, func(msg *Message) error {
+		processed <- true
+		return nil
+	})
+	r.SetPollBackoff(func(emptyPolls int) time.Duration {
+		lock.Lock()
+		emptyPollsSeen = append(emptyPollsSeen, emptyPolls)
+		lock.Unlock()
+		return 10 * time.Millisecond
+	})
+
+	err = r.Subscribe([]string{topic})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run()
+	}()
+
+	// Waits until at least cnt consecutive empty polls have been seen.
+	waitEmptyPolls := func(cnt int) {
+		tEnd := time.Now().Add(30 * time.Second)
+		for time.Now().Before(tEnd) {
+			lock.Lock()
+			seen := len(emptyPollsSeen) > 0 &&
+				emptyPollsSeen[len(emptyPollsSeen)-1] >= cnt
+			lock.Unlock()
+			if seen {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %d empty polls", cnt)
+	}
+
+	waitEmptyPolls(3)
+
+	mockProduce(t, mc, topic, 0, 1)
+	select {
+	case <-processed:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Timed out waiting for message to be processed")
+	}
+
+	waitEmptyPolls(1)
+
+	r.Stop()
+	err = <-runErr
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i := 1; i < len(emptyPollsSeen); i++ {
+		prev, cur := emptyPollsSeen[i-1], emptyPollsSeen[i]
+		if cur != prev+1 && cur != 1 {
+			t.Fatalf("Expected consecutive empty poll counts, got %v",
+				emptyPollsSeen)
+		}
+	}
+	reset := false
+	for i := 1; i < len(emptyPollsSeen); i++ {
+		if emptyPollsSeen[i] == 1 && emptyPollsSeen[i-1] >= 3 {
+			reset = true
+		}
+	}
+	if !reset {
+		t.Errorf("Expected empty poll count to be reset by the message, got %v",
+			emptyPollsSeen)
+	}
+}
+
+// TestPartitionRunnerPrefetchLimit verifies that a fast-producing
+// partition is paused once its buffered messages reach the prefetch
+// limit, without affecting other partitions, and resumed as the messages
+// are processed.
+func TestPartitionRunnerPrefetchLimit(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "partitionrunnerprefetchtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"group.id":                 "partitionrunnerprefetchgroup",
+		"auto.offset.reset":        "earliest",
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	limit := 20
+	fastCnt := 300
+	extraCnt := 100
+	release := make(chan bool)
+	var lock sync.Mutex
+	processed := make(map[int32]int)
+	done := make(chan bool)
+
+	r := NewPartitionRunner(c, func(msg *Message) error {
+		partition := msg.TopicPartition.Partition
+		if partition == 0 {
+			<-release
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		processed[partition]++
+		if processed[0] == fastCnt+extraCnt && processed[1] == 1 {
+			close(done)
+		}
+		return nil
+	})
+	r.SetPrefetchLimit(limit)
+
+	err = r.Subscribe([]string{topic})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run()
+	}()
+
+	// Waits for the partition workers to be started.
+	var worker *partitionWorker
+	tEnd := time.Now().Add(30 * time.Second)
+	for worker == nil {
+		if time.Now().After(tEnd) {
+			t.Fatalf("Timed out waiting for assignment")
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		r.lock.Lock()
+		if len(r.workers) == 2 {
+			worker = r.workers[topicPartitionKey{topic, 0}]
+		}
+		r.lock.Unlock()
+	}
+
+	// Partition 0 is produced to continuously, partition 1 once.
+	mockProduce(t, mc, topic, 1, 1)
+	for i := 0; i < fastCnt; i += 10 {
+		mockProduce(t, mc, topic, 0, 10)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The buffered messages must be bounded, with some slack for the
+	// messages fetched before pausing, and not grow while paused.
+	time.Sleep(500 * time.Millisecond)
+	r.lock.Lock()
+	paused := worker.prefetchPaused
+	r.lock.Unlock()
+	if !paused {
+		t.Errorf("Expected partition 0 to be paused")
+	}
+	qlen := worker.queueLength()
+	if qlen < limit || qlen > fastCnt/2 {
+		t.Errorf("Expected about %d buffered messages, got %d", limit, qlen)
+	}
+
+	mockProduce(t, mc, topic, 0, extraCnt)
+	time.Sleep(500 * time.Millisecond)
+	if pausedQlen := worker.queueLength(); pausedQlen != qlen {
+		t.Errorf("Expected %d buffered messages while paused, got %d",
+			qlen, pausedQlen)
+	}
+
+	lock.Lock()
+	if processed[1] != 1 {
+		t.Errorf("Expected partition 1 to be processed, got %v", processed)
+	}
+	lock.Unlock()
+
+	// Draining resumes the partition, processing all messages.
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		lock.Lock()
+		t.Errorf("Timed out waiting for messages to be processed: %v",
+			processed)
+		lock.Unlock()
+	}
+
+	r.Stop()
+	err = <-runErr
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
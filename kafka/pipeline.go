@@ -0,0 +1,336 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// pipelineMaxTxnMessages is the maximum number of consumed messages
+// processed in a single Pipeline transaction.
+const pipelineMaxTxnMessages = 1000
+
+// pipelineCommitTimeout is the maximum time Run() blocks committing, or
+// aborting, a transaction.
+const pipelineCommitTimeout = 30 * time.Second
+
+// PipelineProcessor transforms a consumed message into the messages to
+// produce for it, see NewPipeline().
+type PipelineProcessor func(msg *Message) ([]*Message, error)
+
+// Pipeline runs an exactly-once consume-transform-produce loop over a
+// Consumer and a transactional Producer, and coordinates their shutdown.
+//
+// Each consumed message is passed to the PipelineProcessor and the
+// returned messages are produced in a transaction along with the
+// consumer's offsets, which is committed whenever the consumer has no
+// more messages ready, or after pipelineMaxTxnMessages messages.
+type Pipeline struct {
+	c       *Consumer
+	p       *Producer
+	process PipelineProcessor
+
+	// Private delivery report channel, drained by a go-routine since
+	// transaction commits report delivery failures.
+	drChan chan Event
+
+	// Transaction state, only accessed by Run() and, once Run() has
+	// returned, Shutdown().
+	inTxn  bool
+	txnCnt int
+
+	lock     sync.Mutex
+	doneChan chan bool // Closed when Run() returns
+	termChan chan bool // Closed to stop Run()
+	stopOnce sync.Once
+}
+
+// NewPipeline creates a Pipeline consuming from c, which must have been
+// created with `enable.auto.commit=false` and have a subscription or
+// assignment, processing messages with process, and producing the
+// results with p, on which InitTransactions() must have been called.
+//
+// The Pipeline takes ownership of c and p which are closed by Shutdown().
+func NewPipeline(c *Consumer, p *Producer, process PipelineProcessor) *Pipeline {
+	pl := &Pipeline{
+		c:        c,
+		p:        p,
+		process:  process,
+		drChan:   make(chan Event, 1000),
+		termChan: make(chan bool),
+	}
+
+	go func() {
+		for range pl.drChan {
+		}
+	}()
+
+	return pl
+}
+
+// Run consumes, processes and produces messages until Shutdown() is
+// called, in which case nil is returned leaving the current transaction,
+// if any, to Shutdown().
+//
+// If processing or producing a message fails, or a transaction fails to
+// commit, the transaction is aborted, the consumer is rewound to the
+// committed offsets and the error is returned. The application may then
+// call Run() again to retry, or Shutdown().
+// Fatal consumer errors are returned as well, other consumer errors
+// are ignored.
+func (pl *Pipeline) Run() error {
+	pl.lock.Lock()
+	if pl.doneChan != nil {
+		pl.lock.Unlock()
+		return newErrorFromString(ErrState, "Pipeline is already running")
+	}
+	done := make(chan bool)
+	pl.doneChan = done
+	pl.lock.Unlock()
+
+	defer func() {
+		pl.lock.Lock()
+		pl.doneChan = nil
+		pl.lock.Unlock()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-pl.termChan:
+			return nil
+		default:
+		}
+
+		msg, err := pl.c.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			kerr, ok := err.(Error)
+			if ok && kerr.Code() == ErrTimedOut {
+				// Nothing more to consume right now.
+				if pl.inTxn {
+					err = pl.commitTransaction(pipelineCommitTimeout)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if ok && kerr.IsFatal() {
+				return err
+			}
+			continue
+		}
+
+		if !pl.inTxn {
+			err = pl.p.BeginTransaction()
+			if err != nil {
+				return err
+			}
+			pl.inTxn = true
+			pl.txnCnt = 0
+		}
+
+		err = pl.processMessage(msg)
+		if err != nil {
+			return pl.abortTransaction(pipelineCommitTimeout, err)
+		}
+
+		pl.txnCnt++
+		if pl.txnCnt >= pipelineMaxTxnMessages {
+			err = pl.commitTransaction(pipelineCommitTimeout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// processMessage processes msg and produces the results.
+func (pl *Pipeline) processMessage(msg *Message) error {
+	results, err := pl.process(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		err = pl.p.Produce(result, pl.drChan)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commitTransaction commits the current transaction along with the
+// consumer's positions, aborting it on failure.
+func (pl *Pipeline) commitTransaction(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := pl.sendOffsets(ctx)
+	if err == nil {
+		err = pl.p.CommitTransaction(ctx)
+	}
+	if err != nil {
+		return pl.abortTransaction(timeout, err)
+	}
+
+	pl.inTxn = false
+
+	return nil
+}
+
+// sendOffsets adds the consumer's positions to the current transaction.
+func (pl *Pipeline) sendOffsets(ctx context.Context) error {
+	assignment, err := pl.c.Assignment()
+	if err != nil {
+		return err
+	}
+
+	positions, err := pl.c.Position(assignment)
+	if err != nil {
+		return err
+	}
+
+	offsets := make([]TopicPartition, 0, len(positions))
+	for _, tp := range positions {
+		// Skip partitions that nothing has been consumed from.
+		if tp.Offset >= 0 {
+			offsets = append(offsets, tp)
+		}
+	}
+
+	cgmd, err := pl.c.GetConsumerGroupMetadata()
+	if err != nil {
+		return err
+	}
+
+	return pl.p.SendOffsetsToTransaction(ctx, offsets, cgmd)
+}
+
+// abortTransaction aborts the current transaction, which failed with
+// cause, and rewinds the consumer to the committed offsets so that the
+// aborted messages are consumed again.
+// Returns the abort or rewind error, if any, else cause.
+func (pl *Pipeline) abortTransaction(timeout time.Duration, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := pl.p.AbortTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	pl.inTxn = false
+
+	err = pl.rewind(timeout)
+	if err != nil {
+		return err
+	}
+
+	return cause
+}
+
+// rewind seeks the consumer's assigned partitions back to the committed
+// offsets, or to `auto.offset.reset` if there is no committed offset.
+func (pl *Pipeline) rewind(timeout time.Duration) error {
+	assignment, err := pl.c.Assignment()
+	if err != nil {
+		return err
+	}
+
+	for i := range assignment {
+		assignment[i].Offset = OffsetStored
+	}
+
+	resolved, err := pl.c.ResolveOffsets(assignment, int(timeout/time.Millisecond))
+	if err != nil {
+		return err
+	}
+
+	for _, tp := range resolved {
+		if tp.Error != nil {
+			return tp.Error
+		}
+		err = pl.c.Seek(tp, int(timeout/time.Millisecond))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Shutdown stops the Pipeline in the order required for exactly-once
+// processing:
+//  1. Run() is stopped, once it has finished processing the current
+//     message, so that no more messages are consumed,
+//  2. the current transaction, if any, is committed along with the
+//     consumer's offsets, and aborted if the commit fails,
+//  3. the producer is flushed,
+//  4. the consumer and the producer are closed.
+//
+// Shutdown proceeds with the remaining steps if a step fails, returning
+// the first error. If Run() does not stop within timeout ErrTimedOut is
+// returned and neither the consumer nor the producer are closed, call
+// Shutdown() again to retry.
+// timeout also bounds the commit and flush, not the closing of the
+// consumer and producer.
+func (pl *Pipeline) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	pl.stopOnce.Do(func() {
+		close(pl.termChan)
+	})
+
+	pl.lock.Lock()
+	done := pl.doneChan
+	pl.lock.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			return newErrorFromString(ErrTimedOut,
+				"Timed out waiting for the pipeline to stop processing")
+		}
+	}
+
+	var err error
+	if pl.inTxn {
+		err = pl.commitTransaction(time.Until(deadline))
+	}
+
+	remainingMs := int(math.Max(0, float64(time.Until(deadline)/time.Millisecond)))
+	if r := pl.p.Flush(remainingMs); r > 0 && err == nil {
+		err = newErrorFromString(ErrTimedOut,
+			fmt.Sprintf("%d messages and requests not flushed", r))
+	}
+
+	if cerr := pl.c.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	pl.p.Close()
+	close(pl.drChan)
+
+	return err
+}
@@ -0,0 +1,136 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestPipelineShutdown shuts down a pipeline mid-stream and verifies
+// that every message processed before the shutdown was produced exactly
+// once. The mock cluster does not store transactional offset commits, so
+// resuming from the committed offsets is not covered here.
+func TestPipelineShutdown(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	inTopic := "pipelineintopic"
+	outTopic := "pipelineouttopic"
+	msgcnt := 100
+	mockProduce(t, mc, inTopic, 0, msgcnt)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"group.id":           "pipelinegroup",
+		"enable.auto.commit": false,
+		"auto.offset.reset":  "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+
+	err = c.Assign([]TopicPartition{{Topic: &inTopic, Partition: 0, Offset: OffsetStored}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"transactional.id":  "pipelinetxnid"})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = p.InitTransactions(ctx)
+	if err != nil {
+		t.Fatalf("InitTransactions: %v", err)
+	}
+
+	// Only accessed from Run() until it has returned.
+	var processed []string
+	stop := make(chan bool)
+	pl := NewPipeline(c, p, func(msg *Message) ([]*Message, error) {
+		processed = append(processed, string(msg.Value))
+		if len(processed) == msgcnt/2 {
+			close(stop)
+		}
+		return []*Message{{
+			TopicPartition: TopicPartition{Topic: &outTopic, Partition: 0},
+			Value:          msg.Value}}, nil
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- pl.Run()
+	}()
+
+	select {
+	case <-stop:
+	case err = <-runErr:
+		t.Fatalf("Run: %v", err)
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Timed out waiting for messages to be processed")
+	}
+
+	err = pl.Shutdown(30 * time.Second)
+	if err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err = <-runErr; err != nil {
+		t.Errorf("Expected Run to return nil after Shutdown, got %v", err)
+	}
+
+	for i, value := range processed {
+		if exp := fmt.Sprintf("value%d", i); value != exp {
+			t.Fatalf("Expected input message %d to be %s, got %s", i, exp, value)
+		}
+	}
+
+	vc, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "pipelineverifier"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer vc.Close()
+
+	err = vc.Assign([]TopicPartition{{Topic: &outTopic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	msgs := mockConsume(t, vc, len(processed), 30*time.Second)
+	for i, m := range msgs {
+		if string(m.Value) != processed[i] {
+			t.Fatalf("Expected output message %d to be %s, got %s", i, processed[i], m.Value)
+		}
+	}
+
+	m, err := vc.ReadMessage(time.Second)
+	if err == nil {
+		t.Errorf("Expected %d output messages, got extra %v: %s",
+			len(processed), m.TopicPartition, m.Value)
+	}
+}
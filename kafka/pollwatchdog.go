@@ -0,0 +1,170 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MaxPollIntervalWarning indicates that the application has not called
+// Poll() or ReadMessage() for `go.max.poll.interval.warn.pct` percent of
+// `max.poll.interval.ms`, after which the consumer leaves the group and
+// its partitions are reassigned to other members, see
+// SetOnMaxPollIntervalWarning().
+type MaxPollIntervalWarning struct {
+	// SinceLastPoll is the time since the last Poll() returned
+	SinceLastPoll time.Duration
+	// MaxPollInterval is the configured `max.poll.interval.ms`
+	MaxPollInterval time.Duration
+}
+
+func (e MaxPollIntervalWarning) String() string {
+	return fmt.Sprintf("MaxPollIntervalWarning: %v since last poll (max.poll.interval.ms %v)",
+		e.SinceLastPoll, e.MaxPollInterval)
+}
+
+// MaxPollIntervalWarningCb is called when the application is about to
+// exceed `max.poll.interval.ms`, see SetOnMaxPollIntervalWarning().
+type MaxPollIntervalWarningCb func(*Consumer, MaxPollIntervalWarning)
+
+// pollWatchdog tracks the time since the last poll for
+// `go.max.poll.interval.warn.pct`.
+type pollWatchdog struct {
+	maxPollInterval time.Duration
+	warnAfter       time.Duration
+	// Time the last poll returned in UnixNano, or math.MaxInt64 while
+	// polling.
+	lastPoll int64
+	// Non-zero once the warning has been emitted since the last poll.
+	warned int32
+	cb     atomic.Value // MaxPollIntervalWarningCb
+}
+
+// SetOnMaxPollIntervalWarning sets a callback that is called, with
+// `go.max.poll.interval.warn.pct` configured, when the time since the last
+// Poll() or ReadMessage() reaches that percentage of
+// `max.poll.interval.ms`, giving the application a chance to react, e.g.,
+// by cutting processing of the current batch short, before the consumer is
+// removed from the group.
+// A MAXPOLL warning log is emitted regardless of the callback.
+//
+// The callback is called at most once between polls, from an internal
+// goroutine, and must not block.
+func (c *Consumer) SetOnMaxPollIntervalWarning(cb MaxPollIntervalWarningCb) {
+	if c.pollWatchdog != nil {
+		c.pollWatchdog.cb.Store(cb)
+	}
+}
+
+// setupPollWatchdog sets up and starts the `go.max.poll.interval.warn.pct`
+// watchdog, which runs until termChan is closed.
+func (c *Consumer) setupPollWatchdog(pct int, termChan chan bool) {
+	v, err := c.handle.getConfigValue("max.poll.interval.ms")
+	if err != nil {
+		// Shouldn't happen, max.poll.interval.ms is always set.
+		return
+	}
+	ms, _ := strconv.Atoi(v)
+
+	w := &pollWatchdog{
+		maxPollInterval: time.Duration(ms) * time.Millisecond,
+		warnAfter:       time.Duration(ms) * time.Millisecond * time.Duration(pct) / 100,
+		lastPoll:        time.Now().UnixNano(),
+	}
+	w.cb.Store(MaxPollIntervalWarningCb(nil))
+	c.pollWatchdog = w
+
+	checkInterval := w.warnAfter / 20
+	if checkInterval < 10*time.Millisecond {
+		checkInterval = 10 * time.Millisecond
+	} else if checkInterval > time.Second {
+		checkInterval = time.Second
+	}
+
+	c.handle.waitGroup.Add(1)
+	go func() {
+		defer c.handle.waitGroup.Done()
+
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-termChan:
+				return
+			case <-ticker.C:
+				c.checkPollWatchdog()
+			}
+		}
+	}()
+}
+
+// pollWatchdogEnter marks the start of a poll.
+func (c *Consumer) pollWatchdogEnter() {
+	atomic.StoreInt64(&c.pollWatchdog.lastPoll, math.MaxInt64)
+}
+
+// pollWatchdogExit marks the end of a poll, re-arming the warning.
+func (c *Consumer) pollWatchdogExit() {
+	atomic.StoreInt64(&c.pollWatchdog.lastPoll, time.Now().UnixNano())
+	atomic.StoreInt32(&c.pollWatchdog.warned, 0)
+}
+
+// checkPollWatchdog emits the MaxPollIntervalWarning if the time since the
+// last poll has reached the warning threshold.
+// Only subscribed consumers are subject to `max.poll.interval.ms`.
+func (c *Consumer) checkPollWatchdog() {
+	w := c.pollWatchdog
+
+	lastPoll := atomic.LoadInt64(&w.lastPoll)
+	if lastPoll == math.MaxInt64 || atomic.LoadInt32(&w.warned) != 0 {
+		return
+	}
+
+	since := time.Since(time.Unix(0, lastPoll))
+	if since < w.warnAfter {
+		return
+	}
+
+	if subscription, err := c.Subscription(); err != nil || len(subscription) == 0 {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&w.warned, 0, 1) {
+		return
+	}
+
+	const logWarning = 4 // syslog LOG_WARNING
+
+	warning := MaxPollIntervalWarning{
+		SinceLastPoll:   since,
+		MaxPollInterval: w.maxPollInterval,
+	}
+
+	c.handle.log(logWarning, "MAXPOLL", fmt.Sprintf(
+		"Application has not polled for %v, the consumer will leave the "+
+			"group if not polled within max.poll.interval.ms (%v)",
+		since.Round(time.Millisecond), w.maxPollInterval))
+
+	if cb := w.cb.Load().(MaxPollIntervalWarningCb); cb != nil {
+		cb(c, warning)
+	}
+}
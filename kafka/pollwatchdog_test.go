@@ -0,0 +1,100 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConsumerMaxPollIntervalWarning verifies that a slow poll loop is
+// warned about before max.poll.interval.ms is exceeded, once per poll.
+func TestConsumerMaxPollIntervalWarning(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "maxpolltopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	_, err = NewConsumer(&ConfigMap{
+		"group.id":                      "maxpollgroup",
+		"go.max.poll.interval.warn.pct": 101})
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Fatalf("Expected ErrInvalidArg for invalid percentage, got %v", err)
+	}
+
+	maxPollInterval := 2 * time.Second
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":             mc.BootstrapServers(),
+		"group.id":                      "maxpollgroup",
+		"session.timeout.ms":            1000,
+		"heartbeat.interval.ms":         100,
+		"max.poll.interval.ms":          int(maxPollInterval / time.Millisecond),
+		"go.max.poll.interval.warn.pct": 80})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	warnings := make(chan MaxPollIntervalWarning, 10)
+	c.SetOnMaxPollIntervalWarning(func(c *Consumer, w MaxPollIntervalWarning) {
+		warnings <- w
+	})
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Polling regularly does not warn.
+	for start := time.Now(); time.Since(start) < 2*maxPollInterval; {
+		c.Poll(100)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("Unexpected warning while polling: %v", <-warnings)
+	}
+
+	// A slow loop is warned about once, at 80% of the interval.
+	c.Poll(0)
+	polled := time.Now()
+
+	select {
+	case w := <-warnings:
+		elapsed := time.Since(polled)
+		if elapsed < maxPollInterval*8/10 || elapsed >= maxPollInterval {
+			t.Errorf("Expected warning between 80%% and 100%% of %v, got %v",
+				maxPollInterval, elapsed)
+		}
+		if w.MaxPollInterval != maxPollInterval || w.SinceLastPoll > elapsed {
+			t.Errorf("Unexpected warning %v after %v", w, elapsed)
+		}
+	case <-time.After(maxPollInterval):
+		t.Fatalf("Expected warning before %v", maxPollInterval)
+	}
+
+	time.Sleep(maxPollInterval / 4)
+	if len(warnings) > 0 {
+		t.Errorf("Expected a single warning between polls, got %v", <-warnings)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 	"unsafe"
 )
@@ -125,6 +126,29 @@ rd_kafka_resp_err_t err;
       return RD_KAFKA_RESP_ERR_NO_ERROR;
 #endif
 }
+
+// Exported from partitioner.go
+extern int32_t goPartitionerCb (rd_kafka_topic_t *rkt,
+                                void *key, size_t keylen,
+                                int32_t partition_cnt,
+                                void *rkt_opaque, void *msg_opaque);
+
+// Set goPartitionerCb as the partitioner for all topics, passing it
+// partitionerId as the topic opaque.
+void set_go_partitioner (rd_kafka_conf_t *conf, uintptr_t partitionerId) {
+  rd_kafka_topic_conf_t *tconf = rd_kafka_conf_get_default_topic_conf(conf);
+
+  if (!tconf) {
+    tconf = rd_kafka_topic_conf_new();
+    rd_kafka_conf_set_default_topic_conf(conf, tconf);
+  }
+
+  rd_kafka_topic_conf_set_partitioner_cb(
+        tconf,
+        (int32_t (*)(const rd_kafka_topic_t *, const void *, size_t,
+                     int32_t, void *, void *))goPartitionerCb);
+  rd_kafka_topic_conf_set_opaque(tconf, (void *)partitionerId);
+}
 */
 import "C"
 
@@ -136,8 +160,44 @@ type Producer struct {
 
 	// Terminates the poller() goroutine
 	pollerTermChan chan bool
+
+	// Copy of the application configuration, for WithAcks()
+	conf ConfigMap
+
+	// Most recent broker errors, for HealthCheck()
+	brokerErrors brokerErrors
+
+	// Config setting, "" if disabled
+	deadLetterTopic string
+
+	// Registered go.partitioner id, 0 if none
+	partitionerID uintptr
+
+	interceptors []ProducerInterceptor
+
+	// Config setting, true if transactional.id is set
+	transactional bool
+	txnFailures   transactionFailures
+
+	// Per-topic rate limits, see SetTopicRateLimit()
+	rateLimits topicRateLimits
 }
 
+// Headers added to messages produced to the `go.dead.letter.topic`, or to
+// a DeserializingConsumer's dead letter queue, in addition to the failed
+// message's own headers.
+const (
+	// DeadLetterHeaderTopic is the topic the message failed to be produced to
+	DeadLetterHeaderTopic = "dlq.original.topic"
+	// DeadLetterHeaderPartition is the partition the message failed to be
+	// produced to, -1 if not yet partitioned
+	DeadLetterHeaderPartition = "dlq.original.partition"
+	// DeadLetterHeaderErrorCode is the numeric ErrorCode of the failure
+	DeadLetterHeaderErrorCode = "dlq.error.code"
+	// DeadLetterHeaderError is the error string of the failure
+	DeadLetterHeaderError = "dlq.error"
+)
+
 // String returns a human readable name for a Producer instance
 func (p *Producer) String() string {
 	return p.handle.String()
@@ -148,11 +208,74 @@ func (p *Producer) gethandle() *handle {
 	return &p.handle
 }
 
-func (p *Producer) produce(msg *Message, msgFlags int, deliveryChan chan Event) error {
+// setDeliveryRetriable sets the retriable flag of err, a delivery report
+// error, if it is a transient error, such as ErrNotEnoughReplicas or
+// ErrMsgTimedOut, that librdkafka gave up retrying, in which case
+// producing the message again may succeed.
+func setDeliveryRetriable(err Error) Error {
+	switch err.Code() {
+	case ErrMsgTimedOut, ErrTimedOut, ErrTransport, ErrAllBrokersDown,
+		ErrRequestTimedOut, ErrNotLeaderForPartition, ErrLeaderNotAvailable,
+		ErrNotEnoughReplicas, ErrNotEnoughReplicasAfterAppend,
+		ErrNetworkException, ErrKafkaStorageError:
+		err.retriable = true
+	}
+	return err
+}
+
+// produceDeadLetter re-produces msg, whose delivery failed permanently,
+// to the dead letter topic with the failure described in headers.
+// Retriable failures, see setDeliveryRetriable(), which may succeed if
+// produced again, and purged messages are not routed, nor are failures to
+// produce to the dead letter topic itself, to avoid loops.
+func (p *Producer) produceDeadLetter(msg *Message) {
+	err, ok := msg.TopicPartition.Error.(Error)
+	if !ok || err.IsRetriable() ||
+		*msg.TopicPartition.Topic == p.deadLetterTopic ||
+		err.Code() == ErrPurgeQueue || err.Code() == ErrPurgeInflight {
+		return
+	}
+
+	headers := make([]Header, len(msg.Headers), len(msg.Headers)+4)
+	copy(headers, msg.Headers)
+	headers = append(headers,
+		Header{DeadLetterHeaderTopic, []byte(*msg.TopicPartition.Topic)},
+		Header{DeadLetterHeaderPartition,
+			[]byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		Header{DeadLetterHeaderErrorCode, []byte(strconv.Itoa(int(err.Code())))},
+		Header{DeadLetterHeaderError, []byte(err.Error())})
+
+	// The delivery report of the dead letter message is handled like any
+	// other, without the failed message's Opaque, which belongs to the
+	// failed message's delivery report.
+	// If it can't be enqueued, e.g., because the queue is full,
+	// the original failed delivery report is all there is.
+	p.produce(&Message{
+		TopicPartition: TopicPartition{Topic: &p.deadLetterTopic, Partition: PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}, 0, nil, 0)
+}
+
+// produce enqueues msg, batchIndex is the index of msg in the messages
+// passed to ProduceBatchSync(), else 0.
+func (p *Producer) produce(msg *Message, msgFlags int, deliveryChan chan Event, batchIndex int) error {
 	if msg == nil || msg.TopicPartition.Topic == nil || len(*msg.TopicPartition.Topic) == 0 {
 		return newErrorFromString(ErrInvalidArg, "")
 	}
 
+	// Rate limit before the interceptors, which would otherwise see
+	// rejected messages that they never get an acknowledgement for.
+	err := p.rateLimit(msg, msgFlags&C.RD_KAFKA_MSG_F_BLOCK != 0)
+	if err != nil {
+		return err
+	}
+
+	if len(p.interceptors) > 0 {
+		msg = p.interceptSend(msg)
+	}
+
 	crkt := p.handle.getRkt(*msg.TopicPartition.Topic)
 
 	// Three problems:
@@ -209,11 +332,13 @@ func (p *Producer) produce(msg *Message, msgFlags int, deliveryChan chan Event)
 	// Per-message state that needs to be retained through the C code:
 	//   delivery channel (if specified)
 	//   message opaque   (if specified)
+	//   ProduceBatchSync() index (with the delivery channel)
 	// Since these cant be passed as opaque pointers to the C code,
 	// due to cgo constraints, we add them to a per-producer map for lookup
 	// when the C code triggers the callbacks or events.
 	if deliveryChan != nil || msg.Opaque != nil {
-		cgoid = p.handle.cgoPut(cgoDr{deliveryChan: deliveryChan, opaque: msg.Opaque})
+		cgoid = p.handle.cgoPut(cgoDr{deliveryChan: deliveryChan,
+			opaque: msg.Opaque, batchIndex: batchIndex})
 	}
 
 	var timestamp int64
@@ -278,11 +403,86 @@ func (p *Producer) produce(msg *Message, msgFlags int, deliveryChan chan Event)
 // or on the Producer object's Events() channel if not.
 // msg.Timestamp requires librdkafka >= 0.9.4 (else returns ErrNotImplemented),
 // api.version.request=true, and broker >= 0.10.0.0.
+// msg.Timestamp is sent as the message's CreateTime, regardless of
+// msg.TimestampType, which is only set on consumed messages, but is
+// overwritten by the broker if the topic's `message.timestamp.type`
+// is LogAppendTime, see TimestampType.
 // msg.Headers requires librdkafka >= 0.11.4 (else returns ErrNotImplemented),
 // api.version.request=true, and broker >= 0.11.0.0.
+//
+// Transient errors are retried by librdkafka according to
+// `message.send.max.retries` and `message.timeout.ms`, intermediate
+// retries are not reported: the delivery report is only emitted once the
+// message has been delivered or has permanently failed.
+// The number of retries it took is not available either: librdkafka 1.7.0
+// does not expose a message's retry count on its delivery report, only the
+// per-broker `txretries` in the Stats event, which can't be attributed to
+// individual messages.
+// A failed delivery report's TopicPartition.Error is marked
+// Error.IsRetriable() if librdkafka exhausted the retries of a transient
+// error, in which case the message may be produced again, else the error
+// is permanent, e.g., ErrMsgSizeTooLarge.
+//
 // Returns an error if message could not be enqueued.
 func (p *Producer) Produce(msg *Message, deliveryChan chan Event) error {
-	return p.produce(msg, 0, deliveryChan)
+	return p.handle.withOp("Producer.Produce", p.produce(msg, 0, deliveryChan, 0))
+}
+
+// ProduceKeyed produces a message with value to topic, with any partition,
+// keyed by key serialized with SerializeKey(), which matches the Java
+// client's default serializers.
+// For keys to be co-partitioned with the Java client's default partitioner
+// the `partitioner` configuration property must also be set to
+// `murmur2_random`.
+// Returns an error if the key could not be serialized or the message
+// could not be enqueued, see Produce().
+func (p *Producer) ProduceKeyed(topic string, key interface{}, value []byte, deliveryChan chan Event) error {
+	return p.handle.withOp("Producer.ProduceKeyed", p.produceKeyed(topic, key, value, deliveryChan))
+}
+
+// produceKeyed implements ProduceKeyed().
+func (p *Producer) produceKeyed(topic string, key interface{}, value []byte, deliveryChan chan Event) error {
+	keyBytes, err := SerializeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+		Key:            keyBytes,
+		Value:          value,
+	}, deliveryChan)
+}
+
+// ProduceIdempotent produces msg with the IdempotencyKeyHeader header set to
+// key, replacing any existing header by that name, for consumers to
+// deduplicate re-produced messages with a Deduplicator using
+// DedupByIdempotencyKey.
+// The key should be unique to the logical message, e.g., a request id, and
+// be reused when the same message is produced again.
+// Returns an error if key is empty or the message could not be enqueued,
+// see Produce().
+func (p *Producer) ProduceIdempotent(key string, msg *Message, deliveryChan chan Event) error {
+	return p.handle.withOp("Producer.ProduceIdempotent", p.produceIdempotent(key, msg, deliveryChan))
+}
+
+// produceIdempotent implements ProduceIdempotent().
+func (p *Producer) produceIdempotent(key string, msg *Message, deliveryChan chan Event) error {
+	if key == "" {
+		return newErrorFromString(ErrInvalidArg, "Idempotency key must not be empty")
+	}
+
+	// Copy the headers rather than modifying them in place, the
+	// application may share the Headers slice between messages.
+	headers := make([]Header, 0, len(msg.Headers)+1)
+	for _, hdr := range msg.Headers {
+		if hdr.Key != IdempotencyKeyHeader {
+			headers = append(headers, hdr)
+		}
+	}
+	msg.Headers = append(headers, Header{Key: IdempotencyKeyHeader, Value: []byte(key)})
+
+	return p.Produce(msg, deliveryChan)
 }
 
 // Produce a batch of messages.
@@ -293,8 +493,20 @@ func (p *Producer) Produce(msg *Message, deliveryChan chan Event) error {
 func (p *Producer) produceBatch(topic string, msgs []*Message, msgFlags int) error {
 	crkt := p.handle.getRkt(topic)
 
+	// Rate limit before the interceptors, see produce().
+	block := msgFlags&C.RD_KAFKA_MSG_F_BLOCK != 0
+	for _, m := range msgs {
+		err := p.rateLimit(m, block)
+		if err != nil {
+			return err
+		}
+	}
+
 	cmsgs := make([]C.rd_kafka_message_t, len(msgs))
 	for i, m := range msgs {
+		if len(p.interceptors) > 0 {
+			m = p.interceptSend(m)
+		}
 		p.handle.messageToC(m, &cmsgs[i])
 	}
 	r := C.rd_kafka_produce_batch(crkt, C.RD_KAFKA_PARTITION_UA, C.int(msgFlags)|C.RD_KAFKA_MSG_F_FREE,
@@ -350,6 +562,51 @@ func (p *Producer) Flush(timeoutMs int) int {
 	return 0
 }
 
+// FlushWithProgress flushes and waits for outstanding messages and requests
+// to complete delivery, like Flush(), while periodically reporting progress.
+// Includes messages on ProduceChannel, as well as delivery reports
+// on the Events() channel that have not yet been read by the application.
+//
+// onProgress is called from the calling goroutine with the number of
+// outstanding events, once before flushing starts and then roughly every
+// 100ms until the value reaches zero or timeoutMs expires.
+// onProgress may be nil.
+//
+// Returns the number of outstanding events still un-flushed.
+func (p *Producer) FlushWithProgress(timeoutMs int, onProgress func(remaining int)) int {
+	termChan := make(chan bool) // unused stand-in termChan
+
+	tEnd := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for {
+		remaining := p.Len()
+		if onProgress != nil {
+			onProgress(remaining)
+		}
+		if remaining == 0 {
+			return 0
+		}
+
+		remain := tEnd.Sub(time.Now()).Seconds()
+		if remain <= 0.0 {
+			return remaining
+		}
+
+		// Keep polling until the next progress report is due,
+		// eventPoll() may return early when it serves an event.
+		tNext := time.Now().Add(100 * time.Millisecond)
+		for p.Len() > 0 {
+			pollRemain := math.Min(tNext.Sub(time.Now()).Seconds(),
+				tEnd.Sub(time.Now()).Seconds())
+			if pollRemain <= 0.0 {
+				break
+			}
+
+			p.handle.eventPoll(p.events,
+				int(math.Ceil(pollRemain*1000)), 1000, termChan)
+		}
+	}
+}
+
 // Close a Producer instance.
 // The Producer object or its channels are no longer usable after this call.
 func (p *Producer) Close() {
@@ -364,6 +621,10 @@ func (p *Producer) Close() {
 	p.handle.cleanup()
 
 	C.rd_kafka_destroy(p.handle.rk)
+
+	if p.partitionerID != 0 {
+		unregisterPartitioner(p.partitionerID)
+	}
 }
 
 const (
@@ -407,12 +668,57 @@ const (
 func (p *Producer) Purge(flags int) error {
 	cErr := C.rd_kafka_purge(p.handle.rk, C.int(flags))
 	if cErr != C.RD_KAFKA_RESP_ERR_NO_ERROR {
-		return newError(cErr)
+		return p.handle.withOp("Producer.Purge", newError(cErr))
 	}
 
 	return nil
 }
 
+// WithAcks creates a new Producer with the same configuration as p,
+// but with `acks` set to acks: -1 (all in-sync replicas), 0 (none) or
+// 1 (leader only).
+//
+// librdkafka does not support per-message acks: acks is a topic
+// configuration property that applies to all messages a client instance
+// produces to the topic. Producing messages with different durability
+// requirements, e.g., audit events with acks=all and telemetry with acks=1,
+// therefore requires separate producers, even for the same topic.
+//
+// The returned Producer is such a separate client instance, with its own
+// broker connections, queues and Events() channel, and must be closed
+// independently of p.
+//
+// Returns ErrInvalidArg if p is a transactional producer, since both
+// instances would share the `transactional.id` and fence each other,
+// or if p is an idempotent producer, which requires acks=all, and acks is
+// not -1.
+func (p *Producer) WithAcks(acks int) (*Producer, error) {
+	producer, err := p.withAcks(acks)
+	return producer, p.handle.withOp("Producer.WithAcks", err)
+}
+
+// withAcks implements WithAcks().
+func (p *Producer) withAcks(acks int) (*Producer, error) {
+	if txnID, err := p.handle.getConfigValue("transactional.id"); err == nil && txnID != "" {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"WithAcks() is not supported for transactional producers")
+	}
+
+	idempotence, err := p.handle.getConfigValue("enable.idempotence")
+	if err == nil && idempotence == "true" && acks != -1 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			fmt.Sprintf("acks=%d is not supported for idempotent producers, "+
+				"which require acks=-1", acks))
+	}
+
+	conf := p.conf.clone()
+	// Remove the alias so it does not conflict with acks.
+	delete(conf, "request.required.acks")
+	conf["acks"] = acks
+
+	return NewProducer(&conf)
+}
+
 // NewProducer creates a new high-level Producer instance.
 //
 // conf is a *ConfigMap with standard librdkafka configuration properties.
@@ -426,8 +732,20 @@ func (p *Producer) Purge(flags int) error {
 //   go.delivery.report.fields (string, "key,value") - Comma separated list of fields to enable for delivery reports.
 //                                       Allowed values: all, none (or empty string), key, value, headers
 //                                       Warning: There is a performance penalty to include headers in the delivery report.
+//   go.dead.letter.topic (string, "") - Re-produce messages whose delivery failed permanently to this topic, with the
+//                                       failure described by the DeadLetterHeader.. headers. Retriable failures,
+//                                       see Error.IsRetriable(), and purged messages are not re-produced.
+//                                       The failed message's delivery report is still emitted, as is the dead
+//                                       letter message's, without the failed message's Opaque.
+//                                       Enables the key, value and headers delivery report fields.
+//   go.partitioner (kafka.Partitioner, nil) - Partition messages produced with PartitionAny by calling this Go function,
+//                                             e.g., to match a legacy partitioning scheme, instead of with librdkafka's
+//                                             `partitioner`. This costs a cgo call per message.
 //   go.events.channel.size (int, 1000000) - Events().
 //   go.produce.channel.size (int, 1000000) - ProduceChannel() buffer size (in number of messages)
+//   go.error.op.enable (bool, false) - Annotate the errors returned by the Producer methods with the method, see Error.Op().
+//   go.error.stack.enable (bool, false) - Also capture the stack where errors are returned, see Error.Stack(), for
+//                                         debugging. Implies go.error.op.enable.
 //   go.logs.channel.enable (bool, false) - Forward log to Logs() channel.
 //   go.logs.channel (chan kafka.LogEvent, nil) - Forward logs to application-provided channel instead of Logs(). Requires go.logs.channel.enable=true.
 //
@@ -443,6 +761,7 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 	// before we do anything with the configuration, create a copy such that
 	// the original is not mutated.
 	confCopy := conf.clone()
+	p.conf = conf.clone()
 
 	v, err := confCopy.extract("delivery.report.only.error", false)
 	if v == true {
@@ -474,6 +793,21 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 		return nil, err
 	}
 
+	v, err = confCopy.extract("go.dead.letter.topic", "")
+	if err != nil {
+		return nil, err
+	}
+	p.deadLetterTopic = v.(string)
+	if p.deadLetterTopic != "" {
+		// The dead letter message is created from the delivery report.
+		p.handle.msgFields.Key = true
+		p.handle.msgFields.Value = true
+		p.handle.msgFields.Headers = true
+	}
+
+	transactionalID, _ := confCopy.get("transactional.id", nil)
+	p.transactional = transactionalID != nil
+
 	v, err = confCopy.extract("go.events.channel.size", 1000000)
 	if err != nil {
 		return nil, err
@@ -486,11 +820,25 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 	}
 	produceChannelSize := v.(int)
 
+	v, err = confCopy.extract("go.partitioner", nil)
+	if err != nil {
+		return nil, err
+	}
+	partitioner, err := partitionerFromConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
 	logsChanEnable, logsChan, err := confCopy.extractLogConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	p.handle.errorOp, p.handle.errorStack, err = confCopy.extractErrorConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	if int(C.rd_kafka_version()) < 0x01000000 {
 		// produce.offset.report is no longer used in librdkafka >= v1.0.0
 		v, _ = confCopy.extract("{topic}.produce.offset.report", nil)
@@ -506,6 +854,11 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 		return nil, err
 	}
 
+	if partitioner != nil {
+		p.partitionerID = registerPartitioner(partitioner)
+		C.set_go_partitioner(cConf, C.uintptr_t(p.partitionerID))
+	}
+
 	cErrstr := (*C.char)(C.malloc(C.size_t(256)))
 	defer C.free(unsafe.Pointer(cErrstr))
 
@@ -514,6 +867,9 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 	// Create librdkafka producer instance
 	p.handle.rk = C.rd_kafka_new(C.RD_KAFKA_PRODUCER, cConf, cErrstr, 256)
 	if p.handle.rk == nil {
+		if p.partitionerID != 0 {
+			unregisterPartitioner(p.partitionerID)
+		}
 		return nil, newErrorFromCString(C.RD_KAFKA_RESP_ERR__INVALID_ARG, cErrstr)
 	}
 
@@ -554,7 +910,7 @@ func NewProducer(conf *ConfigMap) (*Producer, error) {
 // channel_producer serves the ProduceChannel channel
 func channelProducer(p *Producer) {
 	for m := range p.produceChannel {
-		err := p.produce(m, C.RD_KAFKA_MSG_F_BLOCK, nil)
+		err := p.produce(m, C.RD_KAFKA_MSG_F_BLOCK, nil, 0)
 		if err != nil {
 			m.TopicPartition.Error = err
 			p.events <- m
@@ -633,19 +989,48 @@ func poller(p *Producer, termChan chan bool) {
 	}
 }
 
+// LastLatency returns the duration of the last successful op, i.e., the
+// round-trip time to the broker(s) of a QueryWatermarkOffsets() or
+// GetMetadata() call, or 0 if op has not yet succeeded.
+func (p *Producer) LastLatency(op Operation) time.Duration {
+	return p.handle.latencies.get(op)
+}
+
+// SetTopicConfig sets topic-level configuration properties for topic,
+// overriding the `default.topic.config` ones, e.g., `compression.codec`
+// to compress large messages with zstd on one topic while not paying the
+// CPU cost for small messages on another.
+//
+// Must be called before the first message is produced to topic, or the
+// topic is otherwise used by the producer, else ErrState is returned.
+//
+// librdkafka does not support setting the compression codec per message:
+// messages are compressed per batch and a batch holds messages for a
+// single partition, so the codec is a topic-level property.
+// For a topic with both small and large messages use two producers with
+// different codecs; their messages are batched, and thus compressed,
+// separately, which reduces the batch sizes and, like any use of
+// multiple producers, gives no ordering guarantees between them.
+func (p *Producer) SetTopicConfig(topic string, conf ConfigMap) error {
+	return p.handle.withOp("Producer.SetTopicConfig",
+		p.handle.newRktWithConfig(topic, conf))
+}
+
 // GetMetadata queries broker for cluster and topic metadata.
 // If topic is non-nil only information about that topic is returned, else if
 // allTopics is false only information about locally used topics is returned,
 // else information about all topics is returned.
 // GetMetadata is equivalent to listTopics, describeTopics and describeCluster in the Java API.
 func (p *Producer) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*Metadata, error) {
-	return getMetadata(p, topic, allTopics, timeoutMs)
+	md, err := getMetadata(p, topic, allTopics, timeoutMs)
+	return md, p.handle.withOp("Producer.GetMetadata", err)
 }
 
 // QueryWatermarkOffsets returns the broker's low and high offsets for the given topic
 // and partition.
 func (p *Producer) QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error) {
-	return queryWatermarkOffsets(p, topic, partition, timeoutMs)
+	low, high, err = queryWatermarkOffsets(p, topic, partition, timeoutMs)
+	return low, high, p.handle.withOp("Producer.QueryWatermarkOffsets", err)
 }
 
 // OffsetsForTimes looks up offsets by timestamp for the given partitions.
@@ -664,7 +1049,8 @@ func (p *Producer) QueryWatermarkOffsets(topic string, partition int32, timeoutM
 // Duplicate Topic+Partitions are not supported.
 // Per-partition errors may be returned in the `.Error` field.
 func (p *Producer) OffsetsForTimes(times []TopicPartition, timeoutMs int) (offsets []TopicPartition, err error) {
-	return offsetsForTimes(p, times, timeoutMs)
+	offsets, err = offsetsForTimes(p, times, timeoutMs)
+	return offsets, p.handle.withOp("Producer.OffsetsForTimes", err)
 }
 
 // GetFatalError returns an Error object if the client instance has raised a fatal error, else nil.
@@ -689,7 +1075,8 @@ func (p *Producer) TestFatalError(code ErrorCode, str string) ErrorCode {
 // 3) SASL/OAUTHBEARER is supported but is not configured as the client's
 // authentication mechanism.
 func (p *Producer) SetOAuthBearerToken(oauthBearerToken OAuthBearerToken) error {
-	return p.handle.setOAuthBearerToken(oauthBearerToken)
+	return p.handle.withOp("Producer.SetOAuthBearerToken",
+		p.handle.setOAuthBearerToken(oauthBearerToken))
 }
 
 // SetOAuthBearerTokenFailure sets the error message describing why token
@@ -700,7 +1087,14 @@ func (p *Producer) SetOAuthBearerToken(oauthBearerToken OAuthBearerToken) error
 // 2) SASL/OAUTHBEARER is supported but is not configured as the client's
 // authentication mechanism.
 func (p *Producer) SetOAuthBearerTokenFailure(errstr string) error {
-	return p.handle.setOAuthBearerTokenFailure(errstr)
+	return p.handle.withOp("Producer.SetOAuthBearerTokenFailure",
+		p.handle.setOAuthBearerTokenFailure(errstr))
+}
+
+// CPtr returns the underlying librdkafka `rd_kafka_t` instance handle,
+// see Handle.CPtr() for the caveats.
+func (p *Producer) CPtr() unsafe.Pointer {
+	return p.handle.cPtr()
 }
 
 // Transactional API
@@ -744,7 +1138,8 @@ func (p *Producer) InitTransactions(ctx context.Context) error {
 	cError := C.rd_kafka_init_transactions(p.handle.rk,
 		cTimeoutFromContext(ctx))
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return p.handle.withOp("Producer.InitTransactions",
+			newErrorFromCErrorDestroy(cError))
 	}
 
 	return nil
@@ -773,9 +1168,12 @@ func (p *Producer) InitTransactions(ctx context.Context) error {
 func (p *Producer) BeginTransaction() error {
 	cError := C.rd_kafka_begin_transaction(p.handle.rk)
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return p.handle.withOp("Producer.BeginTransaction",
+			newErrorFromCErrorDestroy(cError))
 	}
 
+	p.txnFailures.reset()
+
 	return nil
 }
 
@@ -824,7 +1222,7 @@ func (p *Producer) SendOffsetsToTransaction(ctx context.Context, offsets []Topic
 
 	cgmd, err := deserializeConsumerGroupMetadata(consumerMetadata.serialized)
 	if err != nil {
-		return err
+		return p.handle.withOp("Producer.SendOffsetsToTransaction", err)
 	}
 	defer C.rd_kafka_consumer_group_metadata_destroy(cgmd)
 
@@ -834,7 +1232,8 @@ func (p *Producer) SendOffsetsToTransaction(ctx context.Context, offsets []Topic
 		cgmd,
 		cTimeoutFromContext(ctx))
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return p.handle.withOp("Producer.SendOffsetsToTransaction",
+			newErrorFromCErrorDestroy(cError))
 	}
 
 	return nil
@@ -865,6 +1264,18 @@ func (p *Producer) SendOffsetsToTransaction(ctx context.Context, offsets []Topic
 // The application MUST serve the `producer.Events()` channel for delivery
 // reports in a separate go-routine during this time.
 //
+// Note: Transaction coordinator changes, and the resulting
+// `ErrNotCoordinator` and `ErrCoordinatorNotAvailable` errors, are
+// handled internally by re-querying the coordinator and retrying,
+// these errors are not returned to the application.
+//
+// Note: If the delivery of any message produced in the transaction has
+// already been reported as failed the transaction is not committed and
+// an abortable error listing the failed partitions is returned.
+// The failed partitions are also listed in the abortable error returned
+// for failures reported while flushing, and are available from
+// `TransactionProduceErrors()`.
+//
 // Returns nil on success or an error object on failure.
 // Check whether the returned error object permits retrying
 // by calling `err.(kafka.Error).IsRetriable()`, or whether an abortable
@@ -872,10 +1283,20 @@ func (p *Producer) SendOffsetsToTransaction(ctx context.Context, offsets []Topic
 // `err.(kafka.Error).TxnRequiresAbort()` or `err.(kafka.Error).IsFatal()`
 // respectively.
 func (p *Producer) CommitTransaction(ctx context.Context) error {
+	if failures := p.txnFailures.get(); len(failures) > 0 {
+		return p.handle.withOp("Producer.CommitTransaction",
+			newTransactionFailureError(failures))
+	}
+
 	cError := C.rd_kafka_commit_transaction(p.handle.rk,
 		cTimeoutFromContext(ctx))
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		err := newErrorFromCErrorDestroy(cError)
+		if failures := p.txnFailures.get(); err.TxnRequiresAbort() && len(failures) > 0 {
+			return p.handle.withOp("Producer.CommitTransaction",
+				newTransactionFailureError(failures))
+		}
+		return p.handle.withOp("Producer.CommitTransaction", err)
 	}
 
 	return nil
@@ -911,8 +1332,159 @@ func (p *Producer) AbortTransaction(ctx context.Context) error {
 	cError := C.rd_kafka_abort_transaction(p.handle.rk,
 		cTimeoutFromContext(ctx))
 	if cError != nil {
-		return newErrorFromCErrorDestroy(cError)
+		return p.handle.withOp("Producer.AbortTransaction",
+			newErrorFromCErrorDestroy(cError))
 	}
 
 	return nil
 }
+
+// TransactionalBatch atomically produces msgs, which may be destined for
+// any number of topics and partitions, in a single transaction:
+// it begins a transaction, produces all messages and commits the
+// transaction.
+//
+// If producing any of the messages or committing the transaction fails,
+// the transaction is aborted and the error that caused the abort is
+// returned: none of the messages will be visible to consumers
+// with `isolation.level=read_committed`, while `read_uncommitted`
+// consumers may see some or all of them.
+// If the abort fails as well the abort error is returned instead, the
+// application must then retry AbortTransaction() if the error is
+// retriable, or create a new producer if it is fatal.
+//
+// timeout is the maximum time to block committing the transaction, and
+// if needed, the same again for aborting it.
+//
+// The delivery reports of msgs are not emitted on the Events() channel,
+// the outcome of the transaction is the returned error.
+//
+// Requires InitTransactions() to have been called, and no transaction
+// to be in progress.
+func (p *Producer) TransactionalBatch(msgs []*Message, timeout time.Duration) error {
+	return p.handle.withOp("Producer.TransactionalBatch", p.transactionalBatch(msgs, timeout))
+}
+
+// transactionalBatch implements TransactionalBatch().
+func (p *Producer) transactionalBatch(msgs []*Message, timeout time.Duration) error {
+	err := p.BeginTransaction()
+	if err != nil {
+		return err
+	}
+
+	// A private, non-blocking, delivery channel, so that delivery
+	// reports need not be served while committing.
+	drChan := make(chan Event, len(msgs))
+
+	for _, m := range msgs {
+		err = p.Produce(m, drChan)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = p.CommitTransaction(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if abortErr := p.AbortTransaction(ctx); abortErr != nil {
+		return abortErr
+	}
+
+	return err
+}
+
+// DeliveryResult is the outcome of a message produced by ProduceBatchSync().
+type DeliveryResult struct {
+	// Message is the message as reported by its delivery report, or the
+	// message passed to ProduceBatchSync() if it was not delivered in time
+	// or could not be enqueued.
+	Message *Message
+	// Error is the delivery error, or nil if the message was delivered.
+	Error error
+}
+
+// ProduceBatchSync produces msgs and waits up to timeout for all of their
+// delivery reports, returning the per-message results in the order of msgs.
+//
+// The delivery reports of msgs are not emitted on the Events() channel,
+// and msg.Opaque is passed through to the result Message.
+//
+// Returns an error with the ErrTimedOut code if not all delivery reports
+// were received within timeout, the results of the remaining messages
+// then have the same error. Otherwise, if any message could not be enqueued
+// or delivered, the first such error (in the order of msgs) is returned.
+// Returns nil if all messages were delivered.
+func (p *Producer) ProduceBatchSync(msgs []*Message, timeout time.Duration) ([]DeliveryResult, error) {
+	results := make([]DeliveryResult, len(msgs))
+	done := make([]bool, len(msgs))
+
+	// Buffered to hold all delivery reports, so that late delivery
+	// reports, after timeout, do not block the poller.
+	drChan := make(chan Event, len(msgs))
+
+	outstanding := 0
+	for i, m := range msgs {
+		// The delivery report is mapped back to m by its index, which
+		// is kept by the Go client alongside the Opaque.
+		msg := *m
+
+		err := p.handle.withOp("Producer.ProduceBatchSync",
+			p.produce(&msg, 0, drChan, i))
+		if err != nil {
+			results[i] = DeliveryResult{Message: m, Error: err}
+			done[i] = true
+			continue
+		}
+		outstanding++
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for outstanding > 0 {
+		select {
+		case ev := <-drChan:
+			m, ok := ev.(*Message)
+			if !ok {
+				continue
+			}
+			i := m.batchIndex
+			if i < 0 || i >= len(msgs) || done[i] {
+				continue
+			}
+			results[i] = DeliveryResult{
+				Message: m,
+				Error:   m.TopicPartition.Error,
+			}
+			done[i] = true
+			outstanding--
+
+		case <-timer.C:
+			err := newErrorFromString(ErrTimedOut,
+				fmt.Sprintf("%d of %d messages not delivered within %v",
+					outstanding, len(msgs), timeout))
+			for i := range results {
+				if !done[i] {
+					results[i] = DeliveryResult{Message: msgs[i], Error: err}
+				}
+			}
+			return results, p.handle.withOp("Producer.ProduceBatchSync", err)
+		}
+	}
+
+	for _, r := range results {
+		if r.Error != nil {
+			return results, r.Error
+		}
+	}
+
+	return results, nil
+}
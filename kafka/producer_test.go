@@ -21,8 +21,13 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"hash/crc32"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -222,6 +227,242 @@ func TestPurgeAPI(t *testing.T) {
 	close(purgeDrChan)
 }
 
+// TestProducerFlushWithProgress verifies that FlushWithProgress reports
+// the outstanding message count both when messages can't be delivered
+// and when they are.
+func TestProducerFlushWithProgress(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "flushtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	// Keep the messages queued by failing all Produce requests
+	// until the errors are cleared.
+	for i := 0; i < 100; i++ {
+		mc.SetRoundtripError(mockAPIKeyProduce, ErrNotEnoughReplicas)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":   mc.BootstrapServers(),
+		"retry.backoff.ms":    100,
+		"go.delivery.reports": false})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	msgcnt := 10
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("somevalue")}, nil)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	var reports []int
+	remaining := p.FlushWithProgress(500, func(remaining int) {
+		reports = append(reports, remaining)
+	})
+	// The outstanding count includes in-flight requests, not just messages.
+	if remaining < msgcnt {
+		t.Errorf("Expected at least %d messages remaining, got %d", msgcnt, remaining)
+	}
+	if len(reports) < 2 {
+		t.Errorf("Expected multiple progress reports, got %v", reports)
+	}
+	for _, r := range reports {
+		if r < msgcnt {
+			t.Errorf("Expected progress reports of at least %d, got %v", msgcnt, reports)
+			break
+		}
+	}
+
+	mc.ClearRoundtripErrors(mockAPIKeyProduce)
+
+	reports = nil
+	remaining = p.FlushWithProgress(30000, func(remaining int) {
+		reports = append(reports, remaining)
+	})
+	if remaining != 0 {
+		t.Errorf("Expected all messages flushed, %d remaining", remaining)
+	}
+	if len(reports) == 0 || reports[len(reports)-1] != 0 {
+		t.Errorf("Expected final progress report of 0, got %v", reports)
+	}
+
+	// A nil onProgress is allowed.
+	remaining = p.FlushWithProgress(1000, nil)
+	if remaining != 0 {
+		t.Errorf("Expected nothing to flush, %d remaining", remaining)
+	}
+}
+
+// TestProducerFlushWithProgressDraining verifies that FlushWithProgress
+// reports a decreasing remaining count as the application drains
+// delivery reports from the Events() channel.
+func TestProducerFlushWithProgressDraining(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "flushtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	msgcnt := 20
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("somevalue")}, nil)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	// Slowly drain the delivery reports, which are included in the
+	// outstanding count until read by the application.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < msgcnt; i++ {
+			<-p.Events()
+			time.Sleep(25 * time.Millisecond)
+		}
+		close(done)
+	}()
+
+	var reports []int
+	remaining := p.FlushWithProgress(30000, func(remaining int) {
+		reports = append(reports, remaining)
+	})
+	<-done
+
+	if remaining != 0 {
+		t.Errorf("Expected all messages flushed, %d remaining", remaining)
+	}
+
+	t.Logf("Progress reports: %v", reports)
+
+	distinct := 0
+	for i, r := range reports {
+		if i > 0 && r > reports[i-1] {
+			t.Errorf("Expected decreasing remaining count, got %v", reports)
+			break
+		}
+		if i == 0 || r != reports[i-1] {
+			distinct++
+		}
+	}
+
+	if distinct < 3 {
+		t.Errorf("Expected at least 3 distinct progress reports, got %v", reports)
+	}
+	if len(reports) == 0 || reports[0] < msgcnt || reports[len(reports)-1] != 0 {
+		t.Errorf("Expected progress from at least %d down to 0, got %v",
+			msgcnt, reports)
+	}
+}
+
+// TestProducerWithAcks verifies that WithAcks() creates a producer with
+// a different acks setting.
+func TestProducerWithAcks(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":     mc.BootstrapServers(),
+		"request.required.acks": "all"})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	p1, err := p.WithAcks(1)
+	if err != nil {
+		t.Fatalf("WithAcks: %v", err)
+	}
+	defer p1.Close()
+
+	for _, tc := range []struct {
+		p    *Producer
+		acks string
+	}{{p, "-1"}, {p1, "1"}} {
+		acks, err := tc.p.handle.getConfigValue("acks")
+		if err != nil || acks != tc.acks {
+			t.Errorf("%v: expected acks %s, got %s, %v", tc.p, tc.acks, acks, err)
+		}
+
+		topic := "withackstopic"
+		drChan := make(chan Event, 1)
+		err = tc.p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+			Value:          []byte("value")}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Errorf("%v: delivery failed: %v", tc.p, m.TopicPartition)
+		}
+	}
+
+	pi, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":  mc.BootstrapServers(),
+		"enable.idempotence": true})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer pi.Close()
+
+	_, err = pi.WithAcks(1)
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrInvalidArg {
+		t.Errorf("Expected WithAcks(1) to fail with ErrInvalidArg for idempotent producer, got %v", err)
+	}
+
+	pia, err := pi.WithAcks(-1)
+	if err != nil {
+		t.Errorf("Expected WithAcks(-1) to succeed for idempotent producer, got %v", err)
+	} else {
+		pia.Close()
+	}
+
+	pt, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"transactional.id":  "withacks"})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer pt.Close()
+
+	_, err = pt.WithAcks(-1)
+	if kerr, ok := err.(Error); !ok || kerr.Code() != ErrInvalidArg {
+		t.Errorf("Expected WithAcks(-1) to fail with ErrInvalidArg for transactional producer, got %v", err)
+	}
+}
+
 // TestProducerBufferSafety verifies issue #24, passing any type of memory backed buffer
 // (JSON in this case) to Produce()
 func TestProducerBufferSafety(t *testing.T) {
@@ -565,6 +806,256 @@ func TestTransactionalAPI(t *testing.T) {
 	p.Close()
 }
 
+// TestProducerTransactionalBatch verifies that TransactionalBatch()
+// commits messages to multiple topics, and aborts on failure.
+func TestProducerTransactionalBatch(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topics := []string{"batchtopic1", "batchtopic2"}
+	for _, topic := range topics {
+		err = mc.CreateTopic(topic, 2, 1)
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"transactional.id":  "batchtxnid"})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = p.InitTransactions(ctx)
+	if err != nil {
+		t.Fatalf("InitTransactions: %v", err)
+	}
+
+	batch := func() []*Message {
+		var msgs []*Message
+		for i := range topics {
+			for partition := int32(0); partition < 2; partition++ {
+				msgs = append(msgs, &Message{
+					TopicPartition: TopicPartition{Topic: &topics[i], Partition: partition},
+					Value:          []byte("value")})
+			}
+		}
+		return msgs
+	}
+
+	err = p.TransactionalBatch(batch(), 10*time.Second)
+	if err != nil {
+		t.Fatalf("TransactionalBatch: %v", err)
+	}
+
+	// A permanent produce error fails the commit and aborts the transaction.
+	mc.SetRoundtripError(mockAPIKeyProduce, ErrMsgSizeTooLarge)
+	err = p.TransactionalBatch(batch(), 10*time.Second)
+	if err == nil {
+		t.Fatalf("Expected TransactionalBatch to fail")
+	}
+	t.Logf("TransactionalBatch failed as expected: %v", err)
+
+	// The transaction was aborted, so a new one can be started.
+	err = p.TransactionalBatch(batch(), 10*time.Second)
+	if err != nil {
+		t.Fatalf("TransactionalBatch after abort: %v", err)
+	}
+
+	if len(p.Events()) != 0 {
+		t.Errorf("Expected no delivery reports on Events(), got %d", len(p.Events()))
+	}
+}
+
+// TestProducerTransactionProduceErrors verifies that delivery failures
+// of a transaction's messages fail CommitTransaction() with an abortable
+// error and are reported per partition by TransactionProduceErrors().
+func TestProducerTransactionProduceErrors(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topics := []string{"txnerrtopic1", "txnerrtopic2"}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"transactional.id":  "txnerrtxnid"})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = p.InitTransactions(ctx)
+	if err != nil {
+		t.Fatalf("InitTransactions: %v", err)
+	}
+
+	// produce produces a message to topic and waits for its delivery report.
+	produce := func(topic string) *Message {
+		drChan := make(chan Event, 1)
+		err := p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("value")}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+		return (<-drChan).(*Message)
+	}
+
+	err = p.BeginTransaction()
+	if err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+
+	if m := produce(topics[1]); m.TopicPartition.Error != nil {
+		t.Fatalf("Expected delivery to %s to succeed, got %v", topics[1], m.TopicPartition)
+	}
+	mc.SetRoundtripError(mockAPIKeyProduce, ErrMsgSizeTooLarge)
+	if m := produce(topics[0]); m.TopicPartition.Error == nil {
+		t.Fatalf("Expected delivery to %s to fail", topics[0])
+	}
+
+	failures := p.TransactionProduceErrors()
+	if len(failures) != 1 || *failures[0].Topic != topics[0] ||
+		failures[0].Error.(Error).Code() != ErrMsgSizeTooLarge {
+		t.Fatalf("Expected a single ErrMsgSizeTooLarge failure for %s, got %v",
+			topics[0], failures)
+	}
+
+	err = p.CommitTransaction(ctx)
+	if err == nil || !err.(Error).TxnRequiresAbort() {
+		t.Fatalf("Expected an abortable CommitTransaction error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), topics[0]) || strings.Contains(err.Error(), topics[1]) {
+		t.Errorf("Expected the error to list %s only, got %v", topics[0], err)
+	}
+
+	err = p.AbortTransaction(ctx)
+	if err != nil {
+		t.Fatalf("AbortTransaction: %v", err)
+	}
+
+	// A new transaction starts without failures.
+	err = p.BeginTransaction()
+	if err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	if failures = p.TransactionProduceErrors(); len(failures) != 0 {
+		t.Errorf("Expected no failures in a new transaction, got %v", failures)
+	}
+
+	for _, topic := range topics {
+		if m := produce(topic); m.TopicPartition.Error != nil {
+			t.Fatalf("Expected delivery to %s to succeed, got %v", topic, m.TopicPartition)
+		}
+	}
+
+	err = p.CommitTransaction(ctx)
+	if err != nil {
+		t.Fatalf("CommitTransaction: %v", err)
+	}
+}
+
+// TestProducerSetTopicConfig verifies that messages produced to topics
+// configured with different compression codecs are consumed correctly,
+// and that the configuration of a topic in use can't be changed.
+func TestProducerSetTopicConfig(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	codecs := map[string]string{
+		"zstdtopic": "zstd",
+		"lz4topic":  "lz4",
+	}
+
+	value := bytes.Repeat([]byte("compressible "), 1000)
+	msgcnt := 10
+
+	for topic, codec := range codecs {
+		err = p.SetTopicConfig(topic, ConfigMap{"compression.codec": codec})
+		if err != nil {
+			t.Fatalf("SetTopicConfig(%s, %s): %v", topic, codec, err)
+		}
+	}
+
+	drChan := make(chan Event, msgcnt*len(codecs))
+	for topic := range codecs {
+		topic := topic
+		for i := 0; i < msgcnt; i++ {
+			err = p.Produce(&Message{
+				TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+				Value:          value}, drChan)
+			if err != nil {
+				t.Fatalf("Produce: %v", err)
+			}
+		}
+	}
+	for i := 0; i < msgcnt*len(codecs); i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+
+	// The topic is in use now.
+	for topic := range codecs {
+		err = p.SetTopicConfig(topic, ConfigMap{"compression.codec": "none"})
+		if err == nil || err.(Error).Code() != ErrState {
+			t.Errorf("Expected ErrState for topic %s in use, got %v", topic, err)
+		}
+	}
+
+	err = p.SetTopicConfig("invalidtopic", ConfigMap{"compression.codec": "nosuchcodec"})
+	if err == nil {
+		t.Errorf("Expected SetTopicConfig to fail for an invalid codec")
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "topicconfiggroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	for topic := range codecs {
+		topic := topic
+		err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+		if err != nil {
+			t.Fatalf("Assign: %v", err)
+		}
+
+		for _, m := range mockConsume(t, c, msgcnt, 10*time.Second) {
+			if !bytes.Equal(m.Value, value) {
+				t.Errorf("Unexpected value for %v: %d bytes", m.TopicPartition, len(m.Value))
+			}
+		}
+	}
+}
+
 // TestProducerDeliveryReportFields tests the `go.delivery.report.fields` config setting
 func TestProducerDeliveryReportFields(t *testing.T) {
 	t.Run("none", func(t *testing.T) {
@@ -721,3 +1212,503 @@ func runProducerDeliveryReportFieldTest(t *testing.T, config *ConfigMap, fn func
 		t.Errorf("Expected empty queue after Flush, still has %d", r)
 	}
 }
+
+// TestProducerDeadLetterTopic verifies that a message whose delivery
+// fails permanently is re-produced to the go.dead.letter.topic with
+// error headers.
+func TestProducerDeadLetterTopic(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "dlqsourcetopic"
+	dlqTopic := "dlqtopic"
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":    mc.BootstrapServers(),
+		"go.dead.letter.topic": dlqTopic})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	mc.SetRoundtripError(mockAPIKeyProduce, ErrMsgSizeTooLarge)
+
+	drChan := make(chan Event, 1)
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Key:            []byte("key"),
+		Value:          []byte("value"),
+		Headers:        []Header{{"hdr", []byte("hdrval")}},
+		Opaque:         "opaque"}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	m := (<-drChan).(*Message)
+	if m.TopicPartition.Error == nil ||
+		m.TopicPartition.Error.(Error).Code() != ErrMsgSizeTooLarge {
+		t.Fatalf("Expected ErrMsgSizeTooLarge delivery failure, got %v", m.TopicPartition)
+	}
+
+	// The dead letter message's delivery report is emitted on Events().
+	m = (<-p.Events()).(*Message)
+	if *m.TopicPartition.Topic != dlqTopic || m.TopicPartition.Error != nil {
+		t.Fatalf("Expected successful dead letter delivery, got %v", m.TopicPartition)
+	}
+	if m.Opaque != nil {
+		t.Errorf("Expected the dead letter delivery report without Opaque, got %v", m.Opaque)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{m.TopicPartition})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	m = mockConsume(t, c, 1, 10*time.Second)[0]
+
+	if string(m.Key) != "key" || string(m.Value) != "value" {
+		t.Errorf("Expected original key and value, got %v", m)
+	}
+
+	expHeaders := []Header{
+		{"hdr", []byte("hdrval")},
+		{DeadLetterHeaderTopic, []byte(topic)},
+		{DeadLetterHeaderPartition, []byte("0")},
+		{DeadLetterHeaderErrorCode, []byte(strconv.Itoa(int(ErrMsgSizeTooLarge)))},
+		{DeadLetterHeaderError, []byte(ErrMsgSizeTooLarge.String())}}
+	if !reflect.DeepEqual(m.Headers, expHeaders) {
+		t.Errorf("Expected headers %v, got %v", expHeaders, m.Headers)
+	}
+
+	// A failure to produce to the dead letter topic is not routed again.
+	mc.SetRoundtripError(mockAPIKeyProduce, ErrMsgSizeTooLarge)
+	mc.SetRoundtripError(mockAPIKeyProduce, ErrMsgSizeTooLarge)
+
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("value")}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	<-drChan
+
+	m = (<-p.Events()).(*Message)
+	if *m.TopicPartition.Topic != dlqTopic || m.TopicPartition.Error == nil {
+		t.Fatalf("Expected failed dead letter delivery, got %v", m.TopicPartition)
+	}
+
+	select {
+	case ev := <-p.Events():
+		t.Errorf("Unexpected event after dead letter failure: %v", ev)
+	case <-time.After(time.Second):
+	}
+
+	// Retriable failures are not routed, the message may be produced again.
+	pt, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":    mc.BootstrapServers(),
+		"go.dead.letter.topic": dlqTopic,
+		"message.timeout.ms":   1000})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer pt.Close()
+
+	for i := 0; i < 100; i++ {
+		mc.SetRoundtripError(mockAPIKeyProduce, ErrNotEnoughReplicas)
+	}
+	err = pt.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte("value")}, drChan)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	m = (<-drChan).(*Message)
+	mc.ClearRoundtripErrors(mockAPIKeyProduce)
+	if kerr, ok := m.TopicPartition.Error.(Error); !ok || !kerr.IsRetriable() {
+		t.Fatalf("Expected retriable delivery failure, got %v", m.TopicPartition)
+	}
+
+	select {
+	case ev := <-pt.Events():
+		t.Errorf("Unexpected dead letter delivery report for retriable failure: %v", ev)
+	case <-time.After(time.Second):
+	}
+
+	if p.Len() != 0 {
+		t.Errorf("Expected no messages in flight, got %d", p.Len())
+	}
+}
+
+// TestProducerGoPartitioner verifies that messages are partitioned with
+// the go.partitioner.
+func TestProducerGoPartitioner(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "gopartitionertopic"
+	err = mc.CreateTopic(topic, 7, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	// A legacy partitioner: CRC32 of the key.
+	legacyPartition := func(key []byte, partitionCount int32) int32 {
+		return int32(crc32.ChecksumIEEE(key) % uint32(partitionCount))
+	}
+
+	var calls int32
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"go.partitioner": func(key []byte, partitionCount int32) int32 {
+			atomic.AddInt32(&calls, 1)
+			return legacyPartition(key, partitionCount)
+		}})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	msgcnt := 50
+	drChan := make(chan Event, msgcnt)
+	for i := 0; i < msgcnt; i++ {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+			Key:            []byte(fmt.Sprintf("key%d", i))}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	for i := 0; i < msgcnt; i++ {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+		if exp := legacyPartition(m.Key, 7); m.TopicPartition.Partition != exp {
+			t.Errorf("Expected %s to be produced to partition %d, got %v",
+				m.Key, exp, m.TopicPartition)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n < int32(msgcnt) {
+		t.Errorf("Expected at least %d partitioner calls, got %d", msgcnt, n)
+	}
+
+	_, err = NewProducer(&ConfigMap{"go.partitioner": "crc32"})
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for invalid go.partitioner, got %v", err)
+	}
+}
+
+// opaqueInterceptor records the Opaque of the messages passed to its hooks.
+type opaqueInterceptor struct {
+	lock    sync.Mutex
+	opaques []interface{}
+}
+
+func (oi *opaqueInterceptor) OnSend(msg *Message) *Message {
+	oi.lock.Lock()
+	oi.opaques = append(oi.opaques, msg.Opaque)
+	oi.lock.Unlock()
+	return msg
+}
+
+func (oi *opaqueInterceptor) OnAcknowledgement(msg *Message, err error) {
+	oi.lock.Lock()
+	oi.opaques = append(oi.opaques, msg.Opaque)
+	oi.lock.Unlock()
+}
+
+// TestProducerProduceBatchSync produces a batch and verifies the
+// per-message results, then that undelivered messages time out.
+func TestProducerProduceBatchSync(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "batchsynctopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	batch := func(cnt int) []*Message {
+		msgs := make([]*Message, cnt)
+		for i := range msgs {
+			msgs[i] = &Message{
+				TopicPartition: TopicPartition{Topic: &topic, Partition: int32(i % 2)},
+				Value:          []byte(fmt.Sprintf("value%d", i)),
+				Opaque:         i}
+		}
+		return msgs
+	}
+
+	interceptor := &opaqueInterceptor{}
+	p.AddInterceptor(interceptor)
+
+	msgs := batch(10)
+	results, err := p.ProduceBatchSync(msgs, 10*time.Second)
+	if err != nil {
+		t.Fatalf("ProduceBatchSync: %v", err)
+	}
+
+	// The interceptors only see the application's Opaque.
+	interceptor.lock.Lock()
+	if len(interceptor.opaques) != 2*len(msgs) {
+		t.Errorf("Expected OnSend and OnAcknowledgement for %d messages, got %d calls",
+			len(msgs), len(interceptor.opaques))
+	}
+	for _, opaque := range interceptor.opaques {
+		if _, ok := opaque.(int); !ok {
+			t.Errorf("Expected the interceptors to see the message's Opaque, got %#v", opaque)
+		}
+	}
+	interceptor.lock.Unlock()
+	if len(results) != len(msgs) {
+		t.Fatalf("Expected %d results, got %d", len(msgs), len(results))
+	}
+	for i, r := range results {
+		if r.Error != nil {
+			t.Errorf("Message %d: unexpected error %v", i, r.Error)
+			continue
+		}
+		if r.Message.Opaque != i ||
+			string(r.Message.Value) != string(msgs[i].Value) {
+			t.Errorf("Message %d: result %v does not match %v (opaque %v)",
+				i, r.Message, msgs[i], r.Message.Opaque)
+		}
+		if r.Message.TopicPartition.Offset < 0 {
+			t.Errorf("Message %d: expected an offset, got %v",
+				i, r.Message.TopicPartition)
+		}
+	}
+
+	// Nothing is delivered while the broker is down.
+	err = mc.SetBrokerDown(1)
+	if err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	defer mc.SetBrokerUp(1)
+
+	results, err = p.ProduceBatchSync(batch(3), time.Second)
+	if err == nil || err.(Error).Code() != ErrTimedOut {
+		t.Fatalf("Expected ErrTimedOut, got %v", err)
+	}
+	for i, r := range results {
+		if r.Error == nil || r.Error.(Error).Code() != ErrTimedOut {
+			t.Errorf("Message %d: expected ErrTimedOut, got %v", i, r.Error)
+		}
+	}
+}
+
+// TestProducerProduceIdempotent verifies that the idempotency key header
+// round-trips and that consumers deduplicate re-produced messages with it.
+func TestProducerProduceIdempotent(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "idempotenttopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	err = p.ProduceIdempotent("", &Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny}}, nil)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Fatalf("Expected ErrInvalidArg for empty key, got %v", err)
+	}
+
+	// "id1" is produced twice, the second time replacing a stale header.
+	keys := []string{"id1", "id2", "id1", "id3"}
+	deliveryChan := make(chan Event, len(keys))
+	for i, key := range keys {
+		msg := &Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+			Value:          []byte(fmt.Sprintf("value%d", i)),
+			Headers:        []Header{{Key: "other", Value: []byte("x")}}}
+		if i == 2 {
+			msg.Headers = append(msg.Headers,
+				Header{Key: IdempotencyKeyHeader, Value: []byte("stale")})
+		}
+		err = p.ProduceIdempotent(key, msg, deliveryChan)
+		if err != nil {
+			t.Fatalf("ProduceIdempotent: %v", err)
+		}
+	}
+	for range keys {
+		m := (<-deliveryChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition.Error)
+		}
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "idempotentgroup",
+		"auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	d, err := NewDeduplicator(DedupByIdempotencyKey, time.Minute, 100)
+	if err != nil {
+		t.Fatalf("NewDeduplicator: %v", err)
+	}
+
+	var unique []string
+	for i, m := range mockConsume(t, c, len(keys), 10*time.Second) {
+		key, ok := DedupByIdempotencyKey(m)
+		if !ok || key != keys[i] {
+			t.Errorf("Message %d: expected idempotency key %s, got %v", i, keys[i], m.Headers)
+		}
+		if len(m.Headers) != 2 {
+			t.Errorf("Message %d: expected 2 headers, got %v", i, m.Headers)
+		}
+		if !d.IsDuplicate(m) {
+			unique = append(unique, string(m.Value))
+		}
+	}
+
+	if fmt.Sprintf("%v", unique) != "[value0 value1 value3]" {
+		t.Errorf("Expected duplicate of id1 to be suppressed, got %v", unique)
+	}
+
+	// Messages sharing a Headers slice, with spare capacity, each get
+	// their own key and the shared headers are left untouched.
+	shared := make([]Header, 1, 4)
+	shared[0] = Header{Key: "other", Value: []byte("x")}
+	msgs := []*Message{
+		{TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny}, Headers: shared},
+		{TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny}, Headers: shared}}
+	for i, msg := range msgs {
+		err = p.ProduceIdempotent(fmt.Sprintf("shared%d", i), msg, deliveryChan)
+		if err != nil {
+			t.Fatalf("ProduceIdempotent: %v", err)
+		}
+	}
+	for i, msg := range msgs {
+		<-deliveryChan
+		key, ok := DedupByIdempotencyKey(msg)
+		if !ok || key != fmt.Sprintf("shared%d", i) {
+			t.Errorf("Message %d: expected its own idempotency key, got %v", i, msg.Headers)
+		}
+	}
+	if len(shared) != 1 || len(shared[:2][1].Key) != 0 {
+		t.Errorf("Expected the shared headers to be left untouched, got %v", shared[:2])
+	}
+}
+
+// TestProducerDeliveryErrorRetriable verifies that transient produce errors
+// are retried without being reported, and that delivery reports are
+// marked retriable only when librdkafka exhausted the retries of a
+// transient error.
+func TestProducerDeliveryErrorRetriable(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "deliveryretriabletopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"message.send.max.retries": 2,
+		"retry.backoff.ms":         10})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	const apiKeyProduce = 0
+
+	// Produces a message and returns its single delivery report.
+	produce := func() *Message {
+		deliveryChan := make(chan Event, 2)
+		err := p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("value")}, deliveryChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+		m := (<-deliveryChan).(*Message)
+		select {
+		case ev := <-deliveryChan:
+			t.Errorf("Expected a single delivery report, got %v", ev)
+		case <-time.After(200 * time.Millisecond):
+		}
+		return m
+	}
+
+	// Transient errors within the retries are not reported.
+	mc.SetRoundtripError(apiKeyProduce, ErrNotEnoughReplicas)
+	mc.SetRoundtripError(apiKeyProduce, ErrNotEnoughReplicas)
+	if m := produce(); m.TopicPartition.Error != nil {
+		t.Errorf("Expected retried message to be delivered, got %v",
+			m.TopicPartition.Error)
+	}
+
+	// Exhausted retries of a transient error.
+	for i := 0; i < 3; i++ {
+		mc.SetRoundtripError(apiKeyProduce, ErrNotEnoughReplicas)
+	}
+	m := produce()
+	if err, ok := m.TopicPartition.Error.(Error); !ok ||
+		err.Code() != ErrNotEnoughReplicas || !err.IsRetriable() {
+		t.Errorf("Expected retriable ErrNotEnoughReplicas, got %v", m.TopicPartition.Error)
+	}
+
+	// Permanent error.
+	mc.SetRoundtripError(apiKeyProduce, ErrMsgSizeTooLarge)
+	m = produce()
+	if err, ok := m.TopicPartition.Error.(Error); !ok ||
+		err.Code() != ErrMsgSizeTooLarge || err.IsRetriable() {
+		t.Errorf("Expected permanent ErrMsgSizeTooLarge, got %v", m.TopicPartition.Error)
+	}
+}
@@ -0,0 +1,160 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// TopicRateLimitStats provides the throttling statistics of a topic's
+// rate limit, see Producer.SetTopicRateLimit().
+type TopicRateLimitStats struct {
+	// BytesPerSec is the configured limit.
+	BytesPerSec int
+	// Throttled is the number of messages that were rejected with
+	// ErrRateLimited or delayed to stay within the limit.
+	Throttled int64
+	// ThrottledTime is the total time messages were delayed.
+	ThrottledTime time.Duration
+}
+
+// tokenBucket limits the number of bytes produced per second to a topic.
+// The bucket holds up to one second's worth of bytes, allowing short
+// bursts.
+type tokenBucket struct {
+	bytesPerSec float64
+	tokens      float64
+	last        time.Time
+	stats       TopicRateLimitStats
+}
+
+// take takes n bytes from the bucket, returning 0 if they were taken,
+// else how long to wait before retrying.
+// A message larger than the bucket is admitted once the bucket is full.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.bytesPerSec
+	if b.tokens > b.bytesPerSec {
+		b.tokens = b.bytesPerSec
+	}
+	b.last = now
+
+	need := float64(n)
+	if need > b.bytesPerSec {
+		need = b.bytesPerSec
+	}
+	if b.tokens >= need {
+		b.tokens -= float64(n)
+		return 0
+	}
+
+	return time.Duration((need - b.tokens) / b.bytesPerSec * float64(time.Second))
+}
+
+// topicRateLimits holds the token buckets of the rate limited topics.
+type topicRateLimits struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// SetTopicRateLimit limits the number of bytes, key and value, produced
+// to topic by this producer to bytesPerSec, as enforced by a token bucket
+// in the Go client that allows bursts of up to one second's worth of
+// bytes.
+// This protects a shared cluster from a runaway producer independently of
+// broker quotas.
+// A bytesPerSec of 0 removes the limit.
+//
+// Produce() returns ErrRateLimited rather than enqueuing a message that
+// exceeds the limit, the message may be produced again later.
+// Messages sent on the ProduceChannel() instead block until they are
+// within the limit, also with go.batch.producer.
+// The limit is applied to messages as passed by the application, before
+// the producer interceptors' OnSend(), which do not see rejected messages.
+//
+// The throttling is reported by TopicRateLimitStats().
+func (p *Producer) SetTopicRateLimit(topic string, bytesPerSec int) {
+	p.rateLimits.lock.Lock()
+	defer p.rateLimits.lock.Unlock()
+
+	if bytesPerSec <= 0 {
+		delete(p.rateLimits.buckets, topic)
+		return
+	}
+
+	if p.rateLimits.buckets == nil {
+		p.rateLimits.buckets = make(map[string]*tokenBucket)
+	}
+
+	b, found := p.rateLimits.buckets[topic]
+	if !found {
+		b = &tokenBucket{tokens: float64(bytesPerSec), last: time.Now()}
+		p.rateLimits.buckets[topic] = b
+	}
+	b.bytesPerSec = float64(bytesPerSec)
+	b.stats.BytesPerSec = bytesPerSec
+}
+
+// TopicRateLimitStats returns the throttling statistics of topic,
+// or false if topic is not rate limited, see SetTopicRateLimit().
+func (p *Producer) TopicRateLimitStats(topic string) (TopicRateLimitStats, bool) {
+	p.rateLimits.lock.Lock()
+	defer p.rateLimits.lock.Unlock()
+
+	b, found := p.rateLimits.buckets[topic]
+	if !found {
+		return TopicRateLimitStats{}, false
+	}
+
+	return b.stats, true
+}
+
+// rateLimit applies the rate limit, if any, of msg's topic.
+// If block is true it waits until msg is within the limit, else it returns
+// ErrRateLimited if msg exceeds the limit.
+func (p *Producer) rateLimit(msg *Message, block bool) error {
+	n := len(msg.Key) + len(msg.Value)
+	var waited time.Duration
+
+	for {
+		p.rateLimits.lock.Lock()
+		b, found := p.rateLimits.buckets[*msg.TopicPartition.Topic]
+		if !found {
+			p.rateLimits.lock.Unlock()
+			return nil
+		}
+
+		wait := b.take(n, time.Now())
+		if wait == 0 || !block {
+			if wait > 0 || waited > 0 {
+				b.stats.Throttled++
+				b.stats.ThrottledTime += waited
+			}
+			p.rateLimits.lock.Unlock()
+
+			if wait > 0 {
+				return newErrorFromString(ErrRateLimited,
+					"Topic rate limit exceeded")
+			}
+			return nil
+		}
+		p.rateLimits.lock.Unlock()
+
+		time.Sleep(wait)
+		waited += wait
+	}
+}
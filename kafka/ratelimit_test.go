@@ -0,0 +1,229 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTokenBucket verifies the token bucket refill, bursts and
+// admission of messages larger than the bucket.
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{bytesPerSec: 1000, tokens: 1000, last: now}
+
+	if wait := b.take(600, now); wait != 0 {
+		t.Fatalf("Expected burst within the bucket to be admitted, got wait %v", wait)
+	}
+	wait := b.take(600, now)
+	if wait != 200*time.Millisecond {
+		t.Fatalf("Expected a 200ms wait, got %v", wait)
+	}
+	if wait = b.take(600, now.Add(wait)); wait != 0 {
+		t.Fatalf("Expected admission after the wait, got wait %v", wait)
+	}
+
+	// Larger than the bucket: admitted once the bucket is full.
+	now = now.Add(200 * time.Millisecond)
+	if wait = b.take(5000, now); wait != time.Second {
+		t.Fatalf("Expected a 1s wait for the bucket to fill, got %v", wait)
+	}
+	if wait = b.take(5000, now.Add(2*time.Second)); wait != 0 {
+		t.Fatalf("Expected admission once full, got wait %v", wait)
+	}
+}
+
+// sendCountingInterceptor counts the OnSend() and OnAcknowledgement() calls.
+type sendCountingInterceptor struct {
+	sends int64
+	acks  int64
+}
+
+func (si *sendCountingInterceptor) OnSend(msg *Message) *Message {
+	atomic.AddInt64(&si.sends, 1)
+	return msg
+}
+
+func (si *sendCountingInterceptor) OnAcknowledgement(msg *Message, err error) {
+	atomic.AddInt64(&si.acks, 1)
+}
+
+// TestProducerTopicRateLimit verifies that Produce() returns
+// ErrRateLimited, and that the ProduceChannel() blocks, when a topic
+// exceeds its rate limit.
+func TestProducerTopicRateLimit(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	topic := "ratelimitedtopic"
+	otherTopic := "otherratelimittopic"
+	for _, name := range []string{topic, otherTopic} {
+		err = mc.CreateTopic(name, 1, 1)
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+	}
+	value := make([]byte, 500)
+
+	interceptor := &sendCountingInterceptor{}
+	p.AddInterceptor(interceptor)
+
+	p.SetTopicRateLimit(topic, 1000)
+
+	produce := func(topic string) error {
+		return p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+			Value:          value}, nil)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = produce(topic); err != nil {
+			t.Fatalf("Produce %d: %v", i, err)
+		}
+	}
+	err = produce(topic)
+	if err == nil || err.(Error).Code() != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	if err = produce(otherTopic); err != nil {
+		t.Fatalf("Expected other topic not to be rate limited, got %v", err)
+	}
+
+	stats, ok := p.TopicRateLimitStats(topic)
+	if !ok || stats.BytesPerSec != 1000 || stats.Throttled != 1 {
+		t.Errorf("Expected 1 throttled message at 1000 bytes/s, got %+v (%v)",
+			stats, ok)
+	}
+	if _, ok = p.TopicRateLimitStats(otherTopic); ok {
+		t.Errorf("Expected no stats for a topic without rate limit")
+	}
+
+	// The channel producer waits for the bucket to refill.
+	start := time.Now()
+	p.ProduceChannel() <- &Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+		Value:          value}
+	p.ProduceChannel() <- &Message{
+		TopicPartition: TopicPartition{Topic: &otherTopic, Partition: PartitionAny},
+		Value:          value}
+	// Wait for the delivery of the 5 messages produced so far.
+	for delivered := 0; delivered < 5; {
+		select {
+		case ev := <-p.Events():
+			if m, ok := ev.(*Message); ok {
+				if m.TopicPartition.Error != nil {
+					t.Fatalf("Delivery failed: %v", m.TopicPartition)
+				}
+				delivered++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for delivery reports")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected ProduceChannel() to block for ~500ms, took %v", elapsed)
+	}
+
+	// The rate limited message was not passed to the interceptors.
+	sends, acks := atomic.LoadInt64(&interceptor.sends), atomic.LoadInt64(&interceptor.acks)
+	if sends != 5 || acks != 5 {
+		t.Errorf("Expected 5 sends and acknowledgements, got %d and %d", sends, acks)
+	}
+
+	stats, _ = p.TopicRateLimitStats(topic)
+	if stats.Throttled != 2 || stats.ThrottledTime == 0 {
+		t.Errorf("Expected 2 throttled messages and a throttled time, got %+v", stats)
+	}
+
+	p.SetTopicRateLimit(topic, 0)
+	if err = produce(topic); err != nil {
+		t.Errorf("Expected no rate limit once removed, got %v", err)
+	}
+	if _, ok = p.TopicRateLimitStats(topic); ok {
+		t.Errorf("Expected no stats once the rate limit is removed")
+	}
+}
+
+// TestProducerTopicRateLimitBatch verifies that the rate limit also applies
+// to the ProduceChannel() with go.batch.producer.
+func TestProducerTopicRateLimitBatch(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"go.batch.producer": true})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	topic := "ratelimitedbatchtopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	value := make([]byte, 500)
+
+	p.SetTopicRateLimit(topic, 1000)
+
+	// The first 2 messages are within the burst, the other 2 wait for
+	// the bucket to refill.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		p.ProduceChannel() <- &Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: PartitionAny},
+			Value:          value}
+	}
+
+	for delivered := 0; delivered < 4; {
+		select {
+		case ev := <-p.Events():
+			if m, ok := ev.(*Message); ok {
+				if m.TopicPartition.Error != nil {
+					t.Fatalf("Delivery failed: %v", m.TopicPartition)
+				}
+				delivered++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for delivery reports")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("Expected the batch producer to block for ~1s, took %v", elapsed)
+	}
+
+	stats, _ := p.TopicRateLimitStats(topic)
+	if stats.Throttled != 2 {
+		t.Errorf("Expected 2 throttled messages, got %+v", stats)
+	}
+}
@@ -0,0 +1,184 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// rebalanceReasonMarker precedes the reason in librdkafka's `debug=cgrp`
+// REBALANCE logs, e.g.:
+//
+//	Group "g" is rebalancing (EAGER) in state up (join-state steady)
+//	with 4 assigned partition(s): rebalance in progress
+const rebalanceReasonMarker = " assigned partition(s): "
+
+// parseRebalanceReason returns the rebalance reason of a librdkafka log,
+// or false if the log is not a REBALANCE log with a reason.
+func parseRebalanceReason(tag, message string) (string, bool) {
+	if tag != "REBALANCE" || !strings.HasPrefix(message, "[thrd:") {
+		return "", false
+	}
+
+	idx := strings.Index(message, rebalanceReasonMarker)
+	if idx == -1 {
+		return "", false
+	}
+
+	reason := message[idx+len(rebalanceReasonMarker):]
+	return reason, reason != ""
+}
+
+// setRebalanceReason records the rebalance reason of logEvent, if any.
+// Called from the log queue goroutine.
+func (h *handle) setRebalanceReason(logEvent LogEvent) {
+	reason, ok := parseRebalanceReason(logEvent.Tag, logEvent.Message)
+	if !ok {
+		return
+	}
+
+	h.rebalanceReasonLock.Lock()
+	h.rebalanceReason = reason
+	h.rebalanceReasonLock.Unlock()
+}
+
+// LastRebalanceReason returns the reason for the consumer group's last
+// rebalance, e.g., "rebalance in progress" as another member joins the
+// group, or "Metadata for subscribed topic(s) has changed", or an empty
+// string if unknown.
+//
+// This is best-effort diagnostics only: librdkafka does not provide the
+// reason with the rebalance event, so it is parsed from librdkafka's
+// `debug=cgrp` REBALANCE logs as they are read from the Logs() channel.
+// It thus requires `go.logs.channel.enable=true`, the Logs() channel being
+// consumed, and `debug` including `cgrp`. The logs are read independently
+// of the rebalance events, so during a rebalance the previous rebalance's
+// reason may still be returned, and the reason is lost if librdkafka
+// changes the wording of these logs.
+func (c *Consumer) LastRebalanceReason() string {
+	c.handle.rebalanceReasonLock.Lock()
+	defer c.handle.rebalanceReasonLock.Unlock()
+	return c.handle.rebalanceReason
+}
+
+// formatPartitionKeys formats partitions as a sorted, comma separated,
+// list of topic[partition].
+func formatPartitionKeys(partitions map[topicPartitionKey]bool) string {
+	keys := make([]topicPartitionKey, 0, len(partitions))
+	for key := range partitions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = fmt.Sprintf("%s[%d]", key.topic, key.partition)
+	}
+
+	return "[" + strings.Join(strs, ",") + "]"
+}
+
+// logRebalance logs a rebalance event, with `go.rebalance.log.enable`, as
+// a REBALANCE log of space separated key=value fields:
+//
+//	group=<group.id> generation=unknown protocol=<EAGER|COOPERATIVE>
+//	event=<assign|revoke> added=[<topic>[<partition>],..] revoked=[..]
+//	assignment=[..]
+//
+// where added and revoked are relative to the partitions owned before the
+// rebalance and assignment is the resulting assignment.
+// The generation id is not exposed by librdkafka 1.7.0's public API and
+// is thus logged as unknown.
+// For the EAGER protocol, which revokes the entire assignment before
+// assigning the new one, the assign event logs the net change of the
+// rebalance.
+//
+// Called from the poll path only.
+func (c *Consumer) logRebalance(assign bool, partitions []TopicPartition) {
+	const logInfo = 6 // syslog LOG_INFO
+
+	protocol := c.GetRebalanceProtocol()
+	if protocol == "" {
+		// No rebalance protocol while no assignment has been made.
+		protocol = "NONE"
+	}
+	cooperative := protocol == "COOPERATIVE"
+
+	event := make(map[topicPartitionKey]bool, len(partitions))
+	for _, tp := range partitions {
+		event[topicPartitionKey{*tp.Topic, tp.Partition}] = true
+	}
+
+	added := make(map[topicPartitionKey]bool)
+	revoked := make(map[topicPartitionKey]bool)
+
+	if assign {
+		for key := range event {
+			if !c.rebalanceLogOwned[key] {
+				added[key] = true
+			}
+		}
+		if !cooperative {
+			// The new assignment replaces the owned partitions.
+			for key := range c.rebalanceLogOwned {
+				if !event[key] {
+					revoked[key] = true
+				}
+			}
+			c.rebalanceLogOwned = make(map[topicPartitionKey]bool)
+		}
+		for key := range event {
+			c.rebalanceLogOwned[key] = true
+		}
+	} else {
+		for key := range event {
+			revoked[key] = true
+		}
+		if cooperative {
+			for key := range event {
+				delete(c.rebalanceLogOwned, key)
+			}
+		}
+		// With EAGER the owned partitions are kept until the
+		// assign event, to log the net change of the rebalance.
+	}
+
+	assignment := c.rebalanceLogOwned
+	if !assign && !cooperative {
+		assignment = nil
+	}
+
+	eventName := "revoke"
+	if assign {
+		eventName = "assign"
+	}
+
+	group, _ := c.handle.getConfigValue("group.id")
+
+	c.handle.log(logInfo, "REBALANCE", fmt.Sprintf(
+		"group=%s generation=unknown protocol=%s event=%s added=%s revoked=%s assignment=%s",
+		group, protocol, eventName,
+		formatPartitionKeys(added), formatPartitionKeys(revoked),
+		formatPartitionKeys(assignment)))
+}
@@ -0,0 +1,218 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRebalanceLog verifies that assign and revoke rebalance events are
+// logged with `go.rebalance.log.enable`.
+func TestRebalanceLog(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "rebalancelogtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":       mc.BootstrapServers(),
+		"group.id":                "rebalanceloggroup",
+		"go.logs.channel.enable":  true,
+		"go.rebalance.log.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	// Polls the consumer until a REBALANCE log is received.
+	nextLog := func() string {
+		for start := time.Now(); time.Since(start) < 30*time.Second; {
+			select {
+			case ev := <-c.Logs():
+				if ev.Tag == "REBALANCE" {
+					return ev.Message
+				}
+			default:
+				c.Poll(100)
+			}
+		}
+		t.Fatalf("Timed out waiting for REBALANCE log")
+		return ""
+	}
+
+	expectFields := func(msg string, fields ...string) {
+		for _, field := range fields {
+			if !strings.Contains(msg, field) {
+				t.Errorf("Expected %q in REBALANCE log %q", field, msg)
+			}
+		}
+	}
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msg := nextLog()
+	expectFields(msg,
+		"group=rebalanceloggroup ",
+		"generation=unknown ",
+		"protocol=EAGER ",
+		"event=assign ",
+		"added=[rebalancelogtopic[0],rebalancelogtopic[1]] ",
+		"revoked=[] ",
+		"assignment=[rebalancelogtopic[0],rebalancelogtopic[1]]")
+
+	err = c.Unsubscribe()
+	if err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+
+	msg = nextLog()
+	expectFields(msg,
+		"event=revoke ",
+		"added=[] ",
+		"revoked=[rebalancelogtopic[0],rebalancelogtopic[1]] ")
+}
+
+// TestParseRebalanceReason verifies the parsing of librdkafka's
+// REBALANCE logs.
+func TestParseRebalanceReason(t *testing.T) {
+	for _, tc := range []struct {
+		tag      string
+		message  string
+		expected string
+	}{
+		{"REBALANCE", "[thrd:main]: Group \"g\" is rebalancing (EAGER) in state up (join-state steady) with 4 assigned partition(s): rebalance in progress",
+			"rebalance in progress"},
+		{"REBALANCE", "[thrd:main]: Group \"g\" initiating rebalance (NONE) in state up (join-state wait-metadata) with 0 assigned partition(s): Metadata for subscribed topic(s) has changed",
+			"Metadata for subscribed topic(s) has changed"},
+		// go.rebalance.log.enable log
+		{"REBALANCE", "group=g generation=1 protocol=EAGER event=assign added=[] revoked=[] assignment=[]", ""},
+		{"ASSIGN", "[thrd:main]: Group \"g\": delegating assign of 2 partition(s) to application on queue rd_kafka_cgrp_new: new assignment", ""},
+	} {
+		reason, ok := parseRebalanceReason(tc.tag, tc.message)
+		if reason != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("Expected reason %q for %s %q, got %q (%v)",
+				tc.expected, tc.tag, tc.message, reason, ok)
+		}
+	}
+}
+
+// TestRebalanceReason verifies that LastRebalanceReason() is set as a
+// second member joins the group.
+func TestRebalanceReason(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "rebalancereasontopic"
+	err = mc.CreateTopic(topic, 4, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	newConsumer := func() (*Consumer, chan Event) {
+		c, err := NewConsumer(&ConfigMap{
+			"bootstrap.servers":      mc.BootstrapServers(),
+			"group.id":               "rebalancereasongroup",
+			"debug":                  "cgrp",
+			"go.logs.channel.enable": true,
+			"session.timeout.ms":     6000,
+			"heartbeat.interval.ms":  500})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+
+		rebalances := make(chan Event, 10)
+		err = c.Subscribe(topic, func(c *Consumer, ev Event) error {
+			rebalances <- ev
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		return c, rebalances
+	}
+
+	c1, rebalances1 := newConsumer()
+	defer c1.Close()
+	consumers := []*Consumer{c1}
+
+	// Polls the consumers, draining their logs, until a rebalance event
+	// is received on rebalances.
+	nextRebalance := func(rebalances chan Event) Event {
+		for start := time.Now(); time.Since(start) < 30*time.Second; {
+			select {
+			case ev := <-rebalances:
+				return ev
+			default:
+			}
+			for _, c := range consumers {
+				for len(c.Logs()) > 0 {
+					<-c.Logs()
+				}
+				c.Poll(50)
+			}
+		}
+		t.Fatalf("Timed out waiting for rebalance")
+		return nil
+	}
+
+	if _, ok := nextRebalance(rebalances1).(AssignedPartitions); !ok {
+		t.Fatalf("Expected initial AssignedPartitions")
+	}
+
+	c2, rebalances2 := newConsumer()
+	defer c2.Close()
+	consumers = append(consumers, c2)
+
+	if _, ok := nextRebalance(rebalances1).(RevokedPartitions); !ok {
+		t.Fatalf("Expected RevokedPartitions as the second member joins")
+	}
+
+	for _, rebalances := range []chan Event{rebalances1, rebalances2} {
+		if _, ok := nextRebalance(rebalances).(AssignedPartitions); !ok {
+			t.Fatalf("Expected AssignedPartitions as the second member joins")
+		}
+	}
+
+	// The reason is read from the logs independently of the rebalance
+	// events, drain the remaining logs.
+	for _, c := range consumers {
+		for start := time.Now(); c.LastRebalanceReason() == "" && time.Since(start) < 10*time.Second; {
+			select {
+			case <-c.Logs():
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if c.LastRebalanceReason() == "" {
+			t.Errorf("Expected %v to have a rebalance reason", c)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy controls how an operation is retried on retriable errors,
+// see Consumer.SetWatermarkRetryPolicy() and Consumer.CommitWithRetry().
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the initial
+	// attempt, 0 disables retries.
+	MaxRetries int
+	// Backoff is the time to wait before the first retry, doubled for
+	// each subsequent retry up to MaxBackoff.
+	Backoff time.Duration
+	// MaxBackoff is the maximum time to wait between retries.
+	MaxBackoff time.Duration
+	// AttemptTimeout is the maximum time each attempt may take.
+	AttemptTimeout time.Duration
+}
+
+// validate returns ErrInvalidArg if the policy is invalid.
+func (policy RetryPolicy) validate() error {
+	if policy.MaxRetries < 0 || policy.Backoff < 0 ||
+		policy.MaxBackoff < policy.Backoff || policy.AttemptTimeout <= 0 {
+		return newErrorFromString(ErrInvalidArg,
+			"Invalid retry policy")
+	}
+	return nil
+}
+
+// retryWithPolicy calls attempt, with the time the attempt may take,
+// retrying errors for which retriable returns true according to policy,
+// until the attempt succeeds, fails with a non-retriable error, the
+// retries are exhausted or ctx is done.
+// Returns the last attempt's error, or ctx.Err() if ctx was done while
+// waiting to retry.
+func retryWithPolicy(ctx context.Context, policy RetryPolicy, retriable func(error) bool, attempt func(attemptTimeout time.Duration) error) error {
+	backoff := policy.Backoff
+
+	for retries := 0; ; retries++ {
+		attemptTimeout := policy.AttemptTimeout
+		if remaining, ok := timeout(ctx); ok && remaining < attemptTimeout {
+			attemptTimeout = remaining
+		}
+		if attemptTimeout <= 0 {
+			return newErrorFromString(ErrTimedOut,
+				"Timed out before attempting the operation")
+		}
+
+		err := attempt(attemptTimeout)
+		if err == nil || !retriable(err) || retries >= policy.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
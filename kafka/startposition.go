@@ -0,0 +1,241 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+)
+
+// startPositionLookupTimeoutMs is the maximum time spent looking up the
+// committed offsets of assigned partitions to resolve their StartSource.
+const startPositionLookupTimeoutMs = 5 * 1000
+
+// StartSource is where the initial consume position of an assigned
+// partition came from, see Consumer.StartPositions().
+type StartSource int
+
+const (
+	// StartSourceUnknown - the source has not been resolved yet, or the
+	// committed offset lookup failed
+	StartSourceUnknown = StartSource(iota)
+	// StartSourceCommitted - the partition's committed offset
+	StartSourceCommitted
+	// StartSourceReset - `auto.offset.reset`, since there was no committed
+	// offset
+	StartSourceReset
+	// StartSourceExplicit - an offset passed to Assign(),
+	// IncrementalAssign() or Seek()
+	StartSourceExplicit
+)
+
+func (s StartSource) String() string {
+	switch s {
+	case StartSourceCommitted:
+		return "committed"
+	case StartSourceReset:
+		return "reset"
+	case StartSourceExplicit:
+		return "explicit"
+	default:
+		return "unknown"
+	}
+}
+
+// StartPosition is the initial consume position of an assigned partition.
+type StartPosition struct {
+	// TopicPartition is the partition, with Offset set to the committed
+	// or explicit offset, or to OffsetBeginning or OffsetEnd according to
+	// `auto.offset.reset` (OffsetInvalid for `error`).
+	TopicPartition TopicPartition
+	// Source is where the position came from.
+	Source StartSource
+}
+
+func (sp StartPosition) String() string {
+	return fmt.Sprintf("%v (%v)", sp.TopicPartition, sp.Source)
+}
+
+// startPositions tracks the StartPosition of the assigned partitions,
+// with `go.start.positions.enable`.
+type startPositions struct {
+	lock      sync.Mutex
+	positions map[topicPartitionKey]StartPosition
+	// Offset auto.offset.reset resets to.
+	resetOffset Offset
+}
+
+// setupStartPositions enables start position tracking.
+func (c *Consumer) setupStartPositions() {
+	c.startPositions = &startPositions{
+		positions:   make(map[topicPartitionKey]StartPosition),
+		resetOffset: c.autoOffsetReset(),
+	}
+}
+
+// StartPositions returns the StartPosition of each assigned partition,
+// i.e., whether its initial consume position came from its committed
+// offset, `auto.offset.reset` or an explicit offset, to tell where the
+// consumer actually started, e.g., during incident analysis.
+// Needs to be explicitly enabled by setting the `go.start.positions.enable`
+// configuration property, else nil is returned.
+//
+// The partitions assigned with OffsetStored or OffsetInvalid, typically by
+// a subscription, are resolved by looking up their committed offsets
+// right after assignment, in the background, their Source is
+// StartSourceUnknown until then. A commit made between the assignment
+// and the lookup, which is unusual before consuming, is taken as the
+// committed start offset.
+// Seek() replaces the StartPosition of the sought partition with the sought
+// offset, as StartSourceExplicit.
+func (c *Consumer) StartPositions() []StartPosition {
+	sp := c.startPositions
+	if sp == nil {
+		return nil
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	positions := make([]StartPosition, 0, len(sp.positions))
+	for _, pos := range sp.positions {
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+// trackStartPositions records the StartPosition of newly assigned
+// partitions, replacing the tracked partitions if replace is true, and
+// looks up the committed offsets of the partitions without an explicit
+// offset in the background.
+func (c *Consumer) trackStartPositions(partitions []TopicPartition, replace bool) {
+	sp := c.startPositions
+	if sp == nil {
+		return
+	}
+
+	var lookup []TopicPartition
+
+	sp.lock.Lock()
+	if replace {
+		sp.positions = make(map[topicPartitionKey]StartPosition)
+	}
+	for _, tp := range partitions {
+		pos := StartPosition{TopicPartition: TopicPartition{
+			Topic:     tp.Topic,
+			Partition: tp.Partition,
+			Offset:    tp.Offset,
+		}}
+		if tp.Offset == OffsetStored || tp.Offset == OffsetInvalid {
+			lookup = append(lookup, pos.TopicPartition)
+		} else {
+			pos.Source = StartSourceExplicit
+		}
+		sp.positions[topicPartitionKey{*tp.Topic, tp.Partition}] = pos
+	}
+	sp.lock.Unlock()
+
+	if len(lookup) == 0 {
+		return
+	}
+
+	c.handle.waitGroup.Add(1)
+	go func() {
+		defer c.handle.waitGroup.Done()
+		c.resolveStartPositions(lookup)
+	}()
+}
+
+// resolveStartPositions resolves the StartSource of partitions from
+// their committed offsets.
+func (c *Consumer) resolveStartPositions(partitions []TopicPartition) {
+	sp := c.startPositions
+
+	committed, err := c.Committed(partitions, startPositionLookupTimeoutMs)
+	if err != nil {
+		return
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	for _, tp := range committed {
+		if tp.Error != nil {
+			continue
+		}
+
+		key := topicPartitionKey{*tp.Topic, tp.Partition}
+		pos, found := sp.positions[key]
+		if !found || pos.Source != StartSourceUnknown {
+			// Unassigned or sought meanwhile.
+			continue
+		}
+
+		if tp.Offset >= 0 {
+			pos.TopicPartition.Offset = tp.Offset
+			pos.Source = StartSourceCommitted
+		} else {
+			pos.TopicPartition.Offset = sp.resetOffset
+			pos.Source = StartSourceReset
+		}
+		sp.positions[key] = pos
+	}
+}
+
+// untrackStartPositions stops tracking partitions, or all partitions if
+// partitions is nil.
+func (c *Consumer) untrackStartPositions(partitions []TopicPartition) {
+	sp := c.startPositions
+	if sp == nil {
+		return
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	if partitions == nil {
+		sp.positions = make(map[topicPartitionKey]StartPosition)
+		return
+	}
+	for _, tp := range partitions {
+		delete(sp.positions, topicPartitionKey{*tp.Topic, tp.Partition})
+	}
+}
+
+// trackSeek records the explicit start position of a sought partition.
+func (c *Consumer) trackSeek(partition TopicPartition) {
+	sp := c.startPositions
+	if sp == nil {
+		return
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	key := topicPartitionKey{*partition.Topic, partition.Partition}
+	if _, found := sp.positions[key]; !found {
+		return
+	}
+	sp.positions[key] = StartPosition{
+		TopicPartition: TopicPartition{
+			Topic:     partition.Topic,
+			Partition: partition.Partition,
+			Offset:    partition.Offset,
+		},
+		Source: StartSourceExplicit,
+	}
+}
@@ -0,0 +1,141 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConsumerStartPositions verifies that the start position of each
+// partition is attributed to its committed offset, auto.offset.reset or
+// an explicit offset.
+func TestConsumerStartPositions(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "startpositiontopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	mockProduce(t, mc, topic, 0, 5)
+	mockProduce(t, mc, topic, 1, 5)
+
+	// Only partition 0 has a committed offset.
+	committer, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "startpositiongroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	_, err = committer.CommitOffsets([]TopicPartition{{Topic: &topic, Partition: 0, Offset: 3}})
+	if err != nil {
+		t.Fatalf("CommitOffsets: %v", err)
+	}
+	committer.Close()
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":         mc.BootstrapServers(),
+		"group.id":                  "startpositiongroup",
+		"auto.offset.reset":         "earliest",
+		"session.timeout.ms":        6000,
+		"go.start.positions.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	if positions := c.StartPositions(); len(positions) != 0 {
+		t.Fatalf("Expected no start positions before assignment, got %v",
+			positions)
+	}
+
+	err = c.Subscribe(topic, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Returns the start positions by partition, once resolved.
+	waitStartPositions := func() map[int32]StartPosition {
+		tEnd := time.Now().Add(30 * time.Second)
+		for {
+			c.Poll(100)
+
+			byPartition := make(map[int32]StartPosition)
+			for _, pos := range c.StartPositions() {
+				if pos.Source != StartSourceUnknown {
+					byPartition[pos.TopicPartition.Partition] = pos
+				}
+			}
+			if len(byPartition) == 2 {
+				return byPartition
+			}
+			if time.Now().After(tEnd) {
+				t.Fatalf("Timed out waiting for start positions, got %v",
+					c.StartPositions())
+			}
+		}
+	}
+
+	positions := waitStartPositions()
+	if pos := positions[0]; pos.Source != StartSourceCommitted ||
+		pos.TopicPartition.Offset != 3 {
+		t.Errorf("Expected partition 0 to start at committed offset 3, got %v", pos)
+	}
+	if pos := positions[1]; pos.Source != StartSourceReset ||
+		pos.TopicPartition.Offset != OffsetBeginning {
+		t.Errorf("Expected partition 1 to start from auto.offset.reset "+
+			"beginning, got %v", pos)
+	}
+
+	err = c.Seek(TopicPartition{Topic: &topic, Partition: 1, Offset: 2}, 5000)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	positions = waitStartPositions()
+	if pos := positions[1]; pos.Source != StartSourceExplicit ||
+		pos.TopicPartition.Offset != 2 {
+		t.Errorf("Expected partition 1 to start at explicit offset 2, got %v", pos)
+	}
+
+	err = c.Unsubscribe()
+	if err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	// Let the revoke be handled before assigning manually.
+	for len(c.StartPositions()) > 0 {
+		c.Poll(100)
+	}
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetEnd},
+		{Topic: &topic, Partition: 1, Offset: OffsetStored}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	positions = waitStartPositions()
+	if pos := positions[0]; pos.Source != StartSourceExplicit ||
+		pos.TopicPartition.Offset != OffsetEnd {
+		t.Errorf("Expected partition 0 to start at explicit end, got %v", pos)
+	}
+	if pos := positions[1]; pos.Source != StartSourceReset {
+		t.Errorf("Expected partition 1 to start from auto.offset.reset, got %v", pos)
+	}
+}
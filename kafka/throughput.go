@@ -0,0 +1,130 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// throughputBuckets is the number of buckets the sliding window
+// of a throughputMeter is divided into.
+const throughputBuckets = 10
+
+// throughputPartition holds the per-partition message counts of the
+// buckets in the sliding window.
+type throughputPartition struct {
+	counts [throughputBuckets]int64
+	// Index of the most recently counted bucket.
+	lastBucket int64
+	// When the first message was counted.
+	start time.Time
+}
+
+// throughputMeter counts consumed messages per partition over a sliding
+// window, divided into throughputBuckets buckets.
+type throughputMeter struct {
+	lock       sync.Mutex
+	bucketDur  time.Duration
+	partitions map[topicPartitionKey]*throughputPartition
+}
+
+func newThroughputMeter(window time.Duration) *throughputMeter {
+	return &throughputMeter{
+		bucketDur:  window / throughputBuckets,
+		partitions: make(map[topicPartitionKey]*throughputPartition),
+	}
+}
+
+// expire clears the buckets of tp that have slid out of the window
+// since the last counted bucket, up to and including bucket.
+func (tp *throughputPartition) expire(bucket int64) {
+	if bucket <= tp.lastBucket {
+		return
+	}
+
+	if bucket-tp.lastBucket >= throughputBuckets {
+		tp.counts = [throughputBuckets]int64{}
+	} else {
+		for b := tp.lastBucket + 1; b <= bucket; b++ {
+			tp.counts[b%throughputBuckets] = 0
+		}
+	}
+
+	tp.lastBucket = bucket
+}
+
+// add counts a consumed message for the given partition.
+func (m *throughputMeter) add(topic string, partition int32, now time.Time) {
+	bucket := now.UnixNano() / int64(m.bucketDur)
+	key := topicPartitionKey{topic, partition}
+
+	m.lock.Lock()
+	tp, found := m.partitions[key]
+	if !found {
+		tp = &throughputPartition{lastBucket: bucket, start: now}
+		m.partitions[key] = tp
+	}
+
+	tp.expire(bucket)
+	tp.counts[bucket%throughputBuckets]++
+	m.lock.Unlock()
+}
+
+// rates returns the messages/second rate of each partition over the
+// window, removing partitions with no messages in the window.
+func (m *throughputMeter) rates(now time.Time) map[TopicPartition]float64 {
+	bucket := now.UnixNano() / int64(m.bucketDur)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rates := make(map[TopicPartition]float64, len(m.partitions))
+
+	for key, tp := range m.partitions {
+		tp.expire(bucket)
+
+		var sum int64
+		for _, cnt := range tp.counts {
+			sum += cnt
+		}
+
+		if sum == 0 {
+			delete(m.partitions, key)
+			continue
+		}
+
+		// The window spans the full older buckets plus the
+		// elapsed part of the current bucket, while partitions that
+		// started consuming within the window have not been measured
+		// for the full window.
+		elapsed := time.Duration((throughputBuckets-1)*int64(m.bucketDur) +
+			now.UnixNano()%int64(m.bucketDur))
+		if since := now.Sub(tp.start); since < elapsed {
+			elapsed = since
+		}
+		if elapsed < m.bucketDur {
+			elapsed = m.bucketDur
+		}
+
+		topic := key.topic
+		rates[TopicPartition{Topic: &topic, Partition: key.partition}] =
+			float64(sum) / elapsed.Seconds()
+	}
+
+	return rates
+}
@@ -0,0 +1,140 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestThroughputMeter verifies the sliding window rate calculation.
+func TestThroughputMeter(t *testing.T) {
+	m := newThroughputMeter(10 * time.Second)
+
+	rate := func(rates map[TopicPartition]float64, partition int32) float64 {
+		for tp, r := range rates {
+			if tp.Partition == partition {
+				return r
+			}
+		}
+		return 0
+	}
+
+	// Start at a bucket boundary.
+	now := time.Unix(1600000000, 0)
+
+	// 10 msgs/s on partition 0 and 1 msg/s on partition 1 for 20s.
+	for s := 0; s < 20; s++ {
+		for i := 0; i < 10; i++ {
+			ts := now.Add(time.Duration(s)*time.Second +
+				time.Duration(i)*100*time.Millisecond)
+			m.add("topic", 0, ts)
+			if i == 0 {
+				m.add("topic", 1, ts)
+			}
+		}
+	}
+
+	rates := m.rates(now.Add(20 * time.Second))
+	if r := rate(rates, 0); r < 9.5 || r > 10.5 {
+		t.Errorf("Expected ~10 msgs/s for partition 0, got %v", rates)
+	}
+	if r := rate(rates, 1); r < 0.9 || r > 1.1 {
+		t.Errorf("Expected ~1 msgs/s for partition 1, got %v", rates)
+	}
+
+	// Only 4 of the 9 seconds in the window have messages.
+	rates = m.rates(now.Add(25 * time.Second))
+	if r := rate(rates, 0); r < 4.3 || r > 4.6 {
+		t.Errorf("Expected ~4.4 msgs/s for partition 0, got %v", rates)
+	}
+
+	// No messages in the window.
+	rates = m.rates(now.Add(31 * time.Second))
+	if len(rates) != 0 {
+		t.Errorf("Expected no rates after the window, got %v", rates)
+	}
+}
+
+// TestConsumerPartitionThroughput consumes unevenly produced partitions
+// and verifies that the reported rates reflect the skew.
+func TestConsumerPartitionThroughput(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "throughputtopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	mockProduce(t, mc, topic, 0, 500)
+	mockProduce(t, mc, topic, 1, 50)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "gotest"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: OffsetBeginning},
+		{Topic: &topic, Partition: 1, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	mockConsume(t, c, 550, 30*time.Second)
+
+	rates := c.PartitionThroughput()
+	t.Logf("Partition throughput: %v", rates)
+
+	var rate0, rate1 float64
+	for tp, r := range rates {
+		if *tp.Topic != topic {
+			t.Errorf("Unexpected partition %v", tp)
+		}
+		switch tp.Partition {
+		case 0:
+			rate0 = r
+		case 1:
+			rate1 = r
+		}
+	}
+
+	if rate1 <= 0 || rate0 < 3*rate1 {
+		t.Errorf("Expected partition 0 rate to be well above partition 1 rate, got %v",
+			rates)
+	}
+
+	c2, err := NewConsumer(&ConfigMap{
+		"group.id":                          "gotest",
+		"go.partition.throughput.window.ms": 0})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c2.Close()
+
+	if c2.PartitionThroughput() != nil {
+		t.Errorf("Expected nil throughput when disabled")
+	}
+}
@@ -0,0 +1,223 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the
+// SpanContext of the span that produced a message, see TraceInterceptor.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span of a W3C Trace Context trace.
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	TraceFlags byte
+}
+
+// IsValid returns true if sc has a non-zero TraceID and SpanID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// String returns sc as a version 00 traceparent header value.
+func (sc SpanContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		hex.EncodeToString(sc.TraceID[:]),
+		hex.EncodeToString(sc.SpanID[:]),
+		sc.TraceFlags)
+}
+
+// ParseTraceparent parses a traceparent header value, returning
+// ErrInvalidArg if it is malformed or has an all-zero TraceID or SpanID.
+// Values of future versions are accepted as long as they start with the
+// version 00 fields.
+func ParseTraceparent(traceparent string) (sc SpanContext, err error) {
+	invalid := newErrorFromString(ErrInvalidArg,
+		fmt.Sprintf("Invalid traceparent %q", traceparent))
+
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(traceparent) < 55 ||
+		(len(traceparent) > 55 && traceparent[55] != '-') ||
+		traceparent[2] != '-' || traceparent[35] != '-' ||
+		traceparent[52] != '-' {
+		return sc, invalid
+	}
+
+	var version [1]byte
+	var flags [1]byte
+	if _, err = hex.Decode(version[:], []byte(traceparent[0:2])); err != nil ||
+		version[0] == 0xff || (version[0] == 0 && len(traceparent) != 55) {
+		return sc, invalid
+	}
+	if _, err = hex.Decode(sc.TraceID[:], []byte(traceparent[3:35])); err != nil {
+		return sc, invalid
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(traceparent[36:52])); err != nil {
+		return sc, invalid
+	}
+	if _, err = hex.Decode(flags[:], []byte(traceparent[53:55])); err != nil {
+		return sc, invalid
+	}
+	sc.TraceFlags = flags[0]
+
+	if !sc.IsValid() {
+		return SpanContext{}, invalid
+	}
+
+	return sc, nil
+}
+
+// newSpanContext returns a new span of the trace of parent, or of a new
+// trace if parent is not valid.
+func newSpanContext(parent SpanContext) SpanContext {
+	sc := parent
+	if !parent.IsValid() {
+		// Root span, sampled.
+		sc = SpanContext{TraceFlags: 0x01}
+		randomID(sc.TraceID[:])
+	}
+	randomID(sc.SpanID[:])
+	return sc
+}
+
+// randomID fills id with random bytes, not all zero.
+func randomID(id []byte) {
+	for {
+		if _, err := rand.Read(id); err != nil {
+			panic(fmt.Sprintf("Failed to generate trace id: %v", err))
+		}
+		for _, b := range id {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+// Span is the span of producing or processing a message, see
+// TraceInterceptor.
+type Span struct {
+	// SpanContext of this span.
+	SpanContext SpanContext
+	// Parent is the SpanContext of the parent span, which for a
+	// processing span is the span that produced the message, or the zero
+	// SpanContext for the root span of a new trace.
+	Parent SpanContext
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, if any.
+func SpanFromContext(ctx context.Context) (span Span, ok bool) {
+	span, ok = ctx.Value(spanContextKey{}).(Span)
+	return span, ok
+}
+
+// TraceInterceptor propagates W3C Trace Context through the
+// TraceparentHeader of messages, for end-to-end trace continuity from
+// producers to consumers, as both a ProducerInterceptor and a
+// ConsumerInterceptor.
+//
+// On send, a producer span is started as a child of the active span, the
+// Span carried by the message's Opaque if it is a context.Context, see
+// ContextWithSpan(), or as the root span of a new trace if there is no
+// active span, and injected in the TraceparentHeader, replacing any
+// existing one.
+//
+// On consume, a processing span is started as a child of the producer
+// span extracted from the TraceparentHeader, or as the root span of a new
+// trace if the message has no, or an invalid, TraceparentHeader, and set
+// in the message's Opaque as a context.Context, based on the Opaque set
+// by a previous interceptor if that is a context.Context. The application
+// retrieves it with SpanFromContext(msg.Opaque.(context.Context)), e.g.,
+// to link its own tracer's processing span.
+type TraceInterceptor struct{}
+
+// NewTraceInterceptor creates a TraceInterceptor, to be added to
+// producers with Producer.AddInterceptor() and to consumers with
+// Consumer.AddInterceptor().
+func NewTraceInterceptor() *TraceInterceptor {
+	return &TraceInterceptor{}
+}
+
+// OnSend injects the producer span of msg in its TraceparentHeader.
+func (ti *TraceInterceptor) OnSend(msg *Message) *Message {
+	var parent SpanContext
+	if ctx, ok := msg.Opaque.(context.Context); ok {
+		if span, ok := SpanFromContext(ctx); ok {
+			parent = span.SpanContext
+		}
+	}
+
+	header := Header{TraceparentHeader,
+		[]byte(newSpanContext(parent).String())}
+
+	replaced := false
+	for i := range msg.Headers {
+		if msg.Headers[i].Key == TraceparentHeader {
+			msg.Headers[i] = header
+			replaced = true
+		}
+	}
+	if !replaced {
+		msg.Headers = append(msg.Headers, header)
+	}
+
+	return msg
+}
+
+// OnAcknowledgement implements ProducerInterceptor.
+func (ti *TraceInterceptor) OnAcknowledgement(msg *Message, err error) {
+}
+
+// OnConsume sets the processing span of msg in its Opaque.
+func (ti *TraceInterceptor) OnConsume(msg *Message) *Message {
+	var parent SpanContext
+	for _, header := range msg.Headers {
+		if header.Key == TraceparentHeader {
+			// An invalid traceparent starts a new trace.
+			parent, _ = ParseTraceparent(string(header.Value))
+			break
+		}
+	}
+
+	ctx, ok := msg.Opaque.(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+
+	msg.Opaque = ContextWithSpan(ctx, Span{
+		SpanContext: newSpanContext(parent),
+		Parent:      parent,
+	})
+	return msg
+}
+
+// OnCommit implements ConsumerInterceptor.
+func (ti *TraceInterceptor) OnCommit(offsets []TopicPartition, err error) {
+}
@@ -0,0 +1,176 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestParseTraceparent tests parsing valid and invalid traceparents.
+func TestParseTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatalf("ParseTraceparent(%q): %v", valid, err)
+	}
+	if sc.String() != valid || sc.TraceFlags != 0x01 {
+		t.Errorf("Expected %q, got %q (flags %x)", valid, sc, sc.TraceFlags)
+	}
+
+	// Future versions may append fields.
+	future := "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what"
+	if _, err = ParseTraceparent(future); err != nil {
+		t.Errorf("ParseTraceparent(%q): %v", future, err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"garbage",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceparent(invalid)
+		if err == nil || err.(Error).Code() != ErrInvalidArg {
+			t.Errorf("Expected ErrInvalidArg for %q, got %v", invalid, err)
+		}
+	}
+}
+
+// TestTraceInterceptor tests trace continuity from producer to consumer
+// spans, and new traces for messages without a producer span.
+func TestTraceInterceptor(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "traceinterceptortopic"
+	err = mc.CreateTopic(topic, 1, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+	p.AddInterceptor(NewTraceInterceptor())
+
+	active := Span{SpanContext: newSpanContext(SpanContext{})}
+	drChan := make(chan Event, 2)
+	for _, opaque := range []interface{}{
+		// With an active span.
+		ContextWithSpan(context.Background(), active),
+		// Without an active span, starting a root span.
+		nil} {
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          []byte("value"),
+			Opaque:         opaque}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		dr := (<-drChan).(*Message)
+		if dr.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", dr.TopicPartition)
+		}
+	}
+
+	// Without trace headers.
+	mockProduce(t, mc, topic, 0, 1)
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers(),
+		"group.id":          "traceinterceptorgroup"})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+	c.AddInterceptor(NewTraceInterceptor())
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	var spans []Span
+	var producerSpans []SpanContext
+	for _, msg := range mockConsume(t, c, 3, 10*time.Second) {
+		span, ok := SpanFromContext(msg.Opaque.(context.Context))
+		if !ok || !span.SpanContext.IsValid() {
+			t.Fatalf("Expected a valid processing span, got %v", msg.Opaque)
+		}
+		spans = append(spans, span)
+
+		var producerSpan SpanContext
+		for _, header := range msg.Headers {
+			if header.Key == TraceparentHeader {
+				producerSpan, err = ParseTraceparent(string(header.Value))
+				if err != nil {
+					t.Fatalf("Injected traceparent: %v", err)
+				}
+			}
+		}
+		producerSpans = append(producerSpans, producerSpan)
+	}
+
+	// The producer span is a child of the active span, and the
+	// parent of the processing span.
+	if producerSpans[0].TraceID != active.SpanContext.TraceID ||
+		producerSpans[0].SpanID == active.SpanContext.SpanID {
+		t.Errorf("Expected producer span in trace of %v, got %v",
+			active.SpanContext, producerSpans[0])
+	}
+
+	// The root producer span, in a new trace, is the parent of the
+	// processing span.
+	if !producerSpans[1].IsValid() ||
+		producerSpans[1].TraceID == active.SpanContext.TraceID {
+		t.Errorf("Expected root producer span in a new trace, got %v",
+			producerSpans[1])
+	}
+
+	for i := 0; i < 2; i++ {
+		if spans[i].Parent != producerSpans[i] ||
+			spans[i].SpanContext.TraceID != producerSpans[i].TraceID ||
+			spans[i].SpanContext.SpanID == producerSpans[i].SpanID {
+			t.Errorf("Expected processing span child of %v, got %+v",
+				producerSpans[i], spans[i])
+		}
+	}
+
+	// Without trace headers a new trace is started.
+	if producerSpans[2].IsValid() || spans[2].Parent.IsValid() {
+		t.Errorf("Expected root processing span, got %+v", spans[2])
+	}
+	if spans[2].SpanContext.TraceID == spans[0].SpanContext.TraceID ||
+		spans[2].SpanContext.TraceID == spans[1].SpanContext.TraceID {
+		t.Errorf("Expected processing span in a new trace, got %v",
+			spans[2].SpanContext)
+	}
+}
@@ -336,3 +336,381 @@ func TestTransactionalSendOffsets(t *testing.T) {
 		consumer.Close()
 	}
 }
+
+// consumeUntilLag consumes from c until the lag of partition tp is
+// expected, failing the test if it isn't within timeout.
+func consumeUntilLag(t *testing.T, c *Consumer, tp TopicPartition, expected int64, timeout time.Duration) {
+	var lags []PartitionLag
+	var err error
+
+	tEnd := time.Now().Add(timeout)
+	for time.Now().Before(tEnd) {
+		_, err = c.ReadMessage(100 * time.Millisecond)
+		if err != nil && err.(Error).Code() != ErrTimedOut {
+			t.Fatalf("ReadMessage() failed: %v\n", err)
+		}
+
+		lags, err = c.Lag([]TopicPartition{tp}, 5000)
+		if err != nil {
+			t.Fatalf("Lag() failed: %v\n", err)
+		}
+
+		if lags[0].Lag == expected {
+			return
+		}
+	}
+
+	t.Fatalf("Expected lag %d for %v, last lag was %v\n", expected, tp, lags)
+}
+
+// TestTransactionalLag verifies that Lag() is computed against the
+// Last Stable Offset for read_committed consumers, not reporting
+// messages in open transactions as lag.
+func TestTransactionalLag(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	topic := createTestTopic(t, "txnLag", 1, 1)
+	tp := TopicPartition{Topic: &topic, Partition: 0}
+
+	config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"transactional.id": fmt.Sprintf("go-txnid-%d", rand.Intn(100000))}
+	if err := config.updateFromTestconf(); err != nil {
+		t.Fatalf("Failed to update test configuration: %s\n", err)
+	}
+
+	producer, err := NewProducer(config)
+	if err != nil {
+		t.Fatalf("Failed to create Producer client: %s\n", err)
+	}
+	defer producer.Close()
+
+	err = producer.InitTransactions(nil)
+	if err != nil {
+		t.Fatalf("InitTransactions() failed: %v\n", err)
+	}
+
+	const msgCnt int = 10
+	produceTxn := func(commit bool) {
+		err = producer.BeginTransaction()
+		if err != nil {
+			t.Fatalf("BeginTransaction() failed: %v\n", err)
+		}
+
+		drChan := make(chan Event, msgCnt)
+		for i := 0; i < msgCnt; i++ {
+			err = producer.Produce(&Message{
+				TopicPartition: tp,
+				Value:          []byte(fmt.Sprintf("value%d", i)),
+			}, drChan)
+			if err != nil {
+				t.Fatalf("Failed to produce message: %v\n", err)
+			}
+		}
+
+		if commit {
+			err = producer.CommitTransaction(nil)
+			if err != nil {
+				t.Fatalf("CommitTransaction() failed: %v\n", err)
+			}
+		} else {
+			producer.Flush(-1)
+		}
+
+		expectDeliveryReports(t, drChan, msgCnt, false)
+	}
+
+	// One committed transaction followed by a still open one.
+	produceTxn(true)
+	produceTxn(false)
+
+	newConsumer := func(isolationLevel string) *Consumer {
+		config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+			"group.id":        testconf.GroupID,
+			"isolation.level": isolationLevel}
+		if err := config.updateFromTestconf(); err != nil {
+			t.Fatalf("Failed to update test configuration: %s\n", err)
+		}
+
+		c, err := NewConsumer(config)
+		if err != nil {
+			t.Fatalf("Failed to create Consumer client: %s\n", err)
+		}
+
+		err = c.Assign([]TopicPartition{
+			{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+		if err != nil {
+			t.Fatalf("Assign() failed: %v\n", err)
+		}
+
+		return c
+	}
+
+	committedConsumer := newConsumer("read_committed")
+	defer committedConsumer.Close()
+	uncommittedConsumer := newConsumer("read_uncommitted")
+	defer uncommittedConsumer.Close()
+
+	// The read_committed consumer is caught up to the LSO
+	// even though the open transaction's messages are beyond it.
+	consumeUntilLag(t, committedConsumer, tp, 0, 30*time.Second)
+
+	_, lso, err := committedConsumer.QueryWatermarkOffsets(topic, 0, 5000)
+	if err != nil {
+		t.Fatalf("QueryWatermarkOffsets() failed: %v\n", err)
+	}
+	_, hwm, err := uncommittedConsumer.QueryWatermarkOffsets(topic, 0, 5000)
+	if err != nil {
+		t.Fatalf("QueryWatermarkOffsets() failed: %v\n", err)
+	}
+	t.Logf("LSO %d, high watermark %d\n", lso, hwm)
+
+	if hwm-lso < int64(msgCnt) {
+		t.Errorf("Expected LSO %d to be at least %d behind the high watermark %d\n",
+			lso, msgCnt, hwm)
+	}
+
+	// The read_uncommitted consumer reads the open transaction's messages.
+	consumeUntilLag(t, uncommittedConsumer, tp, 0, 30*time.Second)
+
+	// Committing the transaction advances the LSO past its messages,
+	// which the read_committed consumer then catches up with.
+	err = producer.CommitTransaction(nil)
+	if err != nil {
+		t.Fatalf("CommitTransaction() failed: %v\n", err)
+	}
+
+	lags, err := committedConsumer.Lag([]TopicPartition{tp}, 5000)
+	if err != nil {
+		t.Fatalf("Lag() failed: %v\n", err)
+	}
+	if lags[0].Lag < int64(msgCnt) {
+		t.Errorf("Expected lag of at least %d after commit, got %v\n",
+			msgCnt, lags[0])
+	}
+
+	consumeUntilLag(t, committedConsumer, tp, 0, 30*time.Second)
+}
+
+// TestTransactionalReadCommitted verifies that a read_committed consumer
+// does not deliver messages from aborted transactions, while a
+// read_uncommitted consumer does.
+func TestTransactionalReadCommitted(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	topic := createTestTopic(t, "txnReadCommitted", 1, 1)
+	tp := TopicPartition{Topic: &topic, Partition: 0}
+
+	config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"transactional.id": fmt.Sprintf("go-txnid-%d", rand.Intn(100000))}
+	if err := config.updateFromTestconf(); err != nil {
+		t.Fatalf("Failed to update test configuration: %s\n", err)
+	}
+
+	producer, err := NewProducer(config)
+	if err != nil {
+		t.Fatalf("Failed to create Producer client: %s\n", err)
+	}
+	defer producer.Close()
+
+	err = producer.InitTransactions(nil)
+	if err != nil {
+		t.Fatalf("InitTransactions() failed: %v\n", err)
+	}
+
+	const msgCnt int = 10
+	for _, how := range []string{"aborted", "committed"} {
+		err = producer.BeginTransaction()
+		if err != nil {
+			t.Fatalf("BeginTransaction() failed: %v\n", err)
+		}
+
+		drChan := make(chan Event, msgCnt)
+		for i := 0; i < msgCnt; i++ {
+			err = producer.Produce(&Message{
+				TopicPartition: tp,
+				Value:          []byte(how),
+			}, drChan)
+			if err != nil {
+				t.Fatalf("Failed to produce message: %v\n", err)
+			}
+		}
+
+		producer.Flush(-1)
+		expectDeliveryReports(t, drChan, msgCnt, false)
+
+		if how == "aborted" {
+			err = producer.AbortTransaction(nil)
+		} else {
+			err = producer.CommitTransaction(nil)
+		}
+		if err != nil {
+			t.Fatalf("Failed to end %s transaction: %v\n", how, err)
+		}
+	}
+
+	for _, level := range []string{IsolationLevelReadCommitted,
+		IsolationLevelReadUncommitted} {
+		config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+			"group.id": testconf.GroupID}
+		if err := config.updateFromTestconf(); err != nil {
+			t.Fatalf("Failed to update test configuration: %s\n", err)
+		}
+		if err := config.SetIsolationLevel(level); err != nil {
+			t.Fatalf("SetIsolationLevel() failed: %v\n", err)
+		}
+
+		consumer, err := NewConsumer(config)
+		if err != nil {
+			t.Fatalf("Failed to create Consumer client: %s\n", err)
+		}
+
+		if consumer.IsolationLevel() != level {
+			t.Fatalf("Expected isolation.level %s in effect, got %s\n",
+				level, consumer.IsolationLevel())
+		}
+
+		err = consumer.Assign([]TopicPartition{
+			{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+		if err != nil {
+			t.Fatalf("Assign() failed: %v\n", err)
+		}
+
+		counts := make(map[string]int)
+		for {
+			m, err := consumer.ReadMessage(5 * time.Second)
+			if err != nil {
+				if err.(Error).Code() == ErrTimedOut {
+					break
+				}
+				t.Fatalf("ReadMessage() failed: %v\n", err)
+			}
+			counts[string(m.Value)]++
+		}
+
+		consumer.Close()
+
+		t.Logf("%s consumer read %v\n", level, counts)
+
+		expectedAborted := 0
+		if level == IsolationLevelReadUncommitted {
+			expectedAborted = msgCnt
+		}
+
+		if counts["committed"] != msgCnt || counts["aborted"] != expectedAborted {
+			t.Errorf("%s consumer: expected %d committed and %d aborted messages, got %v\n",
+				level, msgCnt, expectedAborted, counts)
+		}
+	}
+}
+
+// TestTransactionalBatch verifies that TransactionalBatch() produces to
+// multiple topics atomically, as seen by a read_committed consumer.
+func TestTransactionalBatch(t *testing.T) {
+	if !testconfRead() {
+		t.Skipf("Missing testconf.json")
+	}
+
+	topics := []string{createTestTopic(t, "txnBatch1", 2, 1),
+		createTestTopic(t, "txnBatch2", 2, 1)}
+
+	config := &ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"transactional.id": fmt.Sprintf("go-txnid-%d", rand.Intn(100000))}
+	if err := config.updateFromTestconf(); err != nil {
+		t.Fatalf("Failed to update test configuration: %s\n", err)
+	}
+
+	producer, err := NewProducer(config)
+	if err != nil {
+		t.Fatalf("Failed to create Producer client: %s\n", err)
+	}
+	defer producer.Close()
+
+	err = producer.InitTransactions(nil)
+	if err != nil {
+		t.Fatalf("InitTransactions() failed: %v\n", err)
+	}
+
+	const msgCnt int = 5
+	batch := func(value string) []*Message {
+		var msgs []*Message
+		for i := range topics {
+			for j := 0; j < msgCnt; j++ {
+				msgs = append(msgs, &Message{
+					TopicPartition: TopicPartition{Topic: &topics[i],
+						Partition: int32(j % 2)},
+					Value: []byte(value),
+				})
+			}
+		}
+		return msgs
+	}
+
+	err = producer.TransactionalBatch(batch("committed"), 30*time.Second)
+	if err != nil {
+		t.Fatalf("TransactionalBatch() failed: %v\n", err)
+	}
+
+	// The last message of the batch can't be produced, aborting the
+	// transaction and all the batch's messages.
+	msgs := append(batch("aborted"), &Message{
+		TopicPartition: TopicPartition{Topic: &topics[0], Partition: 100},
+		Value:          []byte("aborted"),
+	})
+	err = producer.TransactionalBatch(msgs, 30*time.Second)
+	if err == nil {
+		t.Fatalf("Expected TransactionalBatch() to fail\n")
+	}
+	t.Logf("TransactionalBatch() failed as expected: %v\n", err)
+
+	config = &ConfigMap{"bootstrap.servers": testconf.Brokers,
+		"group.id": testconf.GroupID}
+	if err := config.updateFromTestconf(); err != nil {
+		t.Fatalf("Failed to update test configuration: %s\n", err)
+	}
+	if err := config.SetIsolationLevel(IsolationLevelReadCommitted); err != nil {
+		t.Fatalf("SetIsolationLevel() failed: %v\n", err)
+	}
+
+	consumer, err := NewConsumer(config)
+	if err != nil {
+		t.Fatalf("Failed to create Consumer client: %s\n", err)
+	}
+	defer consumer.Close()
+
+	var partitions []TopicPartition
+	for i := range topics {
+		for p := int32(0); p < 2; p++ {
+			partitions = append(partitions, TopicPartition{
+				Topic: &topics[i], Partition: p, Offset: OffsetBeginning})
+		}
+	}
+	err = consumer.Assign(partitions)
+	if err != nil {
+		t.Fatalf("Assign() failed: %v\n", err)
+	}
+
+	counts := make(map[string]int)
+	for {
+		m, err := consumer.ReadMessage(5 * time.Second)
+		if err != nil {
+			if err.(Error).Code() == ErrTimedOut {
+				break
+			}
+			t.Fatalf("ReadMessage() failed: %v\n", err)
+		}
+		counts[*m.TopicPartition.Topic+"/"+string(m.Value)]++
+	}
+
+	t.Logf("Consumed %v\n", counts)
+
+	for _, topic := range topics {
+		if counts[topic+"/committed"] != msgCnt || counts[topic+"/aborted"] != 0 {
+			t.Errorf("%s: expected %d committed and no aborted messages, got %v\n",
+				topic, msgCnt, counts)
+		}
+	}
+}
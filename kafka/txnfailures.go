@@ -0,0 +1,105 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// transactionFailures collects the partitions of the current
+// transaction, with the first delivery failure of each, since delivery
+// reports are asynchronous to the transaction API.
+type transactionFailures struct {
+	lock     sync.Mutex
+	failures map[topicPartitionKey]TopicPartition
+}
+
+// add records the delivery failure of msg, if any. Purged messages, i.e.,
+// of an aborted transaction, are not failures of the transaction.
+func (tf *transactionFailures) add(msg *Message) {
+	kerr, ok := msg.TopicPartition.Error.(Error)
+	if !ok || kerr.Code() == ErrPurgeQueue || kerr.Code() == ErrPurgeInflight {
+		return
+	}
+
+	tf.lock.Lock()
+	defer tf.lock.Unlock()
+
+	key := topicPartitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+	if _, found := tf.failures[key]; found {
+		return
+	}
+
+	if tf.failures == nil {
+		tf.failures = make(map[topicPartitionKey]TopicPartition)
+	}
+	tf.failures[key] = msg.TopicPartition
+}
+
+// reset forgets the failures of the previous transaction.
+func (tf *transactionFailures) reset() {
+	tf.lock.Lock()
+	tf.failures = nil
+	tf.lock.Unlock()
+}
+
+// get returns the failures sorted by topic and partition.
+func (tf *transactionFailures) get() []TopicPartition {
+	tf.lock.Lock()
+	defer tf.lock.Unlock()
+
+	failures := make([]TopicPartition, 0, len(tf.failures))
+	for _, tp := range tf.failures {
+		failures = append(failures, tp)
+	}
+	sort.Sort(TopicPartitions(failures))
+
+	return failures
+}
+
+// newTransactionFailureError returns an abortable error describing
+// failures.
+func newTransactionFailureError(failures []TopicPartition) Error {
+	descs := make([]string, len(failures))
+	for i, tp := range failures {
+		descs[i] = tp.String()
+	}
+
+	err := newErrorFromString(failures[0].Error.(Error).Code(),
+		fmt.Sprintf("Produce failed for %d partition(s) in the transaction: %s",
+			len(failures), strings.Join(descs, ", ")))
+	err.txnRequiresAbort = true
+
+	return err
+}
+
+// TransactionProduceErrors returns the partitions that messages produced
+// in the current, or last, transaction failed to be delivered to, with
+// the first delivery failure of each partition as the TopicPartition's
+// Error and Offset, sorted by topic and partition.
+//
+// The failures are collected from the delivery reports, which are
+// asynchronous, so this is typically consulted after CommitTransaction()
+// has flushed the transaction's messages and returned an abortable
+// error, to find out which of the topics and partitions were not
+// produced. The failures are reset by BeginTransaction().
+func (p *Producer) TransactionProduceErrors() []TopicPartition {
+	return p.txnFailures.get()
+}
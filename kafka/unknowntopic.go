@@ -0,0 +1,68 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+)
+
+// TopicNotAvailable is emitted, with `go.unknown.topic.errors.suppress`,
+// in place of the first unknown topic error, such as for a subscribed
+// topic that does not exist yet, e.g., with `allow.auto.create.topics=false`.
+//
+// Repeated errors are suppressed until partitions are assigned, which
+// happens once the topic has been created, after which a new error for
+// a missing topic emits a new TopicNotAvailable event.
+type TopicNotAvailable struct {
+	// Error is the ErrUnknownTopicOrPart or ErrUnknownTopic error.
+	Error Error
+}
+
+func (e TopicNotAvailable) String() string {
+	return fmt.Sprintf("TopicNotAvailable: %v", e.Error)
+}
+
+// isUnknownTopicError returns true if code is an unknown topic error.
+func isUnknownTopicError(code ErrorCode) bool {
+	return code == ErrUnknownTopicOrPart || code == ErrUnknownTopic
+}
+
+// suppressUnknownTopic returns a TopicNotAvailable event for err, an unknown
+// topic error, unless the same error has already been emitted, in which
+// case nil is returned.
+// The error string identifies the topic, since the error events do not
+// carry one.
+//
+// Called from the poll path only.
+func (c *Consumer) suppressUnknownTopic(err Error) Event {
+	if c.unknownTopicErrors[err.String()] {
+		return nil
+	}
+	c.unknownTopicErrors[err.String()] = true
+
+	return TopicNotAvailable{Error: err}
+}
+
+// resetUnknownTopics re-enables TopicNotAvailable events after partitions
+// have been assigned.
+//
+// Called from the poll path only.
+func (c *Consumer) resetUnknownTopics() {
+	if len(c.unknownTopicErrors) > 0 {
+		c.unknownTopicErrors = make(map[string]bool)
+	}
+}
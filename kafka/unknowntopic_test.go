@@ -0,0 +1,82 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConsumerUnknownTopicSuppress verifies that only the first of repeated
+// unknown topic errors is emitted with `go.unknown.topic.errors.suppress`,
+// and that errors are emitted again after partitions are assigned.
+func TestConsumerUnknownTopicSuppress(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":                mc.BootstrapServers(),
+		"group.id":                         "unknowntopicgroup",
+		"go.unknown.topic.errors.suppress": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	// The mock cluster creates topics on demand, so the errors are
+	// handed to the suppression directly.
+	errA := newErrorFromString(ErrUnknownTopicOrPart,
+		"Subscribed topic not available: topicA: Broker: Unknown topic or partition")
+	errB := newErrorFromString(ErrUnknownTopicOrPart,
+		"Subscribed topic not available: topicB: Broker: Unknown topic or partition")
+
+	ev := c.suppressUnknownTopic(errA)
+	if tna, ok := ev.(TopicNotAvailable); !ok || tna.Error.Code() != ErrUnknownTopicOrPart {
+		t.Fatalf("Expected TopicNotAvailable for %v, got %v", errA, ev)
+	}
+	for i := 0; i < 3; i++ {
+		if ev = c.suppressUnknownTopic(errA); ev != nil {
+			t.Fatalf("Expected repeated error to be suppressed, got %v", ev)
+		}
+	}
+	if _, ok := c.suppressUnknownTopic(errB).(TopicNotAvailable); !ok {
+		t.Fatalf("Expected TopicNotAvailable for %v", errB)
+	}
+
+	// Once partitions are assigned errors are emitted again.
+	err = c.Subscribe("unknowntopic", nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for start := time.Now(); ; {
+		c.Poll(100)
+		if parts, _ := c.Assignment(); len(parts) > 0 {
+			break
+		}
+		if time.Since(start) > 30*time.Second {
+			t.Fatalf("Timed out waiting for assignment")
+		}
+	}
+
+	if _, ok := c.suppressUnknownTopic(errA).(TopicNotAvailable); !ok {
+		t.Fatalf("Expected TopicNotAvailable for %v after assignment", errA)
+	}
+}
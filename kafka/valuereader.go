@@ -0,0 +1,132 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+/*
+#include "select_rdkafka.h"
+*/
+import "C"
+
+// valueBuffer holds the librdkafka event backing the value of the
+// last message returned by a go.value.reader.enable consumer.
+type valueBuffer struct {
+	lock sync.Mutex
+	// Incremented each time the event is released, invalidating
+	// outstanding readers.
+	generation uint64
+	rkev       *C.rd_kafka_event_t
+}
+
+// valueBufferReader is an io.Reader over a message value in librdkafka
+// memory, valid until the consumer's valueBuffer generation changes.
+type valueBufferReader struct {
+	c          *Consumer
+	generation uint64
+	payload    unsafe.Pointer
+	len        int
+	off        int
+}
+
+// Read implements io.Reader.
+// Fails with ErrState if the consumer has been polled since the message
+// was returned.
+func (r *valueBufferReader) Read(p []byte) (int, error) {
+	vb := &r.c.valueBuffer
+
+	vb.lock.Lock()
+	defer vb.lock.Unlock()
+
+	if r.generation != vb.generation {
+		return 0, newErrorFromString(ErrState,
+			"Message value is no longer available: the consumer has been polled since the message was returned")
+	}
+
+	if r.off >= r.len {
+		return 0, io.EOF
+	}
+
+	n := copy(p, (*[1 << 30]byte)(r.payload)[r.off:r.len:r.len])
+	r.off += n
+
+	return n, nil
+}
+
+// ValueReader returns an io.Reader over the message value.
+//
+// For messages consumed with `go.value.reader.enable=true` the Value field
+// is nil and the reader reads the value directly from the librdkafka
+// buffer, avoiding a copy of the full value into Go memory.
+// The buffer is only valid until the next call to the consumer's Poll(),
+// ReadMessage() or Close(), reads after that fail with ErrState.
+// The reader must therefore be drained, e.g., copied to a file or parser,
+// before polling the consumer again, and must not be retained.
+// Repeated calls to ValueReader() on such a message return the same
+// reader, so the value can only be read once.
+//
+// For all other messages the reader reads from Value.
+func (m *Message) ValueReader() io.Reader {
+	if m.valueReader != nil {
+		return m.valueReader
+	}
+	return bytes.NewReader(m.Value)
+}
+
+// retainValueBuffer keeps rkev, which holds cmsg, alive until the next
+// releaseValueBuffer() and sets up msg's value reader over cmsg's payload.
+func (c *Consumer) retainValueBuffer(msg *Message, cmsg *C.rd_kafka_message_t, rkev *C.rd_kafka_event_t) {
+	vb := &c.valueBuffer
+
+	vb.lock.Lock()
+	defer vb.lock.Unlock()
+
+	vb.rkev = rkev
+
+	if cmsg.payload == nil {
+		return
+	}
+
+	msg.valueReader = &valueBufferReader{
+		c:          c,
+		generation: vb.generation,
+		payload:    cmsg.payload,
+		len:        int(cmsg.len),
+	}
+}
+
+// releaseValueBuffer destroys the retained event, if any, invalidating
+// the value reader of the last returned message.
+func (c *Consumer) releaseValueBuffer() {
+	vb := &c.valueBuffer
+
+	vb.lock.Lock()
+	defer vb.lock.Unlock()
+
+	if vb.rkev == nil {
+		return
+	}
+
+	C.rd_kafka_event_destroy(vb.rkev)
+	vb.rkev = nil
+	vb.generation++
+}
@@ -0,0 +1,122 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// TestConsumerValueReader consumes large messages with go.value.reader.enable
+// and verifies that the values are readable until the next poll.
+func TestConsumerValueReader(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "valuereadertopic"
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers": mc.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	values := make([][]byte, 2)
+	drChan := make(chan Event, len(values))
+	for i := range values {
+		values[i] = bytes.Repeat([]byte{byte('a' + i)}, 512*1024)
+		err = p.Produce(&Message{
+			TopicPartition: TopicPartition{Topic: &topic, Partition: 0},
+			Value:          values[i]}, drChan)
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	for range values {
+		m := (<-drChan).(*Message)
+		if m.TopicPartition.Error != nil {
+			t.Fatalf("Delivery failed: %v", m.TopicPartition)
+		}
+	}
+
+	c, err := NewConsumer(&ConfigMap{
+		"bootstrap.servers":      mc.BootstrapServers(),
+		"group.id":               "gotest",
+		"go.value.reader.enable": true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Assign([]TopicPartition{{Topic: &topic, Partition: 0, Offset: OffsetBeginning}})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	var prev *Message
+	for i := range values {
+		m, err := c.ReadMessage(10 * time.Second)
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+
+		if m.Value != nil {
+			t.Errorf("Expected nil Value, got %d bytes", len(m.Value))
+		}
+
+		value, err := ioutil.ReadAll(m.ValueReader())
+		if err != nil {
+			t.Fatalf("Reading value: %v", err)
+		}
+		if !bytes.Equal(value, values[i]) {
+			t.Errorf("Message %d: value mismatch (%d bytes read)", i, len(value))
+		}
+
+		if prev != nil {
+			_, err = prev.ValueReader().Read(make([]byte, 1))
+			if err == nil || err.(Error).Code() != ErrState {
+				t.Errorf("Expected ErrState reading previous message value, got %v", err)
+			}
+		}
+		prev = m
+	}
+
+	_, err = NewConsumer(&ConfigMap{
+		"group.id":                 "gotest",
+		"go.events.channel.enable": true,
+		"go.value.reader.enable":   true})
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg with go.events.channel.enable, got %v", err)
+	}
+}
+
+// TestMessageValueReader verifies that ValueReader() reads Value for
+// regular messages.
+func TestMessageValueReader(t *testing.T) {
+	m := &Message{Value: []byte("value")}
+
+	value, err := ioutil.ReadAll(m.ValueReader())
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected \"value\", got %q, %v", value, err)
+	}
+}
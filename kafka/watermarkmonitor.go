@@ -0,0 +1,188 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PartitionStalled is emitted by a WatermarkMonitor when a partition's
+// high watermark has not advanced for the monitor's stall timeout.
+type PartitionStalled struct {
+	// TopicPartition.Offset is the stalled high watermark, or
+	// OffsetInvalid if it could not be queried.
+	TopicPartition TopicPartition
+	// LastAdvanced is when the high watermark was last seen advancing,
+	// or when monitoring started.
+	LastAdvanced time.Time
+}
+
+func (e PartitionStalled) String() string {
+	return fmt.Sprintf("PartitionStalled (%v, high watermark unchanged since %v)",
+		e.TopicPartition, e.LastAdvanced)
+}
+
+// watermarkMonitorPartition holds the per-partition sampling state.
+type watermarkMonitorPartition struct {
+	topic        string
+	partition    int32
+	high         int64
+	lastAdvanced time.Time
+	stalled      bool
+}
+
+// WatermarkMonitor periodically samples the high watermarks of a set of
+// partitions and emits a PartitionStalled event on its Events() channel
+// when a partition's high watermark has not advanced for the configured
+// stall timeout, i.e., no new messages have been produced to it.
+//
+// This detects upstream producer outages that consumer lag monitoring
+// does not, since lag remains zero when no new data arrives.
+//
+// A PartitionStalled event is emitted once per stall, the partition
+// is considered active again as soon as its high watermark advances.
+// Failures to query the watermarks do not count as advancing.
+type WatermarkMonitor struct {
+	h              Handle
+	partitions     []*watermarkMonitorPartition
+	stallTimeout   time.Duration
+	sampleInterval time.Duration
+	events         chan Event
+	termChan       chan bool
+	waitGroup      sync.WaitGroup
+}
+
+// NewWatermarkMonitor creates and starts a WatermarkMonitor for the given
+// partitions, querying their watermarks from the cluster through
+// client instance h (a Consumer, Producer or AdminClient) every
+// sampleInterval.
+//
+// The monitor must be closed with Close() when no longer needed,
+// before h is closed.
+func NewWatermarkMonitor(h Handle, partitions []TopicPartition, stallTimeout time.Duration, sampleInterval time.Duration) (*WatermarkMonitor, error) {
+	if stallTimeout <= 0 || sampleInterval <= 0 {
+		return nil, newErrorFromString(ErrInvalidArg,
+			"stallTimeout and sampleInterval must be positive")
+	}
+
+	m := &WatermarkMonitor{
+		h:              h,
+		stallTimeout:   stallTimeout,
+		sampleInterval: sampleInterval,
+		events:         make(chan Event, len(partitions)),
+		termChan:       make(chan bool),
+	}
+
+	now := time.Now()
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			return nil, newErrorFromString(ErrInvalidArg,
+				"Partitions must have a topic")
+		}
+		m.partitions = append(m.partitions, &watermarkMonitorPartition{
+			topic:        *tp.Topic,
+			partition:    tp.Partition,
+			high:         -1,
+			lastAdvanced: now,
+		})
+	}
+
+	m.waitGroup.Add(1)
+	go func() {
+		defer m.waitGroup.Done()
+		m.run()
+	}()
+
+	return m, nil
+}
+
+// Events returns the channel on which PartitionStalled events are emitted.
+func (m *WatermarkMonitor) Events() chan Event {
+	return m.events
+}
+
+// Close stops the monitor and closes its Events() channel.
+func (m *WatermarkMonitor) Close() {
+	close(m.termChan)
+	m.waitGroup.Wait()
+	close(m.events)
+}
+
+// run samples the watermarks every sampleInterval until termChan closes.
+func (m *WatermarkMonitor) run() {
+	ticker := time.NewTicker(m.sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.termChan:
+			return
+		case <-ticker.C:
+		}
+
+		for _, p := range m.partitions {
+			if !m.sample(p) {
+				return
+			}
+		}
+	}
+}
+
+// sample queries the high watermark of a single partition and emits
+// PartitionStalled if it has not advanced for stallTimeout.
+// Returns false if the monitor was closed.
+func (m *WatermarkMonitor) sample(p *watermarkMonitorPartition) bool {
+	_, high, err := queryWatermarkOffsets(m.h, p.topic, p.partition,
+		int(m.sampleInterval/time.Millisecond))
+	now := time.Now()
+
+	if err == nil && high != p.high {
+		advanced := p.high != -1
+		p.high = high
+		if advanced {
+			p.lastAdvanced = now
+			p.stalled = false
+		}
+	}
+
+	if p.stalled || now.Sub(p.lastAdvanced) < m.stallTimeout {
+		return true
+	}
+
+	p.stalled = true
+	topic := p.topic
+	ev := PartitionStalled{
+		TopicPartition: TopicPartition{
+			Topic:     &topic,
+			Partition: p.partition,
+			Offset:    OffsetInvalid,
+		},
+		LastAdvanced: p.lastAdvanced,
+	}
+	if p.high != -1 {
+		ev.TopicPartition.Offset = Offset(p.high)
+	}
+
+	select {
+	case m.events <- ev:
+		return true
+	case <-m.termChan:
+		return false
+	}
+}
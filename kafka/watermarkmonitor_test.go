@@ -0,0 +1,128 @@
+package kafka
+
+/**
+ * Copyright 2021 Confluent Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWatermarkMonitor keeps producing to one partition while leaving
+// another idle, and verifies that only the idle partition is reported
+// as stalled.
+func TestWatermarkMonitor(t *testing.T) {
+	mc, err := NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("NewMockCluster: %v", err)
+	}
+	defer mc.Close()
+
+	topic := "monitortopic"
+	err = mc.CreateTopic(topic, 2, 1)
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	p, err := NewProducer(&ConfigMap{
+		"bootstrap.servers":   mc.BootstrapServers(),
+		"go.delivery.reports": false})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	// Produce one message to partition 1 so its watermark is known.
+	err = p.Produce(&Message{
+		TopicPartition: TopicPartition{Topic: &topic, Partition: 1}}, nil)
+	if err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+	p.Flush(10000)
+
+	m, err := NewWatermarkMonitor(p, []TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1}},
+		time.Second, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewWatermarkMonitor: %v", err)
+	}
+	defer m.Close()
+
+	// Keep partition 0 advancing.
+	termChan := make(chan bool)
+	doneChan := make(chan bool)
+	go func() {
+		defer close(doneChan)
+		for {
+			select {
+			case <-termChan:
+				return
+			case <-time.After(100 * time.Millisecond):
+				p.Produce(&Message{
+					TopicPartition: TopicPartition{Topic: &topic, Partition: 0}}, nil)
+			}
+		}
+	}()
+
+	tEnd := time.After(3 * time.Second)
+	var stalled []PartitionStalled
+loop:
+	for {
+		select {
+		case ev := <-m.Events():
+			t.Logf("Monitor event: %v", ev)
+			stalled = append(stalled, ev.(PartitionStalled))
+		case <-tEnd:
+			break loop
+		}
+	}
+
+	close(termChan)
+	<-doneChan
+
+	if len(stalled) != 1 {
+		t.Fatalf("Expected exactly one PartitionStalled event, got %v", stalled)
+	}
+
+	if stalled[0].TopicPartition.Partition != 1 ||
+		stalled[0].TopicPartition.Offset != 1 {
+		t.Errorf("Expected partition 1 stalled at offset 1, got %v",
+			stalled[0].TopicPartition)
+	}
+}
+
+// TestWatermarkMonitorInvalidArgs verifies argument validation.
+func TestWatermarkMonitorInvalidArgs(t *testing.T) {
+	p, err := NewProducer(&ConfigMap{})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+
+	topic := "sometopic"
+	_, err = NewWatermarkMonitor(p, []TopicPartition{{Topic: &topic}},
+		0, time.Second)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for zero stallTimeout, got %v", err)
+	}
+
+	_, err = NewWatermarkMonitor(p, []TopicPartition{{Partition: 0}},
+		time.Second, time.Second)
+	if err == nil || err.(Error).Code() != ErrInvalidArg {
+		t.Errorf("Expected ErrInvalidArg for missing topic, got %v", err)
+	}
+}